@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"micropanel/internal/config"
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/services"
+)
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Manage deploys",
+	Long:  "List deploys, deploy from git, roll sites back to earlier releases, promote staging releases and prune stored archives.",
+}
+
+var deployListCmd = &cobra.Command{
+	Use:   "list [site_id]",
+	Short: "List deploys of a site",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDeployList,
+}
+
+var deployRollbackCmd = &cobra.Command{
+	Use:   "rollback [site_id]",
+	Short: "Roll a site back to an earlier release",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDeployRollback,
+}
+
+var deployGitCmd = &cobra.Command{
+	Use:   "git [site_id]",
+	Short: "Deploy the latest commit of the repository linked to a site",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDeployGit,
+}
+
+var deployPromoteCmd = &cobra.Command{
+	Use:   "promote [site_id]",
+	Short: "Make the staging release of a site its production release",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDeployPromote,
+}
+
+var deployPruneCmd = &cobra.Command{
+	Use:   "prune [site_id]",
+	Short: "Remove stored deploy archives outside the retention policy",
+	Long:  "Remove uploaded archives that the retention policy of the site (or of every site, without site_id) no longer keeps.",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runDeployPrune,
+}
+
+var (
+	deployListLimit  int
+	deployRollbackTo int64
+	deployGitQueue   bool
+	deployGitStaging bool
+	deployPruneDry   bool
+)
+
+func init() {
+	rootCmd.AddCommand(deployCmd)
+	deployCmd.AddCommand(deployListCmd)
+	deployCmd.AddCommand(deployRollbackCmd)
+	deployCmd.AddCommand(deployGitCmd)
+	deployCmd.AddCommand(deployPromoteCmd)
+	deployCmd.AddCommand(deployPruneCmd)
+
+	deployListCmd.Flags().IntVarP(&deployListLimit, "limit", "l", 20, "Number of deploys to show")
+	deployRollbackCmd.Flags().Int64Var(&deployRollbackTo, "to", 0, "Deploy ID to roll back to (default: previous release)")
+	deployGitCmd.Flags().BoolVar(&deployGitQueue, "queue", false, "Wait for a deploy in progress instead of failing")
+	deployGitCmd.Flags().BoolVar(&deployGitStaging, "staging", false, "Deploy to the staging slot of the site")
+	deployPruneCmd.Flags().BoolVar(&deployPruneDry, "dry-run", false, "Only list the archives that would be removed")
+}
+
+func getDeployService() (*services.DeployService, func()) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	siteRepo := repository.NewSiteRepository(db)
+	deployRepo := repository.NewDeployRepository(db)
+	deployService := services.NewDeployService(cfg, deployRepo, siteRepo)
+	deployService.SetLimitsService(services.NewLimitsService(cfg, repository.NewLimitsRepository(db), siteRepo))
+	deployService.SetHealthCheckService(services.NewHealthCheckService(cfg, repository.NewHealthCheckRepository(db), siteRepo))
+	deployService.SetSharedPathRepo(repository.NewSharedPathRepository(db))
+	deployService.SetSettingsService(services.NewSettingsService(repository.NewSettingsRepository(db)))
+	deployService.SetDeployKeyService(services.NewDeployKeyService(repository.NewDeployKeyRepository(db)))
+	deployService.SetStagingService(services.NewStagingService(cfg, repository.NewStagingRepository(db), siteRepo, repository.NewDomainRepository(db)))
+
+	return deployService, func() { db.Close() }
+}
+
+func parseSiteID(arg string) int64 {
+	var siteID int64
+	if _, err := fmt.Sscanf(arg, "%d", &siteID); err != nil {
+		log.Fatalf("Invalid site ID: %s", arg)
+	}
+	return siteID
+}
+
+func runDeployList(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	svc, cleanup := getDeployService()
+	defer cleanup()
+
+	deploys, err := svc.ListDeploys(siteID, deployListLimit)
+	if err != nil {
+		log.Fatalf("Failed to list deploys: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFILENAME\tCOMMIT\tSTATUS\tRELEASE\tARCHIVE\tACTIVE\tCREATED")
+	for _, d := range deploys {
+		release := "no"
+		if d.HasRelease {
+			release = "yes"
+		}
+		archive := "-"
+		if d.HasArchive() {
+			archive = "yes"
+		} else if d.ArchivePruned != nil {
+			archive = "pruned"
+		}
+		active := ""
+		if d.IsActive {
+			active = "*"
+		} else if d.IsStaged {
+			active = "staging"
+		}
+		commit := d.ShortCommitSHA()
+		if commit == "" {
+			commit = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.ID, d.Filename, commit, d.Status, release, archive, active, d.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
+
+func runDeployGit(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	_, siteRepo, _, _, siteCleanup := getSiteService()
+	defer siteCleanup()
+
+	site, err := siteRepo.GetByID(siteID)
+	if err != nil {
+		log.Fatalf("Site not found: %d", siteID)
+	}
+
+	svc, cleanup := getDeployService()
+	defer cleanup()
+
+	opts := services.DeployOptions{Queue: deployGitQueue, Target: models.DeployTargetProduction}
+	if deployGitStaging {
+		opts.Target = models.DeployTargetStaging
+	}
+
+	// Recorded as a deploy by the site owner
+	deploy, err := svc.DeployGit(siteID, site.OwnerID, opts)
+	if err != nil {
+		log.Fatalf("Deploy failed: %v", err)
+	}
+	fmt.Printf("Deploy #%d of site %d done: %s %s\n", deploy.ID, siteID, deploy.ShortCommitSHA(), deploy.CommitMessage)
+}
+
+func runDeployPromote(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	svc, cleanup := getDeployService()
+	defer cleanup()
+
+	deploy, err := svc.Promote(siteID)
+	if err != nil {
+		log.Fatalf("Promote failed: %v", err)
+	}
+	fmt.Printf("Deploy #%d is now the production release of site %d\n", deploy.ID, siteID)
+}
+
+func runDeployRollback(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	svc, cleanup := getDeployService()
+	defer cleanup()
+
+	if deployRollbackTo > 0 {
+		if _, err := svc.RollbackTo(siteID, deployRollbackTo); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("Site %d rolled back to deploy #%d\n", siteID, deployRollbackTo)
+		return
+	}
+
+	if err := svc.Rollback(siteID); err != nil {
+		log.Fatalf("Rollback failed: %v", err)
+	}
+	fmt.Printf("Site %d rolled back to previous version\n", siteID)
+}
+
+func runDeployPrune(cmd *cobra.Command, args []string) {
+	svc, cleanup := getDeployService()
+	defer cleanup()
+
+	var pruned []services.PrunedArchive
+	var err error
+	if len(args) == 1 {
+		pruned, err = svc.PruneSiteArchives(parseSiteID(args[0]), deployPruneDry)
+	} else {
+		pruned, err = svc.PruneArchives(deployPruneDry)
+	}
+	if err != nil {
+		log.Fatalf("Prune failed: %v", err)
+	}
+
+	if len(pruned) == 0 {
+		fmt.Println("No archives to remove")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tARCHIVE\tSIZE")
+	var total int64
+	for _, a := range pruned {
+		fmt.Fprintf(w, "%d\t%s\t%d\n", a.SiteID, a.Name, a.Size)
+		total += a.Size
+	}
+	w.Flush()
+
+	verb := "Removed"
+	if deployPruneDry {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d archives, %d bytes\n", verb, len(pruned), total)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"micropanel/internal/config"
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/services"
+)
+
+var siteLimitsCmd = &cobra.Command{
+	Use:   "limits [site_id]",
+	Short: "Show or override the limits of a site",
+	Long: `Show the limits and disk usage of a site, or override them with flags.
+Sizes are in megabytes. Pass "default" to drop an override and inherit the
+owner's or the config limit again.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runSiteLimits,
+}
+
+var userLimitsCmd = &cobra.Command{
+	Use:   "limits [email]",
+	Short: "Show or override the limits of a user's sites",
+	Long: `Show or override the limits that apply to all sites of a user.
+Sizes are in megabytes. Pass "default" to drop an override and inherit the
+config limit again. Site overrides still win over user overrides.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runUserLimits,
+}
+
+var limitFlags = []struct {
+	name  string
+	usage string
+	field func(o *models.LimitOverrides) **int64
+}{
+	{"max-archive-size", "Maximum deploy archive size in MB", func(o *models.LimitOverrides) **int64 { return &o.MaxArchiveSize }},
+	{"max-file-size", "Maximum size of a single file in MB", func(o *models.LimitOverrides) **int64 { return &o.MaxFileSize }},
+	{"max-upload-size", "Maximum file manager upload size in MB", func(o *models.LimitOverrides) **int64 { return &o.MaxUploadSize }},
+	{"disk-quota", "Disk quota in MB (0 = unlimited)", func(o *models.LimitOverrides) **int64 { return &o.DiskQuota }},
+}
+
+func init() {
+	siteCmd.AddCommand(siteLimitsCmd)
+	userCmd.AddCommand(userLimitsCmd)
+
+	for _, f := range limitFlags {
+		siteLimitsCmd.Flags().String(f.name, "", f.usage)
+		userLimitsCmd.Flags().String(f.name, "", f.usage)
+	}
+}
+
+func getLimitsService() (*services.LimitsService, *repository.UserRepository, func()) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	siteRepo := repository.NewSiteRepository(db)
+	limitsRepo := repository.NewLimitsRepository(db)
+
+	return services.NewLimitsService(cfg, limitsRepo, siteRepo), repository.NewUserRepository(db), func() { db.Close() }
+}
+
+// applyLimitFlags updates overrides with the limit flags that were given and
+// reports whether there were any.
+func applyLimitFlags(cmd *cobra.Command, o *models.LimitOverrides) bool {
+	changed := false
+	for _, f := range limitFlags {
+		if !cmd.Flags().Changed(f.name) {
+			continue
+		}
+		changed = true
+
+		value, _ := cmd.Flags().GetString(f.name)
+		dst := f.field(o)
+		if value == "default" {
+			*dst = nil
+			continue
+		}
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || mb < 0 {
+			log.Fatalf("Invalid value for --%s: %s (megabytes or \"default\")", f.name, value)
+		}
+		size := mb * 1024 * 1024
+		*dst = &size
+	}
+	return changed
+}
+
+func printLimits(limits *models.Limits, overrides *models.LimitOverrides, usage *models.DiskUsage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tEFFECTIVE\tOVERRIDE")
+	rows := []struct {
+		name      string
+		effective int64
+		override  *int64
+	}{
+		{"max-archive-size", limits.MaxArchiveSize, overrides.MaxArchiveSize},
+		{"max-file-size", limits.MaxFileSize, overrides.MaxFileSize},
+		{"max-upload-size", limits.MaxUploadSize, overrides.MaxUploadSize},
+		{"disk-quota", limits.DiskQuota, overrides.DiskQuota},
+	}
+	for _, r := range rows {
+		override := "-"
+		if r.override != nil {
+			override = formatMB(*r.override)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.name, formatMB(r.effective), override)
+	}
+	w.Flush()
+
+	if usage != nil {
+		fmt.Printf("\nDisk usage: %s\n", formatMB(usage.Used))
+	}
+}
+
+func formatMB(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
+
+func runSiteLimits(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	svc, _, cleanup := getLimitsService()
+	defer cleanup()
+
+	overrides, err := svc.GetSiteOverrides(siteID)
+	if err != nil {
+		log.Fatalf("Failed to load site limits: %v", err)
+	}
+
+	if applyLimitFlags(cmd, overrides) {
+		if err := svc.SetSiteOverrides(siteID, overrides); err != nil {
+			log.Fatalf("Failed to save site limits: %v", err)
+		}
+		fmt.Printf("Limits of site %d updated\n\n", siteID)
+	}
+
+	limits, err := svc.ForSite(siteID)
+	if err != nil {
+		log.Fatalf("Failed to resolve site limits: %v", err)
+	}
+	usage, err := svc.Usage(siteID)
+	if err != nil {
+		log.Printf("Warning: failed to measure disk usage: %v", err)
+	}
+
+	printLimits(limits, overrides, usage)
+}
+
+func runUserLimits(cmd *cobra.Command, args []string) {
+	email := args[0]
+
+	svc, userRepo, cleanup := getLimitsService()
+	defer cleanup()
+
+	user, err := userRepo.GetByEmail(email)
+	if err != nil {
+		log.Fatalf("User not found: %s", email)
+	}
+
+	overrides, err := svc.GetUserOverrides(user.ID)
+	if err != nil {
+		log.Fatalf("Failed to load user limits: %v", err)
+	}
+
+	if applyLimitFlags(cmd, overrides) {
+		if err := svc.SetUserOverrides(user.ID, overrides); err != nil {
+			log.Fatalf("Failed to save user limits: %v", err)
+		}
+		fmt.Printf("Limits of user %s updated\n\n", email)
+	}
+
+	limits, err := svc.ForUser(user.ID)
+	if err != nil {
+		log.Fatalf("Failed to resolve user limits: %v", err)
+	}
+
+	printLimits(limits, overrides, nil)
+}
@@ -77,6 +77,18 @@ func runServe(cmd *cobra.Command, args []string) {
 	auditRepo := repository.NewAuditRepository(db)
 	settingsRepo := repository.NewSettingsRepository(db)
 	apiTokenRepo := repository.NewAPITokenRepository(db)
+	limitsRepo := repository.NewLimitsRepository(db)
+	healthCheckRepo := repository.NewHealthCheckRepository(db)
+	sharedPathRepo := repository.NewSharedPathRepository(db)
+	deployKeyRepo := repository.NewDeployKeyRepository(db)
+	freezeWindowRepo := repository.NewFreezeWindowRepository(db)
+	uploadRepo := repository.NewUploadRepository(db)
+	s3CredentialsRepo := repository.NewS3CredentialsRepository(db)
+	stagingRepo := repository.NewStagingRepository(db)
+	approverRepo := repository.NewApproverRepository(db)
+	proxyRepo := repository.NewProxyRepository(db)
+	headerRuleRepo := repository.NewHeaderRuleRepository(db)
+	snippetRepo := repository.NewNginxSnippetRepository(db)
 
 	auditService := services.NewAuditService(auditRepo)
 	authService := services.NewAuthService(userRepo, sessionRepo)
@@ -87,25 +99,98 @@ func runServe(cmd *cobra.Command, args []string) {
 	nginxService := services.NewNginxService(cfg, siteRepo, domainRepo)
 	nginxService.SetRedirectRepo(redirectRepo)
 	nginxService.SetAuthZoneRepo(authZoneRepo)
+	nginxService.SetStagingRepo(stagingRepo)
+	nginxService.SetProxyRepo(proxyRepo)
+	nginxService.SetHeaderRuleRepo(headerRuleRepo)
+	nginxService.SetNginxSnippetRepo(snippetRepo)
+	limitsService := services.NewLimitsService(cfg, limitsRepo, siteRepo)
 	deployService := services.NewDeployService(cfg, deployRepo, siteRepo)
+	deployService.SetLimitsService(limitsService)
+	healthCheckService := services.NewHealthCheckService(cfg, healthCheckRepo, siteRepo)
+	deployService.SetHealthCheckService(healthCheckService)
+	deployService.SetSharedPathRepo(sharedPathRepo)
+	deployService.SetSettingsService(settingsService)
+	deployKeyService := services.NewDeployKeyService(deployKeyRepo)
+	deployService.SetDeployKeyService(deployKeyService)
+	freezeWindowService := services.NewFreezeWindowService(freezeWindowRepo)
+	deployService.SetFreezeWindowService(freezeWindowService)
+	s3CredentialsService := services.NewS3CredentialsService(s3CredentialsRepo)
+	deployService.SetS3CredentialsService(s3CredentialsService)
+	stagingService := services.NewStagingService(cfg, stagingRepo, siteRepo, domainRepo)
+	deployService.SetStagingService(stagingService)
+	approvalService := services.NewApprovalService(approverRepo, userRepo, siteRepo)
+	proxyService := services.NewProxyService(proxyRepo, siteRepo)
+	pageService := services.NewPageService(cfg, siteRepo)
+	headerRuleService := services.NewHeaderRuleService(headerRuleRepo)
+	snippetService := services.NewNginxSnippetService(cfg, snippetRepo, nginxService)
 	sslService := services.NewSSLService(cfg, siteRepo, domainRepo, nginxService)
+	sslService.SetStagingRepo(stagingRepo)
 	redirectService := services.NewRedirectService(redirectRepo, nginxService)
 	authZoneService := services.NewAuthZoneService(cfg, authZoneRepo, nginxService)
 	fileService := services.NewFileService(cfg)
+	fileService.SetLimitsService(limitsService)
+	fileService.SetSharedPathRepo(sharedPathRepo)
+	fileService.SetSiteRepo(siteRepo)
+	uploadService := services.NewUploadService(cfg, uploadRepo, limitsService)
+
+	migrateSiteLayouts(siteService, deployService, nginxService)
+
+	if err := deployService.RecoverInterrupted(); err != nil {
+		log.Printf("Failed to recover interrupted deploys: %v", err)
+	}
+	deployService.StartWorkers(cfg.Sites.DeployWorkers)
+	deployService.StartArchiveJanitor(services.ArchivePruneInterval)
+	deployService.StartScheduler(services.ScheduleInterval)
+	uploadService.StartJanitor(services.UploadPruneInterval)
 
 	authHandler := handlers.NewAuthHandler(authService, auditService)
 	siteHandler := handlers.NewSiteHandler(siteService, deployService, redirectService, authZoneService, auditService, settingsService, nginxService, sslService)
+	siteHandler.SetLimitsService(limitsService)
+	siteHandler.SetHealthCheckService(healthCheckService)
+	siteHandler.SetDeployKeyService(deployKeyService)
+	siteHandler.SetFreezeWindowService(freezeWindowService)
+	siteHandler.SetS3CredentialsService(s3CredentialsService)
+	siteHandler.SetStagingService(stagingService)
+	siteHandler.SetApprovalService(approvalService)
+	siteHandler.SetProxyService(proxyService)
+	siteHandler.SetPageService(pageService)
+	siteHandler.SetHeaderRuleService(headerRuleService)
+	siteHandler.SetNginxSnippetService(snippetService)
 	domainHandler := handlers.NewDomainHandler(domainRepo, siteService, nginxService, auditService)
 	settingsHandler := handlers.NewSettingsHandler(settingsService, auditService)
-	deployHandler := handlers.NewDeployHandler(deployService, siteService, auditService)
+	deployHandler := handlers.NewDeployHandler(deployService, siteService, auditService, uploadService)
 	sslHandler := handlers.NewSSLHandler(sslService, siteService, auditService)
 	redirectHandler := handlers.NewRedirectHandler(redirectService, siteService, auditService)
+	healthCheckHandler := handlers.NewHealthCheckHandler(healthCheckService, siteService, auditService)
+	deployKeyHandler := handlers.NewDeployKeyHandler(deployKeyService, siteService, auditService)
+	freezeWindowHandler := handlers.NewFreezeWindowHandler(freezeWindowService, siteService, auditService)
+	s3CredentialsHandler := handlers.NewS3CredentialsHandler(s3CredentialsService, siteService, auditService)
+	stagingHandler := handlers.NewStagingHandler(stagingService, deployService, siteService, nginxService, auditService)
+	approvalHandler := handlers.NewApprovalHandler(approvalService, deployService, siteService, auditService)
+	proxyHandler := handlers.NewProxyHandler(proxyService, siteService, nginxService, auditService)
+	pageHandler := handlers.NewPageHandler(pageService, siteService, nginxService, auditService)
+	headerRuleHandler := handlers.NewHeaderRuleHandler(headerRuleService, siteService, nginxService, auditService)
+	snippetHandler := handlers.NewNginxSnippetHandler(snippetService, siteService, auditService)
 	authZoneHandler := handlers.NewAuthZoneHandler(authZoneService, siteService, auditService)
-	fileHandler := handlers.NewFileHandler(fileService, siteService, auditService)
+	fileHandler := handlers.NewFileHandler(fileService, siteService, auditService, uploadService)
 	auditHandler := handlers.NewAuditHandler(auditService, userRepo)
-	userHandler := handlers.NewUserHandler(userRepo, auditService)
+	userHandler := handlers.NewUserHandler(userRepo, auditService, deployKeyService)
 	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenService, auditService)
+	panelUploadHandler := handlers.NewUploadHandler(uploadService, siteService, userRepo, "/uploads")
+	apiUploadHandler := handlers.NewUploadHandler(uploadService, siteService, userRepo, "/api/v1/uploads")
 	apiHandler := handlers.NewAPIHandler(siteService, deployService, nginxService, sslService, auditService, domainRepo, userRepo)
+	apiHandler.SetLimitsService(limitsService)
+	apiHandler.SetHealthCheckService(healthCheckService)
+	apiHandler.SetDeployKeyService(deployKeyService)
+	apiHandler.SetFreezeWindowService(freezeWindowService)
+	apiHandler.SetUploadService(uploadService)
+	apiHandler.SetS3CredentialsService(s3CredentialsService)
+	apiHandler.SetStagingService(stagingService)
+	apiHandler.SetApprovalService(approvalService)
+	apiHandler.SetProxyService(proxyService)
+	apiHandler.SetPageService(pageService)
+	apiHandler.SetHeaderRuleService(headerRuleService)
+	apiHandler.SetNginxSnippetService(snippetService)
 
 	if !cfg.IsDevelopment() {
 		gin.SetMode(gin.ReleaseMode)
@@ -146,13 +231,45 @@ func runServe(cmd *cobra.Command, args []string) {
 		protected.GET("/sites/:id", siteHandler.View)
 		protected.GET("/sites/:id/files-page", siteHandler.Files)
 		protected.POST("/sites/:id", siteHandler.Update)
+		protected.POST("/sites/:id/limits", siteHandler.UpdateLimits)
+		protected.POST("/sites/:id/git", siteHandler.UpdateGitSource)
 		protected.DELETE("/sites/:id", siteHandler.Delete)
 
 		protected.POST("/sites/:id/domains", domainHandler.Create)
 		protected.DELETE("/sites/:id/domains/:domainId", domainHandler.Delete)
 
 		protected.POST("/sites/:id/deploy", deployHandler.Upload)
+		protected.POST("/sites/:id/deploy/git", deployHandler.DeployGit)
+		protected.POST("/sites/:id/deploy/remote", deployHandler.DeployRemote)
 		protected.POST("/sites/:id/rollback", deployHandler.Rollback)
+		protected.POST("/sites/:id/deploys/:deployId/rollback", deployHandler.RollbackTo)
+		protected.POST("/sites/:id/deploys/:deployId/cancel", deployHandler.Cancel)
+		protected.GET("/sites/:id/diff", deployHandler.Diff)
+		protected.POST("/sites/:id/shared-paths", siteHandler.UpdateSharedPaths)
+		protected.POST("/sites/:id/health-checks", healthCheckHandler.Create)
+		protected.DELETE("/sites/:id/health-checks/:checkId", healthCheckHandler.Delete)
+		protected.POST("/sites/:id/deploy-keys", deployKeyHandler.Create)
+		protected.DELETE("/sites/:id/deploy-keys/:keyId", deployKeyHandler.Delete)
+		protected.POST("/sites/:id/freeze-windows", freezeWindowHandler.Create)
+		protected.DELETE("/sites/:id/freeze-windows/:windowId", freezeWindowHandler.Delete)
+		protected.POST("/sites/:id/s3-credentials", s3CredentialsHandler.Update)
+		protected.DELETE("/sites/:id/s3-credentials", s3CredentialsHandler.Delete)
+		protected.POST("/sites/:id/staging", stagingHandler.Update)
+		protected.DELETE("/sites/:id/staging", stagingHandler.Delete)
+		protected.POST("/sites/:id/staging/promote", stagingHandler.Promote)
+		protected.POST("/sites/:id/approval", approvalHandler.Update)
+		protected.POST("/sites/:id/approvers", approvalHandler.AddApprover)
+		protected.DELETE("/sites/:id/approvers/:userId", approvalHandler.RemoveApprover)
+		protected.GET("/sites/:id/deploys/:deployId/review", approvalHandler.Review)
+		protected.POST("/sites/:id/deploys/:deployId/approve", approvalHandler.Approve)
+		protected.POST("/sites/:id/deploys/:deployId/reject", approvalHandler.Reject)
+		protected.GET("/approvals", approvalHandler.List)
+		protected.POST("/sites/:id/proxy", proxyHandler.Update)
+		protected.POST("/sites/:id/pages", pageHandler.Update)
+		protected.POST("/sites/:id/header-rules", headerRuleHandler.Create)
+		protected.POST("/sites/:id/header-rules/:ruleId/move", headerRuleHandler.Move)
+		protected.DELETE("/sites/:id/header-rules/:ruleId", headerRuleHandler.Delete)
+		protected.POST("/sites/:id/nginx-snippets", snippetHandler.Update)
 
 		protected.POST("/sites/:id/ssl/issue", sslHandler.Issue)
 		protected.POST("/ssl/renew", sslHandler.Renew)
@@ -180,11 +297,18 @@ func runServe(cmd *cobra.Command, args []string) {
 		protected.GET("/sites/:id/files/preview", fileHandler.Preview)
 		protected.GET("/sites/:id/files/info", fileHandler.Info)
 
+		protected.OPTIONS("/uploads", panelUploadHandler.Options)
+		protected.POST("/uploads", panelUploadHandler.Create)
+		protected.HEAD("/uploads/:uploadId", panelUploadHandler.Head)
+		protected.PATCH("/uploads/:uploadId", panelUploadHandler.Patch)
+		protected.DELETE("/uploads/:uploadId", panelUploadHandler.Delete)
+
 		protected.GET("/audit", auditHandler.List)
 		protected.GET("/api/audit", auditHandler.ListAPI)
 
 		protected.GET("/settings", settingsHandler.Page)
 		protected.POST("/settings", settingsHandler.Update)
+		protected.POST("/settings/deploy-policy", settingsHandler.UpdateDeployPolicy)
 
 		protected.GET("/users", userHandler.List)
 		protected.POST("/users", userHandler.Create)
@@ -194,6 +318,8 @@ func runServe(cmd *cobra.Command, args []string) {
 
 		protected.GET("/profile", userHandler.Profile)
 		protected.POST("/profile/password", userHandler.ChangePassword)
+		protected.POST("/profile/deploy-keys", deployKeyHandler.CreateForUser)
+		protected.DELETE("/profile/deploy-keys/:keyId", deployKeyHandler.DeleteForUser)
 
 		protected.GET("/api-tokens", apiTokenHandler.List)
 		protected.POST("/api-tokens", apiTokenHandler.Create)
@@ -212,6 +338,50 @@ func runServe(cmd *cobra.Command, args []string) {
 			apiGroup.GET("/sites/:id", apiHandler.GetSite)
 			apiGroup.DELETE("/sites/:id", apiHandler.DeleteSite)
 			apiGroup.POST("/sites/:id/deploy", apiHandler.Deploy)
+			apiGroup.POST("/sites/:id/deploy/git", apiHandler.DeployGit)
+			apiGroup.POST("/sites/:id/deploy/remote", apiHandler.DeployRemote)
+			apiGroup.POST("/sites/:id/deploy/manifest", apiHandler.DeployManifest)
+			apiGroup.PUT("/sites/:id/git", apiHandler.SetGitSource)
+			apiGroup.GET("/sites/:id/shared-paths", apiHandler.ListSharedPaths)
+			apiGroup.PUT("/sites/:id/shared-paths", apiHandler.SetSharedPaths)
+			apiGroup.GET("/sites/:id/health-checks", apiHandler.ListHealthChecks)
+			apiGroup.PUT("/sites/:id/health-checks", apiHandler.SetHealthChecks)
+			apiGroup.GET("/sites/:id/deploy-keys", apiHandler.ListDeployKeys)
+			apiGroup.GET("/sites/:id/freeze-windows", apiHandler.ListFreezeWindows)
+			apiGroup.POST("/sites/:id/freeze-windows", apiHandler.CreateFreezeWindow)
+			apiGroup.DELETE("/sites/:id/freeze-windows/:windowId", apiHandler.DeleteFreezeWindow)
+			apiGroup.GET("/sites/:id/s3-credentials", apiHandler.GetS3Credentials)
+			apiGroup.PUT("/sites/:id/s3-credentials", apiHandler.SetS3Credentials)
+			apiGroup.DELETE("/sites/:id/s3-credentials", apiHandler.DeleteS3Credentials)
+			apiGroup.GET("/sites/:id/staging", apiHandler.GetStaging)
+			apiGroup.PUT("/sites/:id/staging", apiHandler.SetStaging)
+			apiGroup.DELETE("/sites/:id/staging", apiHandler.DeleteStaging)
+			apiGroup.POST("/sites/:id/staging/promote", apiHandler.PromoteStaging)
+			apiGroup.GET("/sites/:id/approval", apiHandler.GetApproval)
+			apiGroup.PUT("/sites/:id/approval", apiHandler.SetApproval)
+			apiGroup.GET("/sites/:id/proxy", apiHandler.GetProxy)
+			apiGroup.PUT("/sites/:id/proxy", apiHandler.SetProxy)
+			apiGroup.GET("/sites/:id/pages", apiHandler.GetPages)
+			apiGroup.PUT("/sites/:id/pages", apiHandler.SetPages)
+			apiGroup.GET("/sites/:id/header-rules", apiHandler.ListHeaderRules)
+			apiGroup.PUT("/sites/:id/header-rules", apiHandler.SetHeaderRules)
+			apiGroup.GET("/sites/:id/nginx-snippets", apiHandler.GetNginxSnippets)
+			apiGroup.PUT("/sites/:id/nginx-snippets", apiHandler.SetNginxSnippets)
+			apiGroup.GET("/sites/:id/deploys", apiHandler.ListDeploys)
+			apiGroup.POST("/sites/:id/rollback", apiHandler.Rollback)
+			apiGroup.GET("/sites/:id/diff", apiHandler.DiffReleases)
+			apiGroup.GET("/deploys/:id", apiHandler.GetDeploy)
+			apiGroup.POST("/deploys/:id/files", apiHandler.UploadDeployFiles)
+			apiGroup.POST("/deploys/:id/cancel", apiHandler.CancelDeploy)
+			apiGroup.GET("/deploys/:id/review", apiHandler.ReviewDeploy)
+			apiGroup.POST("/deploys/:id/approve", apiHandler.ApproveDeploy)
+			apiGroup.POST("/deploys/:id/reject", apiHandler.RejectDeploy)
+
+			apiGroup.OPTIONS("/uploads", apiUploadHandler.Options)
+			apiGroup.POST("/uploads", apiUploadHandler.Create)
+			apiGroup.HEAD("/uploads/:uploadId", apiUploadHandler.Head)
+			apiGroup.PATCH("/uploads/:uploadId", apiUploadHandler.Patch)
+			apiGroup.DELETE("/uploads/:uploadId", apiUploadHandler.Delete)
 
 			apiGroup.POST("/sites/:id/domains", apiHandler.CreateDomain)
 			apiGroup.GET("/sites/:id/domains", apiHandler.ListDomains)
@@ -236,6 +406,47 @@ func runServe(cmd *cobra.Command, args []string) {
 	log.Println("Shutting down server...")
 }
 
+// migrateSiteLayouts moves sites still served from public/ to the release
+// layout and points their nginx configs at the current symlink.
+func migrateSiteLayouts(siteService *services.SiteService, deployService *services.DeployService, nginxService *services.NginxService) {
+	sites, err := siteService.ListAll()
+	if err != nil {
+		log.Printf("Failed to list sites for layout migration: %v", err)
+		return
+	}
+
+	var migrated int
+	for _, site := range sites {
+		ok, err := deployService.MigrateLayout(site.ID)
+		if err != nil {
+			log.Printf("Failed to migrate site %d (%s) to release layout: %v", site.ID, site.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		migrated++
+		if err := nginxService.WriteConfig(site.ID); err != nil {
+			log.Printf("Failed to rewrite nginx config for site %d (%s): %v", site.ID, site.Name, err)
+		}
+	}
+
+	if migrated == 0 {
+		return
+	}
+	log.Printf("Migrated %d site(s) to release layout", migrated)
+
+	// Old configs keep working through the public -> current symlink, so a
+	// failed reload leaves the sites served
+	if err := nginxService.TestConfig(); err != nil {
+		log.Printf("Nginx config test failed after layout migration: %v", err)
+		return
+	}
+	if err := nginxService.Reload(); err != nil {
+		log.Printf("Failed to reload nginx after layout migration: %v", err)
+	}
+}
+
 func validateStartup(cfg *config.Config, userRepo *repository.UserRepository) error {
 	var errors []string
 
@@ -53,9 +53,23 @@ var siteDisableCmd = &cobra.Command{
 	Run:   runSiteDisable,
 }
 
+var siteGitCmd = &cobra.Command{
+	Use:   "git [site_id]",
+	Short: "Show or link the git repository of a site",
+	Long: `Show the git repository a site is deployed from, or link one with --url.
+Use --unlink to remove it. Deploy the linked branch with "micropanel deploy git".`,
+	Args: cobra.ExactArgs(1),
+	Run:  runSiteGit,
+}
+
 var (
 	siteName    string
 	siteOwnerID int64
+
+	siteGitURL    string
+	siteGitBranch string
+	siteGitSubdir string
+	siteGitUnlink bool
 )
 
 func init() {
@@ -65,11 +79,17 @@ func init() {
 	siteCmd.AddCommand(siteDeleteCmd)
 	siteCmd.AddCommand(siteEnableCmd)
 	siteCmd.AddCommand(siteDisableCmd)
+	siteCmd.AddCommand(siteGitCmd)
 
 	siteCreateCmd.Flags().StringVarP(&siteName, "name", "n", "", "Site name (required)")
 	siteCreateCmd.Flags().Int64VarP(&siteOwnerID, "owner", "o", 0, "Owner user ID (required)")
 	siteCreateCmd.MarkFlagRequired("name")
 	siteCreateCmd.MarkFlagRequired("owner")
+
+	siteGitCmd.Flags().StringVar(&siteGitURL, "url", "", "Repository URL or path of a local repository")
+	siteGitCmd.Flags().StringVar(&siteGitBranch, "branch", services.DefaultGitBranch, "Branch to deploy")
+	siteGitCmd.Flags().StringVar(&siteGitSubdir, "subdir", "", "Directory of the repository to serve, e.g. dist")
+	siteGitCmd.Flags().BoolVar(&siteGitUnlink, "unlink", false, "Unlink the repository")
 }
 
 func getSiteService() (*services.SiteService, *repository.SiteRepository, *services.NginxService, *services.SSLService, func()) {
@@ -87,6 +107,9 @@ func getSiteService() (*services.SiteService, *repository.SiteRepository, *servi
 	domainRepo := repository.NewDomainRepository(db)
 	siteService := services.NewSiteService(siteRepo, domainRepo, cfg)
 	nginxService := services.NewNginxService(cfg, siteRepo, domainRepo)
+	nginxService.SetProxyRepo(repository.NewProxyRepository(db))
+	nginxService.SetHeaderRuleRepo(repository.NewHeaderRuleRepository(db))
+	nginxService.SetNginxSnippetRepo(repository.NewNginxSnippetRepository(db))
 	sslService := services.NewSSLService(cfg, siteRepo, domainRepo, nginxService)
 
 	return siteService, siteRepo, nginxService, sslService, func() { db.Close() }
@@ -222,3 +245,40 @@ func runSiteDisable(cmd *cobra.Command, args []string) {
 
 	fmt.Printf("Site '%s' disabled\n", site.Name)
 }
+
+func runSiteGit(cmd *cobra.Command, args []string) {
+	siteID := parseSiteID(args[0])
+
+	svc, _, _, _, cleanup := getSiteService()
+	defer cleanup()
+
+	site, err := svc.GetByID(siteID)
+	if err != nil {
+		log.Fatalf("Site not found: %d", siteID)
+	}
+
+	switch {
+	case siteGitUnlink:
+		if err := svc.SetGitSource(site, "", "", "", true); err != nil {
+			log.Fatalf("Failed to unlink repository: %v", err)
+		}
+		fmt.Printf("Repository of site '%s' unlinked\n", site.Name)
+		return
+	case siteGitURL != "":
+		// The CLI runs on the server, local repositories are allowed
+		if err := svc.SetGitSource(site, siteGitURL, siteGitBranch, siteGitSubdir, true); err != nil {
+			log.Fatalf("Failed to link repository: %v", err)
+		}
+		fmt.Printf("Site '%s' linked to repository\n", site.Name)
+	}
+
+	if !site.HasGitSource() {
+		fmt.Printf("Site '%s' has no git repository\n", site.Name)
+		return
+	}
+	subdir := site.GitSubdir
+	if subdir == "" {
+		subdir = "(root)"
+	}
+	fmt.Printf("URL:    %s\nBranch: %s\nSubdir: %s\n", site.GitURL, site.GitBranch, subdir)
+}
@@ -39,6 +39,7 @@ type LimitsConfig struct {
 	MaxFileSize     int64 `yaml:"max_file_size"`      // bytes
 	MaxUploadSize   int64 `yaml:"max_upload_size"`    // bytes
 	MaxSitesPerUser int   `yaml:"max_sites_per_user"` // 0 = unlimited
+	DiskQuota       int64 `yaml:"disk_quota"`         // bytes per site, 0 = unlimited
 }
 
 type SSLConfig struct {
@@ -59,14 +60,28 @@ type DatabaseConfig struct {
 }
 
 type SitesConfig struct {
-	Path  string `yaml:"path"`
-	User  string `yaml:"user"`
-	Group string `yaml:"group"`
+	Path          string `yaml:"path"`
+	User          string `yaml:"user"`
+	Group         string `yaml:"group"`
+	KeepReleases  int    `yaml:"keep_releases"`  // releases kept on disk per site (can be overridden per site)
+	DeployWorkers int    `yaml:"deploy_workers"` // deploys processed in parallel
+
+	// Retention of uploaded archives in sites/<id>/deploys, 0 = no limit.
+	// Each can be overridden per site.
+	KeepArchives   int   `yaml:"keep_archives"`    // newest archives kept
+	ArchiveMaxAge  int   `yaml:"archive_max_age"`  // days an archive is kept
+	ArchiveMaxSize int64 `yaml:"archive_max_size"` // bytes of archives kept
 }
 
 type NginxConfig struct {
-	ConfigPath string `yaml:"config_path"`
-	ReloadCmd  string `yaml:"reload_cmd"`
+	ConfigPath       string `yaml:"config_path"`
+	ReloadCmd        string `yaml:"reload_cmd"`
+	HealthCheckHTTP  string `yaml:"health_check_http"`  // address deploy health checks connect to for http sites
+	HealthCheckHTTPS string `yaml:"health_check_https"` // and for sites with SSL
+	BrotliStatic     bool   `yaml:"brotli_static"`      // nginx has the brotli module; precompressing sites also get .br files
+
+	// Directories the custom nginx snippets of sites may include files from.
+	SnippetIncludeDirs []string `yaml:"snippet_include_dirs"`
 }
 
 // Default config paths
@@ -88,13 +103,19 @@ func Load() (*Config, error) {
 			Path: "/var/lib/micropanel/micropanel.db",
 		},
 		Sites: SitesConfig{
-			Path:  "/var/www/panel/sites",
-			User:  "micropanel",
-			Group: "micropanel",
+			Path:          "/var/www/panel/sites",
+			User:          "micropanel",
+			Group:         "micropanel",
+			KeepReleases:  5,
+			DeployWorkers: 2,
+			KeepArchives:  10,
 		},
 		Nginx: NginxConfig{
-			ConfigPath: "/etc/nginx/sites-enabled",
-			ReloadCmd:  "sudo systemctl restart nginx",
+			ConfigPath:         "/etc/nginx/sites-enabled",
+			ReloadCmd:          "sudo systemctl restart nginx",
+			HealthCheckHTTP:    "127.0.0.1:80",
+			HealthCheckHTTPS:   "127.0.0.1:443",
+			SnippetIncludeDirs: []string{"/etc/nginx/snippets"},
 		},
 		SSL: SSLConfig{
 			Email:   "",
@@ -102,9 +123,10 @@ func Load() (*Config, error) {
 		},
 		Limits: LimitsConfig{
 			MaxZipSize:      100 * 1024 * 1024, // 100MB
-			MaxFileSize:     5 * 1024 * 1024,   // 5MB
+			MaxFileSize:     10 * 1024 * 1024,  // 10MB
 			MaxUploadSize:   10 * 1024 * 1024,  // 10MB
 			MaxSitesPerUser: 0,                 // unlimited
+			DiskQuota:       0,                 // unlimited
 		},
 		API: APIConfig{
 			Enabled: false,
@@ -144,9 +166,37 @@ func Load() (*Config, error) {
 	if sitesPath := os.Getenv("SITES_PATH"); sitesPath != "" {
 		cfg.Sites.Path = sitesPath
 	}
+	if keepReleases := os.Getenv("KEEP_RELEASES"); keepReleases != "" {
+		if v, err := strconv.Atoi(keepReleases); err == nil {
+			cfg.Sites.KeepReleases = v
+		}
+	}
+	if deployWorkers := os.Getenv("DEPLOY_WORKERS"); deployWorkers != "" {
+		if v, err := strconv.Atoi(deployWorkers); err == nil {
+			cfg.Sites.DeployWorkers = v
+		}
+	}
+	if keepArchives := os.Getenv("KEEP_ARCHIVES"); keepArchives != "" {
+		if v, err := strconv.Atoi(keepArchives); err == nil {
+			cfg.Sites.KeepArchives = v
+		}
+	}
+	if archiveMaxAge := os.Getenv("ARCHIVE_MAX_AGE"); archiveMaxAge != "" {
+		if v, err := strconv.Atoi(archiveMaxAge); err == nil {
+			cfg.Sites.ArchiveMaxAge = v
+		}
+	}
+	if archiveMaxSize := os.Getenv("ARCHIVE_MAX_SIZE"); archiveMaxSize != "" {
+		if v, err := strconv.ParseInt(archiveMaxSize, 10, 64); err == nil {
+			cfg.Sites.ArchiveMaxSize = v
+		}
+	}
 	if nginxPath := os.Getenv("NGINX_CONFIG_PATH"); nginxPath != "" {
 		cfg.Nginx.ConfigPath = nginxPath
 	}
+	if brotliStatic := os.Getenv("NGINX_BROTLI_STATIC"); brotliStatic == "true" {
+		cfg.Nginx.BrotliStatic = true
+	}
 	if sslEmail := os.Getenv("SSL_EMAIL"); sslEmail != "" {
 		cfg.SSL.Email = sslEmail
 	}
@@ -173,6 +223,11 @@ func Load() (*Config, error) {
 			cfg.Limits.MaxSitesPerUser = v
 		}
 	}
+	if diskQuota := os.Getenv("DISK_QUOTA"); diskQuota != "" {
+		if v, err := strconv.ParseInt(diskQuota, 10, 64); err == nil {
+			cfg.Limits.DiskQuota = v
+		}
+	}
 	if apiEnabled := os.Getenv("API_ENABLED"); apiEnabled == "true" {
 		cfg.API.Enabled = true
 	}
@@ -90,9 +90,30 @@ func TestConfig_Defaults(t *testing.T) {
 	if cfg.Sites.Group != "micropanel" {
 		t.Errorf("Default Sites.Group = %q, want %q", cfg.Sites.Group, "micropanel")
 	}
+	if cfg.Sites.KeepReleases != 5 {
+		t.Errorf("Default Sites.KeepReleases = %d, want %d", cfg.Sites.KeepReleases, 5)
+	}
+	if cfg.Sites.DeployWorkers != 2 {
+		t.Errorf("Default Sites.DeployWorkers = %d, want %d", cfg.Sites.DeployWorkers, 2)
+	}
+	if cfg.Sites.KeepArchives != 10 {
+		t.Errorf("Default Sites.KeepArchives = %d, want %d", cfg.Sites.KeepArchives, 10)
+	}
+	if cfg.Nginx.HealthCheckHTTP != "127.0.0.1:80" {
+		t.Errorf("Default Nginx.HealthCheckHTTP = %q, want %q", cfg.Nginx.HealthCheckHTTP, "127.0.0.1:80")
+	}
+	if cfg.Nginx.HealthCheckHTTPS != "127.0.0.1:443" {
+		t.Errorf("Default Nginx.HealthCheckHTTPS = %q, want %q", cfg.Nginx.HealthCheckHTTPS, "127.0.0.1:443")
+	}
 	if cfg.Limits.MaxZipSize != 100*1024*1024 {
 		t.Errorf("Default MaxZipSize = %d, want %d", cfg.Limits.MaxZipSize, 100*1024*1024)
 	}
+	if cfg.Limits.MaxFileSize != 10*1024*1024 {
+		t.Errorf("Default MaxFileSize = %d, want %d", cfg.Limits.MaxFileSize, 10*1024*1024)
+	}
+	if cfg.Limits.DiskQuota != 0 {
+		t.Errorf("Default DiskQuota = %d, want %d", cfg.Limits.DiskQuota, 0)
+	}
 	if cfg.API.Enabled != false {
 		t.Errorf("Default API.Enabled = %v, want %v", cfg.API.Enabled, false)
 	}
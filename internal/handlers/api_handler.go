@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -17,13 +19,25 @@ import (
 )
 
 type APIHandler struct {
-	siteService   *services.SiteService
-	deployService *services.DeployService
-	nginxService  *services.NginxService
-	sslService    *services.SSLService
-	auditService  *services.AuditService
-	domainRepo    *repository.DomainRepository
-	userRepo      *repository.UserRepository
+	siteService     *services.SiteService
+	deployService   *services.DeployService
+	nginxService    *services.NginxService
+	sslService      *services.SSLService
+	auditService    *services.AuditService
+	domainRepo      *repository.DomainRepository
+	userRepo        *repository.UserRepository
+	limitsService   *services.LimitsService
+	healthService   *services.HealthCheckService
+	keyService      *services.DeployKeyService
+	freezeService   *services.FreezeWindowService
+	uploadService   *services.UploadService
+	s3Service       *services.S3CredentialsService
+	stagingService  *services.StagingService
+	approvalService *services.ApprovalService
+	proxyService    *services.ProxyService
+	pageService     *services.PageService
+	headerService   *services.HeaderRuleService
+	snippetService  *services.NginxSnippetService
 }
 
 func NewAPIHandler(siteService *services.SiteService, deployService *services.DeployService, nginxService *services.NginxService, sslService *services.SSLService, auditService *services.AuditService, domainRepo *repository.DomainRepository, userRepo *repository.UserRepository) *APIHandler {
@@ -38,23 +52,194 @@ func NewAPIHandler(siteService *services.SiteService, deployService *services.De
 	}
 }
 
+func (h *APIHandler) SetLimitsService(limitsService *services.LimitsService) {
+	h.limitsService = limitsService
+}
+
+func (h *APIHandler) SetHealthCheckService(healthService *services.HealthCheckService) {
+	h.healthService = healthService
+}
+
+func (h *APIHandler) SetDeployKeyService(keyService *services.DeployKeyService) {
+	h.keyService = keyService
+}
+
+func (h *APIHandler) SetFreezeWindowService(freezeService *services.FreezeWindowService) {
+	h.freezeService = freezeService
+}
+
+func (h *APIHandler) SetUploadService(uploadService *services.UploadService) {
+	h.uploadService = uploadService
+}
+
+func (h *APIHandler) SetS3CredentialsService(s3Service *services.S3CredentialsService) {
+	h.s3Service = s3Service
+}
+
+func (h *APIHandler) SetStagingService(stagingService *services.StagingService) {
+	h.stagingService = stagingService
+}
+
+func (h *APIHandler) SetApprovalService(approvalService *services.ApprovalService) {
+	h.approvalService = approvalService
+}
+
+func (h *APIHandler) SetProxyService(proxyService *services.ProxyService) {
+	h.proxyService = proxyService
+}
+
+func (h *APIHandler) SetPageService(pageService *services.PageService) {
+	h.pageService = pageService
+}
+
+func (h *APIHandler) SetHeaderRuleService(headerService *services.HeaderRuleService) {
+	h.headerService = headerService
+}
+
+func (h *APIHandler) SetNginxSnippetService(snippetService *services.NginxSnippetService) {
+	h.snippetService = snippetService
+}
+
 type createSiteRequest struct {
-	Name         string `json:"name" binding:"required"`
-	SSL          *bool  `json:"ssl"`            // optional, default false; if true, issues cert for all hostnames after creation
-	FixMimeTypes bool   `json:"fix_mime_types"` // optional, default false
+	Name         string          `json:"name" binding:"required"`
+	SSL          *bool           `json:"ssl"`            // optional, default false; if true, issues cert for all hostnames after creation
+	FixMimeTypes bool            `json:"fix_mime_types"` // optional, default false
+	Precompress  bool            `json:"precompress"`    // optional, default false
+	Type         models.SiteType `json:"type"`           // optional, default static; proxy and hybrid need an admin token
+	Upstream     *upstreamBody   `json:"upstream"`       // required for proxy and hybrid sites
 }
 
 type siteResponse struct {
-	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	IsEnabled    bool   `json:"is_enabled"`
-	SSLEnabled   bool   `json:"ssl_enabled"`
-	FixMimeTypes bool   `json:"fix_mime_types"`
+	ID           int64           `json:"id"`
+	Name         string          `json:"name"`
+	IsEnabled    bool            `json:"is_enabled"`
+	SSLEnabled   bool            `json:"ssl_enabled"`
+	FixMimeTypes bool            `json:"fix_mime_types"`
+	Precompress  bool            `json:"precompress"`
+	Type         models.SiteType `json:"type"`
+
+	// Only returned by GetSite
+	Limits    *models.Limits    `json:"limits,omitempty"`
+	DiskUsage *models.DiskUsage `json:"disk_usage,omitempty"`
+	Git       *gitSourceBody    `json:"git,omitempty"`
+	Upstream  *upstreamBody     `json:"upstream,omitempty"`
+}
+
+// upstreamBody is the backend proxy and hybrid sites pass requests to.
+type upstreamBody struct {
+	Servers        []string `json:"servers"`                // host:port or unix:/path
+	ConnectTimeout int      `json:"connect_timeout"`        // seconds, default 60
+	ReadTimeout    int      `json:"read_timeout"`           // seconds, default 60
+	SendTimeout    int      `json:"send_timeout"`           // seconds, default 60
+	WebSocket      bool     `json:"websocket"`              // pass Upgrade requests through
+	PassHeaders    []string `json:"pass_headers,omitempty"` // upstream response headers nginx hides by default
+}
+
+func newUpstreamBody(proxy *models.ProxyConfig) *upstreamBody {
+	if proxy == nil {
+		return nil
+	}
+	return &upstreamBody{
+		Servers:        proxy.Upstreams,
+		ConnectTimeout: proxy.ConnectTimeout,
+		ReadTimeout:    proxy.ReadTimeout,
+		SendTimeout:    proxy.SendTimeout,
+		WebSocket:      proxy.WebSocket,
+		PassHeaders:    proxy.PassHeaders,
+	}
+}
+
+func (b *upstreamBody) proxyConfig() *models.ProxyConfig {
+	if b == nil {
+		return nil
+	}
+	return &models.ProxyConfig{
+		Upstreams:      b.Servers,
+		ConnectTimeout: b.ConnectTimeout,
+		ReadTimeout:    b.ReadTimeout,
+		SendTimeout:    b.SendTimeout,
+		WebSocket:      b.WebSocket,
+		PassHeaders:    b.PassHeaders,
+	}
+}
+
+// gitSourceBody is the repository a site is deployed from.
+type gitSourceBody struct {
+	URL    string `json:"url"`              // empty unlinks the repository
+	Branch string `json:"branch,omitempty"` // defaults to main
+	Subdir string `json:"subdir,omitempty"` // directory served as the site root, such as dist
 }
 
 type deployResponse struct {
+	DeployID    int64  `json:"deploy_id"`
+	Status      string `json:"status"`
+	Phase       string `json:"phase,omitempty"`
+	ScheduledAt string `json:"scheduled_at,omitempty"`
+}
+
+// manifestRequest lists every file of the new release with its SHA-256.
+type manifestRequest struct {
+	Files map[string]string `json:"files" binding:"required"`
+}
+
+// manifestResponse tells the client which files of its manifest to upload.
+type manifestResponse struct {
+	DeployID int64    `json:"deploy_id"`
+	Status   string   `json:"status"`
+	Phase    string   `json:"phase"`
+	Missing  []string `json:"missing"`
+}
+
+// deployConflictResponse is returned with 409 when another deploy of the site
+// is in progress.
+type deployConflictResponse struct {
+	Error    string `json:"error"`
 	DeployID int64  `json:"deploy_id"`
-	Status   string `json:"status"`
+}
+
+// policyViolationResponse is returned with 422 when a release breaks the
+// deploy policy.
+type policyViolationResponse struct {
+	Error      string                   `json:"error"`
+	Violations []models.PolicyViolation `json:"violations"`
+}
+
+// deployFrozenResponse is returned with 423 when the site is in a freeze
+// window.
+type deployFrozenResponse struct {
+	Error       string `json:"error"`
+	FrozenUntil string `json:"frozen_until"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type deployInfoResponse struct {
+	ID            int64                    `json:"id"`
+	SiteID        int64                    `json:"site_id"`
+	Filename      string                   `json:"filename"`
+	CommitSHA     string                   `json:"commit_sha,omitempty"`
+	CommitMessage string                   `json:"commit_message,omitempty"`
+	Status        string                   `json:"status"`
+	Phase         string                   `json:"phase"`
+	Progress      int                      `json:"progress"`
+	ErrorMessage  string                   `json:"error_message,omitempty"`
+	Violations    []models.PolicyViolation `json:"violations,omitempty"`
+	HasRelease    bool                     `json:"has_release"`
+	HasArchive    bool                     `json:"has_archive"`
+	CanRestore    bool                     `json:"can_restore"`
+	IsActive      bool                     `json:"is_active"`
+	Target        string                   `json:"target"`
+	IsStaged      bool                     `json:"is_staged"`
+	NeedsApproval bool                     `json:"needs_approval"`
+	ReviewedBy    *int64                   `json:"reviewed_by,omitempty"`
+	ReviewedAt    string                   `json:"reviewed_at,omitempty"`
+	ReviewNote    string                   `json:"review_note,omitempty"`
+	SignedBy      string                   `json:"signed_by,omitempty"`
+	ScheduledAt   string                   `json:"scheduled_at,omitempty"`
+	CreatedAt     string                   `json:"created_at"`
+}
+
+type rollbackRequest struct {
+	DeployID int64 `json:"deploy_id"` // optional, defaults to the previous release
 }
 
 type errorResponse struct {
@@ -118,21 +303,51 @@ func (h *APIHandler) CreateSite(c *gin.Context) {
 			IsEnabled:    existing.IsEnabled,
 			SSLEnabled:   existing.SSLEnabled,
 			FixMimeTypes: existing.FixMimeTypes,
+			Precompress:  existing.Precompress,
+			Type:         existing.Type,
 		})
 		return
 	}
 
+	if req.Type == "" {
+		req.Type = models.SiteTypeStatic
+	}
+	if !req.Type.Valid() {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: services.ErrInvalidSiteType.Error()})
+		return
+	}
+	// Upstreams reach services on the server, which only admins may expose
+	if (req.Type != models.SiteTypeStatic || req.Upstream != nil) && !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "only admin tokens can create proxy and hybrid sites"})
+		return
+	}
+
 	site, err := h.siteService.Create(req.Name, ownerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to create site"})
 		return
 	}
 
-	// Apply fix_mime_types if requested
-	if req.FixMimeTypes {
-		site.FixMimeTypes = true
+	if req.Type != models.SiteTypeStatic || req.Upstream != nil {
+		if _, err := h.proxyService.Set(site, req.Type, req.Upstream.proxyConfig()); err != nil {
+			if delErr := h.siteService.Delete(site.ID); delErr != nil {
+				slog.Error("failed to remove site after invalid upstream", "site_id", site.ID, "error", delErr)
+			}
+			if isProxyInputError(err) {
+				c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save upstream"})
+			return
+		}
+	}
+
+	// Apply fix_mime_types and precompress if requested
+	if req.FixMimeTypes || req.Precompress {
+		site.FixMimeTypes = req.FixMimeTypes
+		site.Precompress = req.Precompress
 		if err := h.siteService.Update(site); err != nil {
-			slog.Error("failed to set fix_mime_types", "site_id", site.ID, "error", err)
+			slog.Error("failed to set site options", "site_id", site.ID, "error", err)
 		}
 	}
 
@@ -162,6 +377,7 @@ func (h *APIHandler) CreateSite(c *gin.Context) {
 	}
 	h.auditService.LogAnonymous(services.ActionSiteCreate, services.EntitySite, map[string]string{
 		"name":      req.Name,
+		"type":      string(site.Type),
 		"api_token": tokenName,
 	}, c.ClientIP())
 
@@ -171,6 +387,8 @@ func (h *APIHandler) CreateSite(c *gin.Context) {
 		IsEnabled:    site.IsEnabled,
 		SSLEnabled:   site.SSLEnabled,
 		FixMimeTypes: site.FixMimeTypes,
+		Precompress:  site.Precompress,
+		Type:         site.Type,
 	})
 }
 
@@ -207,6 +425,8 @@ func (h *APIHandler) ListSites(c *gin.Context) {
 			IsEnabled:    site.IsEnabled,
 			SSLEnabled:   site.SSLEnabled,
 			FixMimeTypes: site.FixMimeTypes,
+			Precompress:  site.Precompress,
+			Type:         site.Type,
 		})
 	}
 
@@ -256,13 +476,37 @@ func (h *APIHandler) GetSite(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, siteResponse{
+	resp := siteResponse{
 		ID:           site.ID,
 		Name:         site.Name,
 		IsEnabled:    site.IsEnabled,
 		SSLEnabled:   site.SSLEnabled,
 		FixMimeTypes: site.FixMimeTypes,
-	})
+		Precompress:  site.Precompress,
+		Type:         site.Type,
+	}
+	if limits, err := h.limitsService.ForSite(site.ID); err == nil {
+		resp.Limits = limits
+	} else {
+		slog.Error("failed to resolve site limits", "site_id", site.ID, "error", err)
+	}
+	if usage, err := h.limitsService.Usage(site.ID); err == nil {
+		resp.DiskUsage = usage
+	} else {
+		slog.Error("failed to measure disk usage", "site_id", site.ID, "error", err)
+	}
+	if site.HasGitSource() {
+		resp.Git = &gitSourceBody{URL: site.GitURL, Branch: site.GitBranch, Subdir: site.GitSubdir}
+	}
+	if site.IsProxied() {
+		if proxy, err := h.proxyService.Get(site.ID); err == nil {
+			resp.Upstream = newUpstreamBody(proxy)
+		} else {
+			slog.Error("failed to load upstream", "site_id", site.ID, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 // DeleteSite deletes a site by ID.
@@ -321,7 +565,17 @@ func (h *APIHandler) DeleteSite(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "site deleted"})
 }
 
-// Deploy uploads and deploys an archive to a site.
+// Deploy uploads an archive and queues it for deployment. The response is
+// 202 with the deploy ID; poll GET /api/v1/deploys/:id for the outcome.
+// With ?wait=true the request returns once the deploy has finished.
+// While another deploy of the site is in progress the response is 409 with
+// its ID, unless ?queue=true asks to run after it. A site with deploy keys
+// requires the signature of the archive in the signature field or the
+// X-Deploy-Signature header. With an RFC 3339 scheduled_at field the
+// archive is stored and deployed at that time. While the site is in a freeze
+// window the response is 423, unless an admin token adds
+// ?override_freeze=true. With ?target=staging the release is served on the
+// staging hostname of the site until promoted.
 // POST /api/v1/sites/:id/deploy
 func (h *APIHandler) Deploy(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
@@ -330,9 +584,10 @@ func (h *APIHandler) Deploy(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
+	file, err := openPostedFile(c, h.uploadService, getTokenUserID(c))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse{Error: "file is required"})
+		status, message := postedFileError(err, "file is required")
+		c.JSON(status, errorResponse{Error: message})
 		return
 	}
 	defer file.Close()
@@ -340,7 +595,7 @@ func (h *APIHandler) Deploy(c *gin.Context) {
 	site, err := h.siteService.GetByID(id)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			fallbackSite, fallbackErr := h.resolveSiteFromFilename(c, id, header.Filename)
+			fallbackSite, fallbackErr := h.resolveSiteFromFilename(c, id, file.Name)
 			if fallbackErr != nil {
 				c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to resolve site"})
 				return
@@ -361,32 +616,70 @@ func (h *APIHandler) Deploy(c *gin.Context) {
 		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
 		return
 	}
+	if !file.forSite(site.ID) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "upload was started for another site"})
+		return
+	}
 
 	// Deploy using token's user ID
 	userID := getTokenUserID(c)
-	deploy, err := h.deployService.Deploy(site.ID, userID, header.Filename, file, header.Size)
-	if err != nil {
-		status := http.StatusInternalServerError
-		errMsg := "deploy failed"
+	wait := c.Query("wait") == "true"
+	queue := c.Query("queue") == "true"
 
-		switch err {
-		case services.ErrArchiveTooLarge:
-			status = http.StatusRequestEntityTooLarge
-			errMsg = "archive too large"
-		case services.ErrTooManyFiles:
-			status = http.StatusBadRequest
-			errMsg = "too many files in archive"
-		case services.ErrUnsupportedArchive:
-			status = http.StatusBadRequest
-			errMsg = "unsupported archive format (use .zip or .tar.gz)"
-		case services.ErrPathTraversal, services.ErrSymlinkDetected:
-			status = http.StatusBadRequest
-			errMsg = "invalid archive content"
+	var scheduledAt time.Time
+	if value := strings.TrimSpace(c.PostForm("scheduled_at")); value != "" {
+		if scheduledAt, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "scheduled_at must be an RFC 3339 time"})
+			return
 		}
+		if wait {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "scheduled_at cannot be combined with wait"})
+			return
+		}
+	}
+
+	target, ok := deployTarget(c.Query("target"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "target must be production or staging"})
+		return
+	}
+	if target == models.DeployTargetStaging && !scheduledAt.IsZero() {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "scheduled deploys always go to production"})
+		return
+	}
+
+	override, ok := h.freezeOverride(c)
+	if !ok {
+		return
+	}
+
+	opts := services.DeployOptions{
+		Signature:      c.PostForm("signature"),
+		Queue:          queue,
+		Target:         target,
+		Approval:       site.HoldsDeploy(false),
+		OverrideFreeze: override,
+	}
+	if opts.Signature == "" {
+		opts.Signature = c.GetHeader("X-Deploy-Signature")
+	}
 
-		c.JSON(status, errorResponse{Error: errMsg})
+	var deploy *models.Deploy
+	switch {
+	case !scheduledAt.IsZero():
+		deploy, err = h.deployService.Schedule(site.ID, userID, file.Name, file, file.Size, scheduledAt, opts)
+	case !h.checkFreeze(c, site.ID, override):
+		return
+	case wait:
+		deploy, err = h.deployService.Deploy(site.ID, userID, file.Name, file, file.Size, opts)
+	default:
+		deploy, err = h.deployService.Enqueue(site.ID, userID, file.Name, file, file.Size, opts)
+	}
+	if err != nil {
+		writeDeployError(c, err)
 		return
 	}
+	file.used(h.uploadService)
 
 	// Log via audit
 	token := middleware.GetAPIToken(c)
@@ -395,50 +688,2135 @@ func (h *APIHandler) Deploy(c *gin.Context) {
 		tokenName = token.Name
 	}
 	h.auditService.LogAnonymous(services.ActionDeploy, services.EntitySite, map[string]string{
-		"site_name": site.Name,
-		"filename":  header.Filename,
-		"api_token": tokenName,
+		"site_name":       site.Name,
+		"filename":        file.Name,
+		"deploy_id":       strconv.FormatInt(deploy.ID, 10),
+		"signed_by":       deploy.SignedBy,
+		"scheduled_at":    formatScheduledAt(deploy),
+		"override_freeze": strconv.FormatBool(override),
+		"needs_approval":  strconv.FormatBool(deploy.NeedsApproval),
+		"api_token":       tokenName,
 	}, c.ClientIP())
 
-	c.JSON(http.StatusOK, deployResponse{
-		DeployID: deploy.ID,
-		Status:   string(deploy.Status),
+	status := http.StatusAccepted
+	if wait {
+		status = http.StatusOK
+	}
+	c.JSON(status, deployResponse{
+		DeployID:    deploy.ID,
+		Status:      string(deploy.Status),
+		Phase:       string(deploy.Phase),
+		ScheduledAt: formatScheduledAt(deploy),
 	})
 }
 
-func (h *APIHandler) resolveSiteFromFilename(c *gin.Context, requestedID int64, filename string) (*models.Site, error) {
-	domain, ok := inferSiteDomainFromArchive(filename)
-	if !ok {
-		return nil, nil
+// SetGitSource links a site to a git repository, or unlinks it when the URL
+// is empty. Only admins may link repositories on the server's filesystem.
+// PUT /api/v1/sites/:id/git
+func (h *APIHandler) SetGitSource(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
 	}
 
-	site, err := h.siteService.GetByName(domain)
+	var req gitSourceBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
-			return nil, nil
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
 		}
-		return nil, err
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
 	}
 
 	if !h.canAccessSite(c, site) {
-		return nil, nil
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
 	}
 
-	slog.Warn("deploy site id fallback used",
-		"requested_site_id", requestedID,
-		"resolved_site_id", site.ID,
-		"domain", domain,
-	)
-	return site, nil
+	if err := h.siteService.SetGitSource(site, strings.TrimSpace(req.URL), strings.TrimSpace(req.Branch), strings.TrimSpace(req.Subdir), h.isTokenAdmin(c)); err != nil {
+		if errors.Is(err, services.ErrLocalGitSource) {
+			c.JSON(http.StatusForbidden, errorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionGitSource, services.EntitySite, map[string]string{
+		"site_name":  site.Name,
+		"git_url":    site.GitURL,
+		"git_branch": site.GitBranch,
+		"git_subdir": site.GitSubdir,
+		"api_token":  tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, gitSourceBody{URL: site.GitURL, Branch: site.GitBranch, Subdir: site.GitSubdir})
 }
 
-func inferSiteDomainFromArchive(filename string) (string, bool) {
-	base := strings.TrimSuffix(filename, ".zip")
-	if base == filename {
-		base = strings.TrimSuffix(filename, ".tgz")
+// healthCheckBody is a request run against the site after each deploy.
+type healthCheckBody struct {
+	Path           string `json:"path"`
+	ExpectedStatus int    `json:"expected_status,omitempty"` // defaults to 200
+	BodyContains   string `json:"body_contains,omitempty"`
+}
+
+type healthChecksRequest struct {
+	Checks []healthCheckBody `json:"checks"`
+}
+
+type healthChecksResponse struct {
+	Checks []healthCheckBody `json:"checks"`
+}
+
+func toHealthCheckBodies(checks []*models.HealthCheck) []healthCheckBody {
+	bodies := make([]healthCheckBody, 0, len(checks))
+	for _, check := range checks {
+		bodies = append(bodies, healthCheckBody{Path: check.Path, ExpectedStatus: check.ExpectedStatus, BodyContains: check.BodyContains})
 	}
-	if base == filename {
-		base = strings.TrimSuffix(filename, ".tar.gz")
+	return bodies
+}
+
+// siteForRequest loads the site of the :id parameter and checks the token
+// may access it, writing the error response when it fails.
+func (h *APIHandler) siteForRequest(c *gin.Context) (*models.Site, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return nil, false
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return nil, false
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return nil, false
+	}
+	return site, true
+}
+
+// ListHealthChecks returns the checks run after each deploy of a site.
+// GET /api/v1/sites/:id/health-checks
+func (h *APIHandler) ListHealthChecks(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	checks, err := h.healthService.ListBySite(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load health checks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, healthChecksResponse{Checks: toHealthCheckBodies(checks)})
+}
+
+// SetHealthChecks replaces the checks run after each deploy of a site. An
+// empty list turns them off.
+// PUT /api/v1/sites/:id/health-checks
+func (h *APIHandler) SetHealthChecks(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req healthChecksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	checks := make([]*models.HealthCheck, 0, len(req.Checks))
+	for _, body := range req.Checks {
+		checks = append(checks, &models.HealthCheck{Path: body.Path, ExpectedStatus: body.ExpectedStatus, BodyContains: body.BodyContains})
+	}
+
+	if err := h.healthService.Replace(site.ID, checks); err != nil {
+		if errors.Is(err, services.ErrInvalidCheckPath) || errors.Is(err, services.ErrInvalidCheckStatus) || errors.Is(err, services.ErrTooManyChecks) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save health checks"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionHealthChecks, services.EntitySite, map[string]interface{}{
+		"site_name": site.Name,
+		"checks":    len(checks),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, healthChecksResponse{Checks: toHealthCheckBodies(checks)})
+}
+
+// deployKeyBody is a public key that signs the deploy archives of a site.
+type deployKeyBody struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	Scope       string `json:"scope"` // "site", or "user" for a key of the site owner
+	CreatedAt   string `json:"created_at"`
+}
+
+func newDeployKeyBody(key *models.DeployKey) deployKeyBody {
+	scope := "site"
+	if key.UserID != nil {
+		scope = "user"
+	}
+	return deployKeyBody{
+		ID:          key.ID,
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		Scope:       scope,
+		CreatedAt:   key.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// ListDeployKeys returns the keys whose signatures a site accepts: its own
+// and those of its owner. An empty list means unsigned archives are accepted.
+// Keys are only managed in the panel, so a leaked token cannot replace them.
+// GET /api/v1/sites/:id/deploy-keys
+func (h *APIHandler) ListDeployKeys(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	keys, err := h.keyService.ListForSite(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load deploy keys"})
+		return
+	}
+
+	bodies := make([]deployKeyBody, 0, len(keys))
+	for _, key := range keys {
+		bodies = append(bodies, newDeployKeyBody(key))
+	}
+	c.JSON(http.StatusOK, bodies)
+}
+
+// isTokenAdmin reports whether the user of the API token is an admin.
+func (h *APIHandler) isTokenAdmin(c *gin.Context) bool {
+	user, err := h.userRepo.GetByID(getTokenUserID(c))
+	return err == nil && user.IsAdmin()
+}
+
+// freezeOverride reads ?override_freeze=true, which only admin tokens may
+// send. It writes the error response and reports false when it is refused.
+func (h *APIHandler) freezeOverride(c *gin.Context) (bool, bool) {
+	if c.Query("override_freeze") != "true" {
+		return false, true
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "only admins can override a deploy freeze"})
+		return false, false
+	}
+	return true, true
+}
+
+// checkFreeze refuses a deploy starting now while the site is in a freeze
+// window, unless the freeze is overridden. It writes the error response and
+// reports false when the deploy must not go ahead.
+func (h *APIHandler) checkFreeze(c *gin.Context, siteID int64, override bool) bool {
+	if override {
+		return true
+	}
+	if err := h.deployService.CheckFreeze(siteID, time.Now()); err != nil {
+		writeDeployError(c, err)
+		return false
+	}
+	return true
+}
+
+// freezeWindowBody is a period during which a site takes no deploys.
+type freezeWindowBody struct {
+	ID       int64  `json:"id,omitempty"`
+	StartsAt string `json:"starts_at"`
+	EndsAt   string `json:"ends_at"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func newFreezeWindowBody(window *models.FreezeWindow) freezeWindowBody {
+	return freezeWindowBody{
+		ID:       window.ID,
+		StartsAt: window.StartsAt.Format(time.RFC3339),
+		EndsAt:   window.EndsAt.Format(time.RFC3339),
+		Reason:   window.Reason,
+	}
+}
+
+// ListFreezeWindows returns the freeze windows of a site that are not over.
+// GET /api/v1/sites/:id/freeze-windows
+func (h *APIHandler) ListFreezeWindows(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	windows, err := h.freezeService.ListBySite(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load freeze windows"})
+		return
+	}
+
+	bodies := make([]freezeWindowBody, 0, len(windows))
+	for _, window := range windows {
+		bodies = append(bodies, newFreezeWindowBody(window))
+	}
+	c.JSON(http.StatusOK, bodies)
+}
+
+// CreateFreezeWindow adds a freeze window to a site. Admin tokens only.
+// POST /api/v1/sites/:id/freeze-windows
+func (h *APIHandler) CreateFreezeWindow(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "only admins can manage freeze windows"})
+		return
+	}
+
+	var req freezeWindowBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+	startsAt, err := time.Parse(time.RFC3339, req.StartsAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "starts_at must be an RFC 3339 time"})
+		return
+	}
+	endsAt, err := time.Parse(time.RFC3339, req.EndsAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "ends_at must be an RFC 3339 time"})
+		return
+	}
+
+	window, err := h.freezeService.Create(site.ID, startsAt, endsAt, req.Reason)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFreezeWindow) || errors.Is(err, services.ErrFreezeWindowOver) ||
+			errors.Is(err, services.ErrFreezeReasonTooLong) || errors.Is(err, services.ErrTooManyFreezeWindows) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save freeze window"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionFreezeAdd, services.EntityFreezeWindow, map[string]string{
+		"site_name": site.Name,
+		"starts_at": req.StartsAt,
+		"ends_at":   req.EndsAt,
+		"reason":    window.Reason,
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusCreated, newFreezeWindowBody(window))
+}
+
+// DeleteFreezeWindow removes a freeze window of a site. Admin tokens only.
+// DELETE /api/v1/sites/:id/freeze-windows/:windowId
+func (h *APIHandler) DeleteFreezeWindow(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "only admins can manage freeze windows"})
+		return
+	}
+
+	windowID, err := strconv.ParseInt(c.Param("windowId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid freeze window ID"})
+		return
+	}
+	window, err := h.freezeService.GetByID(windowID)
+	if err != nil || window.SiteID != site.ID {
+		c.JSON(http.StatusNotFound, errorResponse{Error: "freeze window not found"})
+		return
+	}
+
+	if err := h.freezeService.Delete(window.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to delete freeze window"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionFreezeDel, services.EntityFreezeWindow, map[string]string{
+		"site_name": site.Name,
+		"window_id": strconv.FormatInt(window.ID, 10),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{"message": "freeze window deleted"})
+}
+
+type sharedPathsBody struct {
+	Paths []string `json:"paths"`
+}
+
+// ListSharedPaths returns the directories of a site kept across deploys.
+// GET /api/v1/sites/:id/shared-paths
+func (h *APIHandler) ListSharedPaths(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	paths, err := h.deployService.SharedPaths(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load shared paths"})
+		return
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+
+	c.JSON(http.StatusOK, sharedPathsBody{Paths: paths})
+}
+
+// SetSharedPaths replaces the directories of a site kept across deploys.
+// PUT /api/v1/sites/:id/shared-paths
+func (h *APIHandler) SetSharedPaths(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req sharedPathsBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	paths, err := h.deployService.SetSharedPaths(site.ID, req.Paths)
+	if err != nil {
+		var inProgress *services.DeployInProgressError
+		if errors.As(err, &inProgress) {
+			c.JSON(http.StatusConflict, deployConflictResponse{Error: err.Error(), DeployID: inProgress.DeployID})
+			return
+		}
+
+		switch {
+		case errors.Is(err, services.ErrInvalidSharedPath), errors.Is(err, services.ErrSharedPathOverlap),
+			errors.Is(err, services.ErrSharedPathNotDir), errors.Is(err, services.ErrTooManySharedPaths):
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrSiteBusy):
+			c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+		default:
+			slog.Error("failed to save shared paths via API", "site_id", site.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save shared paths"})
+		}
+		return
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionSharedPaths, services.EntitySite, map[string]interface{}{
+		"site_name": site.Name,
+		"paths":     paths,
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, sharedPathsBody{Paths: paths})
+}
+
+// DeployGit deploys the head of the branch the site is linked to. Accepts
+// the same wait, queue, override_freeze and target parameters as Deploy.
+// POST /api/v1/sites/:id/deploy/git
+func (h *APIHandler) DeployGit(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	target, ok := deployTarget(c.Query("target"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "target must be production or staging"})
+		return
+	}
+
+	override, ok := h.freezeOverride(c)
+	if !ok || !h.checkFreeze(c, site.ID, override) {
+		return
+	}
+
+	userID := getTokenUserID(c)
+	wait := c.Query("wait") == "true"
+	opts := services.DeployOptions{Queue: c.Query("queue") == "true", Target: target, Approval: site.HoldsDeploy(false)}
+
+	var deploy *models.Deploy
+	if wait {
+		deploy, err = h.deployService.DeployGit(site.ID, userID, opts)
+	} else {
+		deploy, err = h.deployService.EnqueueGit(site.ID, userID, opts)
+	}
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionDeploy, services.EntitySite, map[string]string{
+		"site_name":       site.Name,
+		"filename":        deploy.Filename,
+		"git_url":         site.GitURL,
+		"deploy_id":       strconv.FormatInt(deploy.ID, 10),
+		"signed_by":       deploy.SignedBy,
+		"override_freeze": strconv.FormatBool(override),
+		"needs_approval":  strconv.FormatBool(deploy.NeedsApproval),
+		"api_token":       tokenName,
+	}, c.ClientIP())
+
+	status := http.StatusAccepted
+	if wait {
+		status = http.StatusOK
+	}
+	c.JSON(status, deployResponse{
+		DeployID: deploy.ID,
+		Status:   string(deploy.Status),
+		Phase:    string(deploy.Phase),
+	})
+}
+
+// remoteDeployRequest is an archive for the server to download: a URL with
+// the SHA-256 of the archive, or an object of an S3-compatible bucket.
+type remoteDeployRequest struct {
+	URL       string `json:"url"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// DeployRemote deploys an archive the server downloads from a URL or an
+// S3-compatible bucket. Accepts the same wait, queue, override_freeze and
+// target parameters as Deploy.
+// POST /api/v1/sites/:id/deploy/remote
+func (h *APIHandler) DeployRemote(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req remoteDeployRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	target, ok := deployTarget(c.Query("target"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "target must be production or staging"})
+		return
+	}
+
+	override, ok := h.freezeOverride(c)
+	if !ok || !h.checkFreeze(c, site.ID, override) {
+		return
+	}
+
+	userID := getTokenUserID(c)
+	wait := c.Query("wait") == "true"
+	opts := services.DeployOptions{Queue: c.Query("queue") == "true", Target: target, Approval: site.HoldsDeploy(false)}
+	src := services.RemoteSource{
+		URL:       req.URL,
+		Bucket:    req.Bucket,
+		Key:       req.Key,
+		SHA256:    req.SHA256,
+		Signature: req.Signature,
+	}
+
+	var deploy *models.Deploy
+	var err error
+	if wait {
+		deploy, err = h.deployService.DeployRemote(site.ID, userID, src, opts)
+	} else {
+		deploy, err = h.deployService.EnqueueRemote(site.ID, userID, src, opts)
+	}
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionDeploy, services.EntitySite, map[string]string{
+		"site_name":       site.Name,
+		"filename":        deploy.Filename,
+		"source":          src.String(),
+		"deploy_id":       strconv.FormatInt(deploy.ID, 10),
+		"signed_by":       deploy.SignedBy,
+		"override_freeze": strconv.FormatBool(override),
+		"needs_approval":  strconv.FormatBool(deploy.NeedsApproval),
+		"api_token":       tokenName,
+	}, c.ClientIP())
+
+	status := http.StatusAccepted
+	if wait {
+		status = http.StatusOK
+	}
+	c.JSON(status, deployResponse{
+		DeployID: deploy.ID,
+		Status:   string(deploy.Status),
+		Phase:    string(deploy.Phase),
+	})
+}
+
+// s3CredentialsBody is the object storage account of a site. The secret is
+// accepted but never returned.
+type s3CredentialsBody struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	PathStyle       *bool  `json:"path_style,omitempty"` // defaults to true
+}
+
+func newS3CredentialsBody(creds *models.S3Credentials) s3CredentialsBody {
+	return s3CredentialsBody{
+		Endpoint:    creds.Endpoint,
+		Region:      creds.Region,
+		AccessKeyID: creds.AccessKeyID,
+		PathStyle:   &creds.PathStyle,
+	}
+}
+
+// GetS3Credentials returns the S3 credentials of a site without the secret.
+// GET /api/v1/sites/:id/s3-credentials
+func (h *APIHandler) GetS3Credentials(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	creds, err := h.s3Service.Get(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load S3 credentials"})
+		return
+	}
+	if creds == nil {
+		c.JSON(http.StatusNotFound, errorResponse{Error: "site has no S3 credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newS3CredentialsBody(creds))
+}
+
+// SetS3Credentials stores the S3 credentials of a site. Without a secret,
+// the one stored is kept.
+// PUT /api/v1/sites/:id/s3-credentials
+func (h *APIHandler) SetS3Credentials(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req s3CredentialsBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	creds := &models.S3Credentials{
+		SiteID:          site.ID,
+		Endpoint:        req.Endpoint,
+		Region:          req.Region,
+		AccessKeyID:     req.AccessKeyID,
+		SecretAccessKey: req.SecretAccessKey,
+		PathStyle:       req.PathStyle == nil || *req.PathStyle,
+	}
+	if err := h.s3Service.Set(creds); err != nil {
+		if errors.Is(err, services.ErrInvalidS3Endpoint) || errors.Is(err, services.ErrInvalidS3Region) ||
+			errors.Is(err, services.ErrS3AccessKey) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save S3 credentials via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save S3 credentials"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionS3Credentials, services.EntitySite, map[string]string{
+		"site_name":     site.Name,
+		"endpoint":      creds.Endpoint,
+		"access_key_id": creds.AccessKeyID,
+		"api_token":     tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, newS3CredentialsBody(creds))
+}
+
+// DeleteS3Credentials removes the S3 credentials of a site.
+// DELETE /api/v1/sites/:id/s3-credentials
+func (h *APIHandler) DeleteS3Credentials(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.s3Service.Delete(site.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to delete S3 credentials"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionS3CredsDelete, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{"message": "S3 credentials deleted"})
+}
+
+// stagingRequest sets up the staging slot of a site. Without a password the
+// one stored is kept.
+type stagingRequest struct {
+	Hostname     string   `json:"hostname"` // defaults to staging.<site>
+	AllowedIPs   []string `json:"allowed_ips"`
+	AuthUser     string   `json:"auth_user"`
+	AuthPassword string   `json:"auth_password"`
+}
+
+type stagingResponse struct {
+	Hostname     string              `json:"hostname"`
+	AllowedIPs   []string            `json:"allowed_ips"`
+	AuthUser     string              `json:"auth_user,omitempty"`
+	StagedDeploy *deployInfoResponse `json:"staged_deploy"`
+}
+
+func (h *APIHandler) newStagingResponse(site *models.Site, slot *models.StagingSlot) stagingResponse {
+	resp := stagingResponse{
+		Hostname:   slot.PreviewHostname(site.Name),
+		AllowedIPs: slot.AllowedIPs,
+		AuthUser:   slot.AuthUser,
+	}
+	if resp.AllowedIPs == nil {
+		resp.AllowedIPs = []string{}
+	}
+	if staged, err := h.deployService.GetStagedDeploy(site.ID); err == nil && staged != nil {
+		info := newDeployInfoResponse(staged)
+		resp.StagedDeploy = &info
+	}
+	return resp
+}
+
+// GetStaging returns the staging slot of a site and the deploy it serves.
+// GET /api/v1/sites/:id/staging
+func (h *APIHandler) GetStaging(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	slot, err := h.stagingService.Get(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load staging slot"})
+		return
+	}
+	if slot == nil {
+		c.JSON(http.StatusNotFound, errorResponse{Error: "site has no staging slot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.newStagingResponse(site, slot))
+}
+
+// SetStaging sets up the staging slot of a site and serves its preview
+// hostname. The slot needs basic auth, an IP allowlist or both.
+// PUT /api/v1/sites/:id/staging
+func (h *APIHandler) SetStaging(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req stagingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	slot, err := h.stagingService.Set(site, req.Hostname, req.AllowedIPs, req.AuthUser, req.AuthPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrStagingHostTaken) {
+			c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+			return
+		}
+		if isStagingInputError(err) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save staging slot via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save staging slot"})
+		return
+	}
+
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		slog.Error("failed to apply nginx config after saving staging slot", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to apply nginx config"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionStagingUpdate, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"hostname":  slot.PreviewHostname(site.Name),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, h.newStagingResponse(site, slot))
+}
+
+// DeleteStaging removes the staging slot of a site. Its preview hostname is
+// no longer served and the staged release is pruned like any other.
+// DELETE /api/v1/sites/:id/staging
+func (h *APIHandler) DeleteStaging(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.deployService.DisableStaging(site.ID); err != nil {
+		if isSiteBusy(err) {
+			writeDeployError(c, err)
+			return
+		}
+		slog.Error("failed to delete staging slot via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to delete staging slot"})
+		return
+	}
+
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		slog.Error("failed to apply nginx config after deleting staging slot", "site_id", site.ID, "error", err)
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionStagingDelete, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{"message": "staging slot deleted"})
+}
+
+// PromoteStaging makes the release served on the staging hostname the
+// production release of the site, without uploading it again. While the
+// site is in a freeze window the response is 423, unless an admin token
+// adds ?override_freeze=true.
+// POST /api/v1/sites/:id/staging/promote
+func (h *APIHandler) PromoteStaging(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	override, ok := h.freezeOverride(c)
+	if !ok || !h.checkFreeze(c, site.ID, override) {
+		return
+	}
+
+	deploy, err := h.deployService.Promote(site.ID)
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionPromote, services.EntitySite, map[string]string{
+		"site_name":       site.Name,
+		"deploy_id":       strconv.FormatInt(deploy.ID, 10),
+		"override_freeze": strconv.FormatBool(override),
+		"api_token":       tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, newDeployInfoResponse(deploy))
+}
+
+// isStagingInputError reports whether a staging slot was refused for its settings.
+func isStagingInputError(err error) bool {
+	return errors.Is(err, services.ErrInvalidPreviewHost) || errors.Is(err, services.ErrInvalidStagingUser) ||
+		errors.Is(err, services.ErrInvalidAllowedIP) || errors.Is(err, services.ErrTooManyAllowedIPs) ||
+		errors.Is(err, services.ErrStagingPassword) || errors.Is(err, services.ErrStagingUnprotected)
+}
+
+func isProxyInputError(err error) bool {
+	return errors.Is(err, services.ErrInvalidSiteType) || errors.Is(err, services.ErrNoUpstream) ||
+		errors.Is(err, services.ErrTooManyUpstreams) || errors.Is(err, services.ErrInvalidProxyTimeout) ||
+		errors.Is(err, services.ErrTooManyPassHeaders) || errors.Is(err, validators.ErrInvalidUpstream) ||
+		errors.Is(err, validators.ErrInvalidHeaderName)
+}
+
+// proxyBody is how a site is served and the upstream of proxy and hybrid sites.
+type proxyBody struct {
+	Type     models.SiteType `json:"type"`     // left out to keep the current type
+	Upstream *upstreamBody   `json:"upstream"` // left out to keep the stored upstream
+}
+
+// GetProxy returns the type of a site and its upstream.
+// GET /api/v1/sites/:id/proxy
+func (h *APIHandler) GetProxy(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	proxy, err := h.proxyService.Get(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load upstream"})
+		return
+	}
+	c.JSON(http.StatusOK, proxyBody{Type: site.Type, Upstream: newUpstreamBody(proxy)})
+}
+
+// SetProxy changes the type of a site and its upstream (admin tokens only).
+// PUT /api/v1/sites/:id/proxy
+func (h *APIHandler) SetProxy(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "admin access required"})
+		return
+	}
+
+	var req proxyBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = site.Type
+	}
+
+	proxy, err := h.proxyService.Set(site, req.Type, req.Upstream.proxyConfig())
+	if err != nil {
+		if isProxyInputError(err) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save upstream via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save upstream"})
+		return
+	}
+
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		slog.Error("failed to apply nginx config after saving upstream", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to apply nginx config"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	details := map[string]string{
+		"site_name": site.Name,
+		"type":      string(site.Type),
+		"api_token": tokenName,
+	}
+	if proxy != nil {
+		details["upstreams"] = strings.Join(proxy.Upstreams, ",")
+	}
+	h.auditService.LogAnonymous(services.ActionProxyUpdate, services.EntitySite, details, c.ClientIP())
+
+	c.JSON(http.StatusOK, proxyBody{Type: site.Type, Upstream: newUpstreamBody(proxy)})
+}
+
+// pagesBody is how a site answers paths without a file and errors.
+type pagesBody struct {
+	SPAFallback  bool   `json:"spa_fallback"`
+	ErrorPage404 string `json:"error_page_404"` // path from the site root, "" for the nginx page
+	ErrorPage403 string `json:"error_page_403"`
+	ErrorPage50x string `json:"error_page_50x"`
+}
+
+func newPagesBody(pages models.SitePages) pagesBody {
+	return pagesBody{
+		SPAFallback:  pages.SPAFallback,
+		ErrorPage404: pages.ErrorPage404,
+		ErrorPage403: pages.ErrorPage403,
+		ErrorPage50x: pages.ErrorPage50x,
+	}
+}
+
+// isPageInputError reports whether err comes from invalid pages rather
+// than from saving them.
+func isPageInputError(err error) bool {
+	return errors.Is(err, services.ErrInvalidErrorPage) || errors.Is(err, services.ErrErrorPageNotFound) ||
+		errors.Is(err, services.ErrSPAFallbackType) || errors.Is(err, services.ErrSPAIndexNotFound)
+}
+
+// GetPages returns the SPA fallback and error pages of a site.
+// GET /api/v1/sites/:id/pages
+func (h *APIHandler) GetPages(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, newPagesBody(site.Pages()))
+}
+
+// SetPages replaces the SPA fallback and error pages of a site. Pages must
+// be files of the live release.
+// PUT /api/v1/sites/:id/pages
+func (h *APIHandler) SetPages(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req pagesBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	pages, err := h.pageService.Set(site, models.SitePages{
+		SPAFallback:  req.SPAFallback,
+		ErrorPage404: req.ErrorPage404,
+		ErrorPage403: req.ErrorPage403,
+		ErrorPage50x: req.ErrorPage50x,
+	})
+	if err != nil {
+		if isPageInputError(err) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save pages via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save pages"})
+		return
+	}
+
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		slog.Error("failed to apply nginx config after saving pages", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to apply nginx config"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionPagesUpdate, services.EntitySite, map[string]interface{}{
+		"site_name":      site.Name,
+		"spa_fallback":   pages.SPAFallback,
+		"error_page_404": pages.ErrorPage404,
+		"error_page_403": pages.ErrorPage403,
+		"error_page_50x": pages.ErrorPage50x,
+		"api_token":      tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, newPagesBody(pages))
+}
+
+// headerRulesBody is the ordered response header rules of a site.
+type headerRulesBody struct {
+	Rules []headerRuleBody `json:"rules"`
+}
+
+type headerRuleBody struct {
+	ID        int64                   `json:"id,omitempty"`
+	Preset    string                  `json:"preset,omitempty"` // request only, the rule of a preset
+	MatchType models.HeaderMatchType  `json:"match_type,omitempty"`
+	Match     string                  `json:"match,omitempty"`
+	Headers   []models.ResponseHeader `json:"headers,omitempty"`
+}
+
+func newHeaderRulesBody(rules []*models.HeaderRule) headerRulesBody {
+	body := headerRulesBody{Rules: make([]headerRuleBody, len(rules))}
+	for i, rule := range rules {
+		body.Rules[i] = headerRuleBody{ID: rule.ID, MatchType: rule.MatchType, Match: rule.Match, Headers: rule.Headers}
+	}
+	return body
+}
+
+// ListHeaderRules returns the response header rules of a site in order.
+// GET /api/v1/sites/:id/header-rules
+func (h *APIHandler) ListHeaderRules(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	rules, err := h.headerService.ListBySite(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load header rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newHeaderRulesBody(rules))
+}
+
+// SetHeaderRules replaces the response header rules of a site. A rule may
+// name a preset instead of giving its match and headers.
+// PUT /api/v1/sites/:id/header-rules
+func (h *APIHandler) SetHeaderRules(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req headerRulesBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	rules := make([]*models.HeaderRule, len(req.Rules))
+	for i, r := range req.Rules {
+		if r.Preset != "" {
+			rule, err := services.HeaderRulePresetRule(r.Preset)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			rules[i] = rule
+			continue
+		}
+		rules[i] = &models.HeaderRule{MatchType: r.MatchType, Match: r.Match, Headers: r.Headers}
+	}
+
+	if err := h.headerService.Replace(site.ID, rules); err != nil {
+		if isHeaderRuleInputError(err) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save header rules via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save header rules"})
+		return
+	}
+
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		slog.Error("failed to apply nginx config after saving header rules", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to apply nginx config"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionHeaderRules, services.EntitySite, map[string]interface{}{
+		"site_name": site.Name,
+		"rules":     len(rules),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, newHeaderRulesBody(rules))
+}
+
+// GetNginxSnippets returns the custom nginx snippets of a site by hook.
+// GET /api/v1/sites/:id/nginx-snippets
+func (h *APIHandler) GetNginxSnippets(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	snippets, err := h.snippetService.Get(site.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load snippets"})
+		return
+	}
+	c.JSON(http.StatusOK, snippets)
+}
+
+// SetNginxSnippets replaces the custom nginx snippets of a site; hooks left
+// out have none. Snippets nginx rejects are not kept.
+// PUT /api/v1/sites/:id/nginx-snippets
+func (h *APIHandler) SetNginxSnippets(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "admin access required"})
+		return
+	}
+
+	var req map[models.SnippetHook]string
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	snippets, err := h.snippetService.Set(site, req)
+	if err != nil && snippets == nil {
+		if isSnippetInputError(err) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		slog.Error("failed to save nginx snippets via API", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save snippets"})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to apply nginx config after saving snippets", "site_id", site.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to apply nginx config"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionSnippetsUpdate, services.EntitySite, map[string]interface{}{
+		"site_name": site.Name,
+		"hooks":     snippetHooks(snippets),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, snippets)
+}
+
+// approvalBody is who has to approve the deploys of a site.
+type approvalBody struct {
+	RequireApproval bool           `json:"require_approval"`
+	Approvers       []approverBody `json:"approvers"`
+}
+
+type approverBody struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// approvalRequest changes the approval settings of a site; fields left out
+// are kept.
+type approvalRequest struct {
+	RequireApproval *bool     `json:"require_approval"`
+	Approvers       *[]string `json:"approvers"` // emails of the users allowed to approve
+}
+
+// reviewRequest is the note an approver leaves with a decision.
+type reviewRequest struct {
+	Note string `json:"note"`
+}
+
+// maxReviewNote bounds the note left with an approval or rejection.
+const maxReviewNote = 1000
+
+func (h *APIHandler) newApprovalBody(site *models.Site) (approvalBody, error) {
+	approvers, err := h.approvalService.ListApprovers(site.ID)
+	if err != nil {
+		return approvalBody{}, err
+	}
+	body := approvalBody{RequireApproval: site.RequireApproval, Approvers: []approverBody{}}
+	for _, a := range approvers {
+		body.Approvers = append(body.Approvers, approverBody{UserID: a.UserID, Email: a.Email})
+	}
+	return body, nil
+}
+
+// GetApproval returns whether deploys of a site wait for approval and who
+// may approve them besides the admins.
+// GET /api/v1/sites/:id/approval
+func (h *APIHandler) GetApproval(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+
+	body, err := h.newApprovalBody(site)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load approvers"})
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// SetApproval turns the approval of deploys of a site on or off and sets its
+// approvers (admin tokens only).
+// PUT /api/v1/sites/:id/approval
+func (h *APIHandler) SetApproval(c *gin.Context) {
+	site, ok := h.siteForRequest(c)
+	if !ok {
+		return
+	}
+	if !h.isTokenAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "admin access required"})
+		return
+	}
+
+	var req approvalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if req.Approvers != nil {
+		if _, err := h.approvalService.SetApprovers(site.ID, *req.Approvers); err != nil {
+			if errors.Is(err, services.ErrApproverNotFound) || errors.Is(err, services.ErrTooManyApprovers) {
+				c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			slog.Error("failed to save approvers via API", "site_id", site.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to save approvers"})
+			return
+		}
+	}
+	if req.RequireApproval != nil {
+		if err := h.approvalService.SetRequired(site, *req.RequireApproval); err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to update site"})
+			return
+		}
+	}
+
+	body, err := h.newApprovalBody(site)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load approvers"})
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	emails := make([]string, 0, len(body.Approvers))
+	for _, a := range body.Approvers {
+		emails = append(emails, a.Email)
+	}
+	h.auditService.LogAnonymous(services.ActionApprovalUpdate, services.EntitySite, map[string]string{
+		"site_name":        site.Name,
+		"require_approval": strconv.FormatBool(site.RequireApproval),
+		"approvers":        strings.Join(emails, ","),
+		"api_token":        tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, body)
+}
+
+// heldDeployForRequest loads the deploy of the request, its site and the user
+// of the token. With approver set the user must be allowed to approve the
+// deploys of the site, otherwise access to the site is enough.
+func (h *APIHandler) heldDeployForRequest(c *gin.Context, approver bool) (*models.Deploy, *models.Site, *models.User, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid deploy ID"})
+		return nil, nil, nil, false
+	}
+
+	userID, ok := requireTokenUserID(c)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return nil, nil, nil, false
+	}
+
+	deploy, err := h.deployService.GetDeploy(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "deploy not found"})
+			return nil, nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load deploy"})
+		return nil, nil, nil, false
+	}
+
+	site, err := h.siteService.GetByID(deploy.SiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return nil, nil, nil, false
+	}
+
+	canApprove := h.approvalService.CanApprove(site, user)
+	if !canApprove && (approver || !h.canAccessSite(c, site)) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return nil, nil, nil, false
+	}
+	return deploy, site, user, true
+}
+
+// ReviewDeploy lists the files a deploy awaiting approval changes compared
+// with the release it would replace. Accepts the same content parameter as
+// DiffReleases.
+// GET /api/v1/deploys/:id/review
+func (h *APIHandler) ReviewDeploy(c *gin.Context) {
+	deploy, site, _, ok := h.heldDeployForRequest(c, false)
+	if !ok {
+		return
+	}
+
+	diff, err := h.deployService.ReviewRelease(site.ID, deploy.ID, c.Query("content") != "false")
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotAwaitingApproval):
+			c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrReleaseNotFound):
+			c.JSON(http.StatusNotFound, errorResponse{Error: "release not available"})
+		default:
+			slog.Error("release review failed via API", "site_id", site.ID, "deploy_id", deploy.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to compare releases"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// ApproveDeploy lets a deploy awaiting approval go live. The token must
+// belong to an approver of the site other than the user who made the
+// deploy. While the site is in a freeze window the response is 423, unless
+// an admin token adds ?override_freeze=true.
+// POST /api/v1/deploys/:id/approve
+func (h *APIHandler) ApproveDeploy(c *gin.Context) {
+	h.reviewDeploy(c, true)
+}
+
+// RejectDeploy turns down a deploy awaiting approval and removes its release.
+// POST /api/v1/deploys/:id/reject
+func (h *APIHandler) RejectDeploy(c *gin.Context) {
+	h.reviewDeploy(c, false)
+}
+
+func (h *APIHandler) reviewDeploy(c *gin.Context, approve bool) {
+	deploy, site, user, ok := h.heldDeployForRequest(c, true)
+	if !ok {
+		return
+	}
+
+	var req reviewRequest
+	_ = c.ShouldBindJSON(&req) // body is optional
+	note := strings.TrimSpace(req.Note)
+	if len(note) > maxReviewNote {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "note must be at most " + strconv.Itoa(maxReviewNote) + " characters"})
+		return
+	}
+
+	action := services.ActionDeployReject
+	override := false
+	var err error
+	if approve {
+		action = services.ActionDeployApprove
+		if override, ok = h.freezeOverride(c); !ok || !h.checkFreeze(c, site.ID, override) {
+			return
+		}
+		deploy, err = h.deployService.Approve(site.ID, deploy.ID, user.ID, note)
+	} else {
+		deploy, err = h.deployService.Reject(site.ID, deploy.ID, user.ID, note)
+	}
+
+	// An approved release that failed its health checks is still logged
+	if err != nil && (deploy == nil || deploy.Status != models.DeployStatusFailed) {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	details := map[string]string{
+		"site_name":   site.Name,
+		"deploy_id":   strconv.FormatInt(deploy.ID, 10),
+		"deployed_by": strconv.FormatInt(deploy.UserID, 10),
+		"reviewer":    user.Email,
+		"note":        note,
+		"api_token":   tokenName,
+	}
+	if approve {
+		details["override_freeze"] = strconv.FormatBool(override)
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	h.auditService.LogUser(user.ID, action, services.EntityDeploy, &deploy.ID, details, c.ClientIP())
+
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newDeployInfoResponse(deploy))
+}
+
+// DeployManifest starts an incremental deploy from a manifest of paths and
+// SHA-256 hashes and answers with the files that have to be uploaded.
+// Accepts the same queue and override_freeze parameters as Deploy.
+// POST /api/v1/sites/:id/deploy/manifest
+func (h *APIHandler) DeployManifest(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
+	}
+
+	var req manifestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	override, ok := h.freezeOverride(c)
+	if !ok || !h.checkFreeze(c, site.ID, override) {
+		return
+	}
+
+	opts := services.DeployOptions{Queue: c.Query("queue") == "true", Approval: site.HoldsDeploy(false)}
+	deploy, missing, err := h.deployService.StartManifest(site.ID, getTokenUserID(c), req.Files, opts)
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, manifestResponse{
+		DeployID: deploy.ID,
+		Status:   string(deploy.Status),
+		Phase:    string(deploy.Phase),
+		Missing:  missing,
+	})
+}
+
+// UploadDeployFiles receives the files missing from a manifest deploy as
+// multipart parts named by their path and starts building the release.
+// Accepts the same wait parameter as Deploy.
+// POST /api/v1/deploys/:id/files
+func (h *APIHandler) UploadDeployFiles(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid deploy ID"})
+		return
+	}
+
+	deploy, err := h.deployService.GetDeploy(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "deploy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load deploy"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(deploy.SiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	// Without a multipart body nothing was missing
+	next := func() (string, io.Reader, error) { return "", nil, io.EOF }
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid multipart body"})
+			return
+		}
+		next = func() (string, io.Reader, error) {
+			part, err := reader.NextPart()
+			if err != nil {
+				return "", nil, err
+			}
+			return part.FormName(), part, nil
+		}
+	}
+
+	wait := c.Query("wait") == "true"
+	deploy, err = h.deployService.UploadManifestFiles(deploy.ID, next, wait)
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionDeploy, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"filename":  deploy.Filename,
+		"deploy_id": strconv.FormatInt(deploy.ID, 10),
+		"signed_by": deploy.SignedBy,
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	status := http.StatusAccepted
+	if wait {
+		status = http.StatusOK
+	}
+	c.JSON(status, deployResponse{
+		DeployID: deploy.ID,
+		Status:   string(deploy.Status),
+		Phase:    string(deploy.Phase),
+	})
+}
+
+// writeDeployError maps a failed deploy to a status code without exposing
+// internals.
+func writeDeployError(c *gin.Context, err error) {
+	var inProgress *services.DeployInProgressError
+	if errors.As(err, &inProgress) {
+		c.JSON(http.StatusConflict, deployConflictResponse{Error: err.Error(), DeployID: inProgress.DeployID})
+		return
+	}
+
+	var policyErr *services.PolicyViolationError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusUnprocessableEntity, policyViolationResponse{Error: "deploy policy violated", Violations: policyErr.Violations})
+		return
+	}
+
+	var frozen *services.DeployFrozenError
+	if errors.As(err, &frozen) {
+		c.JSON(http.StatusLocked, deployFrozenResponse{
+			Error:       "deploys to this site are frozen",
+			FrozenUntil: frozen.Window.EndsAt.Format(time.RFC3339),
+			Reason:      frozen.Window.Reason,
+		})
+		return
+	}
+
+	status := http.StatusInternalServerError
+	errMsg := "deploy failed"
+
+	switch {
+	case errors.Is(err, services.ErrSignatureRequired), errors.Is(err, services.ErrInvalidSignature):
+		status = http.StatusForbidden
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrInvalidSchedule):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrNotScheduled):
+		status = http.StatusConflict
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrQuotaExceeded):
+		status = http.StatusInsufficientStorage
+		errMsg = "disk quota exceeded"
+	case errors.Is(err, services.ErrDeployQueueFull):
+		status = http.StatusServiceUnavailable
+		errMsg = "deploy queue is full, try again later"
+	case errors.Is(err, services.ErrArchiveTooLarge):
+		status = http.StatusRequestEntityTooLarge
+		errMsg = "archive too large"
+	case errors.Is(err, services.ErrFileTooLarge):
+		status = http.StatusRequestEntityTooLarge
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrTooManyFiles):
+		status = http.StatusBadRequest
+		errMsg = "too many files in archive"
+	case errors.Is(err, services.ErrUnsupportedArchive):
+		status = http.StatusBadRequest
+		errMsg = "unsupported archive format (use zip or tar, optionally compressed with gzip, zstd, xz or bzip2)"
+	case errors.Is(err, services.ErrPathTraversal), errors.Is(err, services.ErrSymlinkDetected):
+		status = http.StatusBadRequest
+		errMsg = "invalid archive content"
+	case errors.Is(err, services.ErrNoGitSource):
+		status = http.StatusBadRequest
+		errMsg = "site has no git repository"
+	case errors.Is(err, services.ErrGitSubdirNotFound):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrGitFailed):
+		status = http.StatusBadGateway
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrInvalidRemoteSource), errors.Is(err, services.ErrInvalidRemoteURL),
+		errors.Is(err, services.ErrRemoteSHA256), errors.Is(err, services.ErrInvalidS3Bucket),
+		errors.Is(err, services.ErrInvalidS3ObjectKey):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrNoS3Credentials):
+		status = http.StatusBadRequest
+		errMsg = "site has no S3 credentials"
+	case errors.Is(err, services.ErrRemoteFetch):
+		status = http.StatusBadGateway
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrRemoteChecksum):
+		status = http.StatusUnprocessableEntity
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrStagingDisabled):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrNoStagedRelease), errors.Is(err, services.ErrStagingNotPromotable),
+		errors.Is(err, services.ErrSiteBusy):
+		status = http.StatusConflict
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrHealthCheckFailed):
+		status = http.StatusUnprocessableEntity
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrNotAwaitingApproval):
+		status = http.StatusConflict
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrSelfApproval):
+		status = http.StatusForbidden
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrSharedPathInArchive):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrManifestNotWaiting):
+		status = http.StatusConflict
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrInvalidManifest), errors.Is(err, services.ErrInvalidPath),
+		errors.Is(err, services.ErrManifestUnexpected), errors.Is(err, services.ErrManifestHash),
+		errors.Is(err, services.ErrManifestIncomplete):
+		status = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, services.ErrManifestStale):
+		status = http.StatusConflict
+		errMsg = err.Error() + ", send the manifest again"
+	}
+
+	c.JSON(status, errorResponse{Error: errMsg})
+}
+
+// GetDeploy returns the status, phase and progress of a deploy.
+// GET /api/v1/deploys/:id
+func (h *APIHandler) GetDeploy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid deploy ID"})
+		return
+	}
+
+	deploy, err := h.deployService.GetDeploy(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "deploy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load deploy"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(deploy.SiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newDeployInfoResponse(deploy))
+}
+
+// CancelDeploy calls off a scheduled deploy before it goes live.
+// POST /api/v1/deploys/:id/cancel
+func (h *APIHandler) CancelDeploy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid deploy ID"})
+		return
+	}
+
+	deploy, err := h.deployService.GetDeploy(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "deploy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load deploy"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(deploy.SiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	deploy, err = h.deployService.CancelScheduled(site.ID, deploy.ID)
+	if err != nil {
+		writeDeployError(c, err)
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionDeployCancel, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"deploy_id": strconv.FormatInt(deploy.ID, 10),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	c.JSON(http.StatusOK, newDeployInfoResponse(deploy))
+}
+
+// ListDeploys returns the deploy history of a site.
+// GET /api/v1/sites/:id/deploys
+func (h *APIHandler) ListDeploys(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	deploys, err := h.deployService.ListDeploys(site.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to list deploys"})
+		return
+	}
+
+	response := []deployInfoResponse{}
+	for _, d := range deploys {
+		response = append(response, newDeployInfoResponse(d))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Rollback activates a previous release of a site.
+// POST /api/v1/sites/:id/rollback
+//
+// Request body (optional):
+//
+//	{"deploy_id": 42}  - activate the release of deploy 42
+//	{}                 - activate the release before the current one
+func (h *APIHandler) Rollback(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	var req rollbackRequest
+	_ = c.ShouldBindJSON(&req) // body is optional
+
+	if req.DeployID > 0 {
+		_, err = h.deployService.RollbackTo(site.ID, req.DeployID)
+	} else {
+		err = h.deployService.Rollback(site.ID)
+	}
+	if err != nil {
+		var inProgress *services.DeployInProgressError
+		if errors.As(err, &inProgress) {
+			c.JSON(http.StatusConflict, deployConflictResponse{Error: err.Error(), DeployID: inProgress.DeployID})
+			return
+		}
+
+		switch err {
+		case services.ErrReleaseNotFound:
+			c.JSON(http.StatusNotFound, errorResponse{Error: "release not available"})
+		case services.ErrSiteBusy:
+			c.JSON(http.StatusConflict, errorResponse{Error: err.Error()})
+		case services.ErrNoPreviousRelease:
+			c.JSON(http.StatusConflict, errorResponse{Error: "no previous version available"})
+		default:
+			slog.Error("rollback failed via API", "site_id", site.ID, "deploy_id", req.DeployID, "error", err)
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "rollback failed"})
+		}
+		return
+	}
+
+	token := middleware.GetAPIToken(c)
+	tokenName := ""
+	if token != nil {
+		tokenName = token.Name
+	}
+	h.auditService.LogAnonymous(services.ActionRollback, services.EntitySite, map[string]string{
+		"site_name": site.Name,
+		"deploy_id": strconv.FormatInt(req.DeployID, 10),
+		"api_token": tokenName,
+	}, c.ClientIP())
+
+	if active, err := h.deployService.GetActiveDeploy(site.ID); err == nil {
+		c.JSON(http.StatusOK, newDeployInfoResponse(active))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "rolled back"})
+}
+
+// DiffReleases lists the files that differ between the releases of two deploys.
+// GET /api/v1/sites/:id/diff?from=10&to=12&content=false
+//
+// Without to the active deploy is used, without from the deploy before to.
+// content=false leaves out the unified diffs of text files.
+func (h *APIHandler) DiffReleases(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid site ID"})
+		return
+	}
+
+	var fromID, toID int64
+	if from := c.Query("from"); from != "" {
+		if fromID, err = strconv.ParseInt(from, 10, 64); err != nil || fromID < 1 {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid from deploy ID"})
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if toID, err = strconv.ParseInt(to, 10, 64); err != nil || toID < 1 {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid to deploy ID"})
+			return
+		}
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+
+	if !h.canAccessSite(c, site) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	diff, err := h.deployService.DiffReleases(site.ID, fromID, toID, c.Query("content") != "false")
+	if err != nil {
+		switch err {
+		case services.ErrReleaseNotFound:
+			c.JSON(http.StatusNotFound, errorResponse{Error: "release not available"})
+		case services.ErrNoPreviousRelease:
+			c.JSON(http.StatusNotFound, errorResponse{Error: "no previous release to compare with"})
+		default:
+			slog.Error("release diff failed via API", "site_id", site.ID, "from", fromID, "to", toID, "error", err)
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to compare releases"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+func newDeployInfoResponse(d *models.Deploy) deployInfoResponse {
+	resp := deployInfoResponse{
+		ID:            d.ID,
+		SiteID:        d.SiteID,
+		Filename:      d.Filename,
+		CommitSHA:     d.CommitSHA,
+		CommitMessage: d.CommitMessage,
+		Status:        string(d.Status),
+		Phase:         string(d.Phase),
+		Progress:      d.Progress,
+		ErrorMessage:  d.ErrorMessage,
+		Violations:    d.Violations,
+		HasRelease:    d.HasRelease,
+		HasArchive:    d.HasArchive(),
+		CanRestore:    d.CanRestore(),
+		IsActive:      d.IsActive,
+		Target:        string(d.Target),
+		IsStaged:      d.IsStaged,
+		NeedsApproval: d.NeedsApproval,
+		ReviewedBy:    d.ReviewedBy,
+		ReviewNote:    d.ReviewNote,
+		SignedBy:      d.SignedBy,
+		ScheduledAt:   formatScheduledAt(d),
+		CreatedAt:     d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.ReviewedAt != nil {
+		resp.ReviewedAt = d.ReviewedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// formatScheduledAt returns when a scheduled deploy goes live, or "" for a
+// deploy that was not scheduled.
+func formatScheduledAt(d *models.Deploy) string {
+	if d.ScheduledAt == nil {
+		return ""
+	}
+	return d.ScheduledAt.Format(time.RFC3339)
+}
+
+func (h *APIHandler) resolveSiteFromFilename(c *gin.Context, requestedID int64, filename string) (*models.Site, error) {
+	domain, ok := inferSiteDomainFromArchive(filename)
+	if !ok {
+		return nil, nil
+	}
+
+	site, err := h.siteService.GetByName(domain)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !h.canAccessSite(c, site) {
+		return nil, nil
+	}
+
+	slog.Warn("deploy site id fallback used",
+		"requested_site_id", requestedID,
+		"resolved_site_id", site.ID,
+		"domain", domain,
+	)
+	return site, nil
+}
+
+// archiveExtensions are the file name suffixes of deploy archives, longest first.
+var archiveExtensions = []string{".tar.gz", ".tar.zst", ".tar.xz", ".tar.bz2", ".tgz", ".tar", ".zip"}
+
+func inferSiteDomainFromArchive(filename string) (string, bool) {
+	base := filename
+	for _, ext := range archiveExtensions {
+		if trimmed := strings.TrimSuffix(filename, ext); trimmed != filename {
+			base = trimmed
+			break
+		}
 	}
 	if base == filename {
 		return "", false
@@ -513,7 +2891,7 @@ func (h *APIHandler) IssueSSL(c *gin.Context) {
 	// "none" — skip SSL
 	if mode == "none" {
 		c.JSON(http.StatusOK, siteResponse{
-			ID: site.ID, Name: site.Name, IsEnabled: site.IsEnabled, SSLEnabled: site.SSLEnabled, FixMimeTypes: site.FixMimeTypes,
+			ID: site.ID, Name: site.Name, IsEnabled: site.IsEnabled, SSLEnabled: site.SSLEnabled, FixMimeTypes: site.FixMimeTypes, Precompress: site.Precompress, Type: site.Type,
 		})
 		return
 	}
@@ -580,6 +2958,8 @@ func (h *APIHandler) IssueSSL(c *gin.Context) {
 		IsEnabled:    site.IsEnabled,
 		SSLEnabled:   site.SSLEnabled,
 		FixMimeTypes: site.FixMimeTypes,
+		Precompress:  site.Precompress,
+		Type:         site.Type,
 	})
 }
 
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/models"
 )
 
 func init() {
@@ -165,6 +168,122 @@ func TestDeployResponse_JSON(t *testing.T) {
 	}
 }
 
+func TestDeployInfoResponse_Phase(t *testing.T) {
+	resp := newDeployInfoResponse(&models.Deploy{
+		ID:       7,
+		SiteID:   3,
+		Filename: "site.zip",
+		Status:   models.DeployStatusPending,
+		Phase:    models.DeployPhaseExtracting,
+		Progress: 40,
+	})
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+
+	if decoded["status"] != "pending" {
+		t.Errorf("status = %v, want pending", decoded["status"])
+	}
+	if decoded["phase"] != "extracting" {
+		t.Errorf("phase = %v, want extracting", decoded["phase"])
+	}
+	if decoded["progress"].(float64) != 40 {
+		t.Errorf("progress = %v, want 40", decoded["progress"])
+	}
+	if _, ok := decoded["error_message"]; ok {
+		t.Error("error_message should be omitted while the deploy runs")
+	}
+}
+
+func TestDeployInfoResponse_Commit(t *testing.T) {
+	gitDeploy, err := json.Marshal(newDeployInfoResponse(&models.Deploy{
+		ID:            8,
+		Filename:      "git:main",
+		CommitSHA:     "0123456789abcdef0123456789abcdef01234567",
+		CommitMessage: "Build site",
+		Status:        models.DeployStatusSuccess,
+	}))
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(gitDeploy, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if decoded["commit_sha"] != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("commit_sha = %v", decoded["commit_sha"])
+	}
+	if decoded["commit_message"] != "Build site" {
+		t.Errorf("commit_message = %v, want Build site", decoded["commit_message"])
+	}
+
+	upload, err := json.Marshal(newDeployInfoResponse(&models.Deploy{ID: 9, Filename: "site.zip"}))
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	decoded = nil
+	if err := json.Unmarshal(upload, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if _, ok := decoded["commit_sha"]; ok {
+		t.Error("commit_sha should be omitted for uploaded archives")
+	}
+}
+
+func TestDeployInfoResponse_Review(t *testing.T) {
+	reviewer := int64(3)
+	reviewedAt := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(newDeployInfoResponse(&models.Deploy{
+		ID:            10,
+		Filename:      "site.zip",
+		Status:        models.DeployStatusRejected,
+		NeedsApproval: true,
+		ReviewedBy:    &reviewer,
+		ReviewedAt:    &reviewedAt,
+		ReviewNote:    "Wrong branch",
+	}))
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if decoded["needs_approval"] != true {
+		t.Errorf("needs_approval = %v, want true", decoded["needs_approval"])
+	}
+	if decoded["reviewed_by"] != float64(3) {
+		t.Errorf("reviewed_by = %v, want 3", decoded["reviewed_by"])
+	}
+	if decoded["reviewed_at"] != "2026-05-01T10:00:00Z" {
+		t.Errorf("reviewed_at = %v", decoded["reviewed_at"])
+	}
+	if decoded["review_note"] != "Wrong branch" {
+		t.Errorf("review_note = %v, want Wrong branch", decoded["review_note"])
+	}
+
+	held, err := json.Marshal(newDeployInfoResponse(&models.Deploy{ID: 11, Status: models.DeployStatusAwaitingApproval, NeedsApproval: true}))
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	decoded = nil
+	if err := json.Unmarshal(held, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if _, ok := decoded["reviewed_at"]; ok {
+		t.Error("reviewed_at should be omitted until the deploy is reviewed")
+	}
+}
+
 // Helper to create bool pointer
 func ptrBool(b bool) *bool {
 	return &b
@@ -194,3 +313,31 @@ func TestSSLDefaultBehavior(t *testing.T) {
 		t.Error("SSL should be true when explicitly set to true")
 	}
 }
+
+func TestInferSiteDomainFromArchive(t *testing.T) {
+	tests := []struct {
+		filename string
+		domain   string
+		ok       bool
+	}{
+		{"example.com-v12.zip", "example.com", true},
+		{"example.com-v12.tar.gz", "example.com", true},
+		{"example.com-v3.tgz", "example.com", true},
+		{"example.com-v3.tar.zst", "example.com", true},
+		{"example.com-v3.tar.xz", "example.com", true},
+		{"example.com-v3.tar.bz2", "example.com", true},
+		{"example.com-v3.tar", "example.com", true},
+		{"example.com-v3.rar", "", false},
+		{"example.com.zip", "", false},
+		{"example.com-vbeta.zip", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			domain, ok := inferSiteDomainFromArchive(tt.filename)
+			if domain != tt.domain || ok != tt.ok {
+				t.Errorf("inferSiteDomainFromArchive(%q) = %q, %v, want %q, %v", tt.filename, domain, ok, tt.domain, tt.ok)
+			}
+		})
+	}
+}
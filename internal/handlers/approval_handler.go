@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+	"micropanel/internal/templates/pages"
+)
+
+// ApprovalHandler holds the deploys of sites that require approval: who may
+// approve them, and reviewing, approving and rejecting held releases.
+type ApprovalHandler struct {
+	approvalService *services.ApprovalService
+	deployService   *services.DeployService
+	siteService     *services.SiteService
+	auditService    *services.AuditService
+}
+
+func NewApprovalHandler(approvalService *services.ApprovalService, deployService *services.DeployService, siteService *services.SiteService, auditService *services.AuditService) *ApprovalHandler {
+	return &ApprovalHandler{
+		approvalService: approvalService,
+		deployService:   deployService,
+		siteService:     siteService,
+		auditService:    auditService,
+	}
+}
+
+// adminSite loads the site of the request for an admin.
+func (h *ApprovalHandler) adminSite(c *gin.Context) (*models.Site, bool) {
+	user := middleware.GetUser(c)
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return nil, false
+	}
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return nil, false
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return nil, false
+	}
+	return site, true
+}
+
+// Update turns the approval of deploys of a site on or off (admin only)
+func (h *ApprovalHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.adminSite(c)
+	if !ok {
+		return
+	}
+
+	required := c.PostForm("require_approval") == "on"
+	if err := h.approvalService.SetRequired(site, required); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to update site")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionApprovalUpdate, services.EntitySite, &site.ID, map[string]interface{}{
+		"require_approval": required,
+	}, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+// AddApprover lets a user approve the deploys of a site (admin only)
+func (h *ApprovalHandler) AddApprover(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.adminSite(c)
+	if !ok {
+		return
+	}
+
+	approver, err := h.approvalService.AddApprover(site.ID, c.PostForm("email"))
+	if err != nil {
+		if errors.Is(err, services.ErrApproverNotFound) || errors.Is(err, services.ErrTooManyApprovers) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to add approver")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionApproverAdd, services.EntitySite, &site.ID, map[string]interface{}{
+		"user_id": approver.ID,
+		"email":   approver.Email,
+	}, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+// RemoveApprover takes a user off the approvers of a site (admin only)
+func (h *ApprovalHandler) RemoveApprover(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.adminSite(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.approvalService.RemoveApprover(site.ID, userID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to remove approver")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionApproverDel, services.EntitySite, &site.ID, map[string]interface{}{
+		"user_id": userID,
+	}, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+// heldDeploy loads the site and deploy of the request. Approvers of the site
+// may review its deploys without otherwise having access to it.
+func (h *ApprovalHandler) heldDeploy(c *gin.Context) (*models.Site, *models.Deploy, bool, bool) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return nil, nil, false, false
+	}
+	deployID, err := strconv.ParseInt(c.Param("deployId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid deploy ID")
+		return nil, nil, false, false
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return nil, nil, false, false
+	}
+
+	canApprove := h.approvalService.CanApprove(site, user)
+	if !canApprove && !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return nil, nil, false, false
+	}
+
+	deploy, err := h.deployService.GetDeploy(deployID)
+	if err != nil || deploy.SiteID != site.ID {
+		c.String(http.StatusNotFound, "Deploy not found")
+		return nil, nil, false, false
+	}
+	return site, deploy, canApprove, true
+}
+
+// Review shows the files a deploy awaiting approval changes, with the
+// approve and reject buttons for approvers.
+func (h *ApprovalHandler) Review(c *gin.Context) {
+	user := middleware.GetUser(c)
+	csrfToken := middleware.GetCSRFToken(c)
+
+	site, deploy, canApprove, ok := h.heldDeploy(c)
+	if !ok {
+		return
+	}
+
+	diff, err := h.deployService.ReviewRelease(site.ID, deploy.ID, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotAwaitingApproval):
+			c.String(http.StatusConflict, "Deploy #%d is not awaiting approval", deploy.ID)
+		case errors.Is(err, services.ErrReleaseNotFound):
+			c.String(http.StatusNotFound, "Release is no longer available")
+		default:
+			c.String(http.StatusInternalServerError, "Failed to compare releases: %s", err.Error())
+		}
+		return
+	}
+
+	component := pages.DeployReview(user, site, deploy, diff, canApprove, csrfToken)
+	component.Render(c.Request.Context(), c.Writer)
+}
+
+// Approve lets a deploy awaiting approval go live
+func (h *ApprovalHandler) Approve(c *gin.Context) {
+	h.review(c, true)
+}
+
+// Reject turns down a deploy awaiting approval and removes its release
+func (h *ApprovalHandler) Reject(c *gin.Context) {
+	h.review(c, false)
+}
+
+func (h *ApprovalHandler) review(c *gin.Context, approve bool) {
+	user := middleware.GetUser(c)
+
+	site, deploy, canApprove, ok := h.heldDeploy(c)
+	if !ok {
+		return
+	}
+	if !canApprove {
+		c.String(http.StatusForbidden, "Only approvers of this site can review its deploys")
+		return
+	}
+
+	note := strings.TrimSpace(c.PostForm("note"))
+	if len(note) > maxReviewNote {
+		c.String(http.StatusBadRequest, "The note must be at most %d characters", maxReviewNote)
+		return
+	}
+
+	action := services.ActionDeployReject
+	override := false
+	var err error
+	if approve {
+		action = services.ActionDeployApprove
+		if override, ok = freezeOverride(c, user); !ok {
+			return
+		}
+		if !override {
+			if err := h.deployService.CheckFreeze(site.ID, time.Now()); err != nil {
+				var frozen *services.DeployFrozenError
+				if errors.As(err, &frozen) {
+					c.String(http.StatusLocked, "Cannot approve now, %s", err.Error())
+					return
+				}
+				c.String(http.StatusInternalServerError, "Approval failed")
+				return
+			}
+		}
+		deploy, err = h.deployService.Approve(site.ID, deploy.ID, user.ID, note)
+	} else {
+		deploy, err = h.deployService.Reject(site.ID, deploy.ID, user.ID, note)
+	}
+
+	// An approved release that failed its health checks is still logged
+	failed := err != nil && deploy != nil && deploy.Status == models.DeployStatusFailed
+	if err != nil && !failed {
+		switch {
+		case isSiteBusy(err), errors.Is(err, services.ErrNotAwaitingApproval):
+			c.String(http.StatusConflict, "Review failed: %s", err.Error())
+		case errors.Is(err, services.ErrSelfApproval):
+			c.String(http.StatusForbidden, "Review failed: %s", err.Error())
+		case errors.Is(err, services.ErrStagingDisabled):
+			c.String(http.StatusBadRequest, "Set up the staging slot of this site first")
+		case errors.Is(err, services.ErrReleaseNotFound):
+			c.String(http.StatusNotFound, "Release is no longer available")
+		default:
+			c.String(http.StatusInternalServerError, "Review failed: %s", err.Error())
+		}
+		return
+	}
+
+	details := map[string]interface{}{
+		"site_id":     site.ID,
+		"deployed_by": deploy.UserID,
+		"reviewer":    user.Email,
+		"note":        note,
+	}
+	if approve {
+		details["override_freeze"] = override
+	}
+	if failed {
+		details["error"] = err.Error()
+	}
+	h.auditService.LogUser(user.ID, action, services.EntityDeploy, &deploy.ID, details, c.ClientIP())
+
+	if failed {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrHealthCheckFailed) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.String(status, "Approved, but the release failed: %s", err.Error())
+		return
+	}
+
+	h.redirect(c, site.ID)
+}
+
+// List shows the deploys awaiting approval on the sites the user approves
+func (h *ApprovalHandler) List(c *gin.Context) {
+	user := middleware.GetUser(c)
+	csrfToken := middleware.GetCSRFToken(c)
+
+	siteIDs, err := h.approvalService.ApproverSiteIDs(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error loading approvals")
+		return
+	}
+
+	deploys, err := h.deployService.ListAwaitingApproval(siteIDs)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error loading approvals")
+		return
+	}
+
+	sites := make(map[int64]*models.Site)
+	for _, d := range deploys {
+		if _, ok := sites[d.SiteID]; !ok {
+			if site, err := h.siteService.GetByID(d.SiteID); err == nil {
+				sites[d.SiteID] = site
+			}
+		}
+	}
+
+	component := pages.Approvals(user, deploys, sites, csrfToken)
+	component.Render(c.Request.Context(), c.Writer)
+}
+
+func (h *ApprovalHandler) redirect(c *gin.Context, siteID int64) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
@@ -1,27 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
 	"micropanel/internal/services"
+	"micropanel/internal/templates/pages"
 )
 
 type DeployHandler struct {
 	deployService *services.DeployService
 	siteService   *services.SiteService
 	auditService  *services.AuditService
+	uploadService *services.UploadService
 }
 
-func NewDeployHandler(deployService *services.DeployService, siteService *services.SiteService, auditService *services.AuditService) *DeployHandler {
+func NewDeployHandler(deployService *services.DeployService, siteService *services.SiteService, auditService *services.AuditService, uploadService *services.UploadService) *DeployHandler {
 	return &DeployHandler{
 		deployService: deployService,
 		siteService:   siteService,
 		auditService:  auditService,
+		uploadService: uploadService,
 	}
 }
 
@@ -45,50 +52,333 @@ func (h *DeployHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	// Get uploaded file
-	file, header, err := c.Request.FormFile("file")
+	// Get uploaded file, sent with the form or by the resumable uploader
+	file, err := openPostedFile(c, h.uploadService, user.ID)
 	if err != nil {
-		c.String(http.StatusBadRequest, "No file uploaded")
+		status, message := postedFileError(err, "No file uploaded")
+		c.String(status, message)
 		return
 	}
 	defer file.Close()
+	if !file.forSite(siteID) {
+		c.String(http.StatusBadRequest, "The upload was started for another site")
+		return
+	}
+
+	target, ok := deployTarget(c.PostForm("target"))
+	if !ok {
+		c.String(http.StatusBadRequest, "Invalid deploy target")
+		return
+	}
 
-	// Validate file extension
-	filename := strings.ToLower(header.Filename)
-	validExt := strings.HasSuffix(filename, ".zip") ||
-		strings.HasSuffix(filename, ".tgz") ||
-		strings.HasSuffix(filename, ".tar.gz")
-	if !validExt {
-		c.String(http.StatusBadRequest, "Only ZIP and TGZ files are allowed")
+	override, ok := freezeOverride(c, user)
+	if !ok {
 		return
 	}
 
-	// Deploy
-	deploy, err := h.deployService.Deploy(siteID, user.ID, header.Filename, file, header.Size)
+	// Queue deploy, its progress is shown in the deploy history. With a
+	// scheduled time it waits in the history until then.
+	opts := services.DeployOptions{
+		Signature:      c.PostForm("signature"),
+		Queue:          c.PostForm("queue") == "on",
+		Target:         target,
+		Approval:       site.HoldsDeploy(user.IsAdmin()),
+		OverrideFreeze: override,
+	}
+	var deploy *models.Deploy
+	if value := c.PostForm("scheduled_at"); value != "" {
+		at, parseErr := time.ParseInLocation(datetimeLocalLayout, value, time.Local)
+		if parseErr != nil {
+			c.String(http.StatusBadRequest, "Invalid scheduled time")
+			return
+		}
+		if target == models.DeployTargetStaging {
+			c.String(http.StatusBadRequest, "Scheduled deploys always go to production")
+			return
+		}
+		deploy, err = h.deployService.Schedule(siteID, user.ID, file.Name, file, file.Size, at, opts)
+	} else {
+		if !h.checkFreeze(c, siteID, override) {
+			return
+		}
+		deploy, err = h.deployService.Enqueue(siteID, user.ID, file.Name, file, file.Size, opts)
+	}
 	if err != nil {
+		var inProgress *services.DeployInProgressError
+		if errors.As(err, &inProgress) {
+			c.String(http.StatusConflict, "Deploy #%d is already in progress", inProgress.DeployID)
+			return
+		}
+
+		var frozen *services.DeployFrozenError
+		if errors.As(err, &frozen) {
+			c.String(http.StatusLocked, "Cannot deploy at that time, %s", err.Error())
+			return
+		}
+
+		var policyErr *services.PolicyViolationError
+		if errors.As(err, &policyErr) {
+			lines := []string{"Deploy policy violated:"}
+			for _, v := range policyErr.Violations {
+				lines = append(lines, "- "+v.String())
+			}
+			c.String(http.StatusUnprocessableEntity, strings.Join(lines, "\n"))
+			return
+		}
+
 		// Return user-friendly error messages without exposing internals
 		errMsg := "Deploy failed"
 		switch err {
+		case services.ErrDeployQueueFull:
+			c.String(http.StatusServiceUnavailable, "Too many deploys in progress, try again later")
+			return
 		case services.ErrArchiveTooLarge:
 			errMsg = "Archive is too large"
-		case services.ErrTooManyFiles:
-			errMsg = "Too many files in archive"
+		case services.ErrQuotaExceeded:
+			errMsg = "Disk quota of the site exceeded"
 		case services.ErrUnsupportedArchive:
 			errMsg = "Unsupported archive format"
-		case services.ErrPathTraversal:
-			errMsg = "Invalid file paths in archive"
-		case services.ErrSymlinkDetected:
-			errMsg = "Symlinks are not allowed in archive"
+		case services.ErrInvalidSchedule:
+			c.String(http.StatusBadRequest, "The scheduled time must be in the future and within a year")
+			return
+		case services.ErrSignatureRequired:
+			c.String(http.StatusForbidden, "This site only accepts signed archives, paste the signature of the archive")
+			return
+		case services.ErrInvalidSignature:
+			c.String(http.StatusForbidden, "The signature does not match the archive or any deploy key of the site")
+			return
+		case services.ErrStagingDisabled:
+			c.String(http.StatusBadRequest, "Set up the staging slot of this site first")
+			return
 		}
 		c.String(http.StatusInternalServerError, errMsg)
 		return
 	}
 
+	file.used(h.uploadService)
+
 	// Log deploy
 	h.auditService.LogUser(user.ID, services.ActionDeploy, services.EntityDeploy, &deploy.ID, map[string]interface{}{
-		"filename": header.Filename,
-		"site_id":  siteID,
-		"size":     header.Size,
+		"filename":        file.Name,
+		"site_id":         siteID,
+		"size":            file.Size,
+		"signed_by":       deploy.SignedBy,
+		"scheduled_at":    deploy.ScheduledAt,
+		"override_freeze": override,
+		"needs_approval":  deploy.NeedsApproval,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// DeployGit queues a deploy of the branch the site is linked to.
+func (h *DeployHandler) DeployGit(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	target, ok := deployTarget(c.PostForm("target"))
+	if !ok {
+		c.String(http.StatusBadRequest, "Invalid deploy target")
+		return
+	}
+
+	override, ok := freezeOverride(c, user)
+	if !ok || !h.checkFreeze(c, siteID, override) {
+		return
+	}
+
+	opts := services.DeployOptions{Queue: c.PostForm("queue") == "on", Target: target, Approval: site.HoldsDeploy(user.IsAdmin())}
+	deploy, err := h.deployService.EnqueueGit(siteID, user.ID, opts)
+	if err != nil {
+		var inProgress *services.DeployInProgressError
+		if errors.As(err, &inProgress) {
+			c.String(http.StatusConflict, "Deploy #%d is already in progress", inProgress.DeployID)
+			return
+		}
+
+		switch err {
+		case services.ErrNoGitSource:
+			c.String(http.StatusBadRequest, "No git repository is linked to this site")
+		case services.ErrSignatureRequired:
+			c.String(http.StatusForbidden, "This site only accepts signed archives, git deploys are disabled")
+		case services.ErrStagingDisabled:
+			c.String(http.StatusBadRequest, "Set up the staging slot of this site first")
+		case services.ErrDeployQueueFull:
+			c.String(http.StatusServiceUnavailable, "Too many deploys in progress, try again later")
+		default:
+			c.String(http.StatusInternalServerError, "Deploy failed")
+		}
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeploy, services.EntityDeploy, &deploy.ID, map[string]interface{}{
+		"filename":        deploy.Filename,
+		"site_id":         siteID,
+		"git_url":         site.GitURL,
+		"override_freeze": override,
+		"needs_approval":  deploy.NeedsApproval,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// DeployRemote queues a deploy of an archive the server downloads from a URL
+// or an S3-compatible bucket.
+func (h *DeployHandler) DeployRemote(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	target, ok := deployTarget(c.PostForm("target"))
+	if !ok {
+		c.String(http.StatusBadRequest, "Invalid deploy target")
+		return
+	}
+
+	override, ok := freezeOverride(c, user)
+	if !ok || !h.checkFreeze(c, siteID, override) {
+		return
+	}
+
+	src := services.RemoteSource{SHA256: c.PostForm("sha256")}
+	if c.PostForm("source") == "s3" {
+		src.Bucket = c.PostForm("bucket")
+		src.Key = c.PostForm("key")
+	} else {
+		src.URL = c.PostForm("url")
+	}
+
+	opts := services.DeployOptions{Queue: c.PostForm("queue") == "on", Target: target, Approval: site.HoldsDeploy(user.IsAdmin())}
+	deploy, err := h.deployService.EnqueueRemote(siteID, user.ID, src, opts)
+	if err != nil {
+		var inProgress *services.DeployInProgressError
+		if errors.As(err, &inProgress) {
+			c.String(http.StatusConflict, "Deploy #%d is already in progress", inProgress.DeployID)
+			return
+		}
+
+		switch {
+		case errors.Is(err, services.ErrInvalidRemoteSource), errors.Is(err, services.ErrInvalidRemoteURL),
+			errors.Is(err, services.ErrRemoteSHA256), errors.Is(err, services.ErrInvalidS3Bucket),
+			errors.Is(err, services.ErrInvalidS3ObjectKey):
+			c.String(http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrNoS3Credentials):
+			c.String(http.StatusBadRequest, "Save the S3 credentials of this site first")
+		case errors.Is(err, services.ErrStagingDisabled):
+			c.String(http.StatusBadRequest, "Set up the staging slot of this site first")
+		case errors.Is(err, services.ErrArchiveTooLarge):
+			c.String(http.StatusRequestEntityTooLarge, "Archive too large")
+		case errors.Is(err, services.ErrQuotaExceeded):
+			c.String(http.StatusInsufficientStorage, "Disk quota exceeded")
+		case errors.Is(err, services.ErrDeployQueueFull):
+			c.String(http.StatusServiceUnavailable, "Too many deploys in progress, try again later")
+		default:
+			c.String(http.StatusInternalServerError, "Deploy failed")
+		}
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeploy, services.EntityDeploy, &deploy.ID, map[string]interface{}{
+		"filename":        deploy.Filename,
+		"site_id":         siteID,
+		"source":          src.String(),
+		"override_freeze": override,
+		"needs_approval":  deploy.NeedsApproval,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// Cancel calls off a scheduled deploy before it goes live.
+func (h *DeployHandler) Cancel(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	deployID, err := strconv.ParseInt(c.Param("deployId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid deploy ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if _, err := h.deployService.CancelScheduled(siteID, deployID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.String(http.StatusNotFound, "Deploy not found")
+		case errors.Is(err, services.ErrNotScheduled):
+			c.String(http.StatusConflict, "Deploy #%d is no longer scheduled", deployID)
+		default:
+			c.String(http.StatusInternalServerError, "Failed to cancel deploy")
+		}
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployCancel, services.EntityDeploy, &deployID, map[string]interface{}{
+		"site_id": siteID,
 	}, c.ClientIP())
 
 	if c.GetHeader("HX-Request") == "true" {
@@ -121,6 +411,10 @@ func (h *DeployHandler) Rollback(c *gin.Context) {
 	}
 
 	if err := h.deployService.Rollback(siteID); err != nil {
+		if isSiteBusy(err) {
+			c.String(http.StatusConflict, "Rollback failed: %s", err.Error())
+			return
+		}
 		c.String(http.StatusInternalServerError, "Rollback failed: %s", err.Error())
 		return
 	}
@@ -136,3 +430,159 @@ func (h *DeployHandler) Rollback(c *gin.Context) {
 
 	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
 }
+
+func (h *DeployHandler) RollbackTo(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	deployID, err := strconv.ParseInt(c.Param("deployId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid deploy ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if _, err := h.deployService.RollbackTo(siteID, deployID); err != nil {
+		if err == services.ErrReleaseNotFound {
+			c.String(http.StatusNotFound, "Release is no longer available")
+			return
+		}
+		if isSiteBusy(err) {
+			c.String(http.StatusConflict, "Rollback failed: %s", err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Rollback failed: %s", err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionRollback, services.EntitySite, &siteID, map[string]interface{}{
+		"deploy_id": deployID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// Diff shows the files that changed between two releases of a site: the
+// deploys in the from and to query parameters, by default the active deploy
+// and the one before it.
+func (h *DeployHandler) Diff(c *gin.Context) {
+	user := middleware.GetUser(c)
+	csrfToken := middleware.GetCSRFToken(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	var fromID, toID int64
+	if from := c.Query("from"); from != "" {
+		if fromID, err = strconv.ParseInt(from, 10, 64); err != nil {
+			c.String(http.StatusBadRequest, "Invalid deploy ID")
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if toID, err = strconv.ParseInt(to, 10, 64); err != nil {
+			c.String(http.StatusBadRequest, "Invalid deploy ID")
+			return
+		}
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	diff, err := h.deployService.DiffReleases(siteID, fromID, toID, true)
+	if err != nil {
+		switch err {
+		case services.ErrReleaseNotFound:
+			c.String(http.StatusNotFound, "Release is no longer available")
+		case services.ErrNoPreviousRelease:
+			c.String(http.StatusNotFound, "No previous release to compare with")
+		default:
+			c.String(http.StatusInternalServerError, "Failed to compare releases: %s", err.Error())
+		}
+		return
+	}
+
+	component := pages.ReleaseDiff(user, site, diff, csrfToken)
+	component.Render(c.Request.Context(), c.Writer)
+}
+
+// freezeOverride reads the override_freeze field, which only admins may set.
+// It writes the error response and reports false when it is refused.
+func freezeOverride(c *gin.Context, user *models.User) (bool, bool) {
+	if c.PostForm("override_freeze") != "on" {
+		return false, true
+	}
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Only admins can override a deploy freeze")
+		return false, false
+	}
+	return true, true
+}
+
+// deployTarget parses the target of a deploy, production when empty.
+func deployTarget(value string) (models.DeployTarget, bool) {
+	switch models.DeployTarget(value) {
+	case "", models.DeployTargetProduction:
+		return models.DeployTargetProduction, true
+	case models.DeployTargetStaging:
+		return models.DeployTargetStaging, true
+	}
+	return "", false
+}
+
+// checkFreeze refuses a deploy starting now while the site is in a freeze
+// window, unless the freeze is overridden. It writes the error response and
+// reports false when the deploy must not go ahead.
+func (h *DeployHandler) checkFreeze(c *gin.Context, siteID int64, override bool) bool {
+	if override {
+		return true
+	}
+	if err := h.deployService.CheckFreeze(siteID, time.Now()); err != nil {
+		var frozen *services.DeployFrozenError
+		if errors.As(err, &frozen) {
+			c.String(http.StatusLocked, "Cannot deploy now, %s", err.Error())
+			return false
+		}
+		c.String(http.StatusInternalServerError, "Deploy failed")
+		return false
+	}
+	return true
+}
+
+// isSiteBusy reports whether err means a deploy or another operation holds the site.
+func isSiteBusy(err error) bool {
+	var inProgress *services.DeployInProgressError
+	return errors.As(err, &inProgress) || errors.Is(err, services.ErrSiteBusy)
+}
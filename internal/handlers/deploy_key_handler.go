@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/services"
+)
+
+// DeployKeyHandler manages the keys that sign deploy archives: those of a
+// site on its page and those of the current user on the profile page.
+type DeployKeyHandler struct {
+	keyService   *services.DeployKeyService
+	siteService  *services.SiteService
+	auditService *services.AuditService
+}
+
+func NewDeployKeyHandler(keyService *services.DeployKeyService, siteService *services.SiteService, auditService *services.AuditService) *DeployKeyHandler {
+	return &DeployKeyHandler{
+		keyService:   keyService,
+		siteService:  siteService,
+		auditService: auditService,
+	}
+}
+
+func (h *DeployKeyHandler) Create(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	key, err := h.keyService.CreateForSite(siteID, c.PostForm("name"), c.PostForm("public_key"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployKeyAdd, services.EntityDeployKey, &key.ID, map[string]interface{}{
+		"name":        key.Name,
+		"fingerprint": key.Fingerprint,
+		"site_id":     siteID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+func (h *DeployKeyHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	keyID, err := strconv.ParseInt(c.Param("keyId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid deploy key ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	key, err := h.keyService.GetByID(keyID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Deploy key not found")
+		return
+	}
+
+	if key.SiteID == nil || *key.SiteID != siteID {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if err := h.keyService.Delete(keyID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete deploy key")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployKeyDel, services.EntityDeployKey, &keyID, map[string]interface{}{
+		"name":        key.Name,
+		"fingerprint": key.Fingerprint,
+		"site_id":     siteID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// CreateForUser adds a key of the current user, accepted by every site the
+// user owns.
+func (h *DeployKeyHandler) CreateForUser(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	key, err := h.keyService.CreateForUser(user.ID, c.PostForm("name"), c.PostForm("public_key"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployKeyAdd, services.EntityDeployKey, &key.ID, map[string]interface{}{
+		"name":        key.Name,
+		"fingerprint": key.Fingerprint,
+		"user_id":     user.ID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/profile")
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+// DeleteForUser removes a key of the current user.
+func (h *DeployKeyHandler) DeleteForUser(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	keyID, err := strconv.ParseInt(c.Param("keyId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid deploy key ID")
+		return
+	}
+
+	key, err := h.keyService.GetByID(keyID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Deploy key not found")
+		return
+	}
+
+	if key.UserID == nil || *key.UserID != user.ID {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if err := h.keyService.Delete(keyID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete deploy key")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployKeyDel, services.EntityDeployKey, &keyID, map[string]interface{}{
+		"name":        key.Name,
+		"fingerprint": key.Fingerprint,
+		"user_id":     user.ID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/profile")
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/profile")
+}
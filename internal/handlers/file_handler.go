@@ -8,23 +8,43 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"micropanel/internal/middleware"
+	"micropanel/internal/models"
 	"micropanel/internal/services"
 )
 
 type FileHandler struct {
-	fileService  *services.FileService
-	siteService  *services.SiteService
-	auditService *services.AuditService
+	fileService   *services.FileService
+	siteService   *services.SiteService
+	auditService  *services.AuditService
+	uploadService *services.UploadService
 }
 
-func NewFileHandler(fileService *services.FileService, siteService *services.SiteService, auditService *services.AuditService) *FileHandler {
+func NewFileHandler(fileService *services.FileService, siteService *services.SiteService, auditService *services.AuditService, uploadService *services.UploadService) *FileHandler {
 	return &FileHandler{
-		fileService:  fileService,
-		siteService:  siteService,
-		auditService: auditService,
+		fileService:   fileService,
+		siteService:   siteService,
+		auditService:  auditService,
+		uploadService: uploadService,
 	}
 }
 
+// canChangeFiles checks the user may change the given paths of a site,
+// writing the error response when not. On sites holding the deploys of the
+// user for approval the release only changes through a reviewed deploy, but
+// shared paths live outside every release and stay writable.
+func (h *FileHandler) canChangeFiles(c *gin.Context, site *models.Site, user *models.User, paths ...string) bool {
+	if !site.HoldsDeploy(user.IsAdmin()) {
+		return true
+	}
+	for _, p := range paths {
+		if !h.fileService.IsShared(site.ID, p) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Changes to this site need approval, deploy an archive to submit them for review"})
+			return false
+		}
+	}
+	return true
+}
+
 // List returns files at the given path
 func (h *FileHandler) List(c *gin.Context) {
 	user := middleware.GetUser(c)
@@ -150,13 +170,20 @@ func (h *FileHandler) Write(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
 		return
 	}
+	if !h.canChangeFiles(c, site, user, req.Path) {
+		return
+	}
 
 	if err := h.fileService.Write(siteID, req.Path, []byte(req.Content)); err != nil {
 		status := http.StatusInternalServerError
 		if err == services.ErrFileTooBig {
 			status = http.StatusRequestEntityTooLarge
+		} else if err == services.ErrQuotaExceeded {
+			status = http.StatusInsufficientStorage
 		} else if err == services.ErrFilePathTraversal {
 			status = http.StatusForbidden
+		} else if err == services.ErrSiteBusy {
+			status = http.StatusConflict
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -200,6 +227,9 @@ func (h *FileHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
 		return
 	}
+	if !h.canChangeFiles(c, site, user, req.Path) {
+		return
+	}
 
 	if req.IsDir {
 		err = h.fileService.CreateDirectory(siteID, req.Path)
@@ -213,6 +243,8 @@ func (h *FileHandler) Create(c *gin.Context) {
 			status = http.StatusConflict
 		} else if err == services.ErrFilePathTraversal {
 			status = http.StatusForbidden
+		} else if err == services.ErrSiteBusy {
+			status = http.StatusConflict
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -247,15 +279,20 @@ func (h *FileHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
 		return
 	}
+	if !h.canChangeFiles(c, site, user, path) {
+		return
+	}
 
 	if err := h.fileService.Delete(siteID, path); err != nil {
 		status := http.StatusInternalServerError
 		if err == services.ErrFileNotFound {
 			status = http.StatusNotFound
-		} else if err == services.ErrCannotDelete {
+		} else if err == services.ErrCannotDelete || err == services.ErrSharedPathRoot {
 			status = http.StatusForbidden
 		} else if err == services.ErrFilePathTraversal {
 			status = http.StatusForbidden
+		} else if err == services.ErrSiteBusy {
+			status = http.StatusConflict
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -299,6 +336,9 @@ func (h *FileHandler) Rename(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Both paths are required"})
 		return
 	}
+	if !h.canChangeFiles(c, site, user, req.OldPath, req.NewPath) {
+		return
+	}
 
 	if err := h.fileService.Rename(siteID, req.OldPath, req.NewPath); err != nil {
 		status := http.StatusInternalServerError
@@ -306,8 +346,10 @@ func (h *FileHandler) Rename(c *gin.Context) {
 			status = http.StatusNotFound
 		} else if err == services.ErrFileExists {
 			status = http.StatusConflict
-		} else if err == services.ErrFilePathTraversal {
+		} else if err == services.ErrFilePathTraversal || err == services.ErrSharedPathRoot {
 			status = http.StatusForbidden
+		} else if err == services.ErrSiteBusy {
+			status = http.StatusConflict
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -337,31 +379,46 @@ func (h *FileHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
+	// Sent with the form or by the resumable uploader
+	file, err := openPostedFile(c, h.uploadService, user.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		status, message := postedFileError(err, "File is required")
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 	defer file.Close()
+	if !file.forSite(siteID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The upload was started for another site"})
+		return
+	}
 
 	dir := c.PostForm("path")
 	if dir == "" {
 		dir = "/"
 	}
 
-	filename := filepath.Join(dir, header.Filename)
+	filename := filepath.Join(dir, file.Name)
+	if !h.canChangeFiles(c, site, user, filename) {
+		return
+	}
 
-	if err := h.fileService.Upload(siteID, filename, file, header.Size); err != nil {
+	if err := h.fileService.Upload(siteID, filename, file, file.Size); err != nil {
 		status := http.StatusInternalServerError
 		if err == services.ErrFileTooBig {
 			status = http.StatusRequestEntityTooLarge
+		} else if err == services.ErrQuotaExceeded {
+			status = http.StatusInsufficientStorage
 		} else if err == services.ErrFilePathTraversal {
 			status = http.StatusForbidden
+		} else if err == services.ErrSiteBusy {
+			status = http.StatusConflict
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
+	file.used(h.uploadService)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Uploaded", "path": filename})
 }
 
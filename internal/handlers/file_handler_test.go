@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/config"
+	"micropanel/internal/database"
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/services"
+)
+
+func TestFileHandler_RequireApproval(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "micropanel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrations, _ := filepath.Abs("../../migrations")
+	if err := db.Migrate(migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Sites.Path = t.TempDir()
+	cfg.Limits.MaxFileSize = 1024 * 1024
+
+	userRepo := repository.NewUserRepository(db)
+	siteRepo := repository.NewSiteRepository(db)
+	owner := &models.User{Email: "owner@example.com", Role: models.RoleUser, IsActive: true}
+	admin := &models.User{Email: "admin@example.com", Role: models.RoleAdmin, IsActive: true}
+	for _, u := range []*models.User{owner, admin} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	held := &models.Site{Name: "held.example.com", OwnerID: owner.ID, IsEnabled: true, Type: models.SiteTypeStatic, RequireApproval: true}
+	open := &models.Site{Name: "open.example.com", OwnerID: owner.ID, IsEnabled: true, Type: models.SiteTypeStatic}
+	for _, s := range []*models.Site{held, open} {
+		if err := siteRepo.Create(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(cfg.Sites.Path, fmt.Sprint(s.ID), "current"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sharedRepo := repository.NewSharedPathRepository(db)
+	if err := sharedRepo.ReplaceForSite(held.ID, []string{"uploads"}); err != nil {
+		t.Fatal(err)
+	}
+	fileService := services.NewFileService(cfg)
+	fileService.SetSharedPathRepo(sharedRepo)
+
+	siteService := services.NewSiteService(siteRepo, repository.NewDomainRepository(db), cfg)
+	auditService := services.NewAuditService(repository.NewAuditRepository(db))
+	h := NewFileHandler(fileService, siteService, auditService, nil)
+
+	serve := func(user *models.User, method, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(func(c *gin.Context) { c.Set(middleware.UserContextKey, user) })
+		r.POST("/sites/:id/files/write", h.Write)
+		r.POST("/sites/:id/files/create", h.Create)
+		r.DELETE("/sites/:id/files", h.Delete)
+		r.POST("/sites/:id/files/rename", h.Rename)
+		r.POST("/sites/:id/files/upload", h.Upload)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", contentType)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	upload := func(dir string) (string, io.Reader) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		mw.WriteField("path", dir)
+		fw, _ := mw.CreateFormFile("file", "index.html")
+		fw.Write([]byte("changed"))
+		mw.Close()
+		return mw.FormDataContentType(), &buf
+	}
+	jsonBody := func(body string) (string, io.Reader) {
+		return "application/json", strings.NewReader(body)
+	}
+
+	// Every change of the release of a held site is refused to its owner,
+	// while its shared paths stay writable
+	heldPath := "/sites/" + fmt.Sprint(held.ID) + "/files"
+	for _, tt := range []struct {
+		name   string
+		method string
+		target string
+		body   func() (string, io.Reader)
+		want   int
+	}{
+		{"write", http.MethodPost, heldPath + "/write", func() (string, io.Reader) { return jsonBody(`{"path": "/index.html", "content": "changed"}`) }, http.StatusForbidden},
+		{"create", http.MethodPost, heldPath + "/create", func() (string, io.Reader) { return jsonBody(`{"path": "/index.html"}`) }, http.StatusForbidden},
+		{"delete", http.MethodDelete, heldPath + "?path=/index.html", func() (string, io.Reader) { return jsonBody("") }, http.StatusForbidden},
+		{"rename", http.MethodPost, heldPath + "/rename", func() (string, io.Reader) {
+			return jsonBody(`{"old_path": "/uploads/a.txt", "new_path": "/index.html"}`)
+		}, http.StatusForbidden},
+		{"upload", http.MethodPost, heldPath + "/upload", func() (string, io.Reader) { return upload("/") }, http.StatusForbidden},
+		{"write shared", http.MethodPost, heldPath + "/write", func() (string, io.Reader) { return jsonBody(`{"path": "/uploads/a.txt", "content": "changed"}`) }, http.StatusOK},
+		{"upload shared", http.MethodPost, heldPath + "/upload", func() (string, io.Reader) { return upload("/uploads") }, http.StatusOK},
+		{"rename shared", http.MethodPost, heldPath + "/rename", func() (string, io.Reader) {
+			return jsonBody(`{"old_path": "/uploads/a.txt", "new_path": "/uploads/b.txt"}`)
+		}, http.StatusOK},
+		{"delete shared", http.MethodDelete, heldPath + "?path=/uploads/b.txt", func() (string, io.Reader) { return jsonBody("") }, http.StatusOK},
+	} {
+		contentType, body := tt.body()
+		w := serve(owner, tt.method, tt.target, contentType, body)
+		if w.Code != tt.want || (tt.want == http.StatusForbidden && !strings.Contains(w.Body.String(), "approval")) {
+			t.Errorf("%s = %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Sites.Path, fmt.Sprint(held.ID), "current", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("refused write changed the live release: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Sites.Path, fmt.Sprint(held.ID), "shared", "uploads", "index.html")); err != nil {
+		t.Errorf("upload to a shared path: %v", err)
+	}
+
+	// Admins are not held, nor owners of sites without approval
+	for _, tt := range []struct {
+		user *models.User
+		site *models.Site
+	}{{admin, held}, {owner, open}} {
+		contentType, body := jsonBody(`{"path": "/index.html", "content": "changed"}`)
+		w := serve(tt.user, http.MethodPost, "/sites/"+fmt.Sprint(tt.site.ID)+"/files/write", contentType, body)
+		if w.Code != http.StatusOK {
+			t.Errorf("write by %s to %s = %d %s, want 200", tt.user.Email, tt.site.Name, w.Code, w.Body.String())
+		}
+	}
+}
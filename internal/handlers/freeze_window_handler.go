@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/services"
+)
+
+// datetimeLocalLayout is the value format of a datetime-local input, read in
+// the time zone of the server.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// FreezeWindowHandler manages the freeze windows of a site (admin only).
+type FreezeWindowHandler struct {
+	freezeService *services.FreezeWindowService
+	siteService   *services.SiteService
+	auditService  *services.AuditService
+}
+
+func NewFreezeWindowHandler(freezeService *services.FreezeWindowService, siteService *services.SiteService, auditService *services.AuditService) *FreezeWindowHandler {
+	return &FreezeWindowHandler{
+		freezeService: freezeService,
+		siteService:   siteService,
+		auditService:  auditService,
+	}
+}
+
+func (h *FreezeWindowHandler) Create(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	if _, err := h.siteService.GetByID(siteID); err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	startsAt, err := time.ParseInLocation(datetimeLocalLayout, c.PostForm("starts_at"), time.Local)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid start time")
+		return
+	}
+	endsAt, err := time.ParseInLocation(datetimeLocalLayout, c.PostForm("ends_at"), time.Local)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid end time")
+		return
+	}
+
+	window, err := h.freezeService.Create(siteID, startsAt, endsAt, c.PostForm("reason"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFreezeWindow) || errors.Is(err, services.ErrFreezeWindowOver) ||
+			errors.Is(err, services.ErrFreezeReasonTooLong) || errors.Is(err, services.ErrTooManyFreezeWindows) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save freeze window")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionFreezeAdd, services.EntityFreezeWindow, &window.ID, map[string]interface{}{
+		"site_id":   siteID,
+		"starts_at": window.StartsAt,
+		"ends_at":   window.EndsAt,
+		"reason":    window.Reason,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+func (h *FreezeWindowHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	windowID, err := strconv.ParseInt(c.Param("windowId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid freeze window ID")
+		return
+	}
+
+	window, err := h.freezeService.GetByID(windowID)
+	if err != nil || window.SiteID != siteID {
+		c.String(http.StatusNotFound, "Freeze window not found")
+		return
+	}
+
+	if err := h.freezeService.Delete(windowID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete freeze window")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionFreezeDel, services.EntityFreezeWindow, &windowID, map[string]interface{}{
+		"site_id":   siteID,
+		"starts_at": window.StartsAt,
+		"ends_at":   window.EndsAt,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+	"micropanel/internal/validators"
+)
+
+// HeaderRuleHandler manages the response header rules of a site: headers
+// added or overridden for paths or file extensions, and cache presets.
+type HeaderRuleHandler struct {
+	headerRuleService *services.HeaderRuleService
+	siteService       *services.SiteService
+	nginxService      *services.NginxService
+	auditService      *services.AuditService
+}
+
+func NewHeaderRuleHandler(headerRuleService *services.HeaderRuleService, siteService *services.SiteService, nginxService *services.NginxService, auditService *services.AuditService) *HeaderRuleHandler {
+	return &HeaderRuleHandler{
+		headerRuleService: headerRuleService,
+		siteService:       siteService,
+		nginxService:      nginxService,
+		auditService:      auditService,
+	}
+}
+
+// site loads the site of the request for a user who may change it.
+func (h *HeaderRuleHandler) site(c *gin.Context) (*models.Site, bool) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return nil, false
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return nil, false
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return nil, false
+	}
+	return site, true
+}
+
+// rule loads the header rule of the request, which must belong to site.
+func (h *HeaderRuleHandler) rule(c *gin.Context, site *models.Site) (*models.HeaderRule, bool) {
+	ruleID, err := strconv.ParseInt(c.Param("ruleId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid header rule ID")
+		return nil, false
+	}
+
+	rule, err := h.headerRuleService.GetByID(ruleID)
+	if err != nil || rule.SiteID != site.ID {
+		c.String(http.StatusNotFound, "Header rule not found")
+		return nil, false
+	}
+	return rule, true
+}
+
+// Create adds a header rule after the others, from a preset or the form
+func (h *HeaderRuleHandler) Create(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	var rule *models.HeaderRule
+	if preset := c.PostForm("preset"); preset != "" {
+		var err error
+		if rule, err = services.HeaderRulePresetRule(preset); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		headers, err := parseHeaderLines(c.PostForm("headers"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		rule = &models.HeaderRule{
+			MatchType: models.HeaderMatchType(c.PostForm("match_type")),
+			Match:     c.PostForm("match"),
+			Headers:   headers,
+		}
+	}
+
+	if err := h.headerRuleService.Create(site.ID, rule); err != nil {
+		if isHeaderRuleInputError(err) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to add header rule")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionHeaderRuleAdd, services.EntityHeaderRule, &rule.ID, map[string]interface{}{
+		"site_id":    site.ID,
+		"match_type": rule.MatchType,
+		"match":      rule.Match,
+		"headers":    rule.Headers,
+	}, c.ClientIP())
+
+	h.apply(c, site.ID)
+}
+
+// Delete removes a header rule
+func (h *HeaderRuleHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+	rule, ok := h.rule(c, site)
+	if !ok {
+		return
+	}
+
+	if err := h.headerRuleService.Delete(rule.ID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete header rule")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionHeaderRuleDel, services.EntityHeaderRule, &rule.ID, map[string]interface{}{
+		"site_id":    site.ID,
+		"match_type": rule.MatchType,
+		"match":      rule.Match,
+	}, c.ClientIP())
+
+	h.apply(c, site.ID)
+}
+
+// Move shifts a header rule one place up or down, changing which rule sets
+// a header first
+func (h *HeaderRuleHandler) Move(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+	rule, ok := h.rule(c, site)
+	if !ok {
+		return
+	}
+
+	up := c.PostForm("direction") == "up"
+	if err := h.headerRuleService.Move(site.ID, rule.ID, up); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to move header rule")
+		return
+	}
+
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	h.auditService.LogUser(user.ID, services.ActionHeaderRuleMove, services.EntityHeaderRule, &rule.ID, map[string]interface{}{
+		"site_id":   site.ID,
+		"direction": direction,
+	}, c.ClientIP())
+
+	h.apply(c, site.ID)
+}
+
+// apply regenerates the nginx config of a site and goes back to it.
+func (h *HeaderRuleHandler) apply(c *gin.Context, siteID int64) {
+	if err := h.nginxService.ApplyConfig(siteID); err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+// parseHeaderLines reads headers written one "Name: value" per line.
+func parseHeaderLines(text string) ([]models.ResponseHeader, error) {
+	var headers []models.ResponseHeader
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.New(`write headers as "Name: value", one per line`)
+		}
+		headers = append(headers, models.ResponseHeader{Name: name, Value: value})
+	}
+	return headers, nil
+}
+
+// isHeaderRuleInputError reports whether err comes from an invalid header
+// rule rather than from saving it.
+func isHeaderRuleInputError(err error) bool {
+	return errors.Is(err, services.ErrInvalidHeaderMatch) || errors.Is(err, services.ErrInvalidRulePath) ||
+		errors.Is(err, services.ErrInvalidExtension) || errors.Is(err, services.ErrNoRuleHeaders) ||
+		errors.Is(err, services.ErrReservedHeader) || errors.Is(err, services.ErrDuplicateRuleHeader) ||
+		errors.Is(err, services.ErrUnknownPreset) || errors.Is(err, services.ErrTooManyHeaderRules) ||
+		errors.Is(err, services.ErrTooManyRuleHeaders) || errors.Is(err, validators.ErrInvalidHeaderName) ||
+		errors.Is(err, validators.ErrInvalidHeader)
+}
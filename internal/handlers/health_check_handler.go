@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/services"
+)
+
+type HealthCheckHandler struct {
+	healthCheckService *services.HealthCheckService
+	siteService        *services.SiteService
+	auditService       *services.AuditService
+}
+
+func NewHealthCheckHandler(healthCheckService *services.HealthCheckService, siteService *services.SiteService, auditService *services.AuditService) *HealthCheckHandler {
+	return &HealthCheckHandler{
+		healthCheckService: healthCheckService,
+		siteService:        siteService,
+		auditService:       auditService,
+	}
+}
+
+func (h *HealthCheckHandler) Create(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	path := c.PostForm("path")
+	bodyContains := c.PostForm("body_contains")
+
+	expectedStatus := http.StatusOK
+	if statusStr := c.PostForm("expected_status"); statusStr != "" {
+		parsed, err := strconv.Atoi(statusStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid expected status")
+			return
+		}
+		expectedStatus = parsed
+	}
+
+	check, err := h.healthCheckService.Create(siteID, path, expectedStatus, bodyContains)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionHealthCheckAdd, services.EntityHealthCheck, &check.ID, map[string]interface{}{
+		"path":            check.Path,
+		"expected_status": check.ExpectedStatus,
+		"site_id":         siteID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
+
+func (h *HealthCheckHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	checkID, err := strconv.ParseInt(c.Param("checkId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid health check ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	check, err := h.healthCheckService.GetByID(checkID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Health check not found")
+		return
+	}
+
+	if check.SiteID != siteID {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if err := h.healthCheckService.Delete(checkID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete health check")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionHealthCheckDel, services.EntityHealthCheck, &checkID, map[string]interface{}{
+		"path":    check.Path,
+		"site_id": siteID,
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
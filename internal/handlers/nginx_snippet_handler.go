@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+)
+
+// NginxSnippetHandler sets the custom nginx snippets of a site. Snippets
+// change how nginx serves the site beyond what the panel checks, so only
+// admins set them.
+type NginxSnippetHandler struct {
+	snippetService *services.NginxSnippetService
+	siteService    *services.SiteService
+	auditService   *services.AuditService
+}
+
+func NewNginxSnippetHandler(snippetService *services.NginxSnippetService, siteService *services.SiteService, auditService *services.AuditService) *NginxSnippetHandler {
+	return &NginxSnippetHandler{
+		snippetService: snippetService,
+		siteService:    siteService,
+		auditService:   auditService,
+	}
+}
+
+// Update replaces the snippets of a site (admin only)
+func (h *NginxSnippetHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	snippets := make(map[models.SnippetHook]string)
+	for _, hook := range models.SnippetHooks {
+		snippets[hook] = c.PostForm("snippet_" + string(hook))
+	}
+
+	// Snippets nginx rejected are not kept; when nginx fails otherwise they
+	// are, and the error is passed on like other nginx errors
+	saved, err := h.snippetService.Set(site, snippets)
+	if err != nil && saved == nil {
+		if isSnippetInputError(err) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save snippets")
+		return
+	}
+	if err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionSnippetsUpdate, services.EntitySite, &site.ID, map[string]interface{}{
+		"hooks": snippetHooks(saved),
+	}, c.ClientIP())
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(site.ID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(site.ID, 10))
+}
+
+// snippetHooks lists the hooks that have a snippet, for the audit log.
+func snippetHooks(snippets map[models.SnippetHook]string) []models.SnippetHook {
+	hooks := []models.SnippetHook{}
+	for _, hook := range models.SnippetHooks {
+		if snippets[hook] != "" {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+func isSnippetInputError(err error) bool {
+	return errors.Is(err, services.ErrInvalidSnippetHook) || errors.Is(err, services.ErrSnippetTooLong) ||
+		errors.Is(err, services.ErrInvalidSnippet) || errors.Is(err, services.ErrDeniedDirective) ||
+		errors.Is(err, services.ErrSnippetPath) || errors.Is(err, services.ErrSnippetRejected)
+}
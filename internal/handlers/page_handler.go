@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+)
+
+// PageHandler sets how a site answers paths without a file: the SPA
+// fallback and the custom error pages.
+type PageHandler struct {
+	pageService  *services.PageService
+	siteService  *services.SiteService
+	nginxService *services.NginxService
+	auditService *services.AuditService
+}
+
+func NewPageHandler(pageService *services.PageService, siteService *services.SiteService, nginxService *services.NginxService, auditService *services.AuditService) *PageHandler {
+	return &PageHandler{
+		pageService:  pageService,
+		siteService:  siteService,
+		nginxService: nginxService,
+		auditService: auditService,
+	}
+}
+
+// Update changes the SPA fallback and error pages of a site
+func (h *PageHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	pages, err := h.pageService.Set(site, models.SitePages{
+		SPAFallback:  c.PostForm("spa_fallback") == "on",
+		ErrorPage404: c.PostForm("error_page_404"),
+		ErrorPage403: c.PostForm("error_page_403"),
+		ErrorPage50x: c.PostForm("error_page_50x"),
+	})
+	if err != nil {
+		if isPageInputError(err) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save pages")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionPagesUpdate, services.EntitySite, &site.ID, map[string]interface{}{
+		"spa_fallback":   pages.SPAFallback,
+		"error_page_404": pages.ErrorPage404,
+		"error_page_403": pages.ErrorPage403,
+		"error_page_50x": pages.ErrorPage50x,
+	}, c.ClientIP())
+
+	// Regenerate nginx config
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(site.ID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(site.ID, 10))
+}
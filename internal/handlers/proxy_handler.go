@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+)
+
+// ProxyHandler sets how a site is served: static files, an upstream, or
+// both. Upstreams reach services on the server, so only admins set them.
+type ProxyHandler struct {
+	proxyService *services.ProxyService
+	siteService  *services.SiteService
+	nginxService *services.NginxService
+	auditService *services.AuditService
+}
+
+func NewProxyHandler(proxyService *services.ProxyService, siteService *services.SiteService, nginxService *services.NginxService, auditService *services.AuditService) *ProxyHandler {
+	return &ProxyHandler{
+		proxyService: proxyService,
+		siteService:  siteService,
+		nginxService: nginxService,
+		auditService: auditService,
+	}
+}
+
+// Update changes the type of a site and its upstream (admin only)
+func (h *ProxyHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	timeouts := make(map[string]int)
+	for _, field := range []string{"connect_timeout", "read_timeout", "send_timeout"} {
+		if value := c.PostForm(field); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				c.String(http.StatusBadRequest, "Timeouts must be whole seconds")
+				return
+			}
+			timeouts[field] = seconds
+		}
+	}
+
+	proxy, err := h.proxyService.Set(site, models.SiteType(c.PostForm("type")), &models.ProxyConfig{
+		Upstreams:      strings.Fields(c.PostForm("upstreams")),
+		ConnectTimeout: timeouts["connect_timeout"],
+		ReadTimeout:    timeouts["read_timeout"],
+		SendTimeout:    timeouts["send_timeout"],
+		WebSocket:      c.PostForm("websocket") == "on",
+		PassHeaders:    strings.Fields(c.PostForm("pass_headers")),
+	})
+	if err != nil {
+		if isProxyInputError(err) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save upstream")
+		return
+	}
+
+	details := map[string]interface{}{
+		"type": site.Type,
+	}
+	if proxy != nil {
+		details["upstreams"] = proxy.Upstreams
+		details["websocket"] = proxy.WebSocket
+	}
+	h.auditService.LogUser(user.ID, services.ActionProxyUpdate, services.EntitySite, &site.ID, details, c.ClientIP())
+
+	// Regenerate nginx config
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(site.ID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(site.ID, 10))
+}
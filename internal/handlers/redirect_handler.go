@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"micropanel/internal/middleware"
+	"micropanel/internal/models"
 	"micropanel/internal/services"
 )
 
@@ -44,36 +45,40 @@ func (h *RedirectHandler) Create(c *gin.Context) {
 		return
 	}
 
-	sourcePath := c.PostForm("source_path")
-	targetURL := c.PostForm("target_url")
-	codeStr := c.PostForm("code")
-	preservePath := c.PostForm("preserve_path") == "on"
-	preserveQuery := c.PostForm("preserve_query") == "on"
-	priorityStr := c.PostForm("priority")
+	redirect := &models.Redirect{
+		MatchType:     models.RedirectMatchType(c.PostForm("match_type")),
+		SourcePath:    c.PostForm("source_path"),
+		TargetURL:     c.PostForm("target_url"),
+		Code:          301,
+		PreservePath:  c.PostForm("preserve_path") == "on",
+		PreserveQuery: c.PostForm("preserve_query") == "on",
+		Host:          c.PostForm("host"),
+		QueryArg:      c.PostForm("query_arg"),
+		QueryValue:    c.PostForm("query_value"),
+	}
 
-	code := 301
-	if codeStr != "" {
+	if codeStr := c.PostForm("code"); codeStr != "" {
 		if parsed, err := strconv.Atoi(codeStr); err == nil {
-			code = parsed
+			redirect.Code = parsed
 		}
 	}
 
-	priority := 0
-	if priorityStr != "" {
+	if priorityStr := c.PostForm("priority"); priorityStr != "" {
 		if parsed, err := strconv.Atoi(priorityStr); err == nil {
-			priority = parsed
+			redirect.Priority = parsed
 		}
 	}
 
-	redirect, err := h.redirectService.Create(siteID, sourcePath, targetURL, code, preservePath, preserveQuery, priority)
-	if err != nil {
+	if err := h.redirectService.Create(siteID, redirect); err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
 	h.auditService.LogUser(user.ID, services.ActionRedirectAdd, services.EntityRedirect, &redirect.ID, map[string]interface{}{
-		"source_path": sourcePath,
-		"target_url":  targetURL,
+		"match_type":  redirect.MatchType,
+		"source_path": redirect.SourcePath,
+		"target_url":  redirect.TargetURL,
+		"code":        redirect.Code,
 		"site_id":     siteID,
 	}, c.ClientIP())
 
@@ -123,11 +128,17 @@ func (h *RedirectHandler) Update(c *gin.Context) {
 		return
 	}
 
+	if matchType := c.PostForm("match_type"); matchType != "" {
+		redirect.MatchType = models.RedirectMatchType(matchType)
+	}
 	redirect.SourcePath = c.PostForm("source_path")
 	redirect.TargetURL = c.PostForm("target_url")
 	redirect.PreservePath = c.PostForm("preserve_path") == "on"
 	redirect.PreserveQuery = c.PostForm("preserve_query") == "on"
 	redirect.IsEnabled = c.PostForm("is_enabled") == "on"
+	redirect.Host = c.PostForm("host")
+	redirect.QueryArg = c.PostForm("query_arg")
+	redirect.QueryValue = c.PostForm("query_value")
 
 	if codeStr := c.PostForm("code"); codeStr != "" {
 		if parsed, err := strconv.Atoi(codeStr); err == nil {
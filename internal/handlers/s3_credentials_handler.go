@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+)
+
+// S3CredentialsHandler manages the object storage credentials remote deploys
+// of a site read S3 objects with.
+type S3CredentialsHandler struct {
+	s3Service    *services.S3CredentialsService
+	siteService  *services.SiteService
+	auditService *services.AuditService
+}
+
+func NewS3CredentialsHandler(s3Service *services.S3CredentialsService, siteService *services.SiteService, auditService *services.AuditService) *S3CredentialsHandler {
+	return &S3CredentialsHandler{
+		s3Service:    s3Service,
+		siteService:  siteService,
+		auditService: auditService,
+	}
+}
+
+// site loads the site of the request if the user may manage it.
+func (h *S3CredentialsHandler) site(c *gin.Context) (*models.Site, bool) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return nil, false
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return nil, false
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return nil, false
+	}
+	return site, true
+}
+
+func (h *S3CredentialsHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	creds := &models.S3Credentials{
+		SiteID:          site.ID,
+		Endpoint:        c.PostForm("endpoint"),
+		Region:          c.PostForm("region"),
+		AccessKeyID:     c.PostForm("access_key_id"),
+		SecretAccessKey: c.PostForm("secret_access_key"),
+		PathStyle:       c.PostForm("path_style") == "on",
+	}
+	if err := h.s3Service.Set(creds); err != nil {
+		if errors.Is(err, services.ErrInvalidS3Endpoint) || errors.Is(err, services.ErrInvalidS3Region) ||
+			errors.Is(err, services.ErrS3AccessKey) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save S3 credentials")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionS3Credentials, services.EntitySite, &site.ID, map[string]interface{}{
+		"endpoint":      creds.Endpoint,
+		"access_key_id": creds.AccessKeyID,
+	}, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+func (h *S3CredentialsHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	if err := h.s3Service.Delete(site.ID); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete S3 credentials")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionS3CredsDelete, services.EntitySite, &site.ID, nil, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+func (h *S3CredentialsHandler) redirect(c *gin.Context, siteID int64) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"micropanel/internal/middleware"
+	"micropanel/internal/models"
 	"micropanel/internal/services"
 	"micropanel/internal/templates/pages"
 )
@@ -30,11 +33,7 @@ func (h *SettingsHandler) Page(c *gin.Context) {
 		return
 	}
 
-	info := h.settingsService.GetServerInfo()
-	csrfToken := middleware.GetCSRFToken(c)
-
-	component := pages.Settings(user, info, csrfToken, "")
-	component.Render(c.Request.Context(), c.Writer)
+	h.render(c, user, "")
 }
 
 func (h *SettingsHandler) Update(c *gin.Context) {
@@ -49,25 +48,64 @@ func (h *SettingsHandler) Update(c *gin.Context) {
 	serverNotes := c.PostForm("server_notes")
 
 	if err := h.settingsService.UpdateServerName(serverName); err != nil {
-		info := h.settingsService.GetServerInfo()
-		csrfToken := middleware.GetCSRFToken(c)
-		component := pages.Settings(user, info, csrfToken, "Failed to save settings")
-		component.Render(c.Request.Context(), c.Writer)
+		h.render(c, user, "Failed to save settings")
 		return
 	}
 
 	if err := h.settingsService.UpdateServerNotes(serverNotes); err != nil {
-		info := h.settingsService.GetServerInfo()
-		csrfToken := middleware.GetCSRFToken(c)
-		component := pages.Settings(user, info, csrfToken, "Failed to save settings")
-		component.Render(c.Request.Context(), c.Writer)
+		h.render(c, user, "Failed to save settings")
 		return
 	}
 
 	h.auditService.LogUser(user.ID, "settings_update", "settings", nil, nil, c.ClientIP())
 
+	h.render(c, user, "Settings saved successfully")
+}
+
+func (h *SettingsHandler) UpdateDeployPolicy(c *gin.Context) {
+	user := middleware.GetUser(c)
+
+	if !user.IsAdmin() {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	policy := &models.DeployPolicy{
+		RequireIndex: c.PostForm("require_index") == "on",
+		BlockSecrets: c.PostForm("block_secrets") == "on",
+	}
+	for _, line := range strings.Split(c.PostForm("forbidden_paths"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			policy.ForbiddenPaths = append(policy.ForbiddenPaths, line)
+		}
+	}
+	if size := strings.TrimSpace(c.PostForm("max_total_size_mb")); size != "" {
+		mb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || mb < 0 {
+			h.render(c, user, "Maximum total size must be a non-negative number of megabytes")
+			return
+		}
+		policy.MaxTotalSize = mb * 1024 * 1024
+	}
+
+	if err := h.settingsService.UpdateDeployPolicy(policy); err != nil {
+		h.render(c, user, "Failed to save deploy policy: "+err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionDeployPolicy, "settings", nil, policy, c.ClientIP())
+
+	h.render(c, user, "Deploy policy saved successfully")
+}
+
+func (h *SettingsHandler) render(c *gin.Context, user *models.User, message string) {
 	info := h.settingsService.GetServerInfo()
+	policy, err := h.settingsService.GetDeployPolicy()
+	if err != nil {
+		policy = &models.DeployPolicy{}
+	}
 	csrfToken := middleware.GetCSRFToken(c)
-	component := pages.Settings(user, info, csrfToken, "Settings saved successfully")
+
+	component := pages.Settings(user, info, policy, csrfToken, message)
 	component.Render(c.Request.Context(), c.Writer)
 }
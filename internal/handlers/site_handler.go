@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -22,6 +24,17 @@ type SiteHandler struct {
 	settingsService *services.SettingsService
 	nginxService    *services.NginxService
 	sslService      *services.SSLService
+	limitsService   *services.LimitsService
+	healthService   *services.HealthCheckService
+	keyService      *services.DeployKeyService
+	freezeService   *services.FreezeWindowService
+	s3Service       *services.S3CredentialsService
+	stagingService  *services.StagingService
+	approvalService *services.ApprovalService
+	proxyService    *services.ProxyService
+	pageService     *services.PageService
+	headerService   *services.HeaderRuleService
+	snippetService  *services.NginxSnippetService
 }
 
 func NewSiteHandler(siteService *services.SiteService, deployService *services.DeployService, redirectService *services.RedirectService, authZoneService *services.AuthZoneService, auditService *services.AuditService, settingsService *services.SettingsService, nginxService *services.NginxService, sslService *services.SSLService) *SiteHandler {
@@ -37,6 +50,50 @@ func NewSiteHandler(siteService *services.SiteService, deployService *services.D
 	}
 }
 
+func (h *SiteHandler) SetLimitsService(limitsService *services.LimitsService) {
+	h.limitsService = limitsService
+}
+
+func (h *SiteHandler) SetHealthCheckService(healthService *services.HealthCheckService) {
+	h.healthService = healthService
+}
+
+func (h *SiteHandler) SetDeployKeyService(keyService *services.DeployKeyService) {
+	h.keyService = keyService
+}
+
+func (h *SiteHandler) SetFreezeWindowService(freezeService *services.FreezeWindowService) {
+	h.freezeService = freezeService
+}
+
+func (h *SiteHandler) SetS3CredentialsService(s3Service *services.S3CredentialsService) {
+	h.s3Service = s3Service
+}
+
+func (h *SiteHandler) SetStagingService(stagingService *services.StagingService) {
+	h.stagingService = stagingService
+}
+
+func (h *SiteHandler) SetApprovalService(approvalService *services.ApprovalService) {
+	h.approvalService = approvalService
+}
+
+func (h *SiteHandler) SetProxyService(proxyService *services.ProxyService) {
+	h.proxyService = proxyService
+}
+
+func (h *SiteHandler) SetPageService(pageService *services.PageService) {
+	h.pageService = pageService
+}
+
+func (h *SiteHandler) SetHeaderRuleService(headerService *services.HeaderRuleService) {
+	h.headerService = headerService
+}
+
+func (h *SiteHandler) SetNginxSnippetService(snippetService *services.NginxSnippetService) {
+	h.snippetService = snippetService
+}
+
 func (h *SiteHandler) Dashboard(c *gin.Context) {
 	user := middleware.GetUser(c)
 	csrfToken := middleware.GetCSRFToken(c)
@@ -148,7 +205,57 @@ func (h *SiteHandler) View(c *gin.Context) {
 	// Get auth zones with users
 	authZones, _ := h.authZoneService.ListBySiteWithUsers(id)
 
-	component := pages.SiteView(user, site, deploys, redirects, authZones, canRollback, csrfToken)
+	// Get deploy health checks
+	healthChecks, _ := h.healthService.ListBySite(id)
+
+	// Get keys that sign deploy archives
+	deployKeys, _ := h.keyService.ListForSite(id)
+
+	// Get periods without deploys
+	freezeWindows, _ := h.freezeService.ListBySite(id)
+	s3Creds, _ := h.s3Service.Get(id)
+
+	// Get the staging slot and the release it serves
+	staging, _ := h.stagingService.Get(id)
+	staged, _ := h.deployService.GetStagedDeploy(id)
+
+	// Get who approves held deploys
+	approvers, _ := h.approvalService.ListApprovers(id)
+
+	// Get the upstream of proxy and hybrid sites
+	proxy, _ := h.proxyService.Get(id)
+
+	// Get the files of the live release that can be error pages
+	pageChoices, err := h.pageService.ListPages(id)
+	if err != nil {
+		slog.Warn("failed to list pages of the live release", "site_id", id, "error", err)
+	}
+
+	// Get the response header rules
+	headerRules, _ := h.headerService.ListBySite(id)
+	var snippets map[models.SnippetHook]string
+	if user.IsAdmin() {
+		snippets, _ = h.snippetService.Get(id)
+	}
+
+	// Get directories kept across deploys
+	sharedPaths, _ := h.deployService.SharedPaths(id)
+
+	// Get limits and disk usage
+	limits, err := h.limitsService.ForSite(id)
+	if err != nil {
+		slog.Error("failed to resolve site limits", "site_id", id, "error", err)
+	}
+	usage, err := h.limitsService.Usage(id)
+	if err != nil {
+		slog.Error("failed to measure disk usage", "site_id", id, "error", err)
+	}
+	var overrides *models.LimitOverrides
+	if user.IsAdmin() {
+		overrides, _ = h.limitsService.GetSiteOverrides(id)
+	}
+
+	component := pages.SiteView(user, site, deploys, redirects, authZones, healthChecks, deployKeys, freezeWindows, s3Creds, staging, staged, approvers, proxy, pageChoices, headerRules, snippets, sharedPaths, canRollback, limits, usage, overrides, csrfToken)
 	component.Render(c.Request.Context(), c.Writer)
 }
 
@@ -177,11 +284,53 @@ func (h *SiteHandler) Update(c *gin.Context) {
 	oldEnabled := site.IsEnabled
 	oldWWWAlias := site.WWWAlias
 	oldFixMimeTypes := site.FixMimeTypes
+	oldKeepReleases := site.KeepReleases
+	oldPrecompress := site.Precompress
+	oldKeepArchives := site.KeepArchives
+	oldArchiveMaxAge := site.ArchiveMaxAge
+	oldArchiveMaxSize := site.ArchiveMaxSize
 
 	site.Name = c.PostForm("name")
 	site.IsEnabled = c.PostForm("is_enabled") == "on"
 	site.WWWAlias = c.PostForm("www_alias") == "on"
 	site.FixMimeTypes = c.PostForm("fix_mime_types") == "on"
+	site.Precompress = c.PostForm("precompress") == "on"
+
+	if keepStr := c.PostForm("keep_releases"); keepStr != "" {
+		keep, err := strconv.Atoi(keepStr)
+		if err != nil || keep < 0 || keep > 100 {
+			c.String(http.StatusBadRequest, "Releases to keep must be between 0 and 100")
+			return
+		}
+		site.KeepReleases = keep
+	}
+
+	if keepStr := c.PostForm("keep_archives"); keepStr != "" {
+		keep, err := strconv.Atoi(keepStr)
+		if err != nil || keep < 0 || keep > 1000 {
+			c.String(http.StatusBadRequest, "Archives to keep must be between 0 and 1000")
+			return
+		}
+		site.KeepArchives = keep
+	}
+
+	if daysStr := c.PostForm("archive_max_age"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days < 0 || days > 3650 {
+			c.String(http.StatusBadRequest, "Archive age must be between 0 and 3650 days")
+			return
+		}
+		site.ArchiveMaxAge = days
+	}
+
+	if sizeStr := c.PostForm("archive_max_size_mb"); sizeStr != "" {
+		mb, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || mb < 0 || mb > 1024*1024 {
+			c.String(http.StatusBadRequest, "Archive size must be whole megabytes")
+			return
+		}
+		site.ArchiveMaxSize = mb * 1024 * 1024
+	}
 
 	if err := h.siteService.Update(site); err != nil {
 		c.String(http.StatusInternalServerError, "Error updating site")
@@ -189,17 +338,33 @@ func (h *SiteHandler) Update(c *gin.Context) {
 	}
 
 	// Regenerate nginx config if relevant fields changed
-	if oldName != site.Name || oldEnabled != site.IsEnabled || oldWWWAlias != site.WWWAlias || oldFixMimeTypes != site.FixMimeTypes {
+	if oldName != site.Name || oldEnabled != site.IsEnabled || oldWWWAlias != site.WWWAlias || oldFixMimeTypes != site.FixMimeTypes || oldPrecompress != site.Precompress {
 		h.nginxService.ApplyConfig(site.ID)
 	}
 
+	// The current release gets its compressed copies without waiting for
+	// the next deploy
+	if site.Precompress && !oldPrecompress {
+		go func(siteID int64) {
+			if err := h.deployService.PrecompressCurrent(siteID); err != nil {
+				slog.Warn("failed to precompress current release", "site_id", siteID, "error", err)
+			}
+		}(site.ID)
+	}
+
 	// Log site update
-	if oldName != site.Name || oldEnabled != site.IsEnabled || oldWWWAlias != site.WWWAlias || oldFixMimeTypes != site.FixMimeTypes {
+	if oldName != site.Name || oldEnabled != site.IsEnabled || oldWWWAlias != site.WWWAlias || oldFixMimeTypes != site.FixMimeTypes || oldKeepReleases != site.KeepReleases || oldPrecompress != site.Precompress ||
+		oldKeepArchives != site.KeepArchives || oldArchiveMaxAge != site.ArchiveMaxAge || oldArchiveMaxSize != site.ArchiveMaxSize {
 		h.auditService.LogUser(user.ID, services.ActionSiteUpdate, services.EntitySite, &site.ID, map[string]interface{}{
-			"name":           site.Name,
-			"is_enabled":     site.IsEnabled,
-			"www_alias":      site.WWWAlias,
-			"fix_mime_types": site.FixMimeTypes,
+			"name":             site.Name,
+			"is_enabled":       site.IsEnabled,
+			"www_alias":        site.WWWAlias,
+			"fix_mime_types":   site.FixMimeTypes,
+			"keep_releases":    site.KeepReleases,
+			"precompress":      site.Precompress,
+			"keep_archives":    site.KeepArchives,
+			"archive_max_age":  site.ArchiveMaxAge,
+			"archive_max_size": site.ArchiveMaxSize,
 		}, ip)
 	}
 
@@ -212,6 +377,168 @@ func (h *SiteHandler) Update(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(id, 10))
 }
 
+// UpdateLimits sets the limit overrides of a site (admin only). Empty fields
+// inherit the owner's or the config limits.
+func (h *SiteHandler) UpdateLimits(c *gin.Context) {
+	user := middleware.GetUser(c)
+	ip := c.ClientIP()
+
+	if !user.IsAdmin() {
+		c.String(http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	overrides, err := parseLimitOverrides(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid limits: %s", err.Error())
+		return
+	}
+
+	if err := h.limitsService.SetSiteOverrides(site.ID, overrides); err != nil {
+		c.String(http.StatusBadRequest, "Error saving limits: %s", err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionLimitsUpdate, services.EntitySite, &site.ID, overrides, ip)
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(id, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(id, 10))
+}
+
+// UpdateGitSource links the site to a git repository, or unlinks it when the
+// URL is empty.
+func (h *SiteHandler) UpdateGitSource(c *gin.Context) {
+	user := middleware.GetUser(c)
+	ip := c.ClientIP()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	repoURL := strings.TrimSpace(c.PostForm("git_url"))
+	branch := strings.TrimSpace(c.PostForm("git_branch"))
+	subdir := strings.TrimSpace(c.PostForm("git_subdir"))
+	if err := h.siteService.SetGitSource(site, repoURL, branch, subdir, user.IsAdmin()); err != nil {
+		c.String(http.StatusBadRequest, "Error saving git repository: %s", err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionGitSource, services.EntitySite, &site.ID, map[string]interface{}{
+		"git_url":    site.GitURL,
+		"git_branch": site.GitBranch,
+		"git_subdir": site.GitSubdir,
+	}, ip)
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(id, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(id, 10))
+}
+
+// UpdateSharedPaths sets the directories kept across deploys, one per line.
+func (h *SiteHandler) UpdateSharedPaths(c *gin.Context) {
+	user := middleware.GetUser(c)
+	ip := c.ClientIP()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	site, err := h.siteService.GetByID(id)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return
+	}
+
+	paths, err := h.deployService.SetSharedPaths(site.ID, strings.Split(c.PostForm("paths"), "\n"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Error saving shared paths: %s", err.Error())
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionSharedPaths, services.EntitySite, &site.ID, map[string]interface{}{
+		"paths": paths,
+	}, ip)
+
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(id, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(id, 10))
+}
+
+// parseLimitOverrides reads limit overrides given in megabytes. Empty fields
+// are left unset.
+func parseLimitOverrides(c *gin.Context) (*models.LimitOverrides, error) {
+	overrides := &models.LimitOverrides{}
+	fields := []struct {
+		name string
+		dst  **int64
+	}{
+		{"max_archive_size_mb", &overrides.MaxArchiveSize},
+		{"max_file_size_mb", &overrides.MaxFileSize},
+		{"max_upload_size_mb", &overrides.MaxUploadSize},
+		{"disk_quota_mb", &overrides.DiskQuota},
+	}
+
+	for _, f := range fields {
+		value := strings.TrimSpace(c.PostForm(f.name))
+		if value == "" {
+			continue
+		}
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || mb < 0 || mb > 1024*1024 {
+			return nil, fmt.Errorf("invalid value for %s: use whole megabytes", f.name)
+		}
+		size := mb * 1024 * 1024
+		*f.dst = &size
+	}
+
+	return overrides, nil
+}
+
 func (h *SiteHandler) Files(c *gin.Context) {
 	user := middleware.GetUser(c)
 	csrfToken := middleware.GetCSRFToken(c)
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/services"
+)
+
+// StagingHandler manages the staging slot of a site: its preview hostname,
+// how that is protected and promoting the staged release.
+type StagingHandler struct {
+	stagingService *services.StagingService
+	deployService  *services.DeployService
+	siteService    *services.SiteService
+	nginxService   *services.NginxService
+	auditService   *services.AuditService
+}
+
+func NewStagingHandler(stagingService *services.StagingService, deployService *services.DeployService, siteService *services.SiteService, nginxService *services.NginxService, auditService *services.AuditService) *StagingHandler {
+	return &StagingHandler{
+		stagingService: stagingService,
+		deployService:  deployService,
+		siteService:    siteService,
+		nginxService:   nginxService,
+		auditService:   auditService,
+	}
+}
+
+// site loads the site of the request if the user may manage it.
+func (h *StagingHandler) site(c *gin.Context) (*models.Site, bool) {
+	user := middleware.GetUser(c)
+
+	siteID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid site ID")
+		return nil, false
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		c.String(http.StatusNotFound, "Site not found")
+		return nil, false
+	}
+
+	if !h.siteService.CanAccess(site, user) {
+		c.String(http.StatusForbidden, "Access denied")
+		return nil, false
+	}
+	return site, true
+}
+
+// Update enables the staging slot of a site or changes it
+func (h *StagingHandler) Update(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	allowedIPs := strings.Fields(c.PostForm("allowed_ips"))
+	slot, err := h.stagingService.Set(site, c.PostForm("hostname"), allowedIPs, c.PostForm("auth_user"), c.PostForm("auth_password"))
+	if err != nil {
+		if errors.Is(err, services.ErrStagingHostTaken) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		if isStagingInputError(err) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to save staging slot")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionStagingUpdate, services.EntitySite, &site.ID, map[string]interface{}{
+		"hostname":    slot.PreviewHostname(site.Name),
+		"allowed_ips": slot.AllowedIPs,
+		"auth_user":   slot.AuthUser,
+	}, c.ClientIP())
+
+	// Regenerate nginx config
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	h.redirect(c, site.ID)
+}
+
+// Delete removes the staging slot of a site
+func (h *StagingHandler) Delete(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	if err := h.deployService.DisableStaging(site.ID); err != nil {
+		if isSiteBusy(err) {
+			c.String(http.StatusConflict, "Failed to delete staging slot: %s", err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, "Failed to delete staging slot")
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionStagingDelete, services.EntitySite, &site.ID, nil, c.ClientIP())
+
+	// Regenerate nginx config
+	if err := h.nginxService.ApplyConfig(site.ID); err != nil {
+		c.Header("X-Nginx-Error", err.Error())
+	}
+
+	h.redirect(c, site.ID)
+}
+
+// Promote makes the staged release the production release of a site
+func (h *StagingHandler) Promote(c *gin.Context) {
+	user := middleware.GetUser(c)
+	site, ok := h.site(c)
+	if !ok {
+		return
+	}
+
+	override, ok := freezeOverride(c, user)
+	if !ok {
+		return
+	}
+	if !override {
+		if err := h.deployService.CheckFreeze(site.ID, time.Now()); err != nil {
+			var frozen *services.DeployFrozenError
+			if errors.As(err, &frozen) {
+				c.String(http.StatusLocked, "Cannot promote now, %s", err.Error())
+				return
+			}
+			c.String(http.StatusInternalServerError, "Promote failed")
+			return
+		}
+	}
+
+	deploy, err := h.deployService.Promote(site.ID)
+	if err != nil {
+		switch {
+		case isSiteBusy(err), errors.Is(err, services.ErrNoStagedRelease), errors.Is(err, services.ErrStagingNotPromotable):
+			c.String(http.StatusConflict, "Promote failed: %s", err.Error())
+		case errors.Is(err, services.ErrHealthCheckFailed):
+			c.String(http.StatusUnprocessableEntity, "Promote failed: %s", err.Error())
+		default:
+			c.String(http.StatusInternalServerError, "Promote failed: %s", err.Error())
+		}
+		return
+	}
+
+	h.auditService.LogUser(user.ID, services.ActionPromote, services.EntityDeploy, &deploy.ID, map[string]interface{}{
+		"site_id":         site.ID,
+		"override_freeze": override,
+	}, c.ClientIP())
+
+	h.redirect(c, site.ID)
+}
+
+func (h *StagingHandler) redirect(c *gin.Context, siteID int64) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/sites/"+strconv.FormatInt(siteID, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sites/"+strconv.FormatInt(siteID, 10))
+}
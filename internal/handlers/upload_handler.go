@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/middleware"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/services"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+
+	// statusChecksumMismatch is the status tus answers a chunk with when it
+	// does not match its checksum.
+	statusChecksumMismatch = 460
+)
+
+// UploadHandler speaks the tus resumable upload protocol (core, creation,
+// termination and checksum) for files too large or connections too flaky to
+// send them in one request. It is mounted for the panel session and for API
+// tokens; a complete upload is then named by upload_id when deploying or
+// uploading into the file manager.
+type UploadHandler struct {
+	uploadService *services.UploadService
+	siteService   *services.SiteService
+	userRepo      *repository.UserRepository
+	basePath      string // where the handler is mounted, for the URL of new uploads
+}
+
+func NewUploadHandler(uploadService *services.UploadService, siteService *services.SiteService, userRepo *repository.UserRepository, basePath string) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploadService,
+		siteService:   siteService,
+		userRepo:      userRepo,
+		basePath:      basePath,
+	}
+}
+
+// uploadResponse describes an upload for clients that do not speak tus.
+type uploadResponse struct {
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+}
+
+// Options describes what the server supports.
+// OPTIONS /uploads
+func (h *UploadHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Checksum-Algorithm", "sha256")
+	c.Status(http.StatusNoContent)
+}
+
+// Create starts an upload. Upload-Length is the size of the file and
+// Upload-Metadata must carry site_id and filename, and may carry sha256, the
+// hex checksum of the whole file.
+// POST /uploads
+func (h *UploadHandler) Create(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	user := h.requestUser(c)
+	if user == nil {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "API token must have user_id configured"})
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "Upload-Defer-Length is not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "Upload-Length must be the size of the file in bytes"})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	siteID, err := strconv.ParseInt(metadata["site_id"], 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "Upload-Metadata must carry the site_id"})
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		// What most tus clients send
+		filename = metadata["name"]
+	}
+
+	site, err := h.siteService.GetByID(siteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "site not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load site"})
+		return
+	}
+	if !h.siteService.CanAccess(site, user) {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "access denied"})
+		return
+	}
+
+	upload, err := h.uploadService.Create(site.ID, user.ID, filename, length, metadata["sha256"])
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidUploadName), errors.Is(err, services.ErrInvalidChecksum), errors.Is(err, services.ErrInvalidUploadLength):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrUploadExceedsLimits):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, services.ErrQuotaExceeded):
+			status = http.StatusInsufficientStorage
+		case errors.Is(err, services.ErrTooManyUploads):
+			status = http.StatusTooManyRequests
+		default:
+			slog.Error("failed to create upload", "site_id", site.ID, "error", err)
+			c.JSON(status, errorResponse{Error: "failed to create upload"})
+			return
+		}
+		c.JSON(status, errorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Location", h.basePath+"/"+upload.ID)
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, uploadResponse{UploadID: upload.ID, Offset: 0, Length: upload.Size})
+}
+
+// Head returns how much of an upload was received, to resume it from there.
+// HEAD /uploads/:uploadId
+func (h *UploadHandler) Head(c *gin.Context) {
+	upload, ok := h.ownUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	setUploadDigest(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// Patch appends a chunk at Upload-Offset. An Upload-Checksum header is
+// verified before the chunk is kept.
+// PATCH /uploads/:uploadId
+func (h *UploadHandler) Patch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, errorResponse{Error: "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "Upload-Offset must be the number of bytes already uploaded"})
+		return
+	}
+
+	upload, ok := h.ownUpload(c)
+	if !ok {
+		return
+	}
+
+	upload, err = h.uploadService.Append(upload.ID, offset, c.Request.Body, c.GetHeader("Upload-Checksum"))
+	c.Header("Tus-Resumable", tusVersion)
+	if upload != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrUploadOffset):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrUploadTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, services.ErrUploadChecksum), errors.Is(err, services.ErrUploadFileChecksum):
+			status = statusChecksumMismatch
+		case errors.Is(err, services.ErrInvalidChecksum), errors.Is(err, services.ErrUnsupportedChecksum):
+			status = http.StatusBadRequest
+		default:
+			// Mostly a connection that broke off; what arrived is kept
+			slog.Warn("upload chunk failed", "upload_id", c.Param("uploadId"), "error", err)
+			c.JSON(status, errorResponse{Error: "failed to receive the chunk"})
+			return
+		}
+		c.JSON(status, errorResponse{Error: err.Error()})
+		return
+	}
+	setUploadDigest(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// Delete abandons an upload.
+// DELETE /uploads/:uploadId
+func (h *UploadHandler) Delete(c *gin.Context) {
+	upload, ok := h.ownUpload(c)
+	if !ok {
+		return
+	}
+
+	if err := h.uploadService.Delete(upload.ID); err != nil && !errors.Is(err, services.ErrUploadNotFound) {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to delete upload"})
+		return
+	}
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// requestUser returns the user of the panel session or of the API token, nil
+// for a token without a user.
+func (h *UploadHandler) requestUser(c *gin.Context) *models.User {
+	if user := middleware.GetUser(c); user != nil {
+		return user
+	}
+	userID := getTokenUserID(c)
+	if userID == 0 {
+		return nil
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// ownUpload loads the upload of the request, which only the user who started
+// it can see. It writes the error response and reports false otherwise.
+func (h *UploadHandler) ownUpload(c *gin.Context) (*models.Upload, bool) {
+	if !checkTusVersion(c) {
+		return nil, false
+	}
+	user := h.requestUser(c)
+	if user == nil {
+		c.JSON(http.StatusForbidden, errorResponse{Error: "API token must have user_id configured"})
+		return nil, false
+	}
+
+	upload, err := h.uploadService.Get(c.Param("uploadId"))
+	if err == nil && upload.UserID != user.ID {
+		err = services.ErrUploadNotFound
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrUploadNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse{Error: "upload not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to load upload"})
+		return nil, false
+	}
+	return upload, true
+}
+
+// setUploadDigest tells the client the checksum of a complete upload in an
+// Upload-Digest header, in the format of Upload-Checksum, so it can check
+// the server assembled the file it sent.
+func setUploadDigest(c *gin.Context, upload *models.Upload) {
+	if !upload.IsComplete() {
+		return
+	}
+	sum, err := hex.DecodeString(upload.SHA256)
+	if err != nil || len(sum) == 0 {
+		return
+	}
+	c.Header("Upload-Digest", "sha256 "+base64.StdEncoding.EncodeToString(sum))
+}
+
+// checkTusVersion refuses requests made for another version of tus. Requests
+// without a Tus-Resumable header are taken, for plain HTTP clients.
+func checkTusVersion(c *gin.Context) bool {
+	if version := c.GetHeader("Tus-Resumable"); version != "" && version != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, errorResponse{Error: "unsupported tus version, use " + tusVersion})
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and its base64 value. A key may come without a value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %s is not base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// errNoFile is returned by openPostedFile when the request has no file.
+var errNoFile = errors.New("no file in the request")
+
+// postedFile is the file of a deploy or file manager request: sent in its
+// "file" field, or uploaded beforehand with the resumable upload protocol
+// and named by its upload_id field.
+type postedFile struct {
+	io.ReadCloser
+	Name   string
+	Size   int64
+	Upload *models.Upload // nil for a file sent in the request
+}
+
+// openPostedFile opens the file of a request made by the user. A named
+// upload must be complete and started by the user.
+func openPostedFile(c *gin.Context, uploadService *services.UploadService, userID int64) (*postedFile, error) {
+	if id := strings.TrimSpace(c.PostForm("upload_id")); id != "" {
+		f, upload, err := uploadService.Open(id, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &postedFile{ReadCloser: f, Name: upload.Filename, Size: upload.Size, Upload: upload}, nil
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return nil, errNoFile
+	}
+	return &postedFile{ReadCloser: file, Name: header.Filename, Size: header.Size}, nil
+}
+
+// forSite reports whether the file may be used for the site: an upload is
+// only good for the site it was started for.
+func (f *postedFile) forSite(siteID int64) bool {
+	return f.Upload == nil || f.Upload.SiteID == siteID
+}
+
+// used removes the upload the file came from, once it has been deployed or
+// saved.
+func (f *postedFile) used(uploadService *services.UploadService) {
+	if f.Upload == nil {
+		return
+	}
+	if err := uploadService.Delete(f.Upload.ID); err != nil && !errors.Is(err, services.ErrUploadNotFound) {
+		slog.Error("failed to remove used upload", "upload_id", f.Upload.ID, "error", err)
+	}
+}
+
+// postedFileError describes why openPostedFile failed, with the status to
+// answer. missing is the message for a request without a file.
+func postedFileError(err error, missing string) (int, string) {
+	switch {
+	case errors.Is(err, errNoFile):
+		return http.StatusBadRequest, missing
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound, "upload not found"
+	case errors.Is(err, services.ErrUploadIncomplete):
+		return http.StatusConflict, "upload is not complete yet"
+	default:
+		slog.Error("failed to open posted file", "error", err)
+		return http.StatusInternalServerError, "failed to open the uploaded file"
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"micropanel/internal/models"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	got, err := parseUploadMetadata("site_id " + b64("7") + ", filename " + b64("сайт.zip") + ",is_confidential")
+	if err != nil {
+		t.Fatalf("parseUploadMetadata() error = %v", err)
+	}
+	want := map[string]string{"site_id": "7", "filename": "сайт.zip", "is_confidential": ""}
+	if len(got) != len(want) {
+		t.Fatalf("parseUploadMetadata() = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("parseUploadMetadata()[%q] = %q, want %q", key, got[key], value)
+		}
+	}
+
+	if got, err := parseUploadMetadata(""); err != nil || len(got) != 0 {
+		t.Errorf("parseUploadMetadata(\"\") = %v, %v, want empty", got, err)
+	}
+	if _, err := parseUploadMetadata("filename not-base64!"); err == nil {
+		t.Error("parseUploadMetadata() with a bad value, want error")
+	}
+}
+
+func TestSetUploadDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sum := sha256.Sum256([]byte("site"))
+	upload := &models.Upload{Size: 4, Offset: 4, SHA256: hex.EncodeToString(sum[:])}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	setUploadDigest(c, upload)
+	if got, want := w.Header().Get("Upload-Digest"), "sha256 "+base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("Upload-Digest = %q, want %q", got, want)
+	}
+
+	upload.Offset = 2
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	setUploadDigest(c, upload)
+	if got := w.Header().Get("Upload-Digest"); got != "" {
+		t.Errorf("Upload-Digest of an incomplete upload = %q, want none", got)
+	}
+}
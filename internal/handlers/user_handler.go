@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -17,12 +18,14 @@ import (
 type UserHandler struct {
 	userRepo     *repository.UserRepository
 	auditService *services.AuditService
+	keyService   *services.DeployKeyService
 }
 
-func NewUserHandler(userRepo *repository.UserRepository, auditService *services.AuditService) *UserHandler {
+func NewUserHandler(userRepo *repository.UserRepository, auditService *services.AuditService, keyService *services.DeployKeyService) *UserHandler {
 	return &UserHandler{
 		userRepo:     userRepo,
 		auditService: auditService,
+		keyService:   keyService,
 	}
 }
 
@@ -262,10 +265,19 @@ func (h *UserHandler) Profile(c *gin.Context) {
 	user := middleware.GetUser(c)
 	csrfToken := middleware.GetCSRFToken(c)
 
-	component := pages.Profile(user, csrfToken, "")
+	component := pages.Profile(user, h.deployKeys(user), csrfToken, "")
 	component.Render(c.Request.Context(), c.Writer)
 }
 
+// deployKeys returns the deploy keys of a user shown on the profile page.
+func (h *UserHandler) deployKeys(user *models.User) []*models.DeployKey {
+	keys, err := h.keyService.ListByUser(user.ID)
+	if err != nil {
+		slog.Error("failed to load deploy keys", "user_id", user.ID, "error", err)
+	}
+	return keys
+}
+
 // ChangePassword changes current user's password
 func (h *UserHandler) ChangePassword(c *gin.Context) {
 	user := middleware.GetUser(c)
@@ -277,7 +289,7 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 
 	// Verify current password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
-		component := pages.Profile(user, csrfToken, "Current password is incorrect")
+		component := pages.Profile(user, h.deployKeys(user), csrfToken, "Current password is incorrect")
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		component.Render(c.Request.Context(), c.Writer)
 		return
@@ -285,14 +297,14 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 
 	// Validate new password
 	if len(newPassword) < 6 {
-		component := pages.Profile(user, csrfToken, "New password must be at least 6 characters")
+		component := pages.Profile(user, h.deployKeys(user), csrfToken, "New password must be at least 6 characters")
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		component.Render(c.Request.Context(), c.Writer)
 		return
 	}
 
 	if newPassword != confirmPassword {
-		component := pages.Profile(user, csrfToken, "Passwords do not match")
+		component := pages.Profile(user, h.deployKeys(user), csrfToken, "Passwords do not match")
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		component.Render(c.Request.Context(), c.Writer)
 		return
@@ -301,7 +313,7 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 	// Hash new password
 	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
-		component := pages.Profile(user, csrfToken, "Error changing password")
+		component := pages.Profile(user, h.deployKeys(user), csrfToken, "Error changing password")
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		component.Render(c.Request.Context(), c.Writer)
 		return
@@ -309,7 +321,7 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 
 	user.PasswordHash = string(hash)
 	if err := h.userRepo.Update(user); err != nil {
-		component := pages.Profile(user, csrfToken, "Error changing password")
+		component := pages.Profile(user, h.deployKeys(user), csrfToken, "Error changing password")
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		component.Render(c.Request.Context(), c.Writer)
 		return
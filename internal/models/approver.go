@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// SiteApprover is a user who may approve or reject the deploys a site holds
+// for approval. Admins can approve any site without being listed.
+type SiteApprover struct {
+	SiteID    int64     `json:"site_id"`
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -5,17 +5,112 @@ import "time"
 type DeployStatus string
 
 const (
-	DeployStatusPending DeployStatus = "pending"
-	DeployStatusSuccess DeployStatus = "success"
-	DeployStatusFailed  DeployStatus = "failed"
+	DeployStatusScheduled        DeployStatus = "scheduled" // waiting for its ScheduledAt
+	DeployStatusPending          DeployStatus = "pending"
+	DeployStatusAwaitingApproval DeployStatus = "awaiting_approval" // release built, waiting for an approver to activate it
+	DeployStatusSuccess          DeployStatus = "success"
+	DeployStatusFailed           DeployStatus = "failed"
+	DeployStatusCancelled        DeployStatus = "cancelled" // a scheduled deploy called off before its time
+	DeployStatusRejected         DeployStatus = "rejected"  // an approver turned the release down
+)
+
+// DeployTarget is the release slot of a site a deploy is made for.
+type DeployTarget string
+
+const (
+	DeployTargetProduction DeployTarget = "production"
+	DeployTargetStaging    DeployTarget = "staging" // served on the preview hostname until promoted
+)
+
+// DeployPhase is the step a deploy has reached. A failed deploy keeps the
+// phase it failed in.
+type DeployPhase string
+
+const (
+	DeployPhaseSaving      DeployPhase = "saving"
+	DeployPhaseFetching    DeployPhase = "fetching"  // checking out a git revision or downloading an archive
+	DeployPhaseUploading   DeployPhase = "uploading" // waiting for the files missing from a manifest
+	DeployPhaseQueued      DeployPhase = "queued"
+	DeployPhaseExtracting  DeployPhase = "extracting"
+	DeployPhaseCompressing DeployPhase = "compressing" // writing precompressed copies of text assets
+	DeployPhaseApproval    DeployPhase = "approval"    // release built, held for an approver
+	DeployPhaseActivating  DeployPhase = "activating"
+	DeployPhaseChecking    DeployPhase = "checking" // running the health checks of the site
+	DeployPhaseDone        DeployPhase = "done"
 )
 
 type Deploy struct {
-	ID           int64        `json:"id"`
-	SiteID       int64        `json:"site_id"`
-	UserID       int64        `json:"user_id"`
-	Filename     string       `json:"filename"`
-	Status       DeployStatus `json:"status"`
-	ErrorMessage string       `json:"error_message,omitempty"`
-	CreatedAt    time.Time    `json:"created_at"`
+	ID             int64             `json:"id"`
+	SiteID         int64             `json:"site_id"`
+	UserID         int64             `json:"user_id"`
+	Filename       string            `json:"filename"`
+	CommitSHA      string            `json:"commit_sha,omitempty"`     // Commit a git deploy was built from
+	CommitMessage  string            `json:"commit_message,omitempty"` // Subject line of that commit
+	Status         DeployStatus      `json:"status"`
+	ErrorMessage   string            `json:"error_message,omitempty"`
+	Violations     []PolicyViolation `json:"violations,omitempty"` // Why the deploy policy rejected the release
+	Phase          DeployPhase       `json:"phase"`
+	Progress       int               `json:"progress"`                    // 0-100, extraction progress
+	HasRelease     bool              `json:"has_release"`                 // Release directory is kept on disk and can be restored
+	IsActive       bool              `json:"is_active"`                   // Release currently served by nginx
+	Archive        string            `json:"-"`                           // File name of the archive in deploys/ (empty = none)
+	ArchivePruned  *time.Time        `json:"archive_pruned_at,omitempty"` // When retention removed the archive
+	SignedBy       string            `json:"signed_by,omitempty"`         // Fingerprint of the deploy key that signed the archive
+	ScheduledAt    *time.Time        `json:"scheduled_at,omitempty"`      // When a scheduled deploy goes live
+	FreezeOverride bool              `json:"freeze_override,omitempty"`   // An admin let the deploy through a freeze window
+	Target         DeployTarget      `json:"target"`                      // Slot the deploy was made for; promoted staging deploys become production
+	IsStaged       bool              `json:"is_staged"`                   // Release currently served on the staging hostname
+	NeedsApproval  bool              `json:"needs_approval"`              // The release is held until an approver accepts it
+	ReviewedBy     *int64            `json:"reviewed_by,omitempty"`       // User who approved or rejected the release
+	ReviewedAt     *time.Time        `json:"reviewed_at,omitempty"`
+	ReviewNote     string            `json:"review_note,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// IsRunning reports whether the deploy is still queued or being processed.
+func (d *Deploy) IsRunning() bool {
+	return d.Status == DeployStatusPending
+}
+
+// IsScheduled reports whether the deploy is waiting for its scheduled time.
+func (d *Deploy) IsScheduled() bool {
+	return d.Status == DeployStatusScheduled
+}
+
+// IsAwaitingApproval reports whether the release of the deploy is built and
+// waits for an approver.
+func (d *Deploy) IsAwaitingApproval() bool {
+	return d.Status == DeployStatusAwaitingApproval
+}
+
+// ShortCommitSHA returns the abbreviated commit of a git deploy.
+func (d *Deploy) ShortCommitSHA() string {
+	if len(d.CommitSHA) > 7 {
+		return d.CommitSHA[:7]
+	}
+	return d.CommitSHA
+}
+
+// HasArchive reports whether the archive of the deploy is still kept, so its
+// release can be rebuilt after it was pruned.
+func (d *Deploy) HasArchive() bool {
+	return d.Archive != "" && d.ArchivePruned == nil
+}
+
+// CanRestore reports whether the deploy can be activated again via rollback,
+// from its release or, once that is pruned, from its archive.
+func (d *Deploy) CanRestore() bool {
+	return d.Status == DeployStatusSuccess && (d.HasRelease || d.HasArchive()) && !d.IsActive && !d.IsStaging()
+}
+
+// IsStaging reports whether the deploy was made for the staging slot and has
+// not been promoted.
+func (d *Deploy) IsStaging() bool {
+	return d.Target == DeployTargetStaging
+}
+
+// CanPromote reports whether the release of the deploy is served on staging
+// and can be made the production release.
+func (d *Deploy) CanPromote() bool {
+	return d.IsStaged && d.HasRelease && !d.IsActive
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// DeployKey is an ed25519 public key that may sign deploy archives. It
+// belongs to a site, or to a user and then covers every site the user owns.
+// A site with any key only accepts archives signed by one of them.
+type DeployKey struct {
+	ID          int64     `json:"id"`
+	SiteID      *int64    `json:"site_id,omitempty"`
+	UserID      *int64    `json:"user_id,omitempty"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`  // OpenSSH authorized_keys form, "ssh-ed25519 AAAA..."
+	Fingerprint string    `json:"fingerprint"` // "SHA256:..." as printed by ssh-keygen -l
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "fmt"
+
+// DeployPolicy is what admins allow deploys to publish. It applies to every
+// site; a release that breaks it is not activated.
+type DeployPolicy struct {
+	RequireIndex   bool     `json:"require_index"`   // index.html must be at the root
+	ForbiddenPaths []string `json:"forbidden_paths"` // gitignore-style patterns, e.g. *.php or .git/
+	BlockSecrets   bool     `json:"block_secrets"`   // no private keys or access tokens in files
+	MaxTotalSize   int64    `json:"max_total_size"`  // bytes, 0 = unlimited
+}
+
+// IsEmpty reports whether the policy allows everything.
+func (p *DeployPolicy) IsEmpty() bool {
+	return !p.RequireIndex && len(p.ForbiddenPaths) == 0 && !p.BlockSecrets && p.MaxTotalSize <= 0
+}
+
+// Rules a PolicyViolation can break
+const (
+	PolicyRuleRequireIndex  = "require_index"
+	PolicyRuleForbiddenPath = "forbidden_path"
+	PolicyRuleSecret        = "secret"
+	PolicyRuleMaxTotalSize  = "max_total_size"
+)
+
+// PolicyViolation is a path of a release that breaks a rule of the deploy
+// policy. Path is empty for rules about the release as a whole.
+type PolicyViolation struct {
+	Rule   string `json:"rule"`
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail,omitempty"` // matched pattern, kind of secret or size
+}
+
+func (v PolicyViolation) String() string {
+	switch {
+	case v.Path == "":
+		return fmt.Sprintf("%s (%s)", v.Rule, v.Detail)
+	case v.Detail == "":
+		return fmt.Sprintf("%s: %s", v.Path, v.Rule)
+	default:
+		return fmt.Sprintf("%s: %s (%s)", v.Path, v.Rule, v.Detail)
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// FreezeWindow is a period during which a site takes no deploys, such as a
+// release weekend or a sale. Admins can override it for a single deploy.
+type FreezeWindow struct {
+	ID        int64     `json:"id"`
+	SiteID    int64     `json:"site_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Covers reports whether t falls within the window. The end is exclusive.
+func (w *FreezeWindow) Covers(t time.Time) bool {
+	return !t.Before(w.StartsAt) && t.Before(w.EndsAt)
+}
+
+// IsOver reports whether the window has ended at t.
+func (w *FreezeWindow) IsOver(t time.Time) bool {
+	return !t.Before(w.EndsAt)
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// HeaderMatchType is how a header rule picks the requests it applies to.
+type HeaderMatchType string
+
+const (
+	HeaderMatchAll       HeaderMatchType = "all"       // every request
+	HeaderMatchPrefix    HeaderMatchType = "prefix"    // paths starting with Match
+	HeaderMatchExact     HeaderMatchType = "exact"     // the path Match only
+	HeaderMatchExtension HeaderMatchType = "extension" // files with one of the space-separated extensions in Match
+)
+
+// HeaderRule adds or overrides response headers of the requests it matches.
+// Rules are ordered: for each header, the first matching rule sets it.
+type HeaderRule struct {
+	ID        int64            `json:"id"`
+	SiteID    int64            `json:"site_id"`
+	Position  int              `json:"position"`
+	MatchType HeaderMatchType  `json:"match_type"`
+	Match     string           `json:"match"`
+	Headers   []ResponseHeader `json:"headers"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// ResponseHeader is a header a rule sets. An empty value removes the header,
+// including the security headers the panel sets by default.
+type ResponseHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MatchText describes the requests a rule applies to.
+func (r *HeaderRule) MatchText() string {
+	switch r.MatchType {
+	case HeaderMatchAll:
+		return "All requests"
+	case HeaderMatchExact:
+		return r.Match
+	case HeaderMatchExtension:
+		return "*." + strings.ReplaceAll(r.Match, " ", ", *.")
+	}
+	return r.Match + "*"
+}
@@ -0,0 +1,11 @@
+package models
+
+// HealthCheck is a request made to a site right after a deploy activates a
+// release. The deploy is rolled back when the response does not match.
+type HealthCheck struct {
+	ID             int64  `json:"id"`
+	SiteID         int64  `json:"site_id"`
+	Path           string `json:"path"`
+	ExpectedStatus int    `json:"expected_status"`
+	BodyContains   string `json:"body_contains,omitempty"` // Substring the response body must contain (empty = any body)
+}
@@ -0,0 +1,57 @@
+package models
+
+// Limits are the size limits in effect for a site, in bytes.
+type Limits struct {
+	MaxArchiveSize int64 `json:"max_archive_size"` // deploy archive
+	MaxFileSize    int64 `json:"max_file_size"`    // single file in an archive or saved in the editor
+	MaxUploadSize  int64 `json:"max_upload_size"`  // file manager upload
+	DiskQuota      int64 `json:"disk_quota"`       // whole site directory (0 = unlimited)
+}
+
+// LimitOverrides are limits set by an admin for a site or a user. A nil field
+// inherits: site overrides win over the owner's, which win over the config.
+type LimitOverrides struct {
+	MaxArchiveSize *int64 `json:"max_archive_size,omitempty"`
+	MaxFileSize    *int64 `json:"max_file_size,omitempty"`
+	MaxUploadSize  *int64 `json:"max_upload_size,omitempty"`
+	DiskQuota      *int64 `json:"disk_quota,omitempty"`
+}
+
+// IsEmpty reports whether no limit is overridden.
+func (o *LimitOverrides) IsEmpty() bool {
+	return o.MaxArchiveSize == nil && o.MaxFileSize == nil && o.MaxUploadSize == nil && o.DiskQuota == nil
+}
+
+// Apply replaces the limits that are overridden.
+func (o *LimitOverrides) Apply(l *Limits) {
+	if o.MaxArchiveSize != nil {
+		l.MaxArchiveSize = *o.MaxArchiveSize
+	}
+	if o.MaxFileSize != nil {
+		l.MaxFileSize = *o.MaxFileSize
+	}
+	if o.MaxUploadSize != nil {
+		l.MaxUploadSize = *o.MaxUploadSize
+	}
+	if o.DiskQuota != nil {
+		l.DiskQuota = *o.DiskQuota
+	}
+}
+
+// DiskUsage is the space taken by a site against its quota.
+type DiskUsage struct {
+	Used  int64 `json:"used"`  // bytes
+	Quota int64 `json:"quota"` // bytes (0 = unlimited)
+}
+
+// Percent returns the share of the quota in use, capped at 100.
+func (u *DiskUsage) Percent() int {
+	if u.Quota <= 0 {
+		return 0
+	}
+	p := u.Used * 100 / u.Quota
+	if p > 100 {
+		p = 100
+	}
+	return int(p)
+}
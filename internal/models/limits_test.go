@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestLimitOverrides_Apply(t *testing.T) {
+	quota := int64(500)
+	fileSize := int64(20)
+	overrides := &LimitOverrides{MaxFileSize: &fileSize, DiskQuota: &quota}
+
+	limits := Limits{MaxArchiveSize: 100, MaxFileSize: 10, MaxUploadSize: 10, DiskQuota: 0}
+	overrides.Apply(&limits)
+
+	want := Limits{MaxArchiveSize: 100, MaxFileSize: 20, MaxUploadSize: 10, DiskQuota: 500}
+	if limits != want {
+		t.Errorf("Apply() = %+v, want %+v", limits, want)
+	}
+
+	if overrides.IsEmpty() {
+		t.Error("IsEmpty() = true for overrides with values")
+	}
+	if !(&LimitOverrides{}).IsEmpty() {
+		t.Error("IsEmpty() = false for empty overrides")
+	}
+}
+
+func TestDiskUsage_Percent(t *testing.T) {
+	tests := []struct {
+		usage DiskUsage
+		want  int
+	}{
+		{DiskUsage{Used: 50, Quota: 0}, 0},
+		{DiskUsage{Used: 25, Quota: 100}, 25},
+		{DiskUsage{Used: 150, Quota: 100}, 100},
+	}
+	for _, tt := range tests {
+		if got := tt.usage.Percent(); got != tt.want {
+			t.Errorf("%+v.Percent() = %d, want %d", tt.usage, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// SnippetHook is where a custom nginx snippet goes in the config of a site.
+type SnippetHook string
+
+const (
+	SnippetHookServer         SnippetHook = "server"          // server level, next to the panel's directives
+	SnippetHookBeforeLocation SnippetHook = "before_location" // before location /, for extra locations
+	SnippetHookLocation       SnippetHook = "location"        // inside location /
+)
+
+// SnippetHooks lists the hooks in the order they appear in the config.
+var SnippetHooks = []SnippetHook{SnippetHookServer, SnippetHookBeforeLocation, SnippetHookLocation}
+
+func (h SnippetHook) Valid() bool {
+	switch h {
+	case SnippetHookServer, SnippetHookBeforeLocation, SnippetHookLocation:
+		return true
+	}
+	return false
+}
+
+// NginxSnippet is nginx config an admin added to a site at a hook, for
+// directives the panel does not model.
+type NginxSnippet struct {
+	SiteID    int64       `json:"site_id"`
+	Hook      SnippetHook `json:"hook"`
+	Content   string      `json:"content"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
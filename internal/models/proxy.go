@@ -0,0 +1,28 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// ProxyConfig is the upstream a proxy or hybrid site passes requests to.
+type ProxyConfig struct {
+	SiteID         int64     `json:"site_id"`
+	Upstreams      []string  `json:"upstreams"`              // host:port or unix:/path, balanced round-robin
+	ConnectTimeout int       `json:"connect_timeout"`        // seconds
+	ReadTimeout    int       `json:"read_timeout"`           // seconds
+	SendTimeout    int       `json:"send_timeout"`           // seconds
+	WebSocket      bool      `json:"websocket"`              // pass Upgrade requests through
+	PassHeaders    []string  `json:"pass_headers,omitempty"` // upstream response headers nginx hides by default, such as Server
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// UpstreamsText returns the upstreams one per line, as edited in the panel.
+func (p *ProxyConfig) UpstreamsText() string {
+	return strings.Join(p.Upstreams, "\n")
+}
+
+// PassHeadersText returns the passed headers one per line, as edited in the panel.
+func (p *ProxyConfig) PassHeadersText() string {
+	return strings.Join(p.PassHeaders, "\n")
+}
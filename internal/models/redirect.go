@@ -1,13 +1,38 @@
 package models
 
+// RedirectMatchType is how a redirect matches the path of requests.
+type RedirectMatchType string
+
+const (
+	RedirectMatchExact  RedirectMatchType = "exact"  // the path SourcePath only
+	RedirectMatchPrefix RedirectMatchType = "prefix" // paths starting with SourcePath
+	RedirectMatchRegex  RedirectMatchType = "regex"  // paths matching the regular expression SourcePath
+)
+
+// Redirect sends the requests it matches to TargetURL. Redirects are
+// checked by descending Priority, the first match wins. Regex redirects can
+// use the captures of SourcePath as $1 to $9 in TargetURL.
 type Redirect struct {
-	ID            int64  `json:"id"`
-	SiteID        int64  `json:"site_id"`
-	SourcePath    string `json:"source_path"`
-	TargetURL     string `json:"target_url"`
-	Code          int    `json:"code"`
-	PreservePath  bool   `json:"preserve_path"`
-	PreserveQuery bool   `json:"preserve_query"`
-	Priority      int    `json:"priority"`
-	IsEnabled     bool   `json:"is_enabled"`
+	ID            int64             `json:"id"`
+	SiteID        int64             `json:"site_id"`
+	MatchType     RedirectMatchType `json:"match_type"`
+	SourcePath    string            `json:"source_path"`
+	TargetURL     string            `json:"target_url"`
+	Code          int               `json:"code"`
+	PreservePath  bool              `json:"preserve_path"`
+	PreserveQuery bool              `json:"preserve_query"`
+	Priority      int               `json:"priority"`
+	IsEnabled     bool              `json:"is_enabled"`
+
+	// Conditions, empty when unset: the request is for Host, and has the
+	// query argument QueryArg, equal to QueryValue if that is set
+	Host       string `json:"host"`
+	QueryArg   string `json:"query_arg"`
+	QueryValue string `json:"query_value"`
+}
+
+// HasConditions reports whether the redirect only applies to some hosts or
+// query arguments.
+func (r *Redirect) HasConditions() bool {
+	return r.Host != "" || r.QueryArg != ""
 }
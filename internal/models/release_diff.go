@@ -0,0 +1,40 @@
+package models
+
+// FileChange is how a file differs between two releases.
+type FileChange string
+
+const (
+	FileAdded    FileChange = "added"
+	FileRemoved  FileChange = "removed"
+	FileModified FileChange = "modified"
+)
+
+// ReleaseDiff lists the files that differ between the releases of two deploys.
+type ReleaseDiff struct {
+	FromDeployID int64             `json:"from_deploy_id"`
+	ToDeployID   int64             `json:"to_deploy_id"`
+	Added        int               `json:"added"`
+	Removed      int               `json:"removed"`
+	Modified     int               `json:"modified"`
+	SizeDelta    int64             `json:"size_delta"` // bytes, to minus from
+	Files        []ReleaseFileDiff `json:"files"`
+}
+
+// ReleaseFileDiff is a file that differs between two releases.
+type ReleaseFileDiff struct {
+	Path        string     `json:"path"`
+	Change      FileChange `json:"change"`
+	OldSize     int64      `json:"old_size"`
+	NewSize     int64      `json:"new_size"`
+	SizeDelta   int64      `json:"size_delta"`
+	Diff        string     `json:"diff,omitempty"`         // Unified diff of a text file
+	DiffSkipped string     `json:"diff_skipped,omitempty"` // Why Diff is empty, one of DiffSkipped*
+}
+
+// Reasons a ReleaseFileDiff has no unified diff.
+const (
+	DiffSkippedBinary      = "binary"
+	DiffSkippedTooLarge    = "too_large"        // over the editor size limit
+	DiffSkippedTooComplex  = "too_many_changes" // too many changed lines
+	DiffSkippedOutputLimit = "output_limit"     // the diffs of earlier files used up the budget
+)
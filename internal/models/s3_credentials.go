@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// S3Credentials give a site read access to an S3-compatible object storage,
+// such as AWS S3 or MinIO, to deploy archives kept in its buckets.
+type S3Credentials struct {
+	SiteID          int64     `json:"site_id"`
+	Endpoint        string    `json:"endpoint"` // base URL, e.g. https://minio.example.com:9000
+	Region          string    `json:"region"`
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"-"`
+	PathStyle       bool      `json:"path_style"` // bucket in the path rather than the host name
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -2,18 +2,50 @@ package models
 
 import "time"
 
+// SiteType is how nginx serves a site.
+type SiteType string
+
+const (
+	SiteTypeStatic SiteType = "static" // files of the active release
+	SiteTypeProxy  SiteType = "proxy"  // every request goes to the upstream
+	SiteTypeHybrid SiteType = "hybrid" // files of the active release, other requests go to the upstream
+)
+
+// Valid reports whether t is a known site type.
+func (t SiteType) Valid() bool {
+	switch t {
+	case SiteTypeStatic, SiteTypeProxy, SiteTypeHybrid:
+		return true
+	}
+	return false
+}
+
 type Site struct {
-	ID           int64      `json:"id"`
-	Name         string     `json:"name"` // Primary hostname (domain)
-	OwnerID      int64      `json:"owner_id"`
-	IsEnabled    bool       `json:"is_enabled"`
-	SSLEnabled   bool       `json:"ssl_enabled"`
-	SSLExpiresAt *time.Time `json:"ssl_expires_at,omitempty"`
-	SSLCertName  string     `json:"ssl_cert_name,omitempty"` // certbot --cert-name (may differ from Name)
-	WWWAlias     bool       `json:"www_alias"`               // Add www. alias
-	FixMimeTypes bool       `json:"fix_mime_types"`          // Fix MIME types for files with encoded query strings
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID              int64      `json:"id"`
+	Name            string     `json:"name"` // Primary hostname (domain)
+	OwnerID         int64      `json:"owner_id"`
+	IsEnabled       bool       `json:"is_enabled"`
+	SSLEnabled      bool       `json:"ssl_enabled"`
+	SSLExpiresAt    *time.Time `json:"ssl_expires_at,omitempty"`
+	SSLCertName     string     `json:"ssl_cert_name,omitempty"` // certbot --cert-name (may differ from Name)
+	WWWAlias        bool       `json:"www_alias"`               // Add www. alias
+	FixMimeTypes    bool       `json:"fix_mime_types"`          // Fix MIME types for files with encoded query strings
+	KeepReleases    int        `json:"keep_releases"`           // Number of releases kept on disk (0 = config default)
+	GitURL          string     `json:"git_url,omitempty"`       // Repository deployed by "deploy from git" (empty = none)
+	GitBranch       string     `json:"git_branch,omitempty"`    // Branch checked out from GitURL
+	GitSubdir       string     `json:"git_subdir,omitempty"`    // Directory of the repository served as the site root (empty = top level)
+	Precompress     bool       `json:"precompress"`             // Write .gz/.br copies of text assets on deploy and serve them with gzip_static
+	KeepArchives    int        `json:"keep_archives"`           // Uploaded archives kept per site (0 = config default)
+	ArchiveMaxAge   int        `json:"archive_max_age"`         // Days an uploaded archive is kept (0 = config default)
+	ArchiveMaxSize  int64      `json:"archive_max_size"`        // Bytes of uploaded archives kept (0 = config default)
+	RequireApproval bool       `json:"require_approval"`        // Deploys by non-admins and API tokens wait for an approver
+	Type            SiteType   `json:"type"`                    // static, proxy or hybrid
+	SPAFallback     bool       `json:"spa_fallback"`            // Serve /index.html for unknown paths that are not assets
+	ErrorPage404    string     `json:"error_page_404"`          // File of the release shown for 404 (empty = nginx default)
+	ErrorPage403    string     `json:"error_page_403"`          // File of the release shown for 403 (empty = nginx default)
+	ErrorPage50x    string     `json:"error_page_50x"`          // File of the release shown for 500, 502, 503 and 504 (empty = nginx default)
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 
 	// Relations (loaded separately)
 	Owner   *User    `json:"owner,omitempty"`
@@ -29,6 +61,48 @@ func (s *Site) GetSSLCertName() string {
 	return s.Name
 }
 
+// HasGitSource reports whether the site is linked to a git repository.
+func (s *Site) HasGitSource() bool {
+	return s.GitURL != ""
+}
+
+// ServesFiles reports whether nginx serves the files of the active release.
+func (s *Site) ServesFiles() bool {
+	return s.Type != SiteTypeProxy
+}
+
+// IsProxied reports whether requests of the site go to an upstream.
+func (s *Site) IsProxied() bool {
+	return s.Type == SiteTypeProxy || s.Type == SiteTypeHybrid
+}
+
+// SitePages is how a site answers paths without a file and errors: the
+// SPA fallback and the files shown instead of the nginx error pages, as
+// paths from the site root ("" for the nginx default).
+type SitePages struct {
+	SPAFallback  bool
+	ErrorPage404 string
+	ErrorPage403 string
+	ErrorPage50x string
+}
+
+// Pages returns the SPA fallback and error pages of the site.
+func (s *Site) Pages() SitePages {
+	return SitePages{
+		SPAFallback:  s.SPAFallback,
+		ErrorPage404: s.ErrorPage404,
+		ErrorPage403: s.ErrorPage403,
+		ErrorPage50x: s.ErrorPage50x,
+	}
+}
+
+// HoldsDeploy reports whether a deploy of the site waits for an approver.
+// Only deploys by admins in the panel go live right away; API token deploys
+// count as not made by an admin.
+func (s *Site) HoldsDeploy(byAdmin bool) bool {
+	return s.RequireApproval && !byAdmin
+}
+
 // GetAllHostnames returns all hostnames for nginx config (primary + www + aliases)
 func (s *Site) GetAllHostnames() []string {
 	hostnames := []string{s.Name}
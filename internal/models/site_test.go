@@ -120,3 +120,25 @@ func TestSite_SSLExpiry(t *testing.T) {
 		})
 	}
 }
+
+func TestSite_HoldsDeploy(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		byAdmin  bool
+		want     bool
+	}{
+		{"not required", false, false, false},
+		{"required, non-admin", true, false, true},
+		{"required, admin", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			site := Site{RequireApproval: tt.required}
+			if got := site.HoldsDeploy(tt.byAdmin); got != tt.want {
+				t.Errorf("HoldsDeploy(%v) = %v, want %v", tt.byAdmin, got, tt.want)
+			}
+		})
+	}
+}
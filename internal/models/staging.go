@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// StagingSlot is the second release slot of a site. Deploys made for it are
+// served on a preview hostname, behind basic auth or an IP allowlist, until
+// they are promoted to production.
+type StagingSlot struct {
+	SiteID     int64     `json:"site_id"`
+	Hostname   string    `json:"hostname,omitempty"`    // preview hostname (empty = staging.<site>)
+	AllowedIPs []string  `json:"allowed_ips,omitempty"` // addresses and CIDR ranges let in (empty = all)
+	AuthUser   string    `json:"auth_user,omitempty"`   // basic auth user (empty = no basic auth)
+	AuthHash   string    `json:"-"`                     // bcrypt hash of the basic auth password
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PreviewHostname returns the hostname staging is served on.
+func (s *StagingSlot) PreviewHostname(siteName string) string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "staging." + siteName
+}
+
+// HasAuth reports whether the preview hostname asks for a password.
+func (s *StagingSlot) HasAuth() bool {
+	return s.AuthUser != ""
+}
+
+// AllowedIPsText returns the allowlist one entry per line, as edited in the panel.
+func (s *StagingSlot) AllowedIPsText() string {
+	return strings.Join(s.AllowedIPs, "\n")
+}
@@ -0,0 +1,33 @@
+package models
+
+import "testing"
+
+func TestStagingSlot_PreviewHostname(t *testing.T) {
+	slot := &StagingSlot{}
+	if got := slot.PreviewHostname("example.com"); got != "staging.example.com" {
+		t.Errorf("PreviewHostname() = %q, want staging.example.com", got)
+	}
+
+	slot.Hostname = "preview.example.org"
+	if got := slot.PreviewHostname("example.com"); got != "preview.example.org" {
+		t.Errorf("PreviewHostname() = %q, want preview.example.org", got)
+	}
+}
+
+func TestDeploy_CanPromote(t *testing.T) {
+	tests := []struct {
+		name   string
+		deploy Deploy
+		want   bool
+	}{
+		{"staged", Deploy{Target: DeployTargetStaging, IsStaged: true, HasRelease: true}, true},
+		{"already promoted", Deploy{Target: DeployTargetProduction, IsStaged: true, HasRelease: true, IsActive: true}, false},
+		{"no release", Deploy{Target: DeployTargetStaging, IsStaged: true}, false},
+		{"replaced on staging", Deploy{Target: DeployTargetStaging, HasRelease: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.deploy.CanPromote(); got != tt.want {
+			t.Errorf("%s: CanPromote() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
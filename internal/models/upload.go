@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Upload is a file being sent in chunks with the resumable upload protocol,
+// to be deployed or put into the file manager once it is complete.
+type Upload struct {
+	ID        string    `json:"id"`
+	SiteID    int64     `json:"site_id"`
+	UserID    int64     `json:"user_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`             // bytes announced at creation
+	SHA256    string    `json:"sha256,omitempty"` // hex checksum of the whole file, as given or once complete
+	Offset    int64     `json:"offset"`           // bytes received so far, from the file on disk
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsComplete reports whether all bytes of the file have been received.
+func (u *Upload) IsComplete() bool {
+	return u.Offset == u.Size
+}
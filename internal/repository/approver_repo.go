@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type ApproverRepository struct {
+	db *database.DB
+}
+
+func NewApproverRepository(db *database.DB) *ApproverRepository {
+	return &ApproverRepository{db: db}
+}
+
+// ListBySite returns the approvers of a site, ordered by email.
+func (r *ApproverRepository) ListBySite(siteID int64) ([]*models.SiteApprover, error) {
+	rows, err := r.db.Query(
+		`SELECT a.site_id, a.user_id, u.email, a.created_at FROM site_approvers a
+		JOIN users u ON u.id = a.user_id WHERE a.site_id = ? ORDER BY u.email ASC`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvers []*models.SiteApprover
+	for rows.Next() {
+		approver := &models.SiteApprover{}
+		if err := rows.Scan(&approver.SiteID, &approver.UserID, &approver.Email, &approver.CreatedAt); err != nil {
+			return nil, err
+		}
+		approvers = append(approvers, approver)
+	}
+	return approvers, rows.Err()
+}
+
+// ListSiteIDs returns the sites a user approves deploys for.
+func (r *ApproverRepository) ListSiteIDs(userID int64) ([]int64, error) {
+	rows, err := r.db.Query(`SELECT site_id FROM site_approvers WHERE user_id = ? ORDER BY site_id ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var siteIDs []int64
+	for rows.Next() {
+		var siteID int64
+		if err := rows.Scan(&siteID); err != nil {
+			return nil, err
+		}
+		siteIDs = append(siteIDs, siteID)
+	}
+	return siteIDs, rows.Err()
+}
+
+func (r *ApproverRepository) Exists(siteID, userID int64) (bool, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM site_approvers WHERE site_id = ? AND user_id = ?`, siteID, userID).Scan(&count)
+	return count > 0, err
+}
+
+// Add makes a user an approver of a site; adding one twice is not an error.
+func (r *ApproverRepository) Add(siteID, userID int64) error {
+	_, err := r.db.Exec(
+		`INSERT INTO site_approvers (site_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT(site_id, user_id) DO NOTHING`,
+		siteID, userID, time.Now(),
+	)
+	return err
+}
+
+func (r *ApproverRepository) Remove(siteID, userID int64) error {
+	_, err := r.db.Exec(`DELETE FROM site_approvers WHERE site_id = ? AND user_id = ?`, siteID, userID)
+	return err
+}
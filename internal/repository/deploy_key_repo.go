@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+const deployKeyColumns = `id, site_id, user_id, name, public_key, fingerprint, created_at`
+
+type DeployKeyRepository struct {
+	db *database.DB
+}
+
+func NewDeployKeyRepository(db *database.DB) *DeployKeyRepository {
+	return &DeployKeyRepository{db: db}
+}
+
+func (r *DeployKeyRepository) Create(key *models.DeployKey) error {
+	key.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO deploy_keys (site_id, user_id, name, public_key, fingerprint, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		key.SiteID, key.UserID, key.Name, key.PublicKey, key.Fingerprint, key.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	key.ID = id
+	return nil
+}
+
+func (r *DeployKeyRepository) GetByID(id int64) (*models.DeployKey, error) {
+	key := &models.DeployKey{}
+	err := r.db.QueryRow(`SELECT `+deployKeyColumns+` FROM deploy_keys WHERE id = ?`, id).
+		Scan(&key.ID, &key.SiteID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListBySite returns the keys added to a site itself.
+func (r *DeployKeyRepository) ListBySite(siteID int64) ([]*models.DeployKey, error) {
+	return r.list(`SELECT `+deployKeyColumns+` FROM deploy_keys WHERE site_id = ? ORDER BY id ASC`, siteID)
+}
+
+// ListByUser returns the keys of a user.
+func (r *DeployKeyRepository) ListByUser(userID int64) ([]*models.DeployKey, error) {
+	return r.list(`SELECT `+deployKeyColumns+` FROM deploy_keys WHERE user_id = ? ORDER BY id ASC`, userID)
+}
+
+// ListForSite returns every key that may sign archives of a site: its own
+// keys and those of its owner.
+func (r *DeployKeyRepository) ListForSite(siteID int64) ([]*models.DeployKey, error) {
+	return r.list(`
+		SELECT `+deployKeyColumns+` FROM deploy_keys
+		WHERE site_id = ? OR user_id = (SELECT owner_id FROM sites WHERE id = ?)
+		ORDER BY id ASC
+	`, siteID, siteID)
+}
+
+func (r *DeployKeyRepository) list(query string, args ...interface{}) ([]*models.DeployKey, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.DeployKey
+	for rows.Next() {
+		key := &models.DeployKey{}
+		if err := rows.Scan(&key.ID, &key.SiteID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *DeployKeyRepository) Delete(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM deploy_keys WHERE id = ?`, id)
+	return err
+}
@@ -2,7 +2,10 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"micropanel/internal/database"
@@ -17,12 +20,31 @@ func NewDeployRepository(db *database.DB) *DeployRepository {
 	return &DeployRepository{db: db}
 }
 
-func (r *DeployRepository) GetByID(id int64) (*models.Deploy, error) {
+const deployColumns = `id, site_id, user_id, filename, commit_sha, commit_message, status, error_message, violations, phase, progress, has_release, is_active, archive, archive_pruned_at, signed_by, scheduled_at, freeze_override, target, is_staged, needs_approval, reviewed_by, reviewed_at, review_note, created_at`
+
+type deployScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeploy(row deployScanner) (*models.Deploy, error) {
 	deploy := &models.Deploy{}
-	err := r.db.QueryRow(`
-		SELECT id, site_id, user_id, filename, status, error_message, created_at
-		FROM deploys WHERE id = ?
-	`, id).Scan(&deploy.ID, &deploy.SiteID, &deploy.UserID, &deploy.Filename, &deploy.Status, &deploy.ErrorMessage, &deploy.CreatedAt)
+	var errorMessage sql.NullString
+	var violations string
+	err := row.Scan(&deploy.ID, &deploy.SiteID, &deploy.UserID, &deploy.Filename, &deploy.CommitSHA, &deploy.CommitMessage, &deploy.Status, &errorMessage, &violations, &deploy.Phase, &deploy.Progress, &deploy.HasRelease, &deploy.IsActive, &deploy.Archive, &deploy.ArchivePruned, &deploy.SignedBy, &deploy.ScheduledAt, &deploy.FreezeOverride, &deploy.Target, &deploy.IsStaged, &deploy.NeedsApproval, &deploy.ReviewedBy, &deploy.ReviewedAt, &deploy.ReviewNote, &deploy.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	deploy.ErrorMessage = errorMessage.String
+	if violations != "" {
+		if err := json.Unmarshal([]byte(violations), &deploy.Violations); err != nil {
+			return nil, fmt.Errorf("decode violations of deploy %d: %w", deploy.ID, err)
+		}
+	}
+	return deploy, nil
+}
+
+func (r *DeployRepository) GetByID(id int64) (*models.Deploy, error) {
+	deploy, err := scanDeploy(r.db.QueryRow(`SELECT `+deployColumns+` FROM deploys WHERE id = ?`, id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -31,10 +53,13 @@ func (r *DeployRepository) GetByID(id int64) (*models.Deploy, error) {
 
 func (r *DeployRepository) Create(deploy *models.Deploy) error {
 	deploy.CreatedAt = time.Now()
+	if deploy.Target == "" {
+		deploy.Target = models.DeployTargetProduction
+	}
 	result, err := r.db.Exec(`
-		INSERT INTO deploys (site_id, user_id, filename, status, error_message, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, deploy.SiteID, deploy.UserID, deploy.Filename, deploy.Status, deploy.ErrorMessage, deploy.CreatedAt)
+		INSERT INTO deploys (site_id, user_id, filename, commit_sha, commit_message, status, error_message, phase, progress, has_release, is_active, archive, scheduled_at, freeze_override, target, needs_approval, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, deploy.SiteID, deploy.UserID, deploy.Filename, deploy.CommitSHA, deploy.CommitMessage, deploy.Status, deploy.ErrorMessage, deploy.Phase, deploy.Progress, deploy.HasRelease, deploy.IsActive, deploy.Archive, deploy.ScheduledAt, deploy.FreezeOverride, deploy.Target, deploy.NeedsApproval, deploy.CreatedAt)
 	if err != nil {
 		return err
 	}
@@ -53,33 +78,235 @@ func (r *DeployRepository) UpdateStatus(id int64, status models.DeployStatus, er
 	return err
 }
 
+// ChangeStatus moves a deploy from one status to another. It reports false,
+// leaving the deploy alone, when the deploy no longer has status from.
+func (r *DeployRepository) ChangeStatus(id int64, from, to models.DeployStatus) (bool, error) {
+	result, err := r.db.Exec(`UPDATE deploys SET status = ? WHERE id = ? AND status = ?`, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UpdatePhase records the phase a running deploy has reached and its progress.
+func (r *DeployRepository) UpdatePhase(id int64, phase models.DeployPhase, progress int) error {
+	_, err := r.db.Exec(`UPDATE deploys SET phase = ?, progress = ? WHERE id = ?`, phase, progress, id)
+	return err
+}
+
+// GetPending returns the oldest deploy of a site that is still queued or running.
+func (r *DeployRepository) GetPending(siteID int64) (*models.Deploy, error) {
+	deploy, err := scanDeploy(r.db.QueryRow(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? AND status = ? ORDER BY id LIMIT 1
+	`, siteID, models.DeployStatusPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deploy, err
+}
+
+// ListPending returns deploys that are still queued or being processed.
+func (r *DeployRepository) ListPending() ([]*models.Deploy, error) {
+	rows, err := r.db.Query(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE status = ? ORDER BY id
+	`, models.DeployStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanDeploys(rows)
+}
+
+// ListScheduled returns the deploys of all sites waiting for their scheduled time.
+func (r *DeployRepository) ListScheduled() ([]*models.Deploy, error) {
+	rows, err := r.db.Query(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE status = ? ORDER BY id
+	`, models.DeployStatusScheduled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanDeploys(rows)
+}
+
+// ListAwaitingApproval returns the deploys of the given sites held for
+// approval, oldest first. A nil siteIDs lists them for all sites.
+func (r *DeployRepository) ListAwaitingApproval(siteIDs []int64) ([]*models.Deploy, error) {
+	query := `SELECT ` + deployColumns + ` FROM deploys WHERE status = ?`
+	args := []interface{}{models.DeployStatusAwaitingApproval}
+	if siteIDs != nil {
+		if len(siteIDs) == 0 {
+			return nil, nil
+		}
+		query += ` AND site_id IN (?` + strings.Repeat(`, ?`, len(siteIDs)-1) + `)`
+		for _, id := range siteIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := r.db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanDeploys(rows)
+}
+
+// SetReview records who approved or rejected a held deploy and why.
+func (r *DeployRepository) SetReview(id, reviewerID int64, note string) error {
+	_, err := r.db.Exec(`UPDATE deploys SET reviewed_by = ?, reviewed_at = ?, review_note = ? WHERE id = ?`, reviewerID, time.Now(), note, id)
+	return err
+}
+
+// SetCommit records the git commit a deploy was built from.
+func (r *DeployRepository) SetCommit(id int64, sha, message string) error {
+	_, err := r.db.Exec(`UPDATE deploys SET commit_sha = ?, commit_message = ? WHERE id = ?`, sha, message, id)
+	return err
+}
+
+// SetViolations records why the deploy policy rejected the release of a deploy.
+func (r *DeployRepository) SetViolations(id int64, violations []models.PolicyViolation) error {
+	data, err := json.Marshal(violations)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`UPDATE deploys SET violations = ? WHERE id = ?`, string(data), id)
+	return err
+}
+
+// SetHasRelease records whether the release directory of a deploy exists on disk.
+func (r *DeployRepository) SetHasRelease(id int64, hasRelease bool) error {
+	_, err := r.db.Exec(`UPDATE deploys SET has_release = ? WHERE id = ?`, hasRelease, id)
+	return err
+}
+
+// SetArchive records the file in the deploys directory of the site that holds
+// the archive of a deploy. An empty name means the deploy has none.
+func (r *DeployRepository) SetArchive(id int64, archive string) error {
+	_, err := r.db.Exec(`UPDATE deploys SET archive = ?, archive_pruned_at = NULL WHERE id = ?`, archive, id)
+	return err
+}
+
+// SetSignedBy records the fingerprint of the deploy key that signed the
+// archive of a deploy.
+func (r *DeployRepository) SetSignedBy(id int64, fingerprint string) error {
+	_, err := r.db.Exec(`UPDATE deploys SET signed_by = ? WHERE id = ?`, fingerprint, id)
+	return err
+}
+
+// MarkArchivePruned records that the archive file of a site was removed by
+// the retention policy.
+func (r *DeployRepository) MarkArchivePruned(siteID int64, archive string) error {
+	_, err := r.db.Exec(`
+		UPDATE deploys SET archive_pruned_at = ? WHERE site_id = ? AND archive = ? AND archive_pruned_at IS NULL
+	`, time.Now(), siteID, archive)
+	return err
+}
+
+// SetActive marks the given deploy as the one currently served for the site
+// and clears the flag on all other deploys of that site.
+func (r *DeployRepository) SetActive(siteID, deployID int64) error {
+	_, err := r.db.Exec(`
+		UPDATE deploys SET is_active = CASE WHEN id = ? THEN 1 ELSE 0 END WHERE site_id = ?
+	`, deployID, siteID)
+	return err
+}
+
+// SetStaged marks the given deploy as the one served on the staging hostname
+// of the site and clears the flag on all other deploys of that site.
+func (r *DeployRepository) SetStaged(siteID, deployID int64) error {
+	_, err := r.db.Exec(`
+		UPDATE deploys SET is_staged = CASE WHEN id = ? THEN 1 ELSE 0 END WHERE site_id = ?
+	`, deployID, siteID)
+	return err
+}
+
+// Promote makes a staging deploy a production one: it becomes the active
+// release of the site and counts as such for rollbacks.
+func (r *DeployRepository) Promote(siteID, deployID int64) error {
+	_, err := r.db.Exec(`
+		UPDATE deploys SET
+			is_active = CASE WHEN id = ? THEN 1 ELSE 0 END,
+			target = CASE WHEN id = ? THEN ? ELSE target END
+		WHERE site_id = ?
+	`, deployID, deployID, models.DeployTargetProduction, siteID)
+	return err
+}
+
+// GetStaged returns the deploy whose release is served on the staging hostname.
+func (r *DeployRepository) GetStaged(siteID int64) (*models.Deploy, error) {
+	deploy, err := scanDeploy(r.db.QueryRow(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? AND is_staged = 1 LIMIT 1
+	`, siteID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deploy, err
+}
+
 func (r *DeployRepository) ListBySite(siteID int64, limit int) ([]*models.Deploy, error) {
 	rows, err := r.db.Query(`
-		SELECT id, site_id, user_id, filename, status, error_message, created_at
-		FROM deploys WHERE site_id = ? ORDER BY created_at DESC LIMIT ?
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? ORDER BY created_at DESC, id DESC LIMIT ?
 	`, siteID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var deploys []*models.Deploy
-	for rows.Next() {
-		deploy := &models.Deploy{}
-		if err := rows.Scan(&deploy.ID, &deploy.SiteID, &deploy.UserID, &deploy.Filename, &deploy.Status, &deploy.ErrorMessage, &deploy.CreatedAt); err != nil {
-			return nil, err
-		}
-		deploys = append(deploys, deploy)
+	return r.scanDeploys(rows)
+}
+
+// ListReleases returns deploys of a site that still have a release directory, newest first.
+func (r *DeployRepository) ListReleases(siteID int64) ([]*models.Deploy, error) {
+	rows, err := r.db.Query(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? AND has_release = 1 ORDER BY id DESC
+	`, siteID)
+	if err != nil {
+		return nil, err
 	}
-	return deploys, rows.Err()
+	defer rows.Close()
+
+	return r.scanDeploys(rows)
+}
+
+func (r *DeployRepository) GetActive(siteID int64) (*models.Deploy, error) {
+	deploy, err := scanDeploy(r.db.QueryRow(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? AND is_active = 1 LIMIT 1
+	`, siteID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deploy, err
 }
 
 func (r *DeployRepository) GetLastSuccessful(siteID int64) (*models.Deploy, error) {
-	deploy := &models.Deploy{}
-	err := r.db.QueryRow(`
-		SELECT id, site_id, user_id, filename, status, error_message, created_at
+	deploy, err := scanDeploy(r.db.QueryRow(`
+		SELECT `+deployColumns+`
 		FROM deploys WHERE site_id = ? AND status = ? ORDER BY created_at DESC LIMIT 1
-	`, siteID, models.DeployStatusSuccess).Scan(&deploy.ID, &deploy.SiteID, &deploy.UserID, &deploy.Filename, &deploy.Status, &deploy.ErrorMessage, &deploy.CreatedAt)
+	`, siteID, models.DeployStatusSuccess))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deploy, err
+}
+
+// GetPreviousSuccessful returns the newest successful deploy of a site older
+// than the given deploy.
+func (r *DeployRepository) GetPreviousSuccessful(siteID, beforeID int64) (*models.Deploy, error) {
+	deploy, err := scanDeploy(r.db.QueryRow(`
+		SELECT `+deployColumns+`
+		FROM deploys WHERE site_id = ? AND id < ? AND status = ? ORDER BY id DESC LIMIT 1
+	`, siteID, beforeID, models.DeployStatusSuccess))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -91,3 +318,15 @@ func (r *DeployRepository) CountBySite(siteID int64) (int, error) {
 	err := r.db.QueryRow(`SELECT COUNT(*) FROM deploys WHERE site_id = ?`, siteID).Scan(&count)
 	return count, err
 }
+
+func (r *DeployRepository) scanDeploys(rows *sql.Rows) ([]*models.Deploy, error) {
+	var deploys []*models.Deploy
+	for rows.Next() {
+		deploy, err := scanDeploy(rows)
+		if err != nil {
+			return nil, err
+		}
+		deploys = append(deploys, deploy)
+	}
+	return deploys, rows.Err()
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type FreezeWindowRepository struct {
+	db *database.DB
+}
+
+func NewFreezeWindowRepository(db *database.DB) *FreezeWindowRepository {
+	return &FreezeWindowRepository{db: db}
+}
+
+func (r *FreezeWindowRepository) Create(window *models.FreezeWindow) error {
+	window.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO freeze_windows (site_id, starts_at, ends_at, reason, created_at) VALUES (?, ?, ?, ?, ?)`,
+		window.SiteID, window.StartsAt.UTC(), window.EndsAt.UTC(), window.Reason, window.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	window.ID = id
+	return nil
+}
+
+func (r *FreezeWindowRepository) GetByID(id int64) (*models.FreezeWindow, error) {
+	window := &models.FreezeWindow{}
+	err := r.db.QueryRow(
+		`SELECT id, site_id, starts_at, ends_at, reason, created_at FROM freeze_windows WHERE id = ?`,
+		id,
+	).Scan(&window.ID, &window.SiteID, &window.StartsAt, &window.EndsAt, &window.Reason, &window.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+// ListBySite returns the windows of a site, earliest first.
+func (r *FreezeWindowRepository) ListBySite(siteID int64) ([]*models.FreezeWindow, error) {
+	rows, err := r.db.Query(
+		`SELECT id, site_id, starts_at, ends_at, reason, created_at FROM freeze_windows WHERE site_id = ? ORDER BY starts_at ASC, id ASC`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []*models.FreezeWindow
+	for rows.Next() {
+		window := &models.FreezeWindow{}
+		if err := rows.Scan(&window.ID, &window.SiteID, &window.StartsAt, &window.EndsAt, &window.Reason, &window.CreatedAt); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, rows.Err()
+}
+
+func (r *FreezeWindowRepository) Delete(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM freeze_windows WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type HeaderRuleRepository struct {
+	db *database.DB
+}
+
+func NewHeaderRuleRepository(db *database.DB) *HeaderRuleRepository {
+	return &HeaderRuleRepository{db: db}
+}
+
+// Create adds a rule after the other rules of its site.
+func (r *HeaderRuleRepository) Create(rule *models.HeaderRule) error {
+	result, err := r.db.Exec(
+		`INSERT INTO site_header_rules (site_id, position, match_type, pattern, headers)
+		VALUES (?, (SELECT COALESCE(MAX(position), -1) + 1 FROM site_header_rules WHERE site_id = ?), ?, ?, ?)`,
+		rule.SiteID, rule.SiteID, rule.MatchType, rule.Match, encodeHeaders(rule.Headers),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rule.ID = id
+	return nil
+}
+
+func (r *HeaderRuleRepository) GetByID(id int64) (*models.HeaderRule, error) {
+	rule := &models.HeaderRule{}
+	var headers string
+	err := r.db.QueryRow(
+		`SELECT id, site_id, position, match_type, pattern, headers, created_at FROM site_header_rules WHERE id = ?`,
+		id,
+	).Scan(&rule.ID, &rule.SiteID, &rule.Position, &rule.MatchType, &rule.Match, &headers, &rule.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rule.Headers = decodeHeaders(headers)
+	return rule, nil
+}
+
+// ListBySite returns the rules of a site in order.
+func (r *HeaderRuleRepository) ListBySite(siteID int64) ([]*models.HeaderRule, error) {
+	rows, err := r.db.Query(
+		`SELECT id, site_id, position, match_type, pattern, headers, created_at FROM site_header_rules WHERE site_id = ? ORDER BY position ASC, id ASC`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*models.HeaderRule
+	for rows.Next() {
+		rule := &models.HeaderRule{}
+		var headers string
+		if err := rows.Scan(&rule.ID, &rule.SiteID, &rule.Position, &rule.MatchType, &rule.Match, &headers, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rule.Headers = decodeHeaders(headers)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ReplaceForSite swaps the rules of a site for the given ones, in their
+// order, in one transaction.
+func (r *HeaderRuleRepository) ReplaceForSite(siteID int64, rules []*models.HeaderRule) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM site_header_rules WHERE site_id = ?`, siteID); err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		rule.SiteID = siteID
+		rule.Position = i
+		result, err := tx.Exec(
+			`INSERT INTO site_header_rules (site_id, position, match_type, pattern, headers) VALUES (?, ?, ?, ?, ?)`,
+			rule.SiteID, rule.Position, rule.MatchType, rule.Match, encodeHeaders(rule.Headers),
+		)
+		if err != nil {
+			return err
+		}
+		if rule.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetPositions stores the order of the rules of a site, given by ID.
+func (r *HeaderRuleRepository) SetPositions(siteID int64, ids []int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		if _, err := tx.Exec(`UPDATE site_header_rules SET position = ? WHERE id = ? AND site_id = ?`, i, id, siteID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *HeaderRuleRepository) Delete(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM site_header_rules WHERE id = ?`, id)
+	return err
+}
+
+// encodeHeaders stores headers one "Name: value" per line; names and values
+// are validated to have no line breaks.
+func encodeHeaders(headers []models.ResponseHeader) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		lines[i] = h.Name + ": " + h.Value
+	}
+	return strings.Join(lines, "\n")
+}
+
+func decodeHeaders(s string) []models.ResponseHeader {
+	var headers []models.ResponseHeader
+	for _, line := range strings.Split(s, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, models.ResponseHeader{Name: name, Value: strings.TrimPrefix(value, " ")})
+	}
+	return headers
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type HealthCheckRepository struct {
+	db *database.DB
+}
+
+func NewHealthCheckRepository(db *database.DB) *HealthCheckRepository {
+	return &HealthCheckRepository{db: db}
+}
+
+func (r *HealthCheckRepository) Create(check *models.HealthCheck) error {
+	result, err := r.db.Exec(
+		`INSERT INTO health_checks (site_id, path, expected_status, body_contains) VALUES (?, ?, ?, ?)`,
+		check.SiteID, check.Path, check.ExpectedStatus, check.BodyContains,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	check.ID = id
+	return nil
+}
+
+func (r *HealthCheckRepository) GetByID(id int64) (*models.HealthCheck, error) {
+	check := &models.HealthCheck{}
+	err := r.db.QueryRow(
+		`SELECT id, site_id, path, expected_status, body_contains FROM health_checks WHERE id = ?`,
+		id,
+	).Scan(&check.ID, &check.SiteID, &check.Path, &check.ExpectedStatus, &check.BodyContains)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+func (r *HealthCheckRepository) ListBySite(siteID int64) ([]*models.HealthCheck, error) {
+	rows, err := r.db.Query(
+		`SELECT id, site_id, path, expected_status, body_contains FROM health_checks WHERE site_id = ? ORDER BY id ASC`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checks []*models.HealthCheck
+	for rows.Next() {
+		check := &models.HealthCheck{}
+		if err := rows.Scan(&check.ID, &check.SiteID, &check.Path, &check.ExpectedStatus, &check.BodyContains); err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// ReplaceForSite swaps the checks of a site for the given ones in one transaction.
+func (r *HealthCheckRepository) ReplaceForSite(siteID int64, checks []*models.HealthCheck) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM health_checks WHERE site_id = ?`, siteID); err != nil {
+		return err
+	}
+	for _, check := range checks {
+		check.SiteID = siteID
+		result, err := tx.Exec(
+			`INSERT INTO health_checks (site_id, path, expected_status, body_contains) VALUES (?, ?, ?, ?)`,
+			check.SiteID, check.Path, check.ExpectedStatus, check.BodyContains,
+		)
+		if err != nil {
+			return err
+		}
+		if check.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *HealthCheckRepository) Delete(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM health_checks WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+// LimitsRepository stores limit overrides of sites and users. Both tables
+// share their columns and differ only in the key.
+type LimitsRepository struct {
+	db *database.DB
+}
+
+func NewLimitsRepository(db *database.DB) *LimitsRepository {
+	return &LimitsRepository{db: db}
+}
+
+// GetForSite returns the overrides of a site, empty when none are set.
+func (r *LimitsRepository) GetForSite(siteID int64) (*models.LimitOverrides, error) {
+	return r.get(`SELECT max_archive_size, max_file_size, max_upload_size, disk_quota FROM site_limits WHERE site_id = ?`, siteID)
+}
+
+// GetForUser returns the overrides of a user, empty when none are set.
+func (r *LimitsRepository) GetForUser(userID int64) (*models.LimitOverrides, error) {
+	return r.get(`SELECT max_archive_size, max_file_size, max_upload_size, disk_quota FROM user_limits WHERE user_id = ?`, userID)
+}
+
+// SetForSite replaces the overrides of a site.
+func (r *LimitsRepository) SetForSite(siteID int64, o *models.LimitOverrides) error {
+	if o.IsEmpty() {
+		_, err := r.db.Exec(`DELETE FROM site_limits WHERE site_id = ?`, siteID)
+		return err
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO site_limits (site_id, max_archive_size, max_file_size, max_upload_size, disk_quota)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET
+			max_archive_size = excluded.max_archive_size,
+			max_file_size = excluded.max_file_size,
+			max_upload_size = excluded.max_upload_size,
+			disk_quota = excluded.disk_quota
+	`, siteID, o.MaxArchiveSize, o.MaxFileSize, o.MaxUploadSize, o.DiskQuota)
+	return err
+}
+
+// SetForUser replaces the overrides of a user.
+func (r *LimitsRepository) SetForUser(userID int64, o *models.LimitOverrides) error {
+	if o.IsEmpty() {
+		_, err := r.db.Exec(`DELETE FROM user_limits WHERE user_id = ?`, userID)
+		return err
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO user_limits (user_id, max_archive_size, max_file_size, max_upload_size, disk_quota)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			max_archive_size = excluded.max_archive_size,
+			max_file_size = excluded.max_file_size,
+			max_upload_size = excluded.max_upload_size,
+			disk_quota = excluded.disk_quota
+	`, userID, o.MaxArchiveSize, o.MaxFileSize, o.MaxUploadSize, o.DiskQuota)
+	return err
+}
+
+func (r *LimitsRepository) get(query string, id int64) (*models.LimitOverrides, error) {
+	var archive, file, upload, quota sql.NullInt64
+	err := r.db.QueryRow(query, id).Scan(&archive, &file, &upload, &quota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.LimitOverrides{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LimitOverrides{
+		MaxArchiveSize: nullInt64Ptr(archive),
+		MaxFileSize:    nullInt64Ptr(file),
+		MaxUploadSize:  nullInt64Ptr(upload),
+		DiskQuota:      nullInt64Ptr(quota),
+	}, nil
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
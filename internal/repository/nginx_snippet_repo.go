@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type NginxSnippetRepository struct {
+	db *database.DB
+}
+
+func NewNginxSnippetRepository(db *database.DB) *NginxSnippetRepository {
+	return &NginxSnippetRepository{db: db}
+}
+
+// ListBySite returns the snippets of a site, in no particular order.
+func (r *NginxSnippetRepository) ListBySite(siteID int64) ([]*models.NginxSnippet, error) {
+	rows, err := r.db.Query(
+		`SELECT site_id, hook, content, updated_at FROM site_nginx_snippets WHERE site_id = ?`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*models.NginxSnippet
+	for rows.Next() {
+		snippet := &models.NginxSnippet{}
+		if err := rows.Scan(&snippet.SiteID, &snippet.Hook, &snippet.Content, &snippet.UpdatedAt); err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, rows.Err()
+}
+
+// ReplaceForSite swaps the snippets of a site for the given ones in one
+// transaction. Empty snippets are not stored.
+func (r *NginxSnippetRepository) ReplaceForSite(siteID int64, snippets []*models.NginxSnippet) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM site_nginx_snippets WHERE site_id = ?`, siteID); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, snippet := range snippets {
+		if snippet.Content == "" {
+			continue
+		}
+		snippet.SiteID = siteID
+		snippet.UpdatedAt = now
+		if _, err := tx.Exec(
+			`INSERT INTO site_nginx_snippets (site_id, hook, content, updated_at) VALUES (?, ?, ?, ?)`,
+			snippet.SiteID, snippet.Hook, snippet.Content, snippet.UpdatedAt,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
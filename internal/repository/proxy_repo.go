@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type ProxyRepository struct {
+	db *database.DB
+}
+
+func NewProxyRepository(db *database.DB) *ProxyRepository {
+	return &ProxyRepository{db: db}
+}
+
+func (r *ProxyRepository) GetBySite(siteID int64) (*models.ProxyConfig, error) {
+	proxy := &models.ProxyConfig{}
+	var upstreams, passHeaders string
+	err := r.db.QueryRow(
+		`SELECT site_id, upstreams, connect_timeout, read_timeout, send_timeout, websocket, pass_headers, updated_at FROM site_proxies WHERE site_id = ?`,
+		siteID,
+	).Scan(&proxy.SiteID, &upstreams, &proxy.ConnectTimeout, &proxy.ReadTimeout, &proxy.SendTimeout, &proxy.WebSocket, &passHeaders, &proxy.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if upstreams != "" {
+		proxy.Upstreams = strings.Split(upstreams, "\n")
+	}
+	if passHeaders != "" {
+		proxy.PassHeaders = strings.Split(passHeaders, "\n")
+	}
+	return proxy, nil
+}
+
+// Set stores the upstream of a site, replacing the one it had.
+func (r *ProxyRepository) Set(proxy *models.ProxyConfig) error {
+	proxy.UpdatedAt = time.Now()
+	_, err := r.db.Exec(
+		`INSERT INTO site_proxies (site_id, upstreams, connect_timeout, read_timeout, send_timeout, websocket, pass_headers, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET upstreams = excluded.upstreams, connect_timeout = excluded.connect_timeout,
+			read_timeout = excluded.read_timeout, send_timeout = excluded.send_timeout, websocket = excluded.websocket,
+			pass_headers = excluded.pass_headers, updated_at = excluded.updated_at`,
+		proxy.SiteID, strings.Join(proxy.Upstreams, "\n"), proxy.ConnectTimeout, proxy.ReadTimeout, proxy.SendTimeout,
+		proxy.WebSocket, strings.Join(proxy.PassHeaders, "\n"), proxy.UpdatedAt,
+	)
+	return err
+}
+
+func (r *ProxyRepository) Delete(siteID int64) error {
+	_, err := r.db.Exec(`DELETE FROM site_proxies WHERE site_id = ?`, siteID)
+	return err
+}
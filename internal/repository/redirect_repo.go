@@ -15,10 +15,11 @@ func NewRedirectRepository(db *database.DB) *RedirectRepository {
 
 func (r *RedirectRepository) Create(redirect *models.Redirect) error {
 	result, err := r.db.Exec(
-		`INSERT INTO redirects (site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		redirect.SiteID, redirect.SourcePath, redirect.TargetURL, redirect.Code,
+		`INSERT INTO redirects (site_id, match_type, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled, host, query_arg, query_value)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		redirect.SiteID, redirect.MatchType, redirect.SourcePath, redirect.TargetURL, redirect.Code,
 		redirect.PreservePath, redirect.PreserveQuery, redirect.Priority, redirect.IsEnabled,
+		redirect.Host, redirect.QueryArg, redirect.QueryValue,
 	)
 	if err != nil {
 		return err
@@ -35,13 +36,13 @@ func (r *RedirectRepository) Create(redirect *models.Redirect) error {
 func (r *RedirectRepository) GetByID(id int64) (*models.Redirect, error) {
 	redirect := &models.Redirect{}
 	err := r.db.QueryRow(
-		`SELECT id, site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled
+		`SELECT id, site_id, match_type, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled, host, query_arg, query_value
 		 FROM redirects WHERE id = ?`,
 		id,
 	).Scan(
-		&redirect.ID, &redirect.SiteID, &redirect.SourcePath, &redirect.TargetURL,
+		&redirect.ID, &redirect.SiteID, &redirect.MatchType, &redirect.SourcePath, &redirect.TargetURL,
 		&redirect.Code, &redirect.PreservePath, &redirect.PreserveQuery,
-		&redirect.Priority, &redirect.IsEnabled,
+		&redirect.Priority, &redirect.IsEnabled, &redirect.Host, &redirect.QueryArg, &redirect.QueryValue,
 	)
 	if err != nil {
 		return nil, err
@@ -51,7 +52,7 @@ func (r *RedirectRepository) GetByID(id int64) (*models.Redirect, error) {
 
 func (r *RedirectRepository) ListBySite(siteID int64) ([]*models.Redirect, error) {
 	rows, err := r.db.Query(
-		`SELECT id, site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled
+		`SELECT id, site_id, match_type, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled, host, query_arg, query_value
 		 FROM redirects WHERE site_id = ? ORDER BY priority DESC, id ASC`,
 		siteID,
 	)
@@ -64,9 +65,9 @@ func (r *RedirectRepository) ListBySite(siteID int64) ([]*models.Redirect, error
 	for rows.Next() {
 		redirect := &models.Redirect{}
 		if err := rows.Scan(
-			&redirect.ID, &redirect.SiteID, &redirect.SourcePath, &redirect.TargetURL,
+			&redirect.ID, &redirect.SiteID, &redirect.MatchType, &redirect.SourcePath, &redirect.TargetURL,
 			&redirect.Code, &redirect.PreservePath, &redirect.PreserveQuery,
-			&redirect.Priority, &redirect.IsEnabled,
+			&redirect.Priority, &redirect.IsEnabled, &redirect.Host, &redirect.QueryArg, &redirect.QueryValue,
 		); err != nil {
 			return nil, err
 		}
@@ -77,10 +78,12 @@ func (r *RedirectRepository) ListBySite(siteID int64) ([]*models.Redirect, error
 
 func (r *RedirectRepository) Update(redirect *models.Redirect) error {
 	_, err := r.db.Exec(
-		`UPDATE redirects SET source_path = ?, target_url = ?, code = ?, preserve_path = ?, preserve_query = ?, priority = ?, is_enabled = ?
+		`UPDATE redirects SET match_type = ?, source_path = ?, target_url = ?, code = ?, preserve_path = ?, preserve_query = ?, priority = ?, is_enabled = ?,
+		 host = ?, query_arg = ?, query_value = ?
 		 WHERE id = ?`,
-		redirect.SourcePath, redirect.TargetURL, redirect.Code,
+		redirect.MatchType, redirect.SourcePath, redirect.TargetURL, redirect.Code,
 		redirect.PreservePath, redirect.PreserveQuery, redirect.Priority, redirect.IsEnabled,
+		redirect.Host, redirect.QueryArg, redirect.QueryValue,
 		redirect.ID,
 	)
 	return err
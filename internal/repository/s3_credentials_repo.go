@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type S3CredentialsRepository struct {
+	db *database.DB
+}
+
+func NewS3CredentialsRepository(db *database.DB) *S3CredentialsRepository {
+	return &S3CredentialsRepository{db: db}
+}
+
+func (r *S3CredentialsRepository) GetBySite(siteID int64) (*models.S3Credentials, error) {
+	creds := &models.S3Credentials{}
+	err := r.db.QueryRow(
+		`SELECT site_id, endpoint, region, access_key_id, secret_access_key, path_style, updated_at FROM s3_credentials WHERE site_id = ?`,
+		siteID,
+	).Scan(&creds.SiteID, &creds.Endpoint, &creds.Region, &creds.AccessKeyID, &creds.SecretAccessKey, &creds.PathStyle, &creds.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// Set stores the credentials of a site, replacing those it had.
+func (r *S3CredentialsRepository) Set(creds *models.S3Credentials) error {
+	creds.UpdatedAt = time.Now()
+	_, err := r.db.Exec(
+		`INSERT INTO s3_credentials (site_id, endpoint, region, access_key_id, secret_access_key, path_style, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET endpoint = excluded.endpoint, region = excluded.region,
+			access_key_id = excluded.access_key_id, secret_access_key = excluded.secret_access_key,
+			path_style = excluded.path_style, updated_at = excluded.updated_at`,
+		creds.SiteID, creds.Endpoint, creds.Region, creds.AccessKeyID, creds.SecretAccessKey, creds.PathStyle, creds.UpdatedAt,
+	)
+	return err
+}
+
+func (r *S3CredentialsRepository) Delete(siteID int64) error {
+	_, err := r.db.Exec(`DELETE FROM s3_credentials WHERE site_id = ?`, siteID)
+	return err
+}
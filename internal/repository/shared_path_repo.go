@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"micropanel/internal/database"
+)
+
+type SharedPathRepository struct {
+	db *database.DB
+}
+
+func NewSharedPathRepository(db *database.DB) *SharedPathRepository {
+	return &SharedPathRepository{db: db}
+}
+
+func (r *SharedPathRepository) ListBySite(siteID int64) ([]string, error) {
+	rows, err := r.db.Query(`SELECT path FROM shared_paths WHERE site_id = ? ORDER BY path ASC`, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// ReplaceForSite swaps the shared paths of a site for the given ones in one transaction.
+func (r *SharedPathRepository) ReplaceForSite(siteID int64, paths []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM shared_paths WHERE site_id = ?`, siteID); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, err := tx.Exec(`INSERT INTO shared_paths (site_id, path) VALUES (?, ?)`, siteID, path); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
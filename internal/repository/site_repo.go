@@ -20,9 +20,9 @@ func NewSiteRepository(db *database.DB) *SiteRepository {
 func (r *SiteRepository) GetByID(id int64) (*models.Site, error) {
 	site := &models.Site{}
 	err := r.db.QueryRow(`
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites WHERE id = ?
-	`, id).Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.CreatedAt, &site.UpdatedAt)
+	`, id).Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.KeepReleases, &site.GitURL, &site.GitBranch, &site.GitSubdir, &site.Precompress, &site.KeepArchives, &site.ArchiveMaxAge, &site.ArchiveMaxSize, &site.RequireApproval, &site.Type, &site.SPAFallback, &site.ErrorPage404, &site.ErrorPage403, &site.ErrorPage50x, &site.CreatedAt, &site.UpdatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -32,9 +32,9 @@ func (r *SiteRepository) GetByID(id int64) (*models.Site, error) {
 func (r *SiteRepository) GetByName(name string) (*models.Site, error) {
 	site := &models.Site{}
 	err := r.db.QueryRow(`
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites WHERE name = ?
-	`, name).Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.CreatedAt, &site.UpdatedAt)
+	`, name).Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.KeepReleases, &site.GitURL, &site.GitBranch, &site.GitSubdir, &site.Precompress, &site.KeepArchives, &site.ArchiveMaxAge, &site.ArchiveMaxSize, &site.RequireApproval, &site.Type, &site.SPAFallback, &site.ErrorPage404, &site.ErrorPage403, &site.ErrorPage50x, &site.CreatedAt, &site.UpdatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -44,9 +44,9 @@ func (r *SiteRepository) GetByName(name string) (*models.Site, error) {
 func (r *SiteRepository) Create(site *models.Site) error {
 	now := time.Now()
 	result, err := r.db.Exec(`
-		INSERT INTO sites (name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, site.Name, site.OwnerID, site.IsEnabled, site.SSLEnabled, site.SSLExpiresAt, site.SSLCertName, site.WWWAlias, site.FixMimeTypes, now, now)
+		INSERT INTO sites (name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, site.Name, site.OwnerID, site.IsEnabled, site.SSLEnabled, site.SSLExpiresAt, site.SSLCertName, site.WWWAlias, site.FixMimeTypes, site.KeepReleases, site.GitURL, site.GitBranch, site.GitSubdir, site.Precompress, site.KeepArchives, site.ArchiveMaxAge, site.ArchiveMaxSize, site.RequireApproval, site.Type, site.SPAFallback, site.ErrorPage404, site.ErrorPage403, site.ErrorPage50x, now, now)
 	if err != nil {
 		return err
 	}
@@ -63,9 +63,9 @@ func (r *SiteRepository) Create(site *models.Site) error {
 func (r *SiteRepository) Update(site *models.Site) error {
 	site.UpdatedAt = time.Now()
 	_, err := r.db.Exec(`
-		UPDATE sites SET name = ?, is_enabled = ?, ssl_enabled = ?, ssl_expires_at = ?, ssl_cert_name = ?, www_alias = ?, fix_mime_types = ?, updated_at = ?
+		UPDATE sites SET name = ?, is_enabled = ?, ssl_enabled = ?, ssl_expires_at = ?, ssl_cert_name = ?, www_alias = ?, fix_mime_types = ?, keep_releases = ?, git_url = ?, git_branch = ?, git_subdir = ?, precompress = ?, keep_archives = ?, archive_max_age = ?, archive_max_size = ?, require_approval = ?, site_type = ?, spa_fallback = ?, error_page_404 = ?, error_page_403 = ?, error_page_50x = ?, updated_at = ?
 		WHERE id = ?
-	`, site.Name, site.IsEnabled, site.SSLEnabled, site.SSLExpiresAt, site.SSLCertName, site.WWWAlias, site.FixMimeTypes, site.UpdatedAt, site.ID)
+	`, site.Name, site.IsEnabled, site.SSLEnabled, site.SSLExpiresAt, site.SSLCertName, site.WWWAlias, site.FixMimeTypes, site.KeepReleases, site.GitURL, site.GitBranch, site.GitSubdir, site.Precompress, site.KeepArchives, site.ArchiveMaxAge, site.ArchiveMaxSize, site.RequireApproval, site.Type, site.SPAFallback, site.ErrorPage404, site.ErrorPage403, site.ErrorPage50x, site.UpdatedAt, site.ID)
 	return err
 }
 
@@ -76,7 +76,7 @@ func (r *SiteRepository) Delete(id int64) error {
 
 func (r *SiteRepository) ListByOwner(ownerID int64) ([]*models.Site, error) {
 	rows, err := r.db.Query(`
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites WHERE owner_id = ? ORDER BY created_at DESC
 	`, ownerID)
 	if err != nil {
@@ -89,7 +89,7 @@ func (r *SiteRepository) ListByOwner(ownerID int64) ([]*models.Site, error) {
 
 func (r *SiteRepository) ListAll() ([]*models.Site, error) {
 	rows, err := r.db.Query(`
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -104,7 +104,7 @@ func (r *SiteRepository) scanSites(rows *sql.Rows) ([]*models.Site, error) {
 	var sites []*models.Site
 	for rows.Next() {
 		site := &models.Site{}
-		if err := rows.Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.CreatedAt, &site.UpdatedAt); err != nil {
+		if err := rows.Scan(&site.ID, &site.Name, &site.OwnerID, &site.IsEnabled, &site.SSLEnabled, &site.SSLExpiresAt, &site.SSLCertName, &site.WWWAlias, &site.FixMimeTypes, &site.KeepReleases, &site.GitURL, &site.GitBranch, &site.GitSubdir, &site.Precompress, &site.KeepArchives, &site.ArchiveMaxAge, &site.ArchiveMaxSize, &site.RequireApproval, &site.Type, &site.SPAFallback, &site.ErrorPage404, &site.ErrorPage403, &site.ErrorPage50x, &site.CreatedAt, &site.UpdatedAt); err != nil {
 			return nil, err
 		}
 		sites = append(sites, site)
@@ -115,7 +115,7 @@ func (r *SiteRepository) scanSites(rows *sql.Rows) ([]*models.Site, error) {
 func (r *SiteRepository) ListByOwnerPaginated(ownerID int64, search string, page, limit int) ([]*models.Site, error) {
 	offset := (page - 1) * limit
 	query := `
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites WHERE owner_id = ?`
 	args := []interface{}{ownerID}
 
@@ -139,7 +139,7 @@ func (r *SiteRepository) ListByOwnerPaginated(ownerID int64, search string, page
 func (r *SiteRepository) ListAllPaginated(search string, page, limit int) ([]*models.Site, error) {
 	offset := (page - 1) * limit
 	query := `
-		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, created_at, updated_at
+		SELECT id, name, owner_id, is_enabled, ssl_enabled, ssl_expires_at, ssl_cert_name, www_alias, fix_mime_types, keep_releases, git_url, git_branch, git_subdir, precompress, keep_archives, archive_max_age, archive_max_size, require_approval, site_type, spa_fallback, error_page_404, error_page_403, error_page_50x, created_at, updated_at
 		FROM sites`
 	var args []interface{}
 
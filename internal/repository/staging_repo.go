@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type StagingRepository struct {
+	db *database.DB
+}
+
+func NewStagingRepository(db *database.DB) *StagingRepository {
+	return &StagingRepository{db: db}
+}
+
+func (r *StagingRepository) GetBySite(siteID int64) (*models.StagingSlot, error) {
+	slot := &models.StagingSlot{}
+	var allowedIPs string
+	err := r.db.QueryRow(
+		`SELECT site_id, hostname, allowed_ips, auth_user, auth_hash, updated_at FROM staging_slots WHERE site_id = ?`,
+		siteID,
+	).Scan(&slot.SiteID, &slot.Hostname, &allowedIPs, &slot.AuthUser, &slot.AuthHash, &slot.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if allowedIPs != "" {
+		slot.AllowedIPs = strings.Split(allowedIPs, "\n")
+	}
+	return slot, nil
+}
+
+// GetByHostname returns the slot served on a preview hostname.
+func (r *StagingRepository) GetByHostname(hostname string) (*models.StagingSlot, error) {
+	var siteID int64
+	err := r.db.QueryRow(`SELECT site_id FROM staging_slots WHERE hostname = ?`, hostname).Scan(&siteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetBySite(siteID)
+}
+
+// Set stores the staging slot of a site, replacing the one it had.
+func (r *StagingRepository) Set(slot *models.StagingSlot) error {
+	slot.UpdatedAt = time.Now()
+	_, err := r.db.Exec(
+		`INSERT INTO staging_slots (site_id, hostname, allowed_ips, auth_user, auth_hash, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET hostname = excluded.hostname, allowed_ips = excluded.allowed_ips,
+			auth_user = excluded.auth_user, auth_hash = excluded.auth_hash, updated_at = excluded.updated_at`,
+		slot.SiteID, slot.Hostname, strings.Join(slot.AllowedIPs, "\n"), slot.AuthUser, slot.AuthHash, slot.UpdatedAt,
+	)
+	return err
+}
+
+func (r *StagingRepository) Delete(siteID int64) error {
+	_, err := r.db.Exec(`DELETE FROM staging_slots WHERE site_id = ?`, siteID)
+	return err
+}
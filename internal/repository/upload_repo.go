@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+)
+
+type UploadRepository struct {
+	db *database.DB
+}
+
+func NewUploadRepository(db *database.DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+const uploadColumns = `id, site_id, user_id, filename, size, sha256, created_at, updated_at`
+
+func scanUpload(row deployScanner) (*models.Upload, error) {
+	upload := &models.Upload{}
+	err := row.Scan(&upload.ID, &upload.SiteID, &upload.UserID, &upload.Filename, &upload.Size, &upload.SHA256, &upload.CreatedAt, &upload.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (r *UploadRepository) Create(upload *models.Upload) error {
+	now := time.Now()
+	_, err := r.db.Exec(
+		`INSERT INTO uploads (`+uploadColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		upload.ID, upload.SiteID, upload.UserID, upload.Filename, upload.Size, upload.SHA256, now, now,
+	)
+	if err != nil {
+		return err
+	}
+	upload.CreatedAt = now
+	upload.UpdatedAt = now
+	return nil
+}
+
+func (r *UploadRepository) GetByID(id string) (*models.Upload, error) {
+	upload, err := scanUpload(r.db.QueryRow(`SELECT `+uploadColumns+` FROM uploads WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// CountByUser returns the number of uploads the user has in progress.
+func (r *UploadRepository) CountByUser(userID int64) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM uploads WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+// ListIdleSince returns the uploads that received nothing since the given time.
+func (r *UploadRepository) ListIdleSince(t time.Time) ([]*models.Upload, error) {
+	rows, err := r.db.Query(`SELECT `+uploadColumns+` FROM uploads WHERE updated_at < ?`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*models.Upload
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// Touch records that the upload received data.
+func (r *UploadRepository) Touch(id string) error {
+	_, err := r.db.Exec(`UPDATE uploads SET updated_at = ? WHERE id = ?`, time.Now(), id)
+	return err
+}
+
+// SetSHA256 records the checksum of the whole file of an upload.
+func (r *UploadRepository) SetSHA256(id, sum string) error {
+	_, err := r.db.Exec(`UPDATE uploads SET sha256 = ? WHERE id = ?`, sum, id)
+	return err
+}
+
+func (r *UploadRepository) Delete(id string) error {
+	_, err := r.db.Exec(`DELETE FROM uploads WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// MaxSiteApprovers bounds the approvers listed for a site.
+const MaxSiteApprovers = 50
+
+var (
+	ErrApproverNotFound = errors.New("no active user with that email")
+	ErrTooManyApprovers = fmt.Errorf("a site has at most %d approvers", MaxSiteApprovers)
+)
+
+// ApprovalService keeps who may approve the deploys a site holds for
+// approval: the admins and the users listed as approvers of the site.
+type ApprovalService struct {
+	approverRepo *repository.ApproverRepository
+	userRepo     *repository.UserRepository
+	siteRepo     *repository.SiteRepository
+}
+
+func NewApprovalService(approverRepo *repository.ApproverRepository, userRepo *repository.UserRepository, siteRepo *repository.SiteRepository) *ApprovalService {
+	return &ApprovalService{
+		approverRepo: approverRepo,
+		userRepo:     userRepo,
+		siteRepo:     siteRepo,
+	}
+}
+
+// ListApprovers returns the users listed as approvers of a site.
+func (s *ApprovalService) ListApprovers(siteID int64) ([]*models.SiteApprover, error) {
+	return s.approverRepo.ListBySite(siteID)
+}
+
+// AddApprover lists the active user with the given email as an approver of
+// a site.
+func (s *ApprovalService) AddApprover(siteID int64, email string) (*models.User, error) {
+	user, err := s.userRepo.GetByEmail(strings.TrimSpace(email))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrApproverNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrApproverNotFound
+	}
+
+	listed, err := s.approverRepo.Exists(siteID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !listed {
+		approvers, err := s.approverRepo.ListBySite(siteID)
+		if err != nil {
+			return nil, err
+		}
+		if len(approvers) >= MaxSiteApprovers {
+			return nil, ErrTooManyApprovers
+		}
+	}
+
+	if err := s.approverRepo.Add(siteID, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetApprovers replaces the approvers of a site with the active users with
+// the given emails. Nothing changes when one of them is unknown.
+func (s *ApprovalService) SetApprovers(siteID int64, emails []string) ([]*models.SiteApprover, error) {
+	if len(emails) > MaxSiteApprovers {
+		return nil, ErrTooManyApprovers
+	}
+
+	users := make(map[int64]bool)
+	for _, email := range emails {
+		user, err := s.userRepo.GetByEmail(strings.TrimSpace(email))
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && !user.IsActive) {
+			return nil, fmt.Errorf("%w: %s", ErrApproverNotFound, email)
+		}
+		if err != nil {
+			return nil, err
+		}
+		users[user.ID] = true
+	}
+
+	current, err := s.approverRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	for _, approver := range current {
+		if !users[approver.UserID] {
+			if err := s.approverRepo.Remove(siteID, approver.UserID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for userID := range users {
+		if err := s.approverRepo.Add(siteID, userID); err != nil {
+			return nil, err
+		}
+	}
+	return s.approverRepo.ListBySite(siteID)
+}
+
+// RemoveApprover takes a user off the approvers of a site.
+func (s *ApprovalService) RemoveApprover(siteID, userID int64) error {
+	return s.approverRepo.Remove(siteID, userID)
+}
+
+// SetRequired turns the approval of deploys of a site on or off.
+func (s *ApprovalService) SetRequired(site *models.Site, required bool) error {
+	site.RequireApproval = required
+	return s.siteRepo.Update(site)
+}
+
+// CanApprove reports whether a user may approve or reject the deploys of a
+// site: admins may for every site, other users where they are approvers.
+func (s *ApprovalService) CanApprove(site *models.Site, user *models.User) bool {
+	if user.IsAdmin() {
+		return true
+	}
+	listed, err := s.approverRepo.Exists(site.ID, user.ID)
+	return err == nil && listed
+}
+
+// ApproverSiteIDs returns the sites whose deploys a user may approve, nil
+// meaning all of them.
+func (s *ApprovalService) ApproverSiteIDs(user *models.User) ([]int64, error) {
+	if user.IsAdmin() {
+		return nil, nil
+	}
+	siteIDs, err := s.approverRepo.ListSiteIDs(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if siteIDs == nil {
+		siteIDs = []int64{}
+	}
+	return siteIDs, nil
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// archiveFormat is the kind of deploy archive, told apart by its first bytes.
+type archiveFormat string
+
+const (
+	archiveUnknown archiveFormat = ""
+	archiveZip     archiveFormat = "zip"
+	archiveTar     archiveFormat = "tar"
+	archiveTarGz   archiveFormat = "tar.gz"
+	archiveTarZst  archiveFormat = "tar.zst"
+	archiveTarXz   archiveFormat = "tar.xz"
+	archiveTarBz2  archiveFormat = "tar.bz2"
+)
+
+// zstdMaxWindow bounds the memory a zstd stream can make the decoder allocate.
+// It covers archives made with zstd --long.
+const zstdMaxWindow = 128 << 20
+
+var archiveMagic = []struct {
+	magic  []byte
+	format archiveFormat
+}{
+	{[]byte("PK\x03\x04"), archiveZip},
+	{[]byte("PK\x05\x06"), archiveZip}, // empty
+	{[]byte{0x1f, 0x8b}, archiveTarGz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, archiveTarZst},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, archiveTarXz},
+	{[]byte("BZh"), archiveTarBz2},
+}
+
+// tarMagicOffset is where the ustar magic sits in the first tar header.
+const tarMagicOffset = 257
+
+// detectArchiveFormat returns the format of an archive starting with header.
+// Compressed streams are taken to hold a tar archive.
+func detectArchiveFormat(header []byte) archiveFormat {
+	for _, m := range archiveMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format
+		}
+	}
+	if len(header) >= tarMagicOffset+5 && string(header[tarMagicOffset:tarMagicOffset+5]) == "ustar" {
+		return archiveTar
+	}
+	return archiveUnknown
+}
+
+// sniffArchive detects the format of the archive at path.
+func sniffArchive(path string) (archiveFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return archiveUnknown, fmt.Errorf("open archive: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return archiveUnknown, fmt.Errorf("read archive: %w", err)
+	}
+	return detectArchiveFormat(header[:n]), nil
+}
+
+// decompress returns the tar stream inside a compressed archive and a
+// function releasing the decoder.
+func decompress(r io.Reader, format archiveFormat) (io.Reader, func(), error) {
+	switch format {
+	case archiveTar:
+		return r, func() {}, nil
+	case archiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		return gz, func() { gz.Close() }, nil
+	case archiveTarZst:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, nil, fmt.Errorf("create zstd reader: %w", err)
+		}
+		return zr, zr.Close, nil
+	case archiveTarXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create xz reader: %w", err)
+		}
+		return xr, func() {}, nil
+	case archiveTarBz2:
+		return bzip2.NewReader(r), func() {}, nil
+	}
+	return nil, nil, ErrUnsupportedArchive
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
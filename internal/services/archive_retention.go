@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+)
+
+// ArchivePruneInterval is how often the serve process applies the archive
+// retention policy.
+const ArchivePruneInterval = time.Hour
+
+// ArchiveRetention limits the uploaded archives kept in the deploys directory
+// of a site. A zero field means no limit.
+type ArchiveRetention struct {
+	Keep    int           // newest archives kept
+	MaxAge  time.Duration // age after which an archive is removed
+	MaxSize int64         // bytes of archives kept, newest first
+}
+
+// PrunedArchive is an archive removed by the retention policy.
+type PrunedArchive struct {
+	SiteID int64
+	Name   string
+	Size   int64
+}
+
+// archiveFile is an archive found in the deploys directory of a site.
+type archiveFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// recordArchive stores the name of the archive of a deploy before the file is
+// written, so the janitor always knows which deploy a file belongs to.
+func (s *DeployService) recordArchive(deploy *models.Deploy, name string) error {
+	if err := s.deployRepo.SetArchive(deploy.ID, name); err != nil {
+		return fmt.Errorf("record archive: %w", err)
+	}
+	deploy.Archive = name
+	return nil
+}
+
+// archivePath returns the path of an archive in the deploys directory of a site.
+func (s *DeployService) archivePath(siteID int64, name string) string {
+	return filepath.Join(s.sitePath(siteID), "deploys", name)
+}
+
+// archiveRetention returns the retention policy of a site: its own limits,
+// falling back to the config for those it leaves at 0.
+func (s *DeployService) archiveRetention(site *models.Site) ArchiveRetention {
+	keep, maxAge, maxSize := s.config.Sites.KeepArchives, s.config.Sites.ArchiveMaxAge, s.config.Sites.ArchiveMaxSize
+	if site.KeepArchives > 0 {
+		keep = site.KeepArchives
+	}
+	if site.ArchiveMaxAge > 0 {
+		maxAge = site.ArchiveMaxAge
+	}
+	if site.ArchiveMaxSize > 0 {
+		maxSize = site.ArchiveMaxSize
+	}
+	return ArchiveRetention{
+		Keep:    keep,
+		MaxAge:  time.Duration(maxAge) * 24 * time.Hour,
+		MaxSize: maxSize,
+	}
+}
+
+// StartArchiveJanitor applies the archive retention policy of every site now
+// and then every interval, for as long as the process runs.
+func (s *DeployService) StartArchiveJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			pruned, err := s.PruneArchives(false)
+			if err != nil {
+				slog.Error("failed to prune deploy archives", "error", err)
+			}
+			if len(pruned) > 0 {
+				slog.Info("pruned deploy archives", "count", len(pruned))
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PruneArchives applies the retention policy to the archives of every site.
+// Sites with a deploy running are skipped until the next run. With dryRun
+// nothing is removed and the archives that would be are returned.
+func (s *DeployService) PruneArchives(dryRun bool) ([]PrunedArchive, error) {
+	sites, err := s.siteRepo.ListAll()
+	if err != nil {
+		return nil, fmt.Errorf("list sites: %w", err)
+	}
+
+	var pruned []PrunedArchive
+	for _, site := range sites {
+		sitePruned, err := s.PruneSiteArchives(site.ID, dryRun)
+		pruned = append(pruned, sitePruned...)
+		if err != nil {
+			var inProgress *DeployInProgressError
+			if errors.As(err, &inProgress) || errors.Is(err, ErrSiteBusy) {
+				continue
+			}
+			slog.Error("failed to prune deploy archives", "site_id", site.ID, "error", err)
+		}
+	}
+	return pruned, nil
+}
+
+// PruneSiteArchives removes the archives of a site its retention policy does
+// not keep and marks them pruned on their deploys. Archives of deploys that
+// are scheduled, queued or running are kept.
+func (s *DeployService) PruneSiteArchives(siteID int64, dryRun bool) ([]PrunedArchive, error) {
+	site, err := s.siteRepo.GetByID(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	deploysPath := filepath.Join(s.sitePath(siteID), "deploys")
+	if _, err := os.Stat(deploysPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// A restore reads the archive of its deploy under the site lock
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := listArchives(deploysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Loaded after the listing: an archive written meanwhile is recorded on
+	// its deploy before the file is created
+	pending, err := s.deployRepo.ListPending()
+	if err != nil {
+		return nil, fmt.Errorf("list pending deploys: %w", err)
+	}
+	scheduled, err := s.deployRepo.ListScheduled()
+	if err != nil {
+		return nil, fmt.Errorf("list scheduled deploys: %w", err)
+	}
+	inUse := make(map[string]bool)
+	for _, d := range append(pending, scheduled...) {
+		if d.SiteID == siteID && d.Archive != "" {
+			inUse[d.Archive] = true
+		}
+	}
+
+	var pruned []PrunedArchive
+	for _, f := range archivesToPrune(files, s.archiveRetention(site), time.Now(), inUse) {
+		if !dryRun {
+			if err := os.Remove(filepath.Join(deploysPath, f.name)); err != nil && !os.IsNotExist(err) {
+				slog.Error("failed to remove deploy archive", "site_id", siteID, "archive", f.name, "error", err)
+				continue
+			}
+			if err := s.deployRepo.MarkArchivePruned(siteID, f.name); err != nil {
+				slog.Error("failed to mark deploy archive pruned", "site_id", siteID, "archive", f.name, "error", err)
+			}
+		}
+		pruned = append(pruned, PrunedArchive{SiteID: siteID, Name: f.name, Size: f.size})
+	}
+	return pruned, nil
+}
+
+// listArchives returns the archives in a deploys directory, newest first.
+// Hidden entries are scratch space of running deploys, not archives.
+func listArchives(deploysPath string) ([]archiveFile, error) {
+	entries, err := os.ReadDir(deploysPath)
+	if err != nil {
+		return nil, fmt.Errorf("read deploys directory: %w", err)
+	}
+
+	var files []archiveFile
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, archiveFile{name: e.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.After(files[j].modTime)
+		}
+		return files[i].name > files[j].name
+	})
+	return files, nil
+}
+
+// archivesToPrune picks the archives (ordered newest first) outside the
+// retention policy. Archives in use are never picked but count towards the
+// limits, so once one limit is reached every older archive goes.
+func archivesToPrune(files []archiveFile, retention ArchiveRetention, now time.Time, inUse map[string]bool) []archiveFile {
+	var prune []archiveFile
+	var total int64
+	for i, f := range files {
+		total += f.size
+		expired := (retention.Keep > 0 && i >= retention.Keep) ||
+			(retention.MaxAge > 0 && now.Sub(f.modTime) > retention.MaxAge) ||
+			(retention.MaxSize > 0 && total > retention.MaxSize)
+		if expired && !inUse[f.name] {
+			prune = append(prune, f)
+		}
+	}
+	return prune
+}
+
+// restoreRelease rebuilds the pruned release of a deploy from its archive,
+// through the same steps as the deploy itself.
+func (s *DeployService) restoreRelease(deploy *models.Deploy) error {
+	if !deploy.HasArchive() {
+		return ErrReleaseNotFound
+	}
+	archivePath := s.archivePath(deploy.SiteID, deploy.Archive)
+	if _, err := os.Stat(archivePath); err != nil {
+		// Archive vanished from disk, keep the DB in sync
+		s.deployRepo.MarkArchivePruned(deploy.SiteID, deploy.Archive)
+		return ErrReleaseNotFound
+	}
+
+	releasePath := s.releasePath(deploy.SiteID, deploy.ID)
+	os.RemoveAll(releasePath)
+
+	budget, err := s.extractBudget(deploy.SiteID)
+	if err != nil {
+		return err
+	}
+	if err := s.archiveBuilder(archivePath)(releasePath, budget, func(done, total int) {}); err != nil {
+		os.RemoveAll(releasePath)
+		return fmt.Errorf("restore release from archive: %w", err)
+	}
+	if err := s.prepareRelease(deploy.SiteID, releasePath); err != nil {
+		os.RemoveAll(releasePath)
+		return err
+	}
+
+	if site, err := s.siteRepo.GetByID(deploy.SiteID); err == nil && site.Precompress {
+		if _, err := precompressRelease(releasePath, s.config.Nginx.BrotliStatic); err != nil {
+			slog.Warn("failed to precompress release", "deploy_id", deploy.ID, "site_id", deploy.SiteID, "error", err)
+		}
+	}
+
+	if err := s.deployRepo.SetHasRelease(deploy.ID, true); err != nil {
+		return fmt.Errorf("record release: %w", err)
+	}
+	deploy.HasRelease = true
+	return nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+)
+
+func TestArchivesToPrune(t *testing.T) {
+	now := time.Now()
+	// Archives are ordered newest first, one a day, as returned by listArchives
+	files := []archiveFile{
+		{name: "5.zip", size: 10, modTime: now.Add(-1 * time.Hour)},
+		{name: "4.zip", size: 10, modTime: now.Add(-25 * time.Hour)},
+		{name: "3.zip", size: 10, modTime: now.Add(-49 * time.Hour)},
+		{name: "2.zip", size: 10, modTime: now.Add(-73 * time.Hour)},
+		{name: "1.zip", size: 10, modTime: now.Add(-97 * time.Hour)},
+	}
+
+	tests := []struct {
+		name      string
+		retention ArchiveRetention
+		inUse     map[string]bool
+		want      []string
+	}{
+		{"no limits", ArchiveRetention{}, nil, nil},
+		{"keep", ArchiveRetention{Keep: 3}, nil, []string{"2.zip", "1.zip"}},
+		{"max age", ArchiveRetention{MaxAge: 2 * 24 * time.Hour}, nil, []string{"3.zip", "2.zip", "1.zip"}},
+		{"max size", ArchiveRetention{MaxSize: 25}, nil, []string{"3.zip", "2.zip", "1.zip"}},
+		{"strictest limit wins", ArchiveRetention{Keep: 4, MaxAge: 3 * 24 * time.Hour}, nil, []string{"2.zip", "1.zip"}},
+		{"in use is kept", ArchiveRetention{Keep: 1}, map[string]bool{"3.zip": true}, []string{"4.zip", "2.zip", "1.zip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range archivesToPrune(files, tt.retention, now, tt.inUse) {
+				got = append(got, f.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("archivesToPrune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListArchives(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"1_old.zip", "2_new.tar.gz", ".manifest-7"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, mtime, mtime)
+	}
+	os.Mkdir(filepath.Join(dir, ".git-3"), 0755)
+
+	files, err := listArchives(dir)
+	if err != nil {
+		t.Fatalf("listArchives() error = %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	if want := []string{"2_new.tar.gz", "1_old.zip"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listArchives() = %v, want %v", names, want)
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	s := &DeployService{config: &config.Config{Sites: config.SitesConfig{KeepArchives: 10, ArchiveMaxAge: 30}}}
+
+	got := s.archiveRetention(&models.Site{KeepArchives: 3, ArchiveMaxSize: 1000})
+	want := ArchiveRetention{Keep: 3, MaxAge: 30 * 24 * time.Hour, MaxSize: 1000}
+	if got != want {
+		t.Errorf("archiveRetention() = %+v, want %+v", got, want)
+	}
+}
@@ -8,51 +8,84 @@ import (
 
 // Action constants
 const (
-	ActionLogin         = "login"
-	ActionLogout        = "logout"
-	ActionLoginFailed   = "login_failed"
-	ActionSiteCreate    = "site_create"
-	ActionSiteUpdate    = "site_update"
-	ActionSiteDelete    = "site_delete"
-	ActionSiteEnable    = "site_enable"
-	ActionSiteDisable   = "site_disable"
-	ActionDomainAdd     = "domain_add"
-	ActionDomainDelete  = "domain_delete"
-	ActionDomainPrimary = "domain_primary"
-	ActionSSLIssue      = "ssl_issue"
-	ActionSSLRenew      = "ssl_renew"
-	ActionDeploy        = "deploy"
-	ActionRollback      = "rollback"
-	ActionRedirectAdd   = "redirect_add"
-	ActionRedirectEdit  = "redirect_update"
-	ActionRedirectDel   = "redirect_delete"
-	ActionAuthZoneAdd   = "auth_zone_add"
-	ActionAuthZoneEdit  = "auth_zone_update"
-	ActionAuthZoneDel   = "auth_zone_delete"
-	ActionAuthUserAdd   = "auth_user_add"
-	ActionAuthUserDel   = "auth_user_delete"
-	ActionFileCreate    = "file_create"
-	ActionFileEdit      = "file_edit"
-	ActionFileDelete    = "file_delete"
-	ActionFileRename    = "file_rename"
-	ActionFileUpload    = "file_upload"
-	ActionUserCreate    = "user_create"
-	ActionUserUpdate    = "user_update"
-	ActionUserDelete    = "user_delete"
-	ActionUserBlock     = "user_block"
-	ActionUserUnblock   = "user_unblock"
+	ActionLogin          = "login"
+	ActionLogout         = "logout"
+	ActionLoginFailed    = "login_failed"
+	ActionSiteCreate     = "site_create"
+	ActionSiteUpdate     = "site_update"
+	ActionSiteDelete     = "site_delete"
+	ActionSiteEnable     = "site_enable"
+	ActionSiteDisable    = "site_disable"
+	ActionDomainAdd      = "domain_add"
+	ActionDomainDelete   = "domain_delete"
+	ActionDomainPrimary  = "domain_primary"
+	ActionSSLIssue       = "ssl_issue"
+	ActionSSLRenew       = "ssl_renew"
+	ActionDeploy         = "deploy"
+	ActionRollback       = "rollback"
+	ActionRedirectAdd    = "redirect_add"
+	ActionRedirectEdit   = "redirect_update"
+	ActionRedirectDel    = "redirect_delete"
+	ActionAuthZoneAdd    = "auth_zone_add"
+	ActionAuthZoneEdit   = "auth_zone_update"
+	ActionAuthZoneDel    = "auth_zone_delete"
+	ActionAuthUserAdd    = "auth_user_add"
+	ActionAuthUserDel    = "auth_user_delete"
+	ActionFileCreate     = "file_create"
+	ActionFileEdit       = "file_edit"
+	ActionFileDelete     = "file_delete"
+	ActionFileRename     = "file_rename"
+	ActionFileUpload     = "file_upload"
+	ActionUserCreate     = "user_create"
+	ActionUserUpdate     = "user_update"
+	ActionUserDelete     = "user_delete"
+	ActionUserBlock      = "user_block"
+	ActionUserUnblock    = "user_unblock"
+	ActionLimitsUpdate   = "limits_update"
+	ActionGitSource      = "git_source_update"
+	ActionHealthCheckAdd = "health_check_add"
+	ActionHealthCheckDel = "health_check_delete"
+	ActionHealthChecks   = "health_checks_update"
+	ActionSharedPaths    = "shared_paths_update"
+	ActionDeployPolicy   = "deploy_policy_update"
+	ActionDeployKeyAdd   = "deploy_key_add"
+	ActionDeployKeyDel   = "deploy_key_delete"
+	ActionDeployCancel   = "deploy_cancel"
+	ActionFreezeAdd      = "freeze_window_add"
+	ActionFreezeDel      = "freeze_window_delete"
+	ActionS3Credentials  = "s3_credentials_update"
+	ActionS3CredsDelete  = "s3_credentials_delete"
+	ActionStagingUpdate  = "staging_update"
+	ActionStagingDelete  = "staging_delete"
+	ActionPromote        = "promote"
+	ActionDeployApprove  = "deploy_approve"
+	ActionDeployReject   = "deploy_reject"
+	ActionApprovalUpdate = "approval_update"
+	ActionApproverAdd    = "approver_add"
+	ActionApproverDel    = "approver_delete"
+	ActionProxyUpdate    = "proxy_update"
+	ActionPagesUpdate    = "pages_update"
+	ActionHeaderRuleAdd  = "header_rule_add"
+	ActionHeaderRuleDel  = "header_rule_delete"
+	ActionHeaderRuleMove = "header_rule_move"
+	ActionHeaderRules    = "header_rules_update"
+	ActionSnippetsUpdate = "nginx_snippets_update"
 )
 
 // Entity types
 const (
-	EntityUser     = "user"
-	EntitySite     = "site"
-	EntityDomain   = "domain"
-	EntityDeploy   = "deploy"
-	EntityRedirect = "redirect"
-	EntityAuthZone = "auth_zone"
-	EntityAuthUser = "auth_user"
-	EntityFile     = "file"
+	EntityUser         = "user"
+	EntitySite         = "site"
+	EntityDomain       = "domain"
+	EntityDeploy       = "deploy"
+	EntityRedirect     = "redirect"
+	EntityAuthZone     = "auth_zone"
+	EntityAuthUser     = "auth_user"
+	EntityFile         = "file"
+	EntityHealthCheck  = "health_check"
+	EntityDeployKey    = "deploy_key"
+	EntityFreezeWindow = "freeze_window"
+	EntityHeaderRule   = "header_rule"
 )
 
 type AuditService struct {
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+var (
+	ErrNotAwaitingApproval = errors.New("deploy is not awaiting approval")
+	ErrSelfApproval        = errors.New("a deploy must be approved by someone other than the user who made it")
+)
+
+// hold records a deploy whose release is built and checked but waits for an
+// approver. The release stays on disk, it is neither active nor pruned.
+func (s *DeployService) hold(deploy *models.Deploy) {
+	s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusAwaitingApproval, "")
+	deploy.Status = models.DeployStatusAwaitingApproval
+	s.setPhase(deploy, models.DeployPhaseApproval, 100)
+
+	if err := s.deployRepo.SetHasRelease(deploy.ID, true); err != nil {
+		slog.Error("failed to record release", "deploy_id", deploy.ID, "error", err)
+	}
+	deploy.HasRelease = true
+}
+
+// heldDeploy loads a deploy of the site that awaits approval.
+func (s *DeployService) heldDeploy(siteID, deployID int64) (*models.Deploy, error) {
+	deploy, err := s.deployRepo.GetByID(deployID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && deploy.SiteID != siteID) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !deploy.IsAwaitingApproval() {
+		return deploy, ErrNotAwaitingApproval
+	}
+	return deploy, nil
+}
+
+// ListAwaitingApproval returns the deploys held for approval on the given
+// sites, oldest first. A nil siteIDs lists those of all sites.
+func (s *DeployService) ListAwaitingApproval(siteIDs []int64) ([]*models.Deploy, error) {
+	return s.deployRepo.ListAwaitingApproval(siteIDs)
+}
+
+// Approve lets the held release of a deploy go live, like the deploy would
+// have without approval: it is activated and health checked, or staged for
+// a staging deploy. The user who made the deploy cannot approve it.
+func (s *DeployService) Approve(siteID, deployID, reviewerID int64, note string) (*models.Deploy, error) {
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deploy, err := s.heldDeploy(siteID, deployID)
+	if err != nil {
+		return deploy, err
+	}
+	if deploy.UserID == reviewerID {
+		return deploy, ErrSelfApproval
+	}
+	if deploy.IsStaging() {
+		if err := s.checkStaging(siteID); err != nil {
+			return deploy, err
+		}
+	}
+	if _, err := os.Stat(s.releasePath(siteID, deploy.ID)); err != nil {
+		return deploy, ErrReleaseNotFound
+	}
+
+	if err := s.deployRepo.SetReview(deploy.ID, reviewerID, note); err != nil {
+		return deploy, fmt.Errorf("record review: %w", err)
+	}
+	deploy.ReviewedBy = &reviewerID
+	deploy.ReviewNote = note
+
+	if err := s.goLive(deploy); err != nil {
+		s.deployRepo.SetHasRelease(deploy.ID, false)
+		deploy.HasRelease = false
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	s.complete(deploy)
+	s.pruneReleases(siteID)
+	return deploy, nil
+}
+
+// Reject turns down the held release of a deploy and removes it. Anyone who
+// may approve the deploy may reject it, its author included.
+func (s *DeployService) Reject(siteID, deployID, reviewerID int64, note string) (*models.Deploy, error) {
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deploy, err := s.heldDeploy(siteID, deployID)
+	if err != nil {
+		return deploy, err
+	}
+
+	if err := os.RemoveAll(s.releasePath(siteID, deploy.ID)); err != nil {
+		return deploy, fmt.Errorf("remove release: %w", err)
+	}
+	if err := s.deployRepo.SetHasRelease(deploy.ID, false); err != nil {
+		return deploy, err
+	}
+	deploy.HasRelease = false
+
+	if err := s.deployRepo.SetReview(deploy.ID, reviewerID, note); err != nil {
+		return deploy, fmt.Errorf("record review: %w", err)
+	}
+	deploy.ReviewedBy = &reviewerID
+	deploy.ReviewNote = note
+
+	if err := s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusRejected, ""); err != nil {
+		return deploy, err
+	}
+	deploy.Status = models.DeployStatusRejected
+	return deploy, nil
+}
+
+// ReviewRelease compares the held release of a deploy with the release it
+// would replace: the active one, or the staged one for a staging deploy.
+// When there is none, every file of the held release is listed as added.
+func (s *DeployService) ReviewRelease(siteID, deployID int64, content bool) (*models.ReleaseDiff, error) {
+	held, err := s.heldDeploy(siteID, deployID)
+	if err != nil {
+		return nil, err
+	}
+	toRoot := s.releasePath(siteID, held.ID)
+	if _, err := os.Stat(toRoot); err != nil {
+		return nil, ErrReleaseNotFound
+	}
+
+	var current *models.Deploy
+	if held.IsStaging() {
+		current, err = s.deployRepo.GetStaged(siteID)
+	} else {
+		current, err = s.deployRepo.GetActive(siteID)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	var fromRoot string
+	var fromID int64
+	if current != nil {
+		root, cleanup, err := s.releaseSnapshot(current)
+		if err != nil && !errors.Is(err, ErrReleaseNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			defer cleanup()
+			fromRoot, fromID = root, current.ID
+		}
+	}
+	if fromRoot == "" {
+		empty, err := os.MkdirTemp("", "micropanel-review-")
+		if err != nil {
+			return nil, fmt.Errorf("create directory: %w", err)
+		}
+		defer os.RemoveAll(empty)
+		fromRoot = empty
+	}
+
+	diff, err := diffReleaseDirs(fromRoot, toRoot, content)
+	if err != nil {
+		return nil, err
+	}
+	diff.FromDeployID = fromID
+	diff.ToDeployID = held.ID
+	return diff, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+func TestDeployService_Approve(t *testing.T) {
+	env := newDeployTestEnv(t)
+	reviewer := env.addUser(t, "reviewer@example.com")
+
+	live := env.deploy(t, "v1", DeployOptions{})
+	held := env.deploy(t, "v2", DeployOptions{Approval: true})
+	if held.Status != models.DeployStatusAwaitingApproval || held.Phase != models.DeployPhaseApproval || !held.HasRelease {
+		t.Fatalf("held deploy = %s/%s has release %v, want awaiting_approval/approval with release", held.Status, held.Phase, held.HasRelease)
+	}
+	if _, err := os.Stat(env.service.releasePath(env.site.ID, held.ID)); err != nil {
+		t.Fatalf("held release: %v", err)
+	}
+	if got := env.current(); got != live.ID {
+		t.Fatalf("current release = %d while held, want %d", got, live.ID)
+	}
+
+	if _, err := env.service.Approve(env.site.ID, held.ID, env.owner.ID, ""); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("Approve() by the author error = %v, want %v", err, ErrSelfApproval)
+	}
+	if _, err := env.service.Approve(env.site.ID+1, held.ID, reviewer.ID, ""); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Approve() on another site error = %v, want %v", err, repository.ErrNotFound)
+	}
+	if got := env.current(); got != live.ID {
+		t.Fatalf("current release = %d after refused approvals, want %d", got, live.ID)
+	}
+
+	approved, err := env.service.Approve(env.site.ID, held.ID, reviewer.ID, "looks good")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if got := env.current(); got != held.ID {
+		t.Errorf("current release = %d after approval, want %d", got, held.ID)
+	}
+	stored, err := env.deploys.GetByID(approved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.DeployStatusSuccess || !stored.IsActive || stored.ReviewedBy == nil || *stored.ReviewedBy != reviewer.ID || stored.ReviewNote != "looks good" {
+		t.Errorf("approved deploy = %s active %v reviewed by %v %q", stored.Status, stored.IsActive, stored.ReviewedBy, stored.ReviewNote)
+	}
+
+	if _, err := env.service.Approve(env.site.ID, held.ID, reviewer.ID, ""); !errors.Is(err, ErrNotAwaitingApproval) {
+		t.Errorf("Approve() twice error = %v, want %v", err, ErrNotAwaitingApproval)
+	}
+	if _, err := env.service.Reject(env.site.ID, held.ID, reviewer.ID, ""); !errors.Is(err, ErrNotAwaitingApproval) {
+		t.Errorf("Reject() of an approved deploy error = %v, want %v", err, ErrNotAwaitingApproval)
+	}
+}
+
+func TestDeployService_Reject(t *testing.T) {
+	env := newDeployTestEnv(t)
+
+	live := env.deploy(t, "v1", DeployOptions{})
+	held := env.deploy(t, "v2", DeployOptions{Approval: true})
+
+	// The author may take back a deploy, only approving it is left to others
+	if _, err := env.service.Reject(env.site.ID, held.ID, env.owner.ID, "wrong branch"); err != nil {
+		t.Fatalf("Reject() error = %v", err)
+	}
+	if _, err := os.Stat(env.service.releasePath(env.site.ID, held.ID)); !os.IsNotExist(err) {
+		t.Errorf("rejected release still on disk: %v", err)
+	}
+	if got := env.current(); got != live.ID {
+		t.Errorf("current release = %d after rejection, want %d", got, live.ID)
+	}
+	stored, err := env.deploys.GetByID(held.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.DeployStatusRejected || stored.HasRelease || stored.ReviewedBy == nil || stored.ReviewNote != "wrong branch" {
+		t.Errorf("rejected deploy = %s has release %v reviewed by %v %q", stored.Status, stored.HasRelease, stored.ReviewedBy, stored.ReviewNote)
+	}
+
+	if _, err := env.service.Approve(env.site.ID, held.ID, env.addUser(t, "reviewer@example.com").ID, ""); !errors.Is(err, ErrNotAwaitingApproval) {
+		t.Errorf("Approve() of a rejected deploy error = %v, want %v", err, ErrNotAwaitingApproval)
+	}
+}
+
+func TestDeployService_HeldReleaseNotPruned(t *testing.T) {
+	env := newDeployTestEnv(t)
+	env.site.KeepReleases = 1
+	if err := env.sites.Update(env.site); err != nil {
+		t.Fatal(err)
+	}
+
+	held := env.deploy(t, "held", DeployOptions{Approval: true})
+	first := env.deploy(t, "v1", DeployOptions{})
+	last := env.deploy(t, "v2", DeployOptions{})
+
+	if _, err := os.Stat(env.service.releasePath(env.site.ID, held.ID)); err != nil {
+		t.Errorf("held release pruned: %v", err)
+	}
+	if _, err := os.Stat(env.service.releasePath(env.site.ID, first.ID)); !os.IsNotExist(err) {
+		t.Errorf("release beyond keep_releases not pruned: %v", err)
+	}
+	if got := env.current(); got != last.ID {
+		t.Errorf("current release = %d, want %d", got, last.ID)
+	}
+}
+
+func TestDeployService_ApproveStaging(t *testing.T) {
+	env := newDeployTestEnv(t)
+	reviewer := env.addUser(t, "reviewer@example.com")
+	stagingRepo := repository.NewStagingRepository(env.db)
+	if err := stagingRepo.Set(&models.StagingSlot{SiteID: env.site.ID}); err != nil {
+		t.Fatal(err)
+	}
+	env.service.SetStagingService(NewStagingService(env.config, stagingRepo, env.sites, repository.NewDomainRepository(env.db)))
+	sitePath := env.service.sitePath(env.site.ID)
+
+	live := env.deploy(t, "v1", DeployOptions{})
+	held := env.deploy(t, "preview", DeployOptions{Target: models.DeployTargetStaging, Approval: true})
+	if !held.IsAwaitingApproval() {
+		t.Fatalf("staging deploy = %s, want awaiting_approval", held.Status)
+	}
+	if _, err := linkedRelease(sitePath, stagingLinkName); err == nil {
+		t.Errorf("held staging release is served on staging")
+	}
+
+	if _, err := env.service.Approve(env.site.ID, held.ID, reviewer.ID, ""); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if got, err := linkedRelease(sitePath, stagingLinkName); err != nil || got != held.ID {
+		t.Errorf("staging release = %d, %v, want %d", got, err, held.ID)
+	}
+	if got := env.current(); got != live.ID {
+		t.Errorf("current release = %d after staging approval, want %d", got, live.ID)
+	}
+	stored, err := env.deploys.GetByID(held.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.IsStaged || stored.IsActive {
+		t.Errorf("approved staging deploy staged %v active %v, want staged only", stored.IsStaged, stored.IsActive)
+	}
+}
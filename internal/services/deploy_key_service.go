@@ -0,0 +1,231 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const (
+	MaxDeployKeys       = 20 // per site or user
+	MaxDeployKeyNameLen = 100
+)
+
+var (
+	ErrInvalidDeployKey     = errors.New("public key must be an ed25519 key in OpenSSH, PEM or base64 form")
+	ErrDeployKeyNameMissing = errors.New("deploy key name is required")
+	ErrDeployKeyNameTooLong = fmt.Errorf("deploy key name must be at most %d characters", MaxDeployKeyNameLen)
+	ErrDeployKeyExists      = errors.New("this key is already added")
+	ErrTooManyDeployKeys    = fmt.Errorf("at most %d deploy keys can be added", MaxDeployKeys)
+	ErrSignatureRequired    = errors.New("site requires signed archives: signature missing")
+	ErrInvalidSignature     = errors.New("archive signature does not match any deploy key of the site")
+)
+
+// DeployKeyService manages the ed25519 keys that sign deploy archives and
+// verifies the signatures of uploads against them.
+type DeployKeyService struct {
+	keyRepo *repository.DeployKeyRepository
+}
+
+func NewDeployKeyService(keyRepo *repository.DeployKeyRepository) *DeployKeyService {
+	return &DeployKeyService{keyRepo: keyRepo}
+}
+
+func (s *DeployKeyService) GetByID(id int64) (*models.DeployKey, error) {
+	return s.keyRepo.GetByID(id)
+}
+
+func (s *DeployKeyService) ListBySite(siteID int64) ([]*models.DeployKey, error) {
+	return s.keyRepo.ListBySite(siteID)
+}
+
+func (s *DeployKeyService) ListByUser(userID int64) ([]*models.DeployKey, error) {
+	return s.keyRepo.ListByUser(userID)
+}
+
+// ListForSite returns the keys whose signatures a site accepts, its own and
+// those of its owner. An empty list means the site takes unsigned archives.
+func (s *DeployKeyService) ListForSite(siteID int64) ([]*models.DeployKey, error) {
+	return s.keyRepo.ListForSite(siteID)
+}
+
+// CreateForSite adds a key to a site. An empty name is taken from the
+// comment of an OpenSSH key.
+func (s *DeployKeyService) CreateForSite(siteID int64, name, publicKey string) (*models.DeployKey, error) {
+	existing, err := s.keyRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	return s.create(&models.DeployKey{SiteID: &siteID}, existing, name, publicKey)
+}
+
+// CreateForUser adds a key to a user, covering every site the user owns.
+func (s *DeployKeyService) CreateForUser(userID int64, name, publicKey string) (*models.DeployKey, error) {
+	existing, err := s.keyRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.create(&models.DeployKey{UserID: &userID}, existing, name, publicKey)
+}
+
+func (s *DeployKeyService) create(key *models.DeployKey, existing []*models.DeployKey, name, publicKey string) (*models.DeployKey, error) {
+	parsed, comment, err := parseDeployKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		return nil, ErrDeployKeyNameMissing
+	}
+	if len(name) > MaxDeployKeyNameLen {
+		return nil, ErrDeployKeyNameTooLong
+	}
+
+	if len(existing) >= MaxDeployKeys {
+		return nil, ErrTooManyDeployKeys
+	}
+	fingerprint := ssh.FingerprintSHA256(parsed)
+	for _, other := range existing {
+		if other.Fingerprint == fingerprint {
+			return nil, ErrDeployKeyExists
+		}
+	}
+
+	key.Name = name
+	key.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(parsed)))
+	key.Fingerprint = fingerprint
+	if err := s.keyRepo.Create(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *DeployKeyService) Delete(id int64) error {
+	return s.keyRepo.Delete(id)
+}
+
+// Verify checks the signature of an archive with the given SHA-256 digest
+// against the keys of a site and returns the key that made it. A site
+// without keys accepts any archive; Verify then returns nil and no error.
+// The signature is the ed25519 signature of the raw 32-byte digest, in
+// base64 or hex.
+func (s *DeployKeyService) Verify(siteID int64, digest []byte, signature string) (*models.DeployKey, error) {
+	keys, err := s.keyRepo.ListForSite(siteID)
+	if err != nil {
+		return nil, fmt.Errorf("load deploy keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return verifySignature(keys, digest, signature)
+}
+
+// RequiresSignature reports whether a site only accepts signed archives.
+func (s *DeployKeyService) RequiresSignature(siteID int64) (bool, error) {
+	keys, err := s.keyRepo.ListForSite(siteID)
+	if err != nil {
+		return false, fmt.Errorf("load deploy keys: %w", err)
+	}
+	return len(keys) > 0, nil
+}
+
+// verifySignature returns the first of keys that signed digest.
+func verifySignature(keys []*models.DeployKey, digest []byte, signature string) (*models.DeployKey, error) {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return nil, ErrSignatureRequired
+	}
+	sig, ok := decodeSignature(signature)
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+
+	for _, key := range keys {
+		parsed, _, err := parseDeployKey(key.PublicKey)
+		if err != nil {
+			continue
+		}
+		pub := parsed.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+		if ed25519.Verify(pub, digest, sig) {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+// decodeSignature decodes a signature given as hex or base64, with or
+// without padding.
+func decodeSignature(signature string) ([]byte, bool) {
+	decoders := []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	for _, decode := range decoders {
+		if sig, err := decode(signature); err == nil && len(sig) == ed25519.SignatureSize {
+			return sig, true
+		}
+	}
+	return nil, false
+}
+
+// parseDeployKey parses an ed25519 public key given as an OpenSSH
+// authorized_keys line, a PEM "PUBLIC KEY" block or the base64 of its 32
+// bytes. It also returns the comment of an OpenSSH key.
+func parseDeployKey(text string) (ssh.PublicKey, string, error) {
+	text = strings.TrimSpace(text)
+
+	var pub ed25519.PublicKey
+	comment := ""
+	switch {
+	case strings.HasPrefix(text, "-----BEGIN"):
+		block, _ := pem.Decode([]byte(text))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, "", ErrInvalidDeployKey
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, "", ErrInvalidDeployKey
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, "", ErrInvalidDeployKey
+		}
+		pub = key
+	case strings.HasPrefix(text, "ssh-"):
+		parsed, sshComment, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+		if err != nil || parsed.Type() != ssh.KeyAlgoED25519 {
+			return nil, "", ErrInvalidDeployKey
+		}
+		pub = parsed.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+		comment = strings.TrimSpace(sshComment)
+	default:
+		raw, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, "", ErrInvalidDeployKey
+		}
+		pub = ed25519.PublicKey(raw)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, "", ErrInvalidDeployKey
+	}
+	return key, comment, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"micropanel/internal/models"
+)
+
+func TestParseDeployKey(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sshKey, _ := ssh.NewPublicKey(pub)
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecdsaSSH, _ := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+
+	tests := []struct {
+		name        string
+		text        string
+		wantComment string
+		wantErr     error
+	}{
+		{"openssh", authorized + " ci@example.com\n", "ci@example.com", nil},
+		{"pem", pemKey, "", nil},
+		{"raw base64", base64.StdEncoding.EncodeToString(pub), "", nil},
+		{"ecdsa", string(ssh.MarshalAuthorizedKey(ecdsaSSH)), "", ErrInvalidDeployKey},
+		{"short base64", base64.StdEncoding.EncodeToString(pub[:16]), "", ErrInvalidDeployKey},
+		{"garbage", "not a key", "", ErrInvalidDeployKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, comment, err := parseDeployKey(tt.text)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseDeployKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))); got != authorized {
+				t.Errorf("parseDeployKey() = %q, want %q", got, authorized)
+			}
+			if comment != tt.wantComment {
+				t.Errorf("comment = %q, want %q", comment, tt.wantComment)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	newKey := func(name string) (*models.DeployKey, ed25519.PrivateKey) {
+		pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+		sshKey, _ := ssh.NewPublicKey(pub)
+		return &models.DeployKey{
+			Name:        name,
+			PublicKey:   string(ssh.MarshalAuthorizedKey(sshKey)),
+			Fingerprint: ssh.FingerprintSHA256(sshKey),
+		}, priv
+	}
+	ci, ciPriv := newKey("ci")
+	laptop, laptopPriv := newKey("laptop")
+	_, otherPriv := newKey("other")
+	keys := []*models.DeployKey{ci, laptop}
+
+	digest := sha256.Sum256([]byte("archive content"))
+	otherDigest := sha256.Sum256([]byte("tampered content"))
+
+	tests := []struct {
+		name      string
+		signature string
+		want      *models.DeployKey
+		wantErr   error
+	}{
+		{"base64", base64.StdEncoding.EncodeToString(ed25519.Sign(ciPriv, digest[:])), ci, nil},
+		{"hex with newline", hex.EncodeToString(ed25519.Sign(laptopPriv, digest[:])) + "\n", laptop, nil},
+		{"unpadded base64", base64.RawStdEncoding.EncodeToString(ed25519.Sign(ciPriv, digest[:])), ci, nil},
+		{"missing", "", nil, ErrSignatureRequired},
+		{"unknown key", base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, digest[:])), nil, ErrInvalidSignature},
+		{"other archive", base64.StdEncoding.EncodeToString(ed25519.Sign(ciPriv, otherDigest[:])), nil, ErrInvalidSignature},
+		{"malformed", "not a signature", nil, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifySignature(keys, digest[:], tt.signature)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifySignature() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("verifySignature() key = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
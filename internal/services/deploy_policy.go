@@ -0,0 +1,343 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"micropanel/internal/models"
+)
+
+// secretScanSize is how much of each file is searched for secrets.
+const secretScanSize = 1024 * 1024
+
+// maxReportedViolations bounds the violations listed in the error message;
+// the deploy record keeps all of them.
+const maxReportedViolations = 5
+
+// secretPatterns recognize private keys and access tokens that must not be
+// published. Keys meant for browsers, such as Stripe publishable keys, are
+// not included.
+var secretPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+}
+
+// PolicyViolationError is returned when a release breaks the deploy policy.
+type PolicyViolationError struct {
+	Violations []models.PolicyViolation
+}
+
+func (e *PolicyViolationError) Error() string {
+	parts := make([]string, 0, maxReportedViolations)
+	for i, v := range e.Violations {
+		if i == maxReportedViolations {
+			parts = append(parts, fmt.Sprintf("and %d more", len(e.Violations)-i))
+			break
+		}
+		parts = append(parts, v.String())
+	}
+	return "deploy policy violated: " + strings.Join(parts, "; ")
+}
+
+// SetSettingsService enables the deploy policy admins set in the panel.
+// Without it every release is accepted.
+func (s *DeployService) SetSettingsService(settings *SettingsService) {
+	s.settings = settings
+}
+
+// deployPolicy returns the policy releases are checked against, or nil when
+// it allows everything.
+func (s *DeployService) deployPolicy() (*models.DeployPolicy, error) {
+	if s.settings == nil {
+		return nil, nil
+	}
+	policy, err := s.settings.GetDeployPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("load deploy policy: %w", err)
+	}
+	if policy.IsEmpty() {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// checkArchivePolicy checks an uploaded archive against the deploy policy
+// before it is queued, so the uploader learns about violations right away.
+func (s *DeployService) checkArchivePolicy(archivePath string) error {
+	policy, err := s.deployPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	scan, err := scanArchive(archivePath, policy.BlockSecrets)
+	if err != nil {
+		return err
+	}
+	return evaluatePolicy(policy, scan)
+}
+
+// checkReleasePolicy checks a built release against the deploy policy. It
+// covers every kind of deploy, and uploads again in case the policy changed
+// while they were queued.
+func (s *DeployService) checkReleasePolicy(releasePath string) error {
+	policy, err := s.deployPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	scan, err := scanRelease(releasePath, policy.BlockSecrets)
+	if err != nil {
+		return err
+	}
+	return evaluatePolicy(policy, scan)
+}
+
+// releaseScan lists the files of a release the way it will be published.
+type releaseScan struct {
+	files  []scannedFile
+	ignore ignoreRules // from the ignore file, if the files still include it
+}
+
+type scannedFile struct {
+	name   string // slash-separated, relative to the release root
+	size   int64
+	secret string // kind of secret found in the file, if any
+}
+
+// evaluatePolicy returns a *PolicyViolationError listing every file of the
+// scan that breaks the policy.
+func evaluatePolicy(policy *models.DeployPolicy, scan *releaseScan) error {
+	forbidden, err := parseIgnoreRules(policy.ForbiddenPaths)
+	if err != nil {
+		return fmt.Errorf("deploy policy: %w", err)
+	}
+
+	var violations []models.PolicyViolation
+	reported := make(map[string]bool)
+	var total int64
+	hasIndex := false
+
+	for _, f := range scan.files {
+		if f.name == IgnoreFileName {
+			continue
+		}
+		if rule, _ := scan.ignore.excludes(f.name, false); rule != nil {
+			continue
+		}
+
+		total += f.size
+		if f.name == "index.html" {
+			hasIndex = true
+		}
+
+		if rule, matched := forbidden.excludes(f.name, false); rule != nil {
+			if !reported[matched] {
+				reported[matched] = true
+				violations = append(violations, models.PolicyViolation{Rule: models.PolicyRuleForbiddenPath, Path: matched, Detail: rule.pattern})
+			}
+			continue
+		}
+		if policy.BlockSecrets && f.secret != "" {
+			violations = append(violations, models.PolicyViolation{Rule: models.PolicyRuleSecret, Path: f.name, Detail: f.secret})
+		}
+	}
+
+	if policy.RequireIndex && !hasIndex {
+		violations = append(violations, models.PolicyViolation{Rule: models.PolicyRuleRequireIndex, Path: "index.html", Detail: "missing"})
+	}
+	if policy.MaxTotalSize > 0 && total > policy.MaxTotalSize {
+		violations = append(violations, models.PolicyViolation{
+			Rule:   models.PolicyRuleMaxTotalSize,
+			Detail: fmt.Sprintf("%d bytes, limit %d", total, policy.MaxTotalSize),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// scanRelease lists the files of an extracted release.
+func scanRelease(releasePath string, findSecrets bool) (*releaseScan, error) {
+	scan := &releaseScan{}
+	err := filepath.WalkDir(releasePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(releasePath, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f := scannedFile{name: filepath.ToSlash(rel), size: info.Size()}
+		if findSecrets {
+			file, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			f.secret, err = findSecret(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("scan %s: %w", f.name, err)
+			}
+		}
+		scan.files = append(scan.files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan release: %w", err)
+	}
+	return scan, nil
+}
+
+// scanArchive lists the files of an archive as extraction will lay them out,
+// with the common root stripped, and reads its ignore file. Entries the
+// extraction would refuse are left to it.
+func scanArchive(archivePath string, findSecrets bool) (*releaseScan, error) {
+	format, err := sniffArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var root commonRoot
+	var files []scannedFile
+	ignoreFiles := make(map[string][]byte) // by full name, the root is not known yet
+
+	add := func(name string, isDir bool, size int64, open func() (io.ReadCloser, error)) error {
+		root.add(name, isDir)
+		if isDir {
+			return nil
+		}
+
+		f := scannedFile{name: name, size: size}
+		// Only an ignore file at the root or in the common root counts
+		isIgnoreFile := path.Base(name) == IgnoreFileName && strings.Count(strings.TrimPrefix(name, "./"), "/") <= 1
+		if findSecrets || isIgnoreFile {
+			r, err := open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			if isIgnoreFile && size <= maxIgnoreFileSize {
+				data, err := io.ReadAll(io.LimitReader(r, maxIgnoreFileSize))
+				if err != nil {
+					return err
+				}
+				ignoreFiles[name] = data
+				r = io.NopCloser(bytes.NewReader(data))
+			}
+			if findSecrets {
+				if f.secret, err = findSecret(r); err != nil {
+					return err
+				}
+			}
+		}
+		files = append(files, f)
+		return nil
+	}
+
+	switch format {
+	case archiveZip:
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("open zip: %w", err)
+		}
+		defer reader.Close()
+
+		for _, file := range reader.File {
+			if !file.Mode().IsRegular() && !file.FileInfo().IsDir() {
+				continue
+			}
+			if err := add(file.Name, file.FileInfo().IsDir(), int64(file.UncompressedSize64), file.Open); err != nil {
+				return nil, fmt.Errorf("scan %s: %w", file.Name, err)
+			}
+		}
+	case archiveUnknown:
+		return nil, ErrUnsupportedArchive
+	default:
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("open archive: %w", err)
+		}
+		defer file.Close()
+
+		stream, closeStream, err := decompress(file, format)
+		if err != nil {
+			return nil, err
+		}
+		defer closeStream()
+
+		tarReader := tar.NewReader(stream)
+		for count := 1; ; count++ {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read tar header: %w", err)
+			}
+			if count > MaxFiles {
+				return nil, ErrTooManyFiles
+			}
+			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+				continue
+			}
+			open := func() (io.ReadCloser, error) { return io.NopCloser(tarReader), nil }
+			if err := add(header.Name, header.Typeflag == tar.TypeDir, header.Size, open); err != nil {
+				return nil, fmt.Errorf("scan %s: %w", header.Name, err)
+			}
+		}
+	}
+
+	prefix := root.get()
+	scan := &releaseScan{}
+	for name, data := range ignoreFiles {
+		if strings.TrimPrefix(name, "./") == strings.TrimPrefix(prefix, "./")+IgnoreFileName {
+			scan.ignore = readIgnoreRules(data)
+		}
+	}
+	for _, f := range files {
+		f.name = strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(f.name, prefix)), "/")
+		scan.files = append(scan.files, f)
+	}
+	return scan, nil
+}
+
+// findSecret returns the kind of the first secret in the beginning of r, or
+// an empty string.
+func findSecret(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, secretScanSize))
+	if err != nil {
+		return "", err
+	}
+	for _, p := range secretPatterns {
+		if p.re.Match(data) {
+			return p.kind, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"micropanel/internal/models"
+)
+
+// wait returns a deploy once it is no longer queued or running.
+func (env *deployTestEnv) wait(t *testing.T, id int64) *models.Deploy {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deploy, err := env.deploys.GetByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deploy.IsRunning() {
+			return deploy
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deploy %d still %s after 5s", id, deploy.Phase)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// queue creates a queued deploy of the site and hands it to the workers with
+// the given builder.
+func (env *deployTestEnv) queue(t *testing.T, build releaseBuilder) *models.Deploy {
+	t.Helper()
+	deploy, err := env.service.create(env.site.ID, env.owner.ID, "site.zip", DeployOptions{Queue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.service.setPhase(deploy, models.DeployPhaseQueued, 0)
+	queued := *deploy
+	if err := env.service.dispatch(deployJob{deploy: &queued, build: build}); err != nil {
+		t.Fatalf("dispatch() error = %v", err)
+	}
+	return deploy
+}
+
+// fillRelease fills a release with an index.html of the given content.
+func fillRelease(releasePath, content string) error {
+	if err := os.MkdirAll(releasePath, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(releasePath, "index.html"), []byte(content), 0644)
+}
+
+func TestDeployService_Enqueue(t *testing.T) {
+	env := newDeployTestEnv(t)
+
+	// The deploy is saving while the archive is being read
+	reader, writer := io.Pipe()
+	buf := siteZip("v1")
+	size := int64(buf.Len())
+	type result struct {
+		deploy *models.Deploy
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		deploy, err := env.service.Enqueue(env.site.ID, env.owner.ID, "site.zip", reader, size, DeployOptions{})
+		done <- result{deploy, err}
+	}()
+	if _, err := writer.Write(buf.Next(10)); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := env.deploys.GetPending(env.site.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Phase != models.DeployPhaseSaving {
+		t.Errorf("phase while reading the archive = %s, want %s", pending.Phase, models.DeployPhaseSaving)
+	}
+	writer.Write(buf.Bytes())
+	writer.Close()
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("Enqueue() error = %v", res.err)
+	}
+	saved, _ := env.deploys.GetByID(res.deploy.ID)
+	if saved.Phase != models.DeployPhaseQueued || !saved.IsRunning() {
+		t.Errorf("Enqueue() left %s %s, want a pending queued deploy", saved.Status, saved.Phase)
+	}
+
+	env.service.StartWorkers(1)
+	deploy := env.wait(t, res.deploy.ID)
+	if deploy.Status != models.DeployStatusSuccess || deploy.Phase != models.DeployPhaseDone || deploy.Progress != 100 {
+		t.Errorf("deploy = %s %s %d%%, want success done 100%%", deploy.Status, deploy.Phase, deploy.Progress)
+	}
+	if got := env.current(); got != deploy.ID {
+		t.Errorf("current release = %d, want %d", got, deploy.ID)
+	}
+}
+
+func TestDeployService_Phases(t *testing.T) {
+	env := newDeployTestEnv(t)
+	env.service.StartWorkers(1)
+	sitePath := siteDir(env.config.Sites.Path, env.site.ID)
+
+	// The builder reports the deploy as it sees it before and halfway
+	seen := make(chan *models.Deploy, 2)
+	deploy := env.queue(t, func(releasePath string, budget *extractBudget, progress func(done, total int)) error {
+		pending, _ := env.deploys.GetPending(env.site.ID)
+		seen <- pending
+		progress(1, 2)
+		halfway, _ := env.deploys.GetByID(pending.ID)
+		seen <- halfway
+		progress(2, 2)
+
+		// A directory in the way of the current symlink fails the activation
+		if err := os.MkdirAll(filepath.Join(sitePath, currentLinkName, "index.html"), 0755); err != nil {
+			return err
+		}
+		return fillRelease(releasePath, "v1")
+	})
+
+	failed := env.wait(t, deploy.ID)
+	extracting, halfway := <-seen, <-seen
+	if extracting == nil || extracting.Phase != models.DeployPhaseExtracting || extracting.Progress != 0 {
+		t.Errorf("deploy while building = %+v, want extracting at 0%%", extracting)
+	}
+	if halfway == nil || halfway.Phase != models.DeployPhaseExtracting || halfway.Progress != 50 {
+		t.Errorf("deploy halfway = %+v, want extracting at 50%%", halfway)
+	}
+	if failed.Status != models.DeployStatusFailed || failed.Phase != models.DeployPhaseActivating || failed.Progress != 100 {
+		t.Errorf("deploy = %s %s %d%%, want failed in activating at 100%%", failed.Status, failed.Phase, failed.Progress)
+	}
+}
+
+func TestDeployService_QueuedDeploysRunInOrder(t *testing.T) {
+	env := newDeployTestEnv(t)
+	env.service.StartWorkers(2)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := env.queue(t, func(releasePath string, budget *extractBudget, progress func(done, total int)) error {
+		close(started)
+		<-release
+		return fillRelease(releasePath, "v1")
+	})
+	<-started
+
+	buf := siteZip("v2")
+	second, err := env.service.Enqueue(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), DeployOptions{Queue: true})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// A free worker does not pick up a deploy of a site that is busy
+	time.Sleep(100 * time.Millisecond)
+	if waiting, _ := env.deploys.GetByID(second.ID); waiting.Phase != models.DeployPhaseQueued {
+		t.Errorf("second deploy is %s while the first runs, want %s", waiting.Phase, models.DeployPhaseQueued)
+	}
+
+	close(release)
+	for _, d := range []*models.Deploy{env.wait(t, first.ID), env.wait(t, second.ID)} {
+		if d.Status != models.DeployStatusSuccess {
+			t.Errorf("deploy %d = %s (%s), want success", d.ID, d.Status, d.ErrorMessage)
+		}
+	}
+	if got := env.current(); got != second.ID {
+		t.Errorf("current release = %d, want the second deploy %d", got, second.ID)
+	}
+	content, _ := os.ReadFile(filepath.Join(siteCurrentPath(env.config.Sites.Path, env.site.ID), "index.html"))
+	if string(content) != "v2" {
+		t.Errorf("index.html = %q, want v2", content)
+	}
+}
+
+func TestDeployService_QueueFull(t *testing.T) {
+	env := newDeployTestEnv(t)
+
+	// Without workers the first deploy holds the site and the others wait
+	// behind it until the queue of the site is full
+	for i := 0; i <= DeployQueueSize; i++ {
+		buf := siteZip("v1")
+		if _, err := env.service.Enqueue(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), DeployOptions{Queue: true}); err != nil {
+			t.Fatalf("Enqueue() #%d error = %v", i+1, err)
+		}
+	}
+
+	buf := siteZip("v1")
+	deploy, err := env.service.Enqueue(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), DeployOptions{Queue: true})
+	if !errors.Is(err, ErrDeployQueueFull) {
+		t.Fatalf("Enqueue() error = %v, want %v", err, ErrDeployQueueFull)
+	}
+	saved, _ := env.deploys.GetByID(deploy.ID)
+	if saved.Status != models.DeployStatusFailed || saved.ErrorMessage != ErrDeployQueueFull.Error() {
+		t.Errorf("refused deploy = %s (%s), want failed", saved.Status, saved.ErrorMessage)
+	}
+	archives, _ := filepath.Glob(filepath.Join(siteDir(env.config.Sites.Path, env.site.ID), "deploys", "*"))
+	if len(archives) != DeployQueueSize+1 {
+		t.Errorf("%d archives kept, want %d without the refused one", len(archives), DeployQueueSize+1)
+	}
+
+	// Other sites have a queue of their own
+	other := &models.Site{Name: "other.example.com", OwnerID: env.owner.ID, IsEnabled: true, Type: models.SiteTypeStatic}
+	if err := env.sites.Create(other); err != nil {
+		t.Fatal(err)
+	}
+	buf = siteZip("v1")
+	if _, err := env.service.Enqueue(other.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), DeployOptions{}); err != nil {
+		t.Errorf("Enqueue() for another site error = %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const (
+	// ScheduleInterval is how often the serve process looks for scheduled
+	// deploys that are due.
+	ScheduleInterval = 10 * time.Second
+
+	// MaxScheduleAhead is how far in the future a deploy can be scheduled.
+	MaxScheduleAhead = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidSchedule = errors.New("scheduled time must be in the future and within a year")
+	ErrNotScheduled    = errors.New("deploy is not scheduled")
+)
+
+// SetFreezeWindowService enables the freeze windows of sites. Without it no
+// deploy is ever frozen.
+func (s *DeployService) SetFreezeWindowService(freeze *FreezeWindowService) {
+	s.freeze = freeze
+}
+
+// CheckFreeze returns a *DeployFrozenError if a deploy of the site at the
+// given time falls within one of its freeze windows.
+func (s *DeployService) CheckFreeze(siteID int64, at time.Time) error {
+	if s.freeze == nil {
+		return nil
+	}
+	return s.freeze.Check(siteID, at)
+}
+
+// Schedule saves the archive now and leaves the deploy waiting until at,
+// when the scheduler started by StartScheduler runs it. A deploy scheduled
+// within a freeze window is refused with a *DeployFrozenError unless
+// opts.OverrideFreeze is set. Scheduled deploys go to production and are
+// never refused for a deploy in progress, so opts.Target and opts.Queue are
+// not used.
+func (s *DeployService) Schedule(siteID, userID int64, filename string, archiveReader io.Reader, size int64, at time.Time, opts DeployOptions) (*models.Deploy, error) {
+	if err := checkScheduleTime(at, time.Now()); err != nil {
+		return nil, err
+	}
+
+	frozen := s.CheckFreeze(siteID, at)
+	var frozenErr *DeployFrozenError
+	if frozen != nil && (!opts.OverrideFreeze || !errors.As(frozen, &frozenErr)) {
+		return nil, frozen
+	}
+
+	limits, err := s.checkUpload(siteID, size)
+	if err != nil {
+		return nil, err
+	}
+
+	at = at.UTC()
+	deploy := &models.Deploy{
+		SiteID:   siteID,
+		UserID:   userID,
+		Filename: filename,
+		Status:   models.DeployStatusScheduled,
+		Phase:    models.DeployPhaseSaving,
+		// Only recorded when it made a difference
+		FreezeOverride: frozen != nil,
+		ScheduledAt:    &at,
+		NeedsApproval:  opts.Approval,
+	}
+	if err := s.deployRepo.Create(deploy); err != nil {
+		return nil, fmt.Errorf("create deploy record: %w", err)
+	}
+
+	if _, err := s.storeArchive(deploy, archiveReader, limits.MaxArchiveSize, opts.Signature); err != nil {
+		return deploy, err
+	}
+	s.setPhase(deploy, models.DeployPhaseQueued, 0)
+	return deploy, nil
+}
+
+// checkScheduleTime checks that a deploy can be scheduled at the given time.
+func checkScheduleTime(at, now time.Time) error {
+	if !at.After(now) || at.Sub(now) > MaxScheduleAhead {
+		return ErrInvalidSchedule
+	}
+	return nil
+}
+
+// CancelScheduled calls off a scheduled deploy of the site and removes its
+// archive. A deploy that is no longer scheduled returns ErrNotScheduled.
+func (s *DeployService) CancelScheduled(siteID, deployID int64) (*models.Deploy, error) {
+	deploy, err := s.deployRepo.GetByID(deployID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && deploy.SiteID != siteID) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The scheduler may be releasing it right now, whoever changes the
+	// status first wins
+	ok, err := s.deployRepo.ChangeStatus(deploy.ID, models.DeployStatusScheduled, models.DeployStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return deploy, ErrNotScheduled
+	}
+	deploy.Status = models.DeployStatusCancelled
+
+	if deploy.Archive != "" {
+		if err := os.Remove(s.archivePath(siteID, deploy.Archive)); err != nil && !os.IsNotExist(err) {
+			slog.Error("failed to remove archive of cancelled deploy", "deploy_id", deploy.ID, "error", err)
+		}
+		if err := s.deployRepo.SetArchive(deploy.ID, ""); err != nil {
+			slog.Error("failed to clear archive of cancelled deploy", "deploy_id", deploy.ID, "error", err)
+		}
+		deploy.Archive = ""
+	}
+	return deploy, nil
+}
+
+// StartScheduler releases the scheduled deploys that are due now and then
+// every interval, for as long as the process runs.
+func (s *DeployService) StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.ReleaseScheduled(time.Now()); err != nil {
+				slog.Error("failed to release scheduled deploys", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// ReleaseScheduled hands the scheduled deploys due at now to the workers.
+// A deploy that has come to fall within a freeze window of its site fails,
+// unless an admin overrode the freeze when scheduling it.
+func (s *DeployService) ReleaseScheduled(now time.Time) error {
+	deploys, err := s.deployRepo.ListScheduled()
+	if err != nil {
+		return fmt.Errorf("list scheduled deploys: %w", err)
+	}
+
+	for _, d := range deploys {
+		if d.ScheduledAt == nil || d.ScheduledAt.After(now) {
+			continue
+		}
+
+		ok, err := s.deployRepo.ChangeStatus(d.ID, models.DeployStatusScheduled, models.DeployStatusPending)
+		if err != nil {
+			slog.Error("failed to release scheduled deploy", "deploy_id", d.ID, "error", err)
+			continue
+		}
+		if !ok {
+			// Cancelled meanwhile
+			continue
+		}
+		d.Status = models.DeployStatusPending
+
+		if !d.FreezeOverride {
+			if err := s.CheckFreeze(d.SiteID, now); err != nil {
+				s.fail(d, err)
+				continue
+			}
+		}
+
+		archivePath := s.archivePath(d.SiteID, d.Archive)
+		if _, err := os.Stat(archivePath); d.Archive == "" || err != nil {
+			s.fail(d, errors.New("archive of the scheduled deploy is missing"))
+			continue
+		}
+
+		slog.Info("releasing scheduled deploy", "deploy_id", d.ID, "site_id", d.SiteID)
+		if err := s.dispatch(deployJob{deploy: d, build: s.archiveBuilder(archivePath)}); err != nil {
+			os.Remove(archivePath)
+			s.fail(d, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// schedule schedules a deploy of the site at the given time.
+func (env *deployTestEnv) schedule(t *testing.T, at time.Time, opts DeployOptions) *models.Deploy {
+	t.Helper()
+	buf := siteZip("scheduled")
+	deploy, err := env.service.Schedule(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), at, opts)
+	if err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	return deploy
+}
+
+// wantStatus checks the stored status of a deploy.
+func (env *deployTestEnv) wantStatus(t *testing.T, deploy *models.Deploy, want models.DeployStatus) *models.Deploy {
+	t.Helper()
+	stored, err := env.deploys.GetByID(deploy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != want {
+		t.Errorf("deploy %d status = %s, want %s", deploy.ID, stored.Status, want)
+	}
+	return stored
+}
+
+func TestDeployService_ReleaseScheduled(t *testing.T) {
+	env := newDeployTestEnv(t)
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	due := env.schedule(t, at, DeployOptions{})
+	later := env.schedule(t, at.Add(time.Hour), DeployOptions{})
+
+	if err := env.service.ReleaseScheduled(at.Add(-time.Second)); err != nil {
+		t.Fatalf("ReleaseScheduled() error = %v", err)
+	}
+	env.wantStatus(t, due, models.DeployStatusScheduled)
+	if len(env.service.jobs) != 0 {
+		t.Fatalf("%d deploys released before their time", len(env.service.jobs))
+	}
+
+	if err := env.service.ReleaseScheduled(at); err != nil {
+		t.Fatalf("ReleaseScheduled() error = %v", err)
+	}
+	env.wantStatus(t, due, models.DeployStatusPending)
+	env.wantStatus(t, later, models.DeployStatusScheduled)
+	if len(env.service.jobs) != 1 {
+		t.Fatalf("%d deploys released, want 1", len(env.service.jobs))
+	}
+	if job := <-env.service.jobs; job.deploy.ID != due.ID {
+		t.Errorf("released deploy %d, want %d", job.deploy.ID, due.ID)
+	}
+}
+
+func TestDeployService_ReleaseScheduledFrozen(t *testing.T) {
+	env := newDeployTestEnv(t)
+	freeze := NewFreezeWindowService(repository.NewFreezeWindowRepository(env.db))
+	env.service.SetFreezeWindowService(freeze)
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	// A window set after the deploy was scheduled holds it back
+	frozen := env.schedule(t, at, DeployOptions{})
+	if _, err := freeze.Create(env.site.ID, at.Add(-time.Minute), at.Add(time.Minute), "launch"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := siteZip("scheduled")
+	var frozenErr *DeployFrozenError
+	if _, err := env.service.Schedule(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), at, DeployOptions{}); !errors.As(err, &frozenErr) {
+		t.Errorf("Schedule() within a freeze window error = %v, want *DeployFrozenError", err)
+	}
+	overridden := env.schedule(t, at, DeployOptions{OverrideFreeze: true})
+	if !overridden.FreezeOverride {
+		t.Errorf("deploy scheduled through a freeze window does not record the override")
+	}
+
+	if err := env.service.ReleaseScheduled(at); err != nil {
+		t.Fatalf("ReleaseScheduled() error = %v", err)
+	}
+	if stored := env.wantStatus(t, frozen, models.DeployStatusFailed); stored.ErrorMessage == "" {
+		t.Errorf("frozen deploy failed without a message")
+	}
+	env.wantStatus(t, overridden, models.DeployStatusPending)
+	if len(env.service.jobs) != 1 {
+		t.Fatalf("%d deploys released, want 1", len(env.service.jobs))
+	}
+	if job := <-env.service.jobs; job.deploy.ID != overridden.ID {
+		t.Errorf("released deploy %d, want %d", job.deploy.ID, overridden.ID)
+	}
+}
+
+func TestDeployService_CancelScheduled(t *testing.T) {
+	env := newDeployTestEnv(t)
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	cancelled := env.schedule(t, at, DeployOptions{})
+	released := env.schedule(t, at, DeployOptions{})
+
+	if _, err := env.service.CancelScheduled(env.site.ID+1, cancelled.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("CancelScheduled() on another site error = %v, want %v", err, repository.ErrNotFound)
+	}
+	if _, err := env.service.CancelScheduled(env.site.ID, cancelled.ID); err != nil {
+		t.Fatalf("CancelScheduled() error = %v", err)
+	}
+	if stored := env.wantStatus(t, cancelled, models.DeployStatusCancelled); stored.Archive != "" {
+		t.Errorf("cancelled deploy keeps archive %q", stored.Archive)
+	}
+	if _, err := os.Stat(env.service.archivePath(env.site.ID, cancelled.Archive)); !os.IsNotExist(err) {
+		t.Errorf("archive of cancelled deploy still on disk: %v", err)
+	}
+
+	// The scheduler skips the cancelled deploy, and a deploy it released
+	// can no longer be cancelled
+	if err := env.service.ReleaseScheduled(at); err != nil {
+		t.Fatalf("ReleaseScheduled() error = %v", err)
+	}
+	env.wantStatus(t, cancelled, models.DeployStatusCancelled)
+	if len(env.service.jobs) != 1 {
+		t.Fatalf("%d deploys released, want 1", len(env.service.jobs))
+	}
+
+	if _, err := env.service.CancelScheduled(env.site.ID, released.ID); !errors.Is(err, ErrNotScheduled) {
+		t.Errorf("CancelScheduled() of a released deploy error = %v, want %v", err, ErrNotScheduled)
+	}
+	stored := env.wantStatus(t, released, models.DeployStatusPending)
+	if _, err := os.Stat(env.service.archivePath(env.site.ID, stored.Archive)); stored.Archive == "" || err != nil {
+		t.Errorf("archive of released deploy %q removed: %v", stored.Archive, err)
+	}
+}
@@ -3,15 +3,17 @@ package services
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"micropanel/internal/config"
@@ -20,10 +22,16 @@ import (
 )
 
 const (
+	// Used when the limits config leaves a size unset
 	MaxArchiveSize = 100 * 1024 * 1024 // 100MB
 	MaxFileSize    = 10 * 1024 * 1024  // 10MB per file
-	MaxFiles       = 10000
-	MaxPathLength  = 500
+
+	MaxFiles      = 10000
+	MaxPathLength = 500
+
+	DefaultKeepReleases  = 5
+	DefaultDeployWorkers = 2
+	DeployQueueSize      = 100
 )
 
 var (
@@ -34,129 +42,670 @@ var (
 	ErrSymlinkDetected    = errors.New("symlinks not allowed")
 	ErrFileTooLarge       = errors.New("file too large")
 	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrReleaseNotFound    = errors.New("release not available")
+	ErrNoPreviousRelease  = errors.New("no previous version available")
+	ErrDeployQueueFull    = errors.New("deploy queue is full")
 )
 
 type DeployService struct {
 	config     *config.Config
 	deployRepo *repository.DeployRepository
 	siteRepo   *repository.SiteRepository
+	limits     *LimitsService
+	health     *HealthCheckService
+	sharedRepo *repository.SharedPathRepository
+	settings   *SettingsService
+	keys       *DeployKeyService
+	freeze     *FreezeWindowService
+	s3         *S3CredentialsService
+	staging    *StagingService
+	jobs       chan deployJob
+
+	// Serializes the in-progress check and creation of deploy records
+	createMu sync.Mutex
+
+	// Deploys waiting for an earlier deploy of the same site. A site has an
+	// entry while one of its deploys is queued or running.
+	siteQueueMu sync.Mutex
+	siteQueue   map[int64][]deployJob
+}
+
+// deployJob is a deploy waiting for a worker: a release to build, or a git
+// revision or remote archive to fetch first.
+type deployJob struct {
+	deploy  *models.Deploy
+	build   releaseBuilder
+	git     *gitSource
+	remote  *RemoteSource
+	cleanup func() // run once the deploy is over, if set
+}
+
+// DeployOptions are how a deploy is made, whichever way it is started.
+type DeployOptions struct {
+	// Signature is the detached signature of an uploaded archive, required
+	// when the site has deploy keys. Git and manifest deploys are unsigned.
+	Signature string
+
+	// Queue lets the deploy wait for the deploy of the site in progress
+	// instead of failing with a *DeployInProgressError.
+	Queue bool
+
+	// Target picks the release slot, production when empty. A staging
+	// deploy is served on the preview hostname of the site until it is
+	// promoted.
+	Target models.DeployTarget
+
+	// Approval holds the release once it is built and checked, until an
+	// approver accepts it.
+	Approval bool
+
+	// OverrideFreeze lets a scheduled deploy fall within a freeze window.
+	OverrideFreeze bool
 }
 
+// releaseBuilder fills the directory of a new release. It must respect the
+// budget and report progress as it goes.
+type releaseBuilder func(releasePath string, budget *extractBudget, progress func(done, total int)) error
+
 func NewDeployService(cfg *config.Config, deployRepo *repository.DeployRepository, siteRepo *repository.SiteRepository) *DeployService {
 	return &DeployService{
 		config:     cfg,
 		deployRepo: deployRepo,
 		siteRepo:   siteRepo,
+		jobs:       make(chan deployJob, DeployQueueSize),
+		siteQueue:  make(map[int64][]deployJob),
+	}
+}
+
+func (s *DeployService) SetLimitsService(limits *LimitsService) {
+	s.limits = limits
+}
+
+// SetHealthCheckService enables the health checks run after a release is
+// activated. Without it deploys are not checked.
+func (s *DeployService) SetHealthCheckService(health *HealthCheckService) {
+	s.health = health
+}
+
+// StartWorkers starts the goroutines that process deploys queued by Enqueue.
+func (s *DeployService) StartWorkers(n int) {
+	if n < 1 {
+		n = DefaultDeployWorkers
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range s.jobs {
+				s.runSiteQueue(job)
+			}
+		}()
+	}
+}
+
+// RecoverInterrupted resolves deploys left pending by a restart. A deploy
+// whose release is already current is completed, the others are failed.
+func (s *DeployService) RecoverInterrupted() error {
+	deploys, err := s.deployRepo.ListPending()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deploys {
+		// Manifest deploys keep waiting for their files until they expire
+		if d.Phase == models.DeployPhaseUploading {
+			continue
+		}
+		if current, err := currentRelease(s.sitePath(d.SiteID)); err == nil && current == d.ID {
+			s.complete(d)
+			continue
+		}
+		os.RemoveAll(s.releasePath(d.SiteID, d.ID))
+		os.RemoveAll(s.gitWorkPath(d.SiteID, d.ID))
+		s.discardManifest(d)
+		s.fail(d, errors.New("interrupted by restart"))
+	}
+	return nil
+}
+
+// Deploy saves the archive and deploys it as opts says, returning once the
+// release is active, held for approval, or the deploy has failed.
+func (s *DeployService) Deploy(siteID, userID int64, filename string, archiveReader io.Reader, size int64, opts DeployOptions) (*models.Deploy, error) {
+	deploy, archivePath, err := s.save(siteID, userID, filename, archiveReader, size, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	return deploy, s.run(deploy, s.archiveBuilder(archivePath))
+}
+
+// Enqueue saves the archive and hands the deploy to the workers. The returned
+// deploy is pending; its phase and progress can be polled with GetDeploy.
+func (s *DeployService) Enqueue(siteID, userID int64, filename string, archiveReader io.Reader, size int64, opts DeployOptions) (*models.Deploy, error) {
+	deploy, archivePath, err := s.save(siteID, userID, filename, archiveReader, size, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	s.setPhase(deploy, models.DeployPhaseQueued, 0)
+
+	// The worker gets its own copy, the caller keeps reading deploy
+	queued := *deploy
+	if err := s.dispatch(deployJob{deploy: &queued, build: s.archiveBuilder(archivePath)}); err != nil {
+		os.Remove(archivePath)
+		s.fail(deploy, err)
+		return deploy, err
 	}
+
+	return deploy, nil
+}
+
+// dispatch hands a job to the workers, or parks it behind the deploy of the
+// same site that is already queued or running.
+func (s *DeployService) dispatch(job deployJob) error {
+	siteID := job.deploy.SiteID
+
+	s.siteQueueMu.Lock()
+	defer s.siteQueueMu.Unlock()
+
+	if waiting, busy := s.siteQueue[siteID]; busy {
+		if len(waiting) >= DeployQueueSize {
+			return ErrDeployQueueFull
+		}
+		s.siteQueue[siteID] = append(waiting, job)
+		return nil
+	}
+
+	select {
+	case s.jobs <- job:
+		s.siteQueue[siteID] = nil
+		return nil
+	default:
+		return ErrDeployQueueFull
+	}
+}
+
+// runSiteQueue runs a job and then the deploys queued behind it for the
+// same site, in order.
+func (s *DeployService) runSiteQueue(job deployJob) {
+	for {
+		s.runJob(job)
+
+		next, ok := s.nextForSite(job.deploy.SiteID)
+		if !ok {
+			return
+		}
+		job = next
+	}
+}
+
+// runJob fetches the revision of a git job and runs the deploy.
+func (s *DeployService) runJob(job deployJob) {
+	if job.cleanup != nil {
+		defer job.cleanup()
+	}
+
+	build := job.build
+	if job.git != nil {
+		archivePath, err := s.fetchGit(job.deploy, job.git)
+		if err != nil {
+			s.fail(job.deploy, err)
+			return
+		}
+		build = s.archiveBuilder(archivePath)
+	}
+	if job.remote != nil {
+		archivePath, err := s.fetchRemote(job.deploy, job.remote)
+		if err != nil {
+			s.fail(job.deploy, err)
+			return
+		}
+		build = s.archiveBuilder(archivePath)
+	}
+	s.run(job.deploy, build)
+}
+
+// nextForSite pops the next deploy waiting for the site, or marks the site
+// idle when there is none.
+func (s *DeployService) nextForSite(siteID int64) (deployJob, bool) {
+	s.siteQueueMu.Lock()
+	defer s.siteQueueMu.Unlock()
+
+	waiting := s.siteQueue[siteID]
+	if len(waiting) == 0 {
+		delete(s.siteQueue, siteID)
+		return deployJob{}, false
+	}
+	s.siteQueue[siteID] = waiting[1:]
+	return waiting[0], true
 }
 
-func (s *DeployService) Deploy(siteID, userID int64, filename string, archiveReader io.Reader, size int64) (*models.Deploy, error) {
+// save creates the deploy record and stores the archive in the deploys directory.
+func (s *DeployService) save(siteID, userID int64, filename string, archiveReader io.Reader, size int64, opts DeployOptions) (*models.Deploy, string, error) {
+	limits, err := s.checkUpload(siteID, size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	deploy, err := s.create(siteID, userID, filename, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	archivePath, err := s.storeArchive(deploy, archiveReader, limits.MaxArchiveSize, opts.Signature)
+	if err != nil {
+		return deploy, "", err
+	}
+	return deploy, archivePath, nil
+}
+
+// checkUpload checks the announced size of an upload against the limits of
+// the site, which it returns.
+func (s *DeployService) checkUpload(siteID int64, size int64) (*models.Limits, error) {
+	limits := s.siteLimits(siteID)
+
 	// Check size
-	if size > MaxArchiveSize {
+	if size > limits.MaxArchiveSize {
 		return nil, ErrArchiveTooLarge
 	}
+	if err := checkQuota(s.config.Sites.Path, siteID, limits, size); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
 
-	// Create deploy record
-	deploy := &models.Deploy{
-		SiteID:   siteID,
-		UserID:   userID,
-		Filename: filename,
-		Status:   models.DeployStatusPending,
+// storeArchive saves the archive of a new deploy and checks it against the
+// deploy policy, failing the deploy if either goes wrong.
+func (s *DeployService) storeArchive(deploy *models.Deploy, archiveReader io.Reader, maxSize int64, signature string) (string, error) {
+	archivePath, err := s.saveArchive(deploy, archiveReader, maxSize, signature)
+	if err != nil {
+		s.fail(deploy, err)
+		return "", err
 	}
-	if err := s.deployRepo.Create(deploy); err != nil {
-		return nil, fmt.Errorf("create deploy record: %w", err)
+
+	if err := s.checkArchivePolicy(archivePath); err != nil {
+		os.Remove(archivePath)
+		s.fail(deploy, err)
+		return "", err
 	}
+	return archivePath, nil
+}
 
-	// Process deploy
-	if err := s.processDeploy(deploy, archiveReader, size); err != nil {
-		s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusFailed, err.Error())
-		deploy.Status = models.DeployStatusFailed
-		deploy.ErrorMessage = err.Error()
-		return deploy, err
+// create adds the pending deploy record, refusing it while another deploy of
+// the site is in progress unless opts.Queue is set.
+func (s *DeployService) create(siteID, userID int64, filename string, opts DeployOptions) (*models.Deploy, error) {
+	target := opts.Target
+	if target == "" {
+		target = models.DeployTargetProduction
+	}
+	if target == models.DeployTargetStaging {
+		if err := s.checkStaging(siteID); err != nil {
+			return nil, err
+		}
 	}
 
-	s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusSuccess, "")
-	deploy.Status = models.DeployStatusSuccess
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	s.expireManifests(siteID)
+
+	if !opts.Queue {
+		if err := s.checkInProgress(siteID); err != nil {
+			return nil, err
+		}
+	}
+
+	deploy := &models.Deploy{
+		SiteID:        siteID,
+		UserID:        userID,
+		Filename:      filename,
+		Status:        models.DeployStatusPending,
+		Phase:         models.DeployPhaseSaving,
+		Target:        target,
+		NeedsApproval: opts.Approval,
+	}
+	if err := s.deployRepo.Create(deploy); err != nil {
+		return nil, fmt.Errorf("create deploy record: %w", err)
+	}
 	return deploy, nil
 }
 
-func (s *DeployService) processDeploy(deploy *models.Deploy, archiveReader io.Reader, size int64) error {
-	sitePath := filepath.Join(s.config.Sites.Path, fmt.Sprintf("%d", deploy.SiteID))
-	publicPath := filepath.Join(sitePath, "public")
-	publicNewPath := filepath.Join(sitePath, "public_new")
-	publicPrevPath := filepath.Join(sitePath, "public_prev")
-	deploysPath := filepath.Join(sitePath, "deploys")
+// checkInProgress returns a *DeployInProgressError if the site has a deploy
+// queued or running.
+func (s *DeployService) checkInProgress(siteID int64) error {
+	pending, err := s.deployRepo.GetPending(siteID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check deploys in progress: %w", err)
+	}
+	return &DeployInProgressError{DeployID: pending.ID}
+}
 
-	// Ensure directories exist
-	for _, dir := range []string{sitePath, deploysPath} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("create directory: %w", err)
+// lock takes the site lock without waiting. When it is held by a deploy the
+// error says which one.
+func (s *DeployService) lock(siteID int64) (func(), error) {
+	unlock, err := lockSite(s.config.Sites.Path, siteID, false)
+	if errors.Is(err, ErrSiteBusy) {
+		if inProgress := s.checkInProgress(siteID); inProgress != nil {
+			return nil, inProgress
 		}
 	}
+	return unlock, err
+}
+
+// saveArchive writes an uploaded archive to the deploys directory and checks
+// its signature before anything else reads it.
+func (s *DeployService) saveArchive(deploy *models.Deploy, archiveReader io.Reader, maxSize int64, signature string) (string, error) {
+	deploysPath := filepath.Join(s.sitePath(deploy.SiteID), "deploys")
+	if err := os.MkdirAll(deploysPath, 0755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
 
-	// Save archive to deploys directory
-	archiveFilename := fmt.Sprintf("%d_%s", time.Now().Unix(), deploy.Filename)
+	// Save archive to deploys directory, named after the deploy so that
+	// uploads of the same file never share one
+	archiveFilename := fmt.Sprintf("%d_%d_%s", time.Now().Unix(), deploy.ID, deploy.Filename)
 	archivePath := filepath.Join(deploysPath, archiveFilename)
+	if err := s.recordArchive(deploy, archiveFilename); err != nil {
+		return "", err
+	}
 
 	archiveFile, err := os.Create(archivePath)
 	if err != nil {
-		return fmt.Errorf("create archive file: %w", err)
+		return "", fmt.Errorf("create archive file: %w", err)
 	}
 
-	written, err := io.Copy(archiveFile, io.LimitReader(archiveReader, MaxArchiveSize+1))
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(archiveFile, hash), io.LimitReader(archiveReader, maxSize+1))
 	archiveFile.Close()
 	if err != nil {
 		os.Remove(archivePath)
-		return fmt.Errorf("save archive file: %w", err)
+		return "", fmt.Errorf("save archive file: %w", err)
 	}
-	if written > MaxArchiveSize {
+	if written > maxSize {
 		os.Remove(archivePath)
-		return ErrArchiveTooLarge
+		return "", ErrArchiveTooLarge
 	}
 
-	// Clean up public_new if exists
-	os.RemoveAll(publicNewPath)
+	if err := s.verifyArchive(deploy, hash.Sum(nil), signature); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
 
-	// Extract archive based on file extension
-	var extractErr error
-	if s.isTarGz(deploy.Filename) {
-		extractErr = s.extractTarGz(archivePath, publicNewPath)
-	} else if s.isZip(deploy.Filename) {
-		extractErr = s.extractZip(archivePath, publicNewPath)
-	} else {
+	// The format comes from the content, whatever the file is called
+	format, err := sniffArchive(archivePath)
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+	if format == archiveUnknown {
 		os.Remove(archivePath)
-		return ErrUnsupportedArchive
+		return "", ErrUnsupportedArchive
 	}
 
-	if extractErr != nil {
-		os.RemoveAll(publicNewPath)
-		return fmt.Errorf("extract archive: %w", extractErr)
+	return archivePath, nil
+}
+
+// run builds and activates a release and records the outcome.
+func (s *DeployService) run(deploy *models.Deploy, build releaseBuilder) error {
+	// Deploys of the same site run one after another
+	unlock, err := lockSite(s.config.Sites.Path, deploy.SiteID, true)
+	if err != nil {
+		s.fail(deploy, err)
+		return err
 	}
+	defer unlock()
 
-	// Atomic swap
-	// 1. Remove old public_prev
-	os.RemoveAll(publicPrevPath)
+	if err := s.processDeploy(deploy, build); err != nil {
+		s.fail(deploy, err)
+		return err
+	}
 
-	// 2. Move current public to public_prev (if exists)
-	if _, err := os.Stat(publicPath); err == nil {
-		if err := os.Rename(publicPath, publicPrevPath); err != nil {
-			os.RemoveAll(publicNewPath)
-			return fmt.Errorf("backup current public: %w", err)
+	if deploy.NeedsApproval {
+		s.hold(deploy)
+		return nil
+	}
+
+	s.complete(deploy)
+	s.pruneReleases(deploy.SiteID)
+
+	return nil
+}
+
+func (s *DeployService) processDeploy(deploy *models.Deploy, build releaseBuilder) error {
+	releasePath := s.releasePath(deploy.SiteID, deploy.ID)
+
+	// Sites still served from public/ switch layout before their first release
+	if _, err := s.MigrateLayout(deploy.SiteID); err != nil {
+		return fmt.Errorf("migrate site layout: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(releasePath), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	s.setPhase(deploy, models.DeployPhaseExtracting, 0)
+
+	// Clean up leftovers of an earlier attempt with the same ID
+	os.RemoveAll(releasePath)
+
+	budget, err := s.extractBudget(deploy.SiteID)
+	if err != nil {
+		return err
+	}
+
+	if err := build(releasePath, budget, s.progressReporter(deploy)); err != nil {
+		os.RemoveAll(releasePath)
+		return err
+	}
+
+	if err := s.prepareRelease(deploy.SiteID, releasePath); err != nil {
+		os.RemoveAll(releasePath)
+		return err
+	}
+
+	s.precompress(deploy, releasePath)
+
+	// The release waits on disk until an approver lets it go live
+	if deploy.NeedsApproval {
+		return nil
+	}
+
+	return s.goLive(deploy)
+}
+
+// goLive activates the built release of a deploy, or stages it for a
+// staging deploy. A release that fails the health checks is removed and the
+// previous one restored.
+func (s *DeployService) goLive(deploy *models.Deploy) error {
+	releasePath := s.releasePath(deploy.SiteID, deploy.ID)
+
+	s.setPhase(deploy, models.DeployPhaseActivating, 100)
+
+	// Staging releases are only checked by whoever previews them
+	if deploy.IsStaging() {
+		if err := s.stageRelease(deploy.SiteID, deploy.ID); err != nil {
+			os.RemoveAll(releasePath)
+			return err
 		}
+		return nil
 	}
 
-	// 3. Move public_new to public
-	if err := os.Rename(publicNewPath, publicPath); err != nil {
-		// Try to restore
-		if _, err2 := os.Stat(publicPrevPath); err2 == nil {
-			os.Rename(publicPrevPath, publicPath)
+	// Kept to go back to if the health checks fail
+	previous, prevErr := currentRelease(s.sitePath(deploy.SiteID))
+
+	if err := s.activateRelease(deploy.SiteID, deploy.ID); err != nil {
+		os.RemoveAll(releasePath)
+		return err
+	}
+
+	if s.health != nil {
+		s.setPhase(deploy, models.DeployPhaseChecking, 100)
+
+		if err := s.health.Run(deploy.SiteID); err != nil {
+			if prevErr == nil {
+				if restoreErr := s.activateRelease(deploy.SiteID, previous); restoreErr != nil {
+					return fmt.Errorf("%w; rollback failed: %v", err, restoreErr)
+				}
+			} else {
+				// The site had no release before, it goes back to having none
+				os.Remove(siteCurrentPath(s.config.Sites.Path, deploy.SiteID))
+			}
+			os.RemoveAll(releasePath)
+			return fmt.Errorf("%w; rolled back", err)
 		}
-		return fmt.Errorf("activate new public: %w", err)
+	}
+
+	return nil
+}
+
+// prepareRelease applies the ignore file of a built release and checks it
+// against the deploy policy and the shared paths of the site.
+func (s *DeployService) prepareRelease(siteID int64, releasePath string) error {
+	if err := applyIgnoreFile(releasePath); err != nil {
+		return fmt.Errorf("apply %s: %w", IgnoreFileName, err)
+	}
+	if err := s.checkReleasePolicy(releasePath); err != nil {
+		return err
+	}
+	return s.checkSharedPaths(siteID, releasePath)
+}
+
+// archiveBuilder builds a release by extracting an archive, picking the
+// format from its first bytes.
+func (s *DeployService) archiveBuilder(archivePath string) releaseBuilder {
+	return func(releasePath string, budget *extractBudget, progress func(done, total int)) error {
+		format, err := sniffArchive(archivePath)
+		switch {
+		case err != nil:
+		case format == archiveZip:
+			err = s.extractZip(archivePath, releasePath, budget, progress)
+		case format == archiveUnknown:
+			err = ErrUnsupportedArchive
+		default:
+			err = s.extractTar(archivePath, format, releasePath, budget, progress)
+		}
+		if err != nil {
+			return fmt.Errorf("extract archive: %w", err)
+		}
+		return nil
+	}
+}
+
+// siteLimits returns the limits in effect for a site.
+func (s *DeployService) siteLimits(siteID int64) *models.Limits {
+	return resolveLimits(s.limits, s.config, siteID)
+}
+
+// extractBudget returns the limits an extraction into the site must respect.
+func (s *DeployService) extractBudget(siteID int64) (*extractBudget, error) {
+	limits := s.siteLimits(siteID)
+	remaining, err := quotaRemaining(s.config.Sites.Path, siteID, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &extractBudget{maxFileSize: limits.MaxFileSize, remaining: remaining}, nil
+}
+
+// extractBudget bounds the files an extraction may write.
+type extractBudget struct {
+	maxFileSize int64
+	remaining   int64 // bytes left under the disk quota, -1 = no quota
+}
+
+// take reserves room for a file of the given size.
+func (b *extractBudget) take(name string, size int64) error {
+	if size > b.maxFileSize {
+		return fmt.Errorf("%w: %s (%d bytes)", ErrFileTooLarge, name, size)
+	}
+	if b.remaining >= 0 {
+		if size > b.remaining {
+			return fmt.Errorf("%w: %s does not fit", ErrQuotaExceeded, name)
+		}
+		b.remaining -= size
+	}
+	return nil
+}
+
+// complete records a deploy whose release has been activated.
+func (s *DeployService) complete(deploy *models.Deploy) {
+	s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusSuccess, "")
+	deploy.Status = models.DeployStatusSuccess
+	s.setPhase(deploy, models.DeployPhaseDone, 100)
+
+	if err := s.deployRepo.SetHasRelease(deploy.ID, true); err != nil {
+		slog.Error("failed to record release", "deploy_id", deploy.ID, "error", err)
+	}
+	deploy.HasRelease = true
+
+	if deploy.IsStaging() {
+		if err := s.deployRepo.SetStaged(deploy.SiteID, deploy.ID); err != nil {
+			slog.Error("failed to mark release staged", "deploy_id", deploy.ID, "error", err)
+		}
+		deploy.IsStaged = true
+		return
+	}
+	if err := s.deployRepo.SetActive(deploy.SiteID, deploy.ID); err != nil {
+		slog.Error("failed to mark release active", "deploy_id", deploy.ID, "error", err)
+	}
+	deploy.IsActive = true
+}
+
+// fail records a deploy as failed. The phase it failed in is kept.
+func (s *DeployService) fail(deploy *models.Deploy, err error) {
+	s.deployRepo.UpdateStatus(deploy.ID, models.DeployStatusFailed, err.Error())
+	deploy.Status = models.DeployStatusFailed
+	deploy.ErrorMessage = err.Error()
+
+	var policyErr *PolicyViolationError
+	if errors.As(err, &policyErr) {
+		if err := s.deployRepo.SetViolations(deploy.ID, policyErr.Violations); err != nil {
+			slog.Error("failed to record policy violations", "deploy_id", deploy.ID, "error", err)
+		}
+		deploy.Violations = policyErr.Violations
+	}
+
+	slog.Warn("deploy failed", "deploy_id", deploy.ID, "site_id", deploy.SiteID, "phase", deploy.Phase, "error", err)
+}
+
+func (s *DeployService) setPhase(deploy *models.Deploy, phase models.DeployPhase, progress int) {
+	deploy.Phase = phase
+	deploy.Progress = progress
+	if err := s.deployRepo.UpdatePhase(deploy.ID, phase, progress); err != nil {
+		slog.Error("failed to record deploy phase", "deploy_id", deploy.ID, "phase", phase, "error", err)
+	}
+}
+
+// progressReporter returns an extraction callback that records progress in
+// steps of 5% to keep database writes down.
+func (s *DeployService) progressReporter(deploy *models.Deploy) func(done, total int) {
+	return func(done, total int) {
+		if total <= 0 {
+			return
+		}
+		progress := done * 100 / total
+		if progress >= deploy.Progress+5 || (done == total && progress != deploy.Progress) {
+			s.setPhase(deploy, deploy.Phase, progress)
+		}
+	}
+}
+
+// activateRelease points the current symlink of a site at the given release.
+func (s *DeployService) activateRelease(siteID, releaseID int64) error {
+	releasePath := s.releasePath(siteID, releaseID)
+	sharedPaths, err := s.SharedPaths(siteID)
+	if err != nil {
+		return fmt.Errorf("load shared paths: %w", err)
+	}
+	if err := s.linkSharedPaths(siteID, releasePath, sharedPaths); err != nil {
+		return fmt.Errorf("link shared paths: %w", err)
 	}
 
 	// Chown to configured user/group
-	s.chownPath(publicPath)
+	s.chownPath(releasePath)
 
+	if err := switchRelease(s.sitePath(siteID), releaseID); err != nil {
+		return fmt.Errorf("activate release: %w", err)
+	}
 	return nil
 }
 
@@ -199,7 +748,7 @@ func (s *DeployService) getSiteOwnership() (uid, gid int, err error) {
 	return uid, gid, nil
 }
 
-func (s *DeployService) extractZip(zipPath, destPath string) error {
+func (s *DeployService) extractZip(zipPath, destPath string, budget *extractBudget, progress func(done, total int)) error {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return fmt.Errorf("open zip: %w", err)
@@ -218,40 +767,56 @@ func (s *DeployService) extractZip(zipPath, destPath string) error {
 	// Check for common root directory in ZIP
 	commonRoot := s.findCommonRoot(reader.File)
 
-	for _, file := range reader.File {
-		if err := s.extractFile(file, destPath, commonRoot); err != nil {
+	for i, file := range reader.File {
+		if err := s.extractFile(file, destPath, commonRoot, budget); err != nil {
 			return err
 		}
+		progress(i+1, len(reader.File))
 	}
 
 	return nil
 }
 
 func (s *DeployService) findCommonRoot(files []*zip.File) string {
-	if len(files) == 0 {
-		return ""
+	var root commonRoot
+	for _, file := range files {
+		root.add(file.Name, file.FileInfo().IsDir())
 	}
+	return root.get()
+}
 
-	// Check if all files start with the same directory
-	var commonRoot string
-	for _, file := range files {
-		name := file.Name
-		if idx := strings.Index(name, "/"); idx > 0 {
-			root := name[:idx+1]
-			if commonRoot == "" {
-				commonRoot = root
-			} else if commonRoot != root {
-				return "" // No common root
-			}
-		} else if !file.FileInfo().IsDir() {
-			return "" // File at root level
+// commonRoot finds the directory all entries of an archive are in, so that
+// archives of a dist/ folder deploy its contents. Entries are added in
+// archive order.
+type commonRoot struct {
+	root  string
+	mixed bool // entries outside a single top-level directory
+}
+
+func (r *commonRoot) add(name string, isDir bool) {
+	if r.mixed {
+		return
+	}
+	if idx := strings.Index(name, "/"); idx > 0 {
+		root := name[:idx+1]
+		if r.root == "" {
+			r.root = root
+		} else if r.root != root {
+			r.mixed = true // No common root
 		}
+	} else if !isDir {
+		r.mixed = true // File at root level
 	}
+}
 
-	return commonRoot
+func (r *commonRoot) get() string {
+	if r.mixed {
+		return ""
+	}
+	return r.root
 }
 
-func (s *DeployService) extractFile(file *zip.File, destPath, commonRoot string) error {
+func (s *DeployService) extractFile(file *zip.File, destPath, commonRoot string, budget *extractBudget) error {
 	// Get relative path, stripping common root if present
 	name := file.Name
 	if commonRoot != "" && strings.HasPrefix(name, commonRoot) {
@@ -286,9 +851,12 @@ func (s *DeployService) extractFile(file *zip.File, destPath, commonRoot string)
 	}
 
 	// Check file size
-	if file.UncompressedSize64 > MaxFileSize {
+	if file.UncompressedSize64 > uint64(budget.maxFileSize) {
 		return fmt.Errorf("%w: %s (%d bytes)", ErrFileTooLarge, name, file.UncompressedSize64)
 	}
+	if err := budget.take(name, int64(file.UncompressedSize64)); err != nil {
+		return err
+	}
 
 	// Create parent directory
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
@@ -309,7 +877,7 @@ func (s *DeployService) extractFile(file *zip.File, destPath, commonRoot string)
 	defer srcFile.Close()
 
 	// Copy with size limit
-	_, err = io.Copy(destFile, io.LimitReader(srcFile, MaxFileSize+1))
+	_, err = io.Copy(destFile, io.LimitReader(srcFile, budget.maxFileSize+1))
 	return err
 }
 
@@ -343,40 +911,38 @@ func (s *DeployService) validatePath(name string) error {
 	return nil
 }
 
-func (s *DeployService) isZip(filename string) bool {
-	return strings.HasSuffix(strings.ToLower(filename), ".zip")
-}
-
-func (s *DeployService) isTarGz(filename string) bool {
-	lower := strings.ToLower(filename)
-	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
-}
-
-func (s *DeployService) extractTarGz(archivePath, destPath string) error {
+// extractTar extracts a tar archive, compressed in the given format, in a
+// single pass. Entries keep their full names until the end, when the common
+// root, if there is one, is moved up to become the release.
+func (s *DeployService) extractTar(archivePath string, format archiveFormat, destPath string, budget *extractBudget, progress func(done, total int)) error {
 	file, err := os.Open(archivePath)
 	if err != nil {
 		return fmt.Errorf("open archive: %w", err)
 	}
 	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("create gzip reader: %w", err)
+		return fmt.Errorf("stat archive: %w", err)
 	}
-	defer gzReader.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	// Progress follows the compressed bytes read, the entry count is unknown
+	counter := &countingReader{r: file}
+	stream, closeStream, err := decompress(counter, format)
+	if err != nil {
+		return err
+	}
+	defer closeStream()
+
+	tarReader := tar.NewReader(stream)
 
 	// Create destination directory
 	if err := os.MkdirAll(destPath, 0755); err != nil {
 		return err
 	}
 
-	// First pass: collect all entries to find common root and count files
-	var entries []tarEntry
-	fileCount := 0
-
-	for {
+	var root commonRoot
+	for fileCount := 1; ; fileCount++ {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -385,78 +951,43 @@ func (s *DeployService) extractTarGz(archivePath, destPath string) error {
 			return fmt.Errorf("read tar header: %w", err)
 		}
 
-		fileCount++
 		if fileCount > MaxFiles {
 			return ErrTooManyFiles
 		}
 
-		entries = append(entries, tarEntry{name: header.Name, header: header})
-	}
-
-	// Find common root
-	commonRoot := s.findCommonRootTar(entries)
-
-	// Reopen archive for extraction
-	file.Seek(0, 0)
-	gzReader2, err := gzip.NewReader(file)
-	if err != nil {
-		return fmt.Errorf("reopen gzip: %w", err)
-	}
-	defer gzReader2.Close()
-
-	tarReader2 := tar.NewReader(gzReader2)
-
-	for {
-		header, err := tarReader2.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("read tar header: %w", err)
-		}
-
-		if err := s.extractTarEntry(tarReader2, header, destPath, commonRoot); err != nil {
+		root.add(header.Name, header.Typeflag == tar.TypeDir)
+		if err := s.extractTarEntry(tarReader, header, destPath, budget); err != nil {
 			return err
 		}
+		progress(int(counter.n), int(info.Size()))
 	}
 
-	return nil
-}
+	if err := hoistRoot(destPath, root.get()); err != nil {
+		return fmt.Errorf("strip common root: %w", err)
+	}
+	progress(int(info.Size()), int(info.Size()))
 
-type tarEntry struct {
-	name   string
-	header *tar.Header
+	return nil
 }
 
-func (s *DeployService) findCommonRootTar(entries []tarEntry) string {
-	if len(entries) == 0 {
-		return ""
+// hoistRoot replaces destPath with its subdirectory root.
+func hoistRoot(destPath, root string) error {
+	if root == "" || filepath.Clean(root) == "." {
+		return nil
 	}
 
-	var commonRoot string
-	for _, entry := range entries {
-		name := entry.name
-		if idx := strings.Index(name, "/"); idx > 0 {
-			root := name[:idx+1]
-			if commonRoot == "" {
-				commonRoot = root
-			} else if commonRoot != root {
-				return ""
-			}
-		} else if entry.header.Typeflag != tar.TypeDir {
-			return ""
-		}
-	}
+	tmpPath := destPath + ".extract"
+	os.RemoveAll(tmpPath)
+	defer os.RemoveAll(tmpPath)
 
-	return commonRoot
+	if err := os.Rename(destPath, tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpPath, root), destPath)
 }
 
-func (s *DeployService) extractTarEntry(reader *tar.Reader, header *tar.Header, destPath, commonRoot string) error {
+func (s *DeployService) extractTarEntry(reader *tar.Reader, header *tar.Header, destPath string, budget *extractBudget) error {
 	name := header.Name
-	if commonRoot != "" && strings.HasPrefix(name, commonRoot) {
-		name = strings.TrimPrefix(name, commonRoot)
-	}
-
 	if name == "" {
 		return nil
 	}
@@ -480,8 +1011,8 @@ func (s *DeployService) extractTarEntry(reader *tar.Reader, header *tar.Header,
 	case tar.TypeDir:
 		return os.MkdirAll(fullPath, 0755)
 	case tar.TypeReg:
-		if header.Size > MaxFileSize {
-			return fmt.Errorf("%w: %s (%d bytes)", ErrFileTooLarge, name, header.Size)
+		if err := budget.take(name, header.Size); err != nil {
+			return err
 		}
 
 		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
@@ -494,54 +1025,243 @@ func (s *DeployService) extractTarEntry(reader *tar.Reader, header *tar.Header,
 		}
 		defer destFile.Close()
 
-		_, err = io.Copy(destFile, io.LimitReader(reader, MaxFileSize+1))
+		_, err = io.Copy(destFile, io.LimitReader(reader, budget.maxFileSize+1))
 		return err
 	}
 
 	return nil
 }
 
+// Rollback activates the release deployed before the current one. Sites that
+// have a single release can go back to their initial content, if it is kept.
 func (s *DeployService) Rollback(siteID int64) error {
-	sitePath := filepath.Join(s.config.Sites.Path, fmt.Sprintf("%d", siteID))
-	publicPath := filepath.Join(sitePath, "public")
-	publicPrevPath := filepath.Join(sitePath, "public_prev")
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	prev, err := s.previousRelease(siteID)
+	if err == nil {
+		_, err = s.rollbackTo(siteID, prev.ID)
+		return err
+	}
+
+	if !s.canRestoreInitial(siteID) {
+		return ErrNoPreviousRelease
+	}
+
+	if err := s.activateRelease(siteID, InitialReleaseID); err != nil {
+		return err
+	}
+
+	// The initial release does not belong to any deploy
+	return s.deployRepo.SetActive(siteID, 0)
+}
 
-	// Check if previous version exists
-	if _, err := os.Stat(publicPrevPath); os.IsNotExist(err) {
-		return errors.New("no previous version available")
+// RollbackTo activates the release of the given deploy.
+func (s *DeployService) RollbackTo(siteID, deployID int64) (*models.Deploy, error) {
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
+
+	return s.rollbackTo(siteID, deployID)
+}
 
-	// Swap: public <-> public_prev
-	tempPath := filepath.Join(sitePath, "public_temp")
+func (s *DeployService) rollbackTo(siteID, deployID int64) (*models.Deploy, error) {
+	deploy, err := s.deployRepo.GetByID(deployID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, err
+	}
+	if deploy.SiteID != siteID || deploy.Status != models.DeployStatusSuccess || deploy.IsStaging() {
+		return nil, ErrReleaseNotFound
+	}
 
-	// Move current to temp
-	if err := os.Rename(publicPath, tempPath); err != nil {
-		return fmt.Errorf("move current to temp: %w", err)
+	releasePath := s.releasePath(siteID, deployID)
+	if _, err := os.Stat(releasePath); err != nil || !deploy.HasRelease {
+		if deploy.HasRelease {
+			// Release directory vanished from disk, keep the DB in sync
+			s.deployRepo.SetHasRelease(deployID, false)
+		}
+		// A pruned release is rebuilt from the archive, while it is kept
+		if err := s.restoreRelease(deploy); err != nil {
+			return nil, err
+		}
 	}
 
-	// Move prev to current
-	if err := os.Rename(publicPrevPath, publicPath); err != nil {
-		// Restore
-		os.Rename(tempPath, publicPath)
-		return fmt.Errorf("restore previous: %w", err)
+	if err := s.activateRelease(siteID, deployID); err != nil {
+		return nil, err
 	}
 
-	// Move temp to prev
-	if err := os.Rename(tempPath, publicPrevPath); err != nil {
-		// This is not critical, just log it
-		os.RemoveAll(tempPath)
+	if err := s.deployRepo.SetActive(siteID, deployID); err != nil {
+		return nil, fmt.Errorf("mark release active: %w", err)
 	}
+	deploy.IsActive = true
 
-	return nil
+	return deploy, nil
+}
+
+// canRestoreInitial reports whether the initial release of a site can be
+// activated by Rollback: it must exist and not be current, and the site must
+// not have another release to go back to.
+func (s *DeployService) canRestoreInitial(siteID int64) bool {
+	releases, err := s.deployRepo.ListReleases(siteID)
+	if err != nil || len(releases) > 1 {
+		return false
+	}
+	if current, err := currentRelease(s.sitePath(siteID)); err != nil || current == InitialReleaseID {
+		return false
+	}
+	_, err = os.Stat(s.releasePath(siteID, InitialReleaseID))
+	return err == nil
+}
+
+// previousRelease returns the newest restorable release older than the active one.
+func (s *DeployService) previousRelease(siteID int64) (*models.Deploy, error) {
+	releases, err := s.deployRepo.ListReleases(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	foundActive := false
+	for _, d := range releases {
+		if d.IsActive {
+			foundActive = true
+			continue
+		}
+		if foundActive && d.Status == models.DeployStatusSuccess && !d.IsStaging() {
+			return d, nil
+		}
+	}
+
+	return nil, ErrNoPreviousRelease
+}
+
+// pruneReleases removes release directories beyond the retention count of the site.
+func (s *DeployService) pruneReleases(siteID int64) {
+	releases, err := s.deployRepo.ListReleases(siteID)
+	if err != nil {
+		slog.Error("failed to list releases", "site_id", siteID, "error", err)
+		return
+	}
+
+	for _, d := range releasesToPrune(releases, s.keepReleases(siteID)) {
+		if err := os.RemoveAll(s.releasePath(siteID, d.ID)); err != nil {
+			slog.Error("failed to remove release", "site_id", siteID, "deploy_id", d.ID, "error", err)
+			continue
+		}
+		s.deployRepo.SetHasRelease(d.ID, false)
+	}
+
+	// Once the site has as many deploys as it keeps, the initial release is
+	// no longer needed for rollback
+	if len(releases) >= s.keepReleases(siteID) {
+		if current, err := currentRelease(s.sitePath(siteID)); err == nil && current != InitialReleaseID {
+			os.RemoveAll(s.releasePath(siteID, InitialReleaseID))
+		}
+	}
+}
+
+// releasesToPrune picks releases (ordered newest first) exceeding keep.
+// The active release always counts towards keep and is never pruned, nor are
+// the staged one and those awaiting approval.
+func releasesToPrune(releases []*models.Deploy, keep int) []*models.Deploy {
+	if keep < 1 {
+		keep = 1
+	}
+
+	kept := 0
+	for _, d := range releases {
+		if d.IsActive {
+			kept++
+			break
+		}
+	}
+
+	var prune []*models.Deploy
+	for _, d := range releases {
+		if d.IsActive || d.IsStaged || d.IsAwaitingApproval() {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		prune = append(prune, d)
+	}
+	return prune
+}
+
+func (s *DeployService) keepReleases(siteID int64) int {
+	if site, err := s.siteRepo.GetByID(siteID); err == nil && site.KeepReleases > 0 {
+		return site.KeepReleases
+	}
+	if s.config.Sites.KeepReleases > 0 {
+		return s.config.Sites.KeepReleases
+	}
+	return DefaultKeepReleases
+}
+
+func (s *DeployService) sitePath(siteID int64) string {
+	return siteDir(s.config.Sites.Path, siteID)
+}
+
+func (s *DeployService) releasePath(siteID, releaseID int64) string {
+	return siteReleasePath(s.config.Sites.Path, siteID, releaseID)
 }
 
 func (s *DeployService) ListDeploys(siteID int64, limit int) ([]*models.Deploy, error) {
 	return s.deployRepo.ListBySite(siteID, limit)
 }
 
+func (s *DeployService) GetDeploy(id int64) (*models.Deploy, error) {
+	return s.deployRepo.GetByID(id)
+}
+
+// GetActiveDeploy returns the deploy whose release is currently served.
+func (s *DeployService) GetActiveDeploy(siteID int64) (*models.Deploy, error) {
+	return s.deployRepo.GetActive(siteID)
+}
+
 func (s *DeployService) HasPreviousVersion(siteID int64) bool {
-	sitePath := filepath.Join(s.config.Sites.Path, fmt.Sprintf("%d", siteID))
-	publicPrevPath := filepath.Join(sitePath, "public_prev")
-	_, err := os.Stat(publicPrevPath)
-	return err == nil
+	if _, err := s.previousRelease(siteID); err == nil {
+		return true
+	}
+	return s.canRestoreInitial(siteID)
+}
+
+// MigrateLayout moves a site served from public/ to the release layout.
+// public/ becomes the release of the active deploy, or the initial release
+// when no deploy is active. It reports whether the site was migrated, in
+// which case its nginx config should be regenerated.
+func (s *DeployService) MigrateLayout(siteID int64) (bool, error) {
+	releaseID := InitialReleaseID
+	active, err := s.deployRepo.GetActive(siteID)
+	if err == nil {
+		releaseID = active.ID
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return false, err
+	}
+
+	sitePath := s.sitePath(siteID)
+	migrated, err := migrateLegacyLayout(sitePath, releaseID)
+	if err != nil || !migrated {
+		return migrated, err
+	}
+
+	// With an active deploy, public_prev duplicates an older release
+	prevPath := filepath.Join(sitePath, legacyPrevName)
+	if active != nil {
+		os.RemoveAll(prevPath)
+	} else if _, err := os.Stat(prevPath); err == nil {
+		slog.Warn("previous version left in place, it can no longer be restored from the panel", "site_id", siteID, "path", prevPath)
+	}
+
+	slog.Info("site migrated to release layout", "site_id", siteID, "release", releaseID)
+	return true, nil
 }
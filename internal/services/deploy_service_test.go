@@ -1,10 +1,110 @@
 package services
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"micropanel/internal/config"
+	"micropanel/internal/database"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
 )
 
+// deployTestEnv is a deploy service on a temp sites directory and a
+// migrated SQLite database, with a site and its owner.
+type deployTestEnv struct {
+	db      *database.DB
+	config  *config.Config
+	service *DeployService
+	deploys *repository.DeployRepository
+	sites   *repository.SiteRepository
+	site    *models.Site
+	owner   *models.User
+}
+
+func newDeployTestEnv(t *testing.T) *deployTestEnv {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "micropanel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrations, _ := filepath.Abs("../../migrations")
+	if err := db.Migrate(migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Sites.Path = filepath.Join(dir, "sites")
+	cfg.Limits.MaxZipSize = 10 * 1024 * 1024
+	cfg.Limits.MaxFileSize = 1024 * 1024
+
+	env := &deployTestEnv{
+		db:      db,
+		config:  cfg,
+		deploys: repository.NewDeployRepository(db),
+		sites:   repository.NewSiteRepository(db),
+	}
+	env.service = NewDeployService(cfg, env.deploys, env.sites)
+	env.owner = env.addUser(t, "owner@example.com")
+	env.site = &models.Site{Name: "example.com", OwnerID: env.owner.ID, IsEnabled: true, Type: models.SiteTypeStatic}
+	if err := env.sites.Create(env.site); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func (env *deployTestEnv) addUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Role: models.RoleUser, IsActive: true}
+	if err := repository.NewUserRepository(env.db).Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// siteZip returns a zip archive holding index.html with the given content.
+func siteZip(content string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("index.html")
+	w.Write([]byte(content))
+	zw.Close()
+	return &buf
+}
+
+// deploy deploys a site whose index.html has the given content.
+func (env *deployTestEnv) deploy(t *testing.T, content string, opts DeployOptions) *models.Deploy {
+	t.Helper()
+	buf := siteZip(content)
+	deploy, err := env.service.Deploy(env.site.ID, env.owner.ID, "site.zip", buf, int64(buf.Len()), opts)
+	if err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	return deploy
+}
+
+// current returns the ID of the release the site serves, 0 if none.
+func (env *deployTestEnv) current() int64 {
+	id, _ := currentRelease(siteDir(env.config.Sites.Path, env.site.ID))
+	return id
+}
+
 // makePathBytes creates a byte slice of 'a' characters for path testing
 func makePathBytes(length int) []byte {
 	return []byte(strings.Repeat("a", length))
@@ -70,52 +170,163 @@ func TestDeployService_validatePath(t *testing.T) {
 	}
 }
 
-func TestDeployService_isZip(t *testing.T) {
-	svc := &DeployService{}
+func TestDetectArchiveFormat(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar\x0000")
+	gnuTarHeader := make([]byte, 512)
+	copy(gnuTarHeader[257:], "ustar  \x00")
 
 	tests := []struct {
-		filename string
-		expected bool
+		name     string
+		header   []byte
+		expected archiveFormat
 	}{
-		{"site.zip", true},
-		{"site.ZIP", true},
-		{"site.Zip", true},
-		{"site.tar.gz", false},
-		{"site.tgz", false},
-		{"site.txt", false},
-		{"", false},
+		{"zip", []byte("PK\x03\x04\x14\x00"), archiveZip},
+		{"empty zip", []byte("PK\x05\x06\x00\x00"), archiveZip},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, archiveTarGz},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, archiveTarZst},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, archiveTarXz},
+		{"bzip2", []byte("BZh91AY&SY"), archiveTarBz2},
+		{"tar", tarHeader, archiveTar},
+		{"gnu tar", gnuTarHeader, archiveTar},
+		{"html", []byte("<!DOCTYPE html>"), archiveUnknown},
+		{"short", []byte("P"), archiveUnknown},
+		{"empty", nil, archiveUnknown},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.filename, func(t *testing.T) {
-			if got := svc.isZip(tt.filename); got != tt.expected {
-				t.Errorf("isZip(%q) = %v, want %v", tt.filename, got, tt.expected)
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectArchiveFormat(tt.header); got != tt.expected {
+				t.Errorf("detectArchiveFormat() = %q, want %q", got, tt.expected)
 			}
 		})
 	}
 }
 
-func TestDeployService_isTarGz(t *testing.T) {
-	svc := &DeployService{}
+// writeTestTar writes a tar archive of files, compressed with compress.
+func writeTestTar(t *testing.T, path string, files []string, compress func(io.Writer) io.WriteCloser) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range files {
+		if strings.HasSuffix(name, "/") {
+			tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755})
+			continue
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := compress(f)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestDeployService_extractTar(t *testing.T) {
+	s := &DeployService{}
+
+	compressors := map[archiveFormat]func(io.Writer) io.WriteCloser{
+		archiveTar: func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+		archiveTarGz: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		archiveTarZst: func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+		archiveTarXz: func(w io.Writer) io.WriteCloser {
+			xw, _ := xz.NewWriter(w)
+			return xw
+		},
+	}
 
 	tests := []struct {
-		filename string
-		expected bool
+		name     string
+		files    []string
+		expected []string
+	}{
+		{"common root", []string{"dist/", "dist/index.html", "dist/css/app.css"}, []string{"index.html", "css/app.css"}},
+		{"dot root", []string{"./", "./index.html"}, []string{"index.html"}},
+		{"no root", []string{"index.html", "css/app.css"}, []string{"index.html", "css/app.css"}},
+		{"two roots", []string{"a/index.html", "b/index.html"}, []string{"a/index.html", "b/index.html"}},
+	}
+
+	for format, compress := range compressors {
+		for _, tt := range tests {
+			t.Run(string(format)+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archivePath := filepath.Join(dir, "site.bin")
+				writeTestTar(t, archivePath, tt.files, compress)
+
+				got, err := sniffArchive(archivePath)
+				if err != nil || got != format {
+					t.Fatalf("sniffArchive() = %q, %v, want %q", got, err, format)
+				}
+
+				dest := filepath.Join(dir, "release")
+				budget := &extractBudget{maxFileSize: 1024, remaining: -1}
+				var progress int
+				err = s.extractTar(archivePath, format, dest, budget, func(done, total int) {
+					progress = done * 100 / total
+				})
+				if err != nil {
+					t.Fatalf("extractTar() error = %v", err)
+				}
+				if progress != 100 {
+					t.Errorf("final progress = %d, want 100", progress)
+				}
+
+				for _, name := range tt.expected {
+					if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+						t.Errorf("%s not extracted: %v", name, err)
+					}
+				}
+				if _, err := os.Stat(dest + ".extract"); !os.IsNotExist(err) {
+					t.Errorf("temporary directory left behind: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestDeployService_extractTar_Rejects(t *testing.T) {
+	s := &DeployService{}
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr error
 	}{
-		{"site.tar.gz", true},
-		{"site.TAR.GZ", true},
-		{"site.tgz", true},
-		{"site.TGZ", true},
-		{"site.zip", false},
-		{"site.tar", false},
-		{"site.gz", false},
-		{"", false},
+		{"traversal", []string{"../evil.html"}, ErrPathTraversal},
+		{"nested traversal", []string{"dist/../../evil.html"}, ErrPathTraversal},
+		{"too large", []string{"dist/" + strings.Repeat("a", 40) + ".html"}, ErrFileTooLarge},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.filename, func(t *testing.T) {
-			if got := svc.isTarGz(tt.filename); got != tt.expected {
-				t.Errorf("isTarGz(%q) = %v, want %v", tt.filename, got, tt.expected)
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archivePath := filepath.Join(dir, "site.tgz")
+			writeTestTar(t, archivePath, tt.files, gz)
+
+			budget := &extractBudget{maxFileSize: 40, remaining: -1}
+			err := s.extractTar(archivePath, archiveTarGz, filepath.Join(dir, "release"), budget, func(done, total int) {})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("extractTar() error = %v, want %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -135,3 +346,267 @@ func TestConstants(t *testing.T) {
 		t.Errorf("MaxPathLength = %d, want %d", MaxPathLength, 500)
 	}
 }
+
+func TestReleasesToPrune(t *testing.T) {
+	// Releases are ordered newest first, as returned by ListReleases
+	releases := func(activeID int64, ids ...int64) []*models.Deploy {
+		var list []*models.Deploy
+		for _, id := range ids {
+			list = append(list, &models.Deploy{ID: id, IsActive: id == activeID})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name     string
+		releases []*models.Deploy
+		keep     int
+		want     []int64
+	}{
+		{"under limit", releases(3, 3, 2, 1), 5, nil},
+		{"at limit", releases(3, 3, 2, 1), 3, nil},
+		{"over limit", releases(5, 5, 4, 3, 2, 1), 3, []int64{2, 1}},
+		{"active is old release", releases(1, 5, 4, 3, 2, 1), 3, []int64{3, 2}},
+		{"keep one", releases(2, 3, 2, 1), 1, []int64{3, 1}},
+		{"zero keeps active", releases(2, 2, 1), 0, []int64{1}},
+		{"no active release", releases(0, 3, 2, 1), 2, []int64{1}},
+	}
+
+	t.Run("staged release kept", func(t *testing.T) {
+		list := releases(4, 5, 4, 3, 2, 1)
+		list[0].IsStaged = true
+		got := releasesToPrune(list, 2)
+		if len(got) != 2 || got[0].ID != 2 || got[1].ID != 1 {
+			t.Errorf("releasesToPrune() = %v, want releases 2 and 1", got)
+		}
+	})
+
+	t.Run("held release kept", func(t *testing.T) {
+		list := releases(4, 5, 4, 3, 2, 1)
+		list[0].Status = models.DeployStatusAwaitingApproval
+		got := releasesToPrune(list, 2)
+		if len(got) != 2 || got[0].ID != 2 || got[1].ID != 1 {
+			t.Errorf("releasesToPrune() = %v, want releases 2 and 1", got)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := releasesToPrune(tt.releases, tt.keep)
+			if len(got) != len(tt.want) {
+				t.Fatalf("releasesToPrune() pruned %d releases, want %d", len(got), len(tt.want))
+			}
+			for i, d := range got {
+				if d.ID != tt.want[i] {
+					t.Errorf("releasesToPrune()[%d] = %d, want %d", i, d.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSwitchRelease(t *testing.T) {
+	sitePath := t.TempDir()
+	for _, id := range []int64{1, 2} {
+		dir := filepath.Join(sitePath, "releases", fmt.Sprintf("%d", id))
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte(fmt.Sprintf("release %d", id)), 0644)
+	}
+
+	for _, id := range []int64{1, 2, 1} {
+		if err := switchRelease(sitePath, id); err != nil {
+			t.Fatalf("switchRelease(%d) error = %v", id, err)
+		}
+
+		got, err := currentRelease(sitePath)
+		if err != nil || got != id {
+			t.Fatalf("currentRelease() = %d, %v, want %d", got, err, id)
+		}
+		content, _ := os.ReadFile(filepath.Join(sitePath, "current", "index.html"))
+		if want := fmt.Sprintf("release %d", id); string(content) != want {
+			t.Errorf("current/index.html = %q, want %q", content, want)
+		}
+	}
+
+	// No temporary links left behind
+	entries, _ := os.ReadDir(sitePath)
+	if len(entries) != 2 {
+		t.Errorf("site directory has %d entries, want releases and current", len(entries))
+	}
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	sitePath := t.TempDir()
+	os.MkdirAll(filepath.Join(sitePath, "public"), 0755)
+	os.WriteFile(filepath.Join(sitePath, "public", "index.html"), []byte("edited"), 0644)
+	os.MkdirAll(filepath.Join(sitePath, "releases", "7"), 0755)
+	os.WriteFile(filepath.Join(sitePath, "releases", "7", "index.html"), []byte("deployed"), 0644)
+
+	migrated, err := migrateLegacyLayout(sitePath, 7)
+	if err != nil || !migrated {
+		t.Fatalf("migrateLegacyLayout() = %v, %v, want true", migrated, err)
+	}
+
+	if id, err := currentRelease(sitePath); err != nil || id != 7 {
+		t.Errorf("currentRelease() = %d, %v, want 7", id, err)
+	}
+	for _, dir := range []string{"current", "public"} {
+		content, _ := os.ReadFile(filepath.Join(sitePath, dir, "index.html"))
+		if string(content) != "edited" {
+			t.Errorf("%s/index.html = %q, want public content", dir, content)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(sitePath, "public")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("public should be a symlink after migration")
+	}
+
+	migrated, err = migrateLegacyLayout(sitePath, 7)
+	if err != nil || migrated {
+		t.Errorf("second migrateLegacyLayout() = %v, %v, want false", migrated, err)
+	}
+}
+
+func TestLockSite(t *testing.T) {
+	sitesPath := t.TempDir()
+
+	unlock, err := lockSite(sitesPath, 1, false)
+	if err != nil {
+		t.Fatalf("lockSite() error = %v", err)
+	}
+
+	if _, err := lockSite(sitesPath, 1, false); err != ErrSiteBusy {
+		t.Errorf("second lockSite() error = %v, want %v", err, ErrSiteBusy)
+	}
+
+	// Other sites are not affected
+	unlockOther, err := lockSite(sitesPath, 2, false)
+	if err != nil {
+		t.Fatalf("lockSite() of another site error = %v", err)
+	}
+	unlockOther()
+
+	// A waiting lock gets the site once it is released
+	acquired := make(chan struct{})
+	go func() {
+		unlockWaiting, err := lockSite(sitesPath, 1, true)
+		if err == nil {
+			unlockWaiting()
+		}
+		close(acquired)
+	}()
+
+	unlock()
+	<-acquired
+}
+
+func TestExtractBudget(t *testing.T) {
+	budget := &extractBudget{maxFileSize: 100, remaining: 150}
+
+	if err := budget.take("a.html", 101); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("take() over file size error = %v, want %v", err, ErrFileTooLarge)
+	}
+	if err := budget.take("a.html", 100); err != nil {
+		t.Errorf("take() error = %v", err)
+	}
+	if err := budget.take("b.html", 60); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("take() over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := budget.take("c.html", 50); err != nil {
+		t.Errorf("take() filling quota error = %v", err)
+	}
+
+	unlimited := &extractBudget{maxFileSize: 100, remaining: -1}
+	for i := 0; i < 10; i++ {
+		if err := unlimited.take("a.html", 100); err != nil {
+			t.Fatalf("take() without quota error = %v", err)
+		}
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDeployService_validateManifest(t *testing.T) {
+	s := &DeployService{}
+	hash := sha256Hex("x")
+
+	files, err := s.validateManifest(map[string]string{"index.html": strings.ToUpper(hash), "css/app.css": hash})
+	if err != nil {
+		t.Fatalf("validateManifest() error = %v", err)
+	}
+	if files["index.html"] != hash {
+		t.Errorf("hash not normalized to lower case: %q", files["index.html"])
+	}
+
+	invalid := []map[string]string{
+		{},
+		{"/index.html": hash},
+		{"./index.html": hash},
+		{"a//b.html": hash},
+		{"../index.html": hash},
+		{"index.html": "abc"},
+		{"css": hash, "css/app.css": hash},
+	}
+	for _, m := range invalid {
+		if _, err := s.validateManifest(m); err == nil {
+			t.Errorf("validateManifest(%v) expected error", m)
+		}
+	}
+}
+
+func TestManifestBuilder(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Sites.Path = t.TempDir()
+	s := &DeployService{config: cfg}
+
+	base := s.releasePath(1, 1)
+	os.MkdirAll(filepath.Join(base, "css"), 0755)
+	os.WriteFile(filepath.Join(base, "index.html"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(base, "css", "app.css"), []byte("body{}"), 0644)
+
+	manifest := &deployManifest{
+		Files: map[string]string{
+			"index.html":  sha256Hex("hello"),
+			"css/app.css": sha256Hex("body{color:red}"),
+		},
+		BaseRelease: 1,
+	}
+	manifest.Missing = s.missingFiles(1, manifest)
+	if len(manifest.Missing) != 1 || manifest.Missing[0] != "css/app.css" {
+		t.Fatalf("missingFiles() = %v, want [css/app.css]", manifest.Missing)
+	}
+
+	staged := filepath.Join(s.manifestStagingPath(1, 2), "css", "app.css")
+	if _, err := stageManifestFile(staged, strings.NewReader("body{color:blue}"), manifest.Files["css/app.css"], 100); !errors.Is(err, ErrManifestHash) {
+		t.Errorf("stageManifestFile() with wrong content error = %v, want %v", err, ErrManifestHash)
+	}
+	if _, err := stageManifestFile(staged, strings.NewReader("body{color:red}"), manifest.Files["css/app.css"], 10); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("stageManifestFile() over file size error = %v, want %v", err, ErrFileTooLarge)
+	}
+	if _, err := stageManifestFile(staged, strings.NewReader("body{color:red}"), manifest.Files["css/app.css"], 100); err != nil {
+		t.Fatalf("stageManifestFile() error = %v", err)
+	}
+
+	release := s.releasePath(1, 2)
+	build := s.manifestBuilder(1, 2, manifest)
+	if err := build(release, nil, func(done, total int) {}); err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(release, "css", "app.css")); string(data) != "body{color:red}" {
+		t.Errorf("uploaded file content = %q", data)
+	}
+	baseInfo, _ := os.Stat(filepath.Join(base, "index.html"))
+	newInfo, err := os.Stat(filepath.Join(release, "index.html"))
+	if err != nil || !os.SameFile(baseInfo, newInfo) {
+		t.Errorf("unchanged file is not hardlinked from the base release (err = %v)", err)
+	}
+
+	// A file changed in the base release after the manifest was sent
+	os.WriteFile(filepath.Join(base, "index.html"), []byte("changed"), 0644)
+	if err := s.manifestBuilder(1, 3, manifest)(s.releasePath(1, 3), nil, func(done, total int) {}); !errors.Is(err, ErrManifestStale) {
+		t.Errorf("build() with changed base error = %v, want %v", err, ErrManifestStale)
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+
+	"micropanel/internal/models"
+)
+
+// SetDeployKeyService enables signed deploys: a site with deploy keys then
+// only accepts archives signed by one of them. Without it signatures are
+// not checked.
+func (s *DeployService) SetDeployKeyService(keys *DeployKeyService) {
+	s.keys = keys
+}
+
+// verifyArchive checks the signature of an uploaded archive, given its
+// SHA-256 digest, and records the key that made it on the deploy.
+func (s *DeployService) verifyArchive(deploy *models.Deploy, digest []byte, signature string) error {
+	if s.keys == nil {
+		return nil
+	}
+	key, err := s.keys.Verify(deploy.SiteID, digest, signature)
+	if err != nil || key == nil {
+		return err
+	}
+	if err := s.deployRepo.SetSignedBy(deploy.ID, key.Fingerprint); err != nil {
+		return fmt.Errorf("record signature: %w", err)
+	}
+	deploy.SignedBy = key.Fingerprint
+	return nil
+}
+
+// checkUnsigned refuses deploys that cannot carry a signature, from git or
+// a manifest, for sites that only accept signed archives.
+func (s *DeployService) checkUnsigned(siteID int64) error {
+	if s.keys == nil {
+		return nil
+	}
+	required, err := s.keys.RequiresSignature(siteID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrSignatureRequired
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// SetStagingService enables staging deploys. Without it every deploy goes to
+// production.
+func (s *DeployService) SetStagingService(staging *StagingService) {
+	s.staging = staging
+}
+
+// checkStaging returns ErrStagingDisabled unless the site has a staging slot.
+func (s *DeployService) checkStaging(siteID int64) error {
+	if s.staging == nil {
+		return ErrStagingDisabled
+	}
+	slot, err := s.staging.Get(siteID)
+	if err != nil {
+		return err
+	}
+	if slot == nil {
+		return ErrStagingDisabled
+	}
+	return nil
+}
+
+// stageRelease points the staging symlink of a site at the given release.
+func (s *DeployService) stageRelease(siteID, releaseID int64) error {
+	releasePath := s.releasePath(siteID, releaseID)
+	sharedPaths, err := s.SharedPaths(siteID)
+	if err != nil {
+		return fmt.Errorf("load shared paths: %w", err)
+	}
+	if err := s.linkSharedPaths(siteID, releasePath, sharedPaths); err != nil {
+		return fmt.Errorf("link shared paths: %w", err)
+	}
+
+	s.chownPath(releasePath)
+
+	if err := switchLink(s.sitePath(siteID), stagingLinkName, releaseID); err != nil {
+		return fmt.Errorf("stage release: %w", err)
+	}
+	return nil
+}
+
+// GetStagedDeploy returns the deploy whose release is served on the preview
+// hostname of the site, nil if there is none.
+func (s *DeployService) GetStagedDeploy(siteID int64) (*models.Deploy, error) {
+	deploy, err := s.deployRepo.GetStaged(siteID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return deploy, err
+}
+
+// Promote makes the staged release of a site its production release. The
+// current symlink is switched like for a rollback, nothing is uploaded or
+// extracted again. If the health checks of the site fail, the previous
+// release is restored.
+func (s *DeployService) Promote(siteID int64) (*models.Deploy, error) {
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deploy, err := s.GetStagedDeploy(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if deploy == nil || !deploy.HasRelease {
+		return nil, ErrNoStagedRelease
+	}
+	if deploy.IsActive {
+		return deploy, ErrStagingNotPromotable
+	}
+	if _, err := os.Stat(s.releasePath(siteID, deploy.ID)); err != nil {
+		return nil, ErrNoStagedRelease
+	}
+
+	previous, prevErr := currentRelease(s.sitePath(siteID))
+
+	if err := s.activateRelease(siteID, deploy.ID); err != nil {
+		return nil, err
+	}
+
+	if s.health != nil {
+		if err := s.health.Run(siteID); err != nil {
+			if prevErr == nil {
+				if restoreErr := s.activateRelease(siteID, previous); restoreErr != nil {
+					return nil, fmt.Errorf("%w; rollback failed: %v", err, restoreErr)
+				}
+			} else {
+				os.Remove(siteCurrentPath(s.config.Sites.Path, siteID))
+			}
+			return nil, fmt.Errorf("%w; rolled back", err)
+		}
+	}
+
+	if err := s.deployRepo.Promote(siteID, deploy.ID); err != nil {
+		return nil, fmt.Errorf("mark release active: %w", err)
+	}
+	deploy.IsActive = true
+	deploy.Target = models.DeployTargetProduction
+
+	s.pruneReleases(siteID)
+	return deploy, nil
+}
+
+// DisableStaging removes the staging slot of a site. The staged release is
+// no longer served and is pruned like any other release.
+func (s *DeployService) DisableStaging(siteID int64) error {
+	if s.staging == nil {
+		return ErrStagingDisabled
+	}
+
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.staging.delete(siteID); err != nil {
+		return err
+	}
+	if err := os.Remove(siteStagingPath(s.config.Sites.Path, siteID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove staging link: %w", err)
+	}
+	if err := s.deployRepo.SetStaged(siteID, 0); err != nil {
+		return err
+	}
+
+	s.pruneReleases(siteID)
+	return nil
+}
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
 )
 
 var (
@@ -20,11 +24,12 @@ var (
 	ErrFileInvalidPath   = errors.New("invalid path")
 	ErrFileExists        = errors.New("file already exists")
 	ErrCannotDelete      = errors.New("cannot delete this path")
+	ErrSharedPathRoot    = errors.New("shared paths cannot be deleted or renamed")
 )
 
 const (
-	MaxEditFileSize = 5 * 1024 * 1024  // 5MB
-	MaxUploadSize   = 10 * 1024 * 1024 // 10MB
+	MaxEditFileSize = 5 * 1024 * 1024  // 5MB, files larger than this are not opened in the editor
+	MaxUploadSize   = 10 * 1024 * 1024 // 10MB, used when the limits config leaves it unset
 )
 
 type FileInfo struct {
@@ -36,7 +41,10 @@ type FileInfo struct {
 }
 
 type FileService struct {
-	config *config.Config
+	config     *config.Config
+	limits     *LimitsService
+	sharedRepo *repository.SharedPathRepository
+	siteRepo   *repository.SiteRepository
 }
 
 func NewFileService(cfg *config.Config) *FileService {
@@ -45,9 +53,108 @@ func NewFileService(cfg *config.Config) *FileService {
 	}
 }
 
-// GetSitePath returns the base path for a site
+func (s *FileService) SetLimitsService(limits *LimitsService) {
+	s.limits = limits
+}
+
+// SetSharedPathRepo makes the file manager read and write shared paths in
+// shared/, where they are kept across deploys.
+func (s *FileService) SetSharedPathRepo(repo *repository.SharedPathRepository) {
+	s.sharedRepo = repo
+}
+
+// SetSiteRepo lets the file manager keep the precompressed copies of sites
+// that have them up to date.
+func (s *FileService) SetSiteRepo(repo *repository.SiteRepository) {
+	s.siteRepo = repo
+}
+
+// precompresses reports whether a site keeps .gz and .br copies of its text
+// assets.
+func (s *FileService) precompresses(siteID int64) bool {
+	if s.siteRepo == nil {
+		return false
+	}
+	site, err := s.siteRepo.GetByID(siteID)
+	return err == nil && site.Precompress
+}
+
+// refreshPrecompressed regenerates the compressed copies of a file after it
+// changed, so nginx does not serve the old content.
+func (s *FileService) refreshPrecompressed(siteID int64, fullPath string) {
+	if !s.precompresses(siteID) {
+		return
+	}
+	if err := precompressFile(fullPath, s.config.Nginx.BrotliStatic); err != nil {
+		slog.Warn("failed to precompress file", "site_id", siteID, "path", fullPath, "error", err)
+		removePrecompressed(fullPath)
+	}
+}
+
+// sharedPaths returns the shared paths of a site, or none when they cannot
+// be loaded.
+func (s *FileService) sharedPaths(siteID int64) []string {
+	if s.sharedRepo == nil {
+		return nil
+	}
+	paths, err := s.sharedRepo.ListBySite(siteID)
+	if err != nil {
+		slog.Error("failed to load shared paths", "site_id", siteID, "error", err)
+		return nil
+	}
+	return paths
+}
+
+// IsShared reports whether relativePath is or lies in a shared path of the
+// site, which is kept in shared/ rather than in a release.
+func (s *FileService) IsShared(siteID int64, relativePath string) bool {
+	return sharedPathOf(s.sharedPaths(siteID), cleanRelativePath(relativePath)) != ""
+}
+
+// isSharedRoot reports whether relativePath is a shared path or one of its
+// parents. Removing or moving it would cut the link to the shared files.
+func (s *FileService) isSharedRoot(siteID int64, relativePath string) bool {
+	rel := cleanRelativePath(relativePath)
+	for _, p := range s.sharedPaths(siteID) {
+		if rel == p || strings.HasPrefix(p, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRelativePath turns a path from the file manager into a slash
+// separated path relative to the site root, "" for the root itself.
+func cleanRelativePath(relativePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+}
+
+// GetSitePath returns the base path for a site: the current release, or
+// public for sites not yet migrated to the release layout
 func (s *FileService) GetSitePath(siteID int64) string {
-	return filepath.Join(s.config.Sites.Path, fmt.Sprintf("%d", siteID), "public")
+	currentPath := siteCurrentPath(s.config.Sites.Path, siteID)
+	if _, err := os.Lstat(currentPath); err == nil {
+		return currentPath
+	}
+	return filepath.Join(siteDir(s.config.Sites.Path, siteID), legacyPublicName)
+}
+
+// lock keeps deploys and rollbacks away while the file manager changes files.
+// It fails with ErrSiteBusy instead of waiting.
+func (s *FileService) lock(siteID int64) (func(), error) {
+	return lockSite(s.config.Sites.Path, siteID, false)
+}
+
+// checkWrite verifies that writing size bytes over fullPath keeps the site
+// within its disk quota. The file being replaced does not count.
+func (s *FileService) checkWrite(siteID int64, limits *models.Limits, fullPath string, size int64) error {
+	if info, err := os.Stat(fullPath); err == nil && info.Mode().IsRegular() {
+		size -= info.Size()
+	}
+	if size <= 0 {
+		return nil
+	}
+	return checkQuota(s.config.Sites.Path, siteID, limits, size)
 }
 
 // ValidatePath checks if the path is within the site directory (sandbox check)
@@ -88,6 +195,13 @@ func (s *FileService) ValidatePath(siteID int64, relativePath string) (string, e
 		return "", ErrFilePathTraversal
 	}
 
+	// Shared paths go to their persistent location, whether or not the
+	// current release links them
+	relPath = filepath.ToSlash(relPath)
+	if sharedPathOf(s.sharedPaths(siteID), relPath) != "" {
+		return filepath.Abs(siteSharedPath(s.config.Sites.Path, siteID, relPath))
+	}
+
 	return absPath, nil
 }
 
@@ -124,21 +238,39 @@ func (s *FileService) List(siteID int64, relativePath string) ([]FileInfo, error
 	}
 
 	var files []FileInfo
-	basePath := s.GetSitePath(siteID)
+	dirPath := cleanRelativePath(relativePath)
+
+	// Precompressed copies are kept up to date by the panel, only the
+	// files they are made from are listed
+	var names map[string]bool
+	if s.precompresses(siteID) {
+		names = make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			names[entry.Name()] = true
+		}
+	}
 
 	for _, entry := range entries {
+		if names != nil && isPrecompressedCopy(entry.Name(), names) {
+			continue
+		}
+
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
-		entryPath := filepath.Join(fullPath, entry.Name())
-		relPath, _ := filepath.Rel(basePath, entryPath)
+		// Shared paths are linked into the release, show what they point to
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(fullPath, entry.Name())); err == nil {
+				info = target
+			}
+		}
 
 		files = append(files, FileInfo{
 			Name:    entry.Name(),
-			Path:    "/" + relPath,
-			IsDir:   entry.IsDir(),
+			Path:    "/" + path.Join(dirPath, entry.Name()),
+			IsDir:   info.IsDir(),
 			Size:    info.Size(),
 			ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
 		})
@@ -175,7 +307,8 @@ func (s *FileService) Read(siteID int64, relativePath string) ([]byte, error) {
 
 // Write saves content to a file
 func (s *FileService) Write(siteID int64, relativePath string, content []byte) error {
-	if len(content) > MaxEditFileSize {
+	limits := resolveLimits(s.limits, s.config, siteID)
+	if int64(len(content)) > limits.MaxFileSize {
 		return ErrFileTooBig
 	}
 
@@ -184,13 +317,32 @@ func (s *FileService) Write(siteID int64, relativePath string, content []byte) e
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.checkWrite(siteID, limits, fullPath, int64(len(content))); err != nil {
+		return err
+	}
+
 	// Ensure parent directory exists
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("create directory: %w", err)
 	}
 
-	return os.WriteFile(fullPath, content, 0644)
+	err = replaceFile(fullPath, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.refreshPrecompressed(siteID, fullPath)
+	return nil
 }
 
 // CreateFile creates a new empty file
@@ -200,6 +352,12 @@ func (s *FileService) CreateFile(siteID int64, relativePath string) error {
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Check if file already exists
 	if _, err := os.Stat(fullPath); err == nil {
 		return ErrFileExists
@@ -226,6 +384,12 @@ func (s *FileService) CreateDirectory(siteID int64, relativePath string) error {
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Check if already exists
 	if _, err := os.Stat(fullPath); err == nil {
 		return ErrFileExists
@@ -240,22 +404,43 @@ func (s *FileService) Delete(siteID int64, relativePath string) error {
 	if relativePath == "" || relativePath == "/" {
 		return ErrCannotDelete
 	}
+	if s.isSharedRoot(siteID, relativePath) {
+		return ErrSharedPathRoot
+	}
 
 	fullPath, err := s.ValidatePath(siteID, relativePath)
 	if err != nil {
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Check if exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
 		return ErrFileNotFound
 	}
 
-	return os.RemoveAll(fullPath)
+	if err := os.RemoveAll(fullPath); err != nil {
+		return err
+	}
+	// The precompressed copies of a file go with it
+	if info != nil && !info.IsDir() && s.precompresses(siteID) {
+		removePrecompressed(fullPath)
+	}
+	return nil
 }
 
 // Rename moves/renames a file or directory
 func (s *FileService) Rename(siteID int64, oldPath, newPath string) error {
+	if s.isSharedRoot(siteID, oldPath) || s.isSharedRoot(siteID, newPath) {
+		return ErrSharedPathRoot
+	}
+
 	oldFullPath, err := s.ValidatePath(siteID, oldPath)
 	if err != nil {
 		return err
@@ -266,6 +451,12 @@ func (s *FileService) Rename(siteID int64, oldPath, newPath string) error {
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Check if source exists
 	if _, err := os.Stat(oldFullPath); os.IsNotExist(err) {
 		return ErrFileNotFound
@@ -282,12 +473,21 @@ func (s *FileService) Rename(siteID int64, oldPath, newPath string) error {
 		return fmt.Errorf("create directory: %w", err)
 	}
 
-	return os.Rename(oldFullPath, newFullPath)
+	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+		return err
+	}
+
+	if s.precompresses(siteID) {
+		removePrecompressed(oldFullPath)
+		s.refreshPrecompressed(siteID, newFullPath)
+	}
+	return nil
 }
 
 // Upload saves an uploaded file
 func (s *FileService) Upload(siteID int64, relativePath string, reader io.Reader, size int64) error {
-	if size > MaxUploadSize {
+	limits := resolveLimits(s.limits, s.config, siteID)
+	if size > limits.MaxUploadSize {
 		return ErrFileTooBig
 	}
 
@@ -296,25 +496,65 @@ func (s *FileService) Upload(siteID int64, relativePath string, reader io.Reader
 		return err
 	}
 
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.checkWrite(siteID, limits, fullPath, size); err != nil {
+		return err
+	}
+
 	// Ensure parent directory exists
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("create directory: %w", err)
 	}
 
-	// Create file
-	f, err := os.Create(fullPath)
+	err = replaceFile(fullPath, func(w io.Writer) error {
+		// Copy with size limit
+		_, err := io.CopyN(w, reader, limits.MaxUploadSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	// Copy with size limit
-	_, err = io.CopyN(f, reader, MaxUploadSize)
-	if err != nil && err != io.EOF {
+	s.refreshPrecompressed(siteID, fullPath)
+	return nil
+}
+
+// replaceFile writes a file to a temporary file next to it and renames it
+// into place. Releases share unchanged files as hardlinks, so writing in
+// place would also change the file in older releases.
+func replaceFile(fullPath string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
 		return err
 	}
+	tmpPath := tmp.Name()
 
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
 	return nil
 }
 
@@ -333,12 +573,9 @@ func (s *FileService) GetFileInfo(siteID int64, relativePath string) (*FileInfo,
 		return nil, err
 	}
 
-	basePath := s.GetSitePath(siteID)
-	relPath, _ := filepath.Rel(basePath, fullPath)
-
 	return &FileInfo{
 		Name:    info.Name(),
-		Path:    "/" + relPath,
+		Path:    "/" + cleanRelativePath(relativePath),
 		IsDir:   info.IsDir(),
 		Size:    info.Size(),
 		ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
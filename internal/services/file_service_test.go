@@ -143,3 +143,82 @@ func TestFileService_Read_CannotReadOutsidePublic(t *testing.T) {
 		t.Errorf("Should block reading with nested traversal, got: %v", err)
 	}
 }
+
+func TestFileService_Write_SiteBusy(t *testing.T) {
+	sitesDir := filepath.Join(t.TempDir(), "sites")
+	os.MkdirAll(filepath.Join(sitesDir, "1", "public"), 0755)
+
+	cfg := &config.Config{}
+	cfg.Sites.Path = sitesDir
+
+	fs := NewFileService(cfg)
+
+	// A deploy holds the site
+	unlock, err := lockSite(sitesDir, 1, false)
+	if err != nil {
+		t.Fatalf("lockSite() error = %v", err)
+	}
+
+	if err := fs.Write(1, "/index.html", []byte("edited")); err != ErrSiteBusy {
+		t.Errorf("Write() during deploy error = %v, want %v", err, ErrSiteBusy)
+	}
+
+	unlock()
+
+	if err := fs.Write(1, "/index.html", []byte("edited")); err != nil {
+		t.Errorf("Write() after deploy error = %v", err)
+	}
+}
+
+func TestFileService_Write_QuotaExceeded(t *testing.T) {
+	sitesDir := filepath.Join(t.TempDir(), "sites")
+	os.MkdirAll(filepath.Join(sitesDir, "1", "public"), 0755)
+	os.WriteFile(filepath.Join(sitesDir, "1", "public", "index.html"), make([]byte, 60), 0644)
+
+	cfg := &config.Config{}
+	cfg.Sites.Path = sitesDir
+	cfg.Limits.DiskQuota = 100
+
+	fs := NewFileService(cfg)
+
+	if err := fs.Write(1, "/big.html", make([]byte, 50)); err != ErrQuotaExceeded {
+		t.Errorf("Write() over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Replacing a file only counts the difference
+	if err := fs.Write(1, "/index.html", make([]byte, 90)); err != nil {
+		t.Errorf("Write() replacing file within quota error = %v", err)
+	}
+
+	if err := fs.Write(1, "/small.html", make([]byte, 10)); err != nil {
+		t.Errorf("Write() within quota error = %v", err)
+	}
+}
+
+func TestFileService_Write_KeepsHardlinkedReleases(t *testing.T) {
+	sitesDir := filepath.Join(t.TempDir(), "sites")
+	oldRelease := filepath.Join(sitesDir, "1", "releases", "1")
+	newRelease := filepath.Join(sitesDir, "1", "releases", "2")
+	os.MkdirAll(oldRelease, 0755)
+	os.MkdirAll(newRelease, 0755)
+	os.WriteFile(filepath.Join(oldRelease, "index.html"), []byte("old"), 0644)
+	if err := os.Link(filepath.Join(oldRelease, "index.html"), filepath.Join(newRelease, "index.html")); err != nil {
+		t.Skipf("hardlinks not supported: %v", err)
+	}
+	os.Symlink(filepath.Join("releases", "2"), filepath.Join(sitesDir, "1", "current"))
+
+	cfg := &config.Config{}
+	cfg.Sites.Path = sitesDir
+
+	fs := NewFileService(cfg)
+	if err := fs.Write(1, "/index.html", []byte("edited")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(newRelease, "index.html")); string(data) != "edited" {
+		t.Errorf("current release content = %q, want %q", data, "edited")
+	}
+	if data, _ := os.ReadFile(filepath.Join(oldRelease, "index.html")); string(data) != "old" {
+		t.Errorf("older release content = %q, want it unchanged", data)
+	}
+}
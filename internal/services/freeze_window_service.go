@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const (
+	MaxFreezeWindows      = 50 // per site, not counting windows that are over
+	MaxFreezeReasonLength = 200
+)
+
+var (
+	ErrInvalidFreezeWindow  = errors.New("freeze window must end after it starts")
+	ErrFreezeWindowOver     = errors.New("freeze window is already over")
+	ErrFreezeReasonTooLong  = fmt.Errorf("freeze reason must be at most %d characters", MaxFreezeReasonLength)
+	ErrTooManyFreezeWindows = fmt.Errorf("a site can have at most %d freeze windows", MaxFreezeWindows)
+)
+
+// DeployFrozenError is returned when a deploy falls within a freeze window
+// of its site.
+type DeployFrozenError struct {
+	Window *models.FreezeWindow
+}
+
+func (e *DeployFrozenError) Error() string {
+	msg := "deploys are frozen until " + e.Window.EndsAt.Local().Format("2006-01-02 15:04 MST")
+	if e.Window.Reason != "" {
+		msg += ": " + e.Window.Reason
+	}
+	return msg
+}
+
+// FreezeWindowService stores the freeze windows of sites and tells whether
+// a deploy falls within one.
+type FreezeWindowService struct {
+	windowRepo *repository.FreezeWindowRepository
+}
+
+func NewFreezeWindowService(windowRepo *repository.FreezeWindowRepository) *FreezeWindowService {
+	return &FreezeWindowService{windowRepo: windowRepo}
+}
+
+func (s *FreezeWindowService) GetByID(id int64) (*models.FreezeWindow, error) {
+	return s.windowRepo.GetByID(id)
+}
+
+// ListBySite returns the windows of a site that are not over yet, earliest
+// first.
+func (s *FreezeWindowService) ListBySite(siteID int64) ([]*models.FreezeWindow, error) {
+	windows, err := s.windowRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current := windows[:0]
+	for _, w := range windows {
+		if !w.IsOver(now) {
+			current = append(current, w)
+		}
+	}
+	return current, nil
+}
+
+func (s *FreezeWindowService) Create(siteID int64, startsAt, endsAt time.Time, reason string) (*models.FreezeWindow, error) {
+	reason = strings.TrimSpace(reason)
+	if !endsAt.After(startsAt) {
+		return nil, ErrInvalidFreezeWindow
+	}
+	if !endsAt.After(time.Now()) {
+		return nil, ErrFreezeWindowOver
+	}
+	if len(reason) > MaxFreezeReasonLength {
+		return nil, ErrFreezeReasonTooLong
+	}
+
+	existing, err := s.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxFreezeWindows {
+		return nil, ErrTooManyFreezeWindows
+	}
+
+	window := &models.FreezeWindow{SiteID: siteID, StartsAt: startsAt, EndsAt: endsAt, Reason: reason}
+	if err := s.windowRepo.Create(window); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+func (s *FreezeWindowService) Delete(id int64) error {
+	return s.windowRepo.Delete(id)
+}
+
+// Check returns a *DeployFrozenError if a deploy of the site at the given
+// time falls within one of its freeze windows.
+func (s *FreezeWindowService) Check(siteID int64, at time.Time) error {
+	windows, err := s.windowRepo.ListBySite(siteID)
+	if err != nil {
+		return fmt.Errorf("load freeze windows: %w", err)
+	}
+	if window := freezeWindowAt(windows, at); window != nil {
+		return &DeployFrozenError{Window: window}
+	}
+	return nil
+}
+
+// freezeWindowAt returns the window covering t that ends last, or nil.
+func freezeWindowAt(windows []*models.FreezeWindow, t time.Time) *models.FreezeWindow {
+	var found *models.FreezeWindow
+	for _, w := range windows {
+		if w.Covers(t) && (found == nil || w.EndsAt.After(found.EndsAt)) {
+			found = w
+		}
+	}
+	return found
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"micropanel/internal/models"
+)
+
+func TestFreezeWindowAt(t *testing.T) {
+	base := time.Date(2025, 11, 28, 12, 0, 0, 0, time.UTC)
+	windows := []*models.FreezeWindow{
+		{ID: 1, StartsAt: base, EndsAt: base.Add(24 * time.Hour)},
+		{ID: 2, StartsAt: base.Add(12 * time.Hour), EndsAt: base.Add(72 * time.Hour)},
+		{ID: 3, StartsAt: base.Add(96 * time.Hour), EndsAt: base.Add(120 * time.Hour)},
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int64 // 0 = none
+	}{
+		{"before all", base.Add(-time.Minute), 0},
+		{"start is inclusive", base, 1},
+		{"overlap picks the later end", base.Add(18 * time.Hour), 2},
+		{"end is exclusive", base.Add(72 * time.Hour), 0},
+		{"gap", base.Add(80 * time.Hour), 0},
+		{"last window", base.Add(100 * time.Hour), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			if w := freezeWindowAt(windows, tt.at); w != nil {
+				got = w.ID
+			}
+			if got != tt.want {
+				t.Errorf("freezeWindowAt() = window %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeployFrozenError(t *testing.T) {
+	window := &models.FreezeWindow{EndsAt: time.Now().Add(time.Hour)}
+	if msg := (&DeployFrozenError{Window: window}).Error(); strings.HasSuffix(msg, ": ") {
+		t.Errorf("Error() = %q, want no reason", msg)
+	}
+
+	window.Reason = "Black Friday"
+	if msg := (&DeployFrozenError{Window: window}).Error(); !strings.HasSuffix(msg, ": Black Friday") {
+		t.Errorf("Error() = %q, want the reason at the end", msg)
+	}
+}
+
+func TestCheckScheduleTime(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		at      time.Time
+		wantErr bool
+	}{
+		{"in an hour", now.Add(time.Hour), false},
+		{"in eleven months", now.Add(330 * 24 * time.Hour), false},
+		{"now", now, true},
+		{"in the past", now.Add(-time.Minute), true},
+		{"too far ahead", now.Add(MaxScheduleAhead + time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkScheduleTime(tt.at, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkScheduleTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
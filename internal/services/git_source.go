@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const gitTimeout = 5 * time.Minute
+
+// gitArchivePrefix is the directory the checkout is packed under. It is
+// stripped again on extraction, so a subdirectory holding a single folder
+// is not flattened the way an uploaded archive with one root folder is.
+const gitArchivePrefix = "release/"
+
+var (
+	ErrNoGitSource       = errors.New("site has no git repository")
+	ErrGitFailed         = errors.New("git command failed")
+	ErrGitSubdirNotFound = errors.New("subdirectory not found in repository")
+)
+
+// gitSource is the repository a git deploy checks out, captured when the
+// deploy is created so later changes to the site do not affect it.
+type gitSource struct {
+	url    string
+	branch string
+	subdir string
+}
+
+// DeployGit checks out the branch the site is linked to and deploys it like
+// an uploaded archive.
+func (s *DeployService) DeployGit(siteID, userID int64, opts DeployOptions) (*models.Deploy, error) {
+	deploy, src, err := s.createGit(siteID, userID, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	archivePath, err := s.fetchGit(deploy, src)
+	if err != nil {
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	return deploy, s.run(deploy, s.archiveBuilder(archivePath))
+}
+
+// EnqueueGit hands a git deploy to the workers, which check out the
+// revision before extracting it.
+func (s *DeployService) EnqueueGit(siteID, userID int64, opts DeployOptions) (*models.Deploy, error) {
+	deploy, src, err := s.createGit(siteID, userID, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	s.setPhase(deploy, models.DeployPhaseQueued, 0)
+
+	queued := *deploy
+	if err := s.dispatch(deployJob{deploy: &queued, git: src}); err != nil {
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	return deploy, nil
+}
+
+func (s *DeployService) createGit(siteID, userID int64, opts DeployOptions) (*models.Deploy, *gitSource, error) {
+	site, err := s.siteRepo.GetByID(siteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("get site: %w", err)
+	}
+	if !site.HasGitSource() {
+		return nil, nil, ErrNoGitSource
+	}
+	if err := s.checkUnsigned(siteID); err != nil {
+		return nil, nil, err
+	}
+
+	src := &gitSource{url: site.GitURL, branch: site.GitBranch, subdir: site.GitSubdir}
+	deploy, err := s.create(siteID, userID, "git:"+src.branch, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deploy, src, nil
+}
+
+// fetchGit fetches the head of the branch into a scratch repository, records
+// its commit and packs the tree to deploy into an archive next to uploaded
+// ones. The archive then goes through the same checks as an upload.
+func (s *DeployService) fetchGit(deploy *models.Deploy, src *gitSource) (string, error) {
+	s.setPhase(deploy, models.DeployPhaseFetching, 0)
+
+	deploysPath := filepath.Join(s.sitePath(deploy.SiteID), "deploys")
+	if err := os.MkdirAll(deploysPath, 0755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
+
+	repoPath := s.gitWorkPath(deploy.SiteID, deploy.ID)
+	os.RemoveAll(repoPath)
+	defer os.RemoveAll(repoPath)
+
+	if _, err := runGit("", "init", "--quiet", "--bare", repoPath); err != nil {
+		return "", err
+	}
+	if _, err := runGit(repoPath, "fetch", "--quiet", "--depth", "1", "--no-tags", "--", src.url, "refs/heads/"+src.branch); err != nil {
+		return "", err
+	}
+
+	out, err := runGit(repoPath, "log", "-1", "--format=%H%n%s", "FETCH_HEAD")
+	if err != nil {
+		return "", err
+	}
+	sha, message, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	deploy.CommitSHA = sha
+	deploy.CommitMessage = message
+	if err := s.deployRepo.SetCommit(deploy.ID, sha, message); err != nil {
+		return "", fmt.Errorf("record commit: %w", err)
+	}
+
+	// A tree rather than the commit, so the archive has no pax header
+	tree := "FETCH_HEAD^{tree}"
+	if src.subdir != "" {
+		tree = "FETCH_HEAD:" + src.subdir
+		out, err := runGit(repoPath, "cat-file", "-t", tree)
+		if err != nil || strings.TrimSpace(string(out)) != "tree" {
+			return "", fmt.Errorf("%w: %s", ErrGitSubdirNotFound, src.subdir)
+		}
+	}
+
+	archiveFilename := fmt.Sprintf("%d_%d_git-%s.tar.gz", time.Now().Unix(), deploy.ID, deploy.ShortCommitSHA())
+	if err := s.recordArchive(deploy, archiveFilename); err != nil {
+		return "", err
+	}
+	archivePath := filepath.Join(deploysPath, archiveFilename)
+	if _, err := runGit(repoPath, "archive", "--format=tar.gz", "--prefix="+gitArchivePrefix, "-o", archivePath, tree); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	if err := s.checkGitArchive(deploy.SiteID, archivePath); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	return archivePath, nil
+}
+
+// checkGitArchive applies the archive limits of an upload to a packed checkout.
+func (s *DeployService) checkGitArchive(siteID int64, archivePath string) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return fmt.Errorf("stat archive: %w", err)
+	}
+
+	limits := s.siteLimits(siteID)
+	if info.Size() > limits.MaxArchiveSize {
+		return ErrArchiveTooLarge
+	}
+
+	// The archive is already on disk and counted in the usage
+	remaining, err := quotaRemaining(s.config.Sites.Path, siteID, limits)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// gitWorkPath is the scratch repository a git deploy fetches into.
+func (s *DeployService) gitWorkPath(siteID, deployID int64) string {
+	return filepath.Join(s.sitePath(siteID), "deploys", fmt.Sprintf(".git-%d", deployID))
+}
+
+// runGit runs git non-interactively with a timeout. Credentials have to come
+// from the URL or the ssh keys of the panel user; git never prompts.
+func runGit(dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=file:git:http:https:ssh",
+		"GIT_SSH_COMMAND=ssh -o BatchMode=yes",
+	)
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("%w: timed out after %v", ErrGitFailed, gitTimeout)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return output, fmt.Errorf("%w: %s", ErrGitFailed, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return output, fmt.Errorf("%w: %v", ErrGitFailed, err)
+	}
+	return output, nil
+}
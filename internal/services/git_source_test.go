@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"micropanel/internal/models"
+)
+
+// git runs a git command in dir for a test.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gitRepo commits files to the main branch of a new bare repository and
+// returns its path and the commit. A file whose content starts with "->" is
+// a symlink to the rest.
+func gitRepo(t *testing.T, message string, files map[string]string) (string, string) {
+	t.Helper()
+	bare, work := t.TempDir(), t.TempDir()
+	git(t, bare, "init", "--quiet", "--bare")
+	git(t, work, "init", "--quiet", "--initial-branch=main")
+	for name, content := range files {
+		path := filepath.Join(work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			err = os.Symlink(target, path)
+		} else {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, work, "add", "--all")
+	git(t, work, "commit", "--quiet", "-m", message)
+	git(t, work, "push", "--quiet", bare, "main")
+	return bare, git(t, work, "rev-parse", "HEAD")
+}
+
+// linkGit links the site to a branch of a repository.
+func (env *deployTestEnv) linkGit(t *testing.T, url, branch, subdir string) {
+	t.Helper()
+	env.site.GitURL, env.site.GitBranch, env.site.GitSubdir = url, branch, subdir
+	if err := env.sites.Update(env.site); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeployService_DeployGit(t *testing.T) {
+	env := newDeployTestEnv(t)
+	url, sha := gitRepo(t, "Build the site\n\nWith a body.", map[string]string{
+		"README.md":        "not served",
+		"dist/index.html":  "v1",
+		"dist/css/app.css": "body {}",
+	})
+	env.linkGit(t, url, "main", "dist")
+
+	deploy, err := env.service.DeployGit(env.site.ID, env.owner.ID, DeployOptions{})
+	if err != nil {
+		t.Fatalf("DeployGit() error = %v", err)
+	}
+	if deploy.Filename != "git:main" || deploy.Status != models.DeployStatusSuccess {
+		t.Errorf("DeployGit() = %s %s, want a successful git:main deploy", deploy.Filename, deploy.Status)
+	}
+
+	saved, _ := env.deploys.GetByID(deploy.ID)
+	if saved.CommitSHA != sha || saved.CommitMessage != "Build the site" {
+		t.Errorf("recorded commit = %s %q, want %s %q", saved.CommitSHA, saved.CommitMessage, sha, "Build the site")
+	}
+
+	// The subdirectory is the root of the release
+	current := siteCurrentPath(env.config.Sites.Path, env.site.ID)
+	if content, _ := os.ReadFile(filepath.Join(current, "index.html")); string(content) != "v1" {
+		t.Errorf("index.html = %q, want v1", content)
+	}
+	if _, err := os.Stat(filepath.Join(current, "css", "app.css")); err != nil {
+		t.Errorf("css/app.css not deployed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(current, "README.md")); !os.IsNotExist(err) {
+		t.Errorf("README.md outside the subdirectory deployed, stat error = %v", err)
+	}
+
+	// The scratch repository is removed
+	if _, err := os.Stat(env.service.gitWorkPath(env.site.ID, deploy.ID)); !os.IsNotExist(err) {
+		t.Errorf("scratch repository left behind, stat error = %v", err)
+	}
+}
+
+func TestDeployService_DeployGitFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		branch    string
+		subdir    string
+		limits    func(*deployTestEnv)
+		wantErr   error
+		wantPhase models.DeployPhase
+	}{
+		{
+			name:      "missing branch",
+			files:     map[string]string{"index.html": "v1"},
+			branch:    "gone",
+			wantErr:   ErrGitFailed,
+			wantPhase: models.DeployPhaseFetching,
+		},
+		{
+			name:      "missing subdirectory",
+			files:     map[string]string{"index.html": "v1"},
+			branch:    "main",
+			subdir:    "dist",
+			wantErr:   ErrGitSubdirNotFound,
+			wantPhase: models.DeployPhaseFetching,
+		},
+		{
+			name:      "symlink",
+			files:     map[string]string{"index.html": "v1", "passwd": "->/etc/passwd"},
+			branch:    "main",
+			wantErr:   ErrSymlinkDetected,
+			wantPhase: models.DeployPhaseExtracting,
+		},
+		{
+			name:      "file too large",
+			files:     map[string]string{"index.html": "v1", "big.bin": strings.Repeat("a", 2048)},
+			branch:    "main",
+			limits:    func(env *deployTestEnv) { env.config.Limits.MaxFileSize = 1024 },
+			wantErr:   ErrFileTooLarge,
+			wantPhase: models.DeployPhaseExtracting,
+		},
+		{
+			name:      "archive too large",
+			files:     map[string]string{"index.html": "v1"},
+			branch:    "main",
+			limits:    func(env *deployTestEnv) { env.config.Limits.MaxZipSize = 10 },
+			wantErr:   ErrArchiveTooLarge,
+			wantPhase: models.DeployPhaseFetching,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newDeployTestEnv(t)
+			url, _ := gitRepo(t, "Build the site", tt.files)
+			env.linkGit(t, url, tt.branch, tt.subdir)
+			if tt.limits != nil {
+				tt.limits(env)
+			}
+
+			deploy, err := env.service.DeployGit(env.site.ID, env.owner.ID, DeployOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeployGit() error = %v, want %v", err, tt.wantErr)
+			}
+			saved, _ := env.deploys.GetByID(deploy.ID)
+			if saved.Status != models.DeployStatusFailed || saved.Phase != tt.wantPhase {
+				t.Errorf("deploy = %s in %s, want failed in %s", saved.Status, saved.Phase, tt.wantPhase)
+			}
+			if got := env.current(); got != 0 {
+				t.Errorf("current release = %d, want none", got)
+			}
+		})
+	}
+}
+
+func TestDeployService_DeployGitWithoutSource(t *testing.T) {
+	env := newDeployTestEnv(t)
+	if _, err := env.service.DeployGit(env.site.ID, env.owner.ID, DeployOptions{}); !errors.Is(err, ErrNoGitSource) {
+		t.Errorf("DeployGit() error = %v, want %v", err, ErrNoGitSource)
+	}
+}
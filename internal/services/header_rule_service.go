@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/validators"
+)
+
+const (
+	// MaxHeaderRules bounds the header rules of a site.
+	MaxHeaderRules = 50
+	// MaxRuleHeaders bounds the headers a rule sets.
+	MaxRuleHeaders = 10
+	// MaxRuleExtensions bounds the extensions a rule matches.
+	MaxRuleExtensions = 30
+)
+
+var extensionRegex = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+
+var (
+	ErrInvalidHeaderMatch  = errors.New("match type must be all, prefix, exact or extension")
+	ErrInvalidRulePath     = errors.New("rule path must be a URL path starting with /")
+	ErrInvalidExtension    = errors.New("extensions must be letters and digits, separated by spaces")
+	ErrNoRuleHeaders       = errors.New("a header rule needs at least one header")
+	ErrReservedHeader      = errors.New("header is set by nginx and cannot be changed by a rule")
+	ErrDuplicateRuleHeader = errors.New("a rule sets each header once")
+	ErrUnknownPreset       = errors.New("unknown header rule preset")
+	ErrTooManyHeaderRules  = fmt.Errorf("a site can have at most %d header rules", MaxHeaderRules)
+	ErrTooManyRuleHeaders  = fmt.Errorf("a header rule sets at most %d headers", MaxRuleHeaders)
+)
+
+// reservedHeaders are managed by nginx itself: adding them would duplicate
+// or break the response framing.
+var reservedHeaders = []string{
+	"Connection", "Content-Encoding", "Content-Length", "Date", "Keep-Alive",
+	"Location", "Server", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// HeaderRulePreset is a ready-made header rule.
+type HeaderRulePreset struct {
+	Name  string
+	Title string
+	Rule  models.HeaderRule
+}
+
+// HeaderRulePresets are the presets offered in the panel and the API.
+var HeaderRulePresets = []HeaderRulePreset{
+	{
+		Name:  "immutable-assets",
+		Title: "Immutable hashed assets",
+		Rule: models.HeaderRule{
+			MatchType: models.HeaderMatchExtension,
+			Match:     "css js mjs woff woff2 ttf otf png jpg jpeg gif svg webp avif ico wasm",
+			Headers:   []models.ResponseHeader{{Name: "Cache-Control", Value: "public, max-age=31536000, immutable"}},
+		},
+	},
+	{
+		Name:  "no-cache-html",
+		Title: "No-cache HTML",
+		Rule: models.HeaderRule{
+			MatchType: models.HeaderMatchExtension,
+			Match:     "html htm",
+			Headers:   []models.ResponseHeader{{Name: "Cache-Control", Value: "no-cache"}},
+		},
+	},
+	{
+		Name:  "noindex",
+		Title: "Keep out of search engines",
+		Rule: models.HeaderRule{
+			MatchType: models.HeaderMatchAll,
+			Headers:   []models.ResponseHeader{{Name: "X-Robots-Tag", Value: "noindex, nofollow"}},
+		},
+	},
+}
+
+// HeaderRulePresetRule returns a copy of the rule of a preset.
+func HeaderRulePresetRule(name string) (*models.HeaderRule, error) {
+	for _, p := range HeaderRulePresets {
+		if p.Name == name {
+			rule := p.Rule
+			rule.Headers = slices.Clone(p.Rule.Headers)
+			return &rule, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, name)
+}
+
+// HeaderRuleService keeps the ordered response header rules of sites.
+type HeaderRuleService struct {
+	ruleRepo *repository.HeaderRuleRepository
+}
+
+func NewHeaderRuleService(ruleRepo *repository.HeaderRuleRepository) *HeaderRuleService {
+	return &HeaderRuleService{ruleRepo: ruleRepo}
+}
+
+func (s *HeaderRuleService) ListBySite(siteID int64) ([]*models.HeaderRule, error) {
+	return s.ruleRepo.ListBySite(siteID)
+}
+
+func (s *HeaderRuleService) GetByID(id int64) (*models.HeaderRule, error) {
+	return s.ruleRepo.GetByID(id)
+}
+
+// Create adds a rule after the other rules of a site.
+func (s *HeaderRuleService) Create(siteID int64, rule *models.HeaderRule) error {
+	if err := normalizeHeaderRule(rule); err != nil {
+		return err
+	}
+
+	existing, err := s.ruleRepo.ListBySite(siteID)
+	if err != nil {
+		return err
+	}
+	if len(existing) >= MaxHeaderRules {
+		return ErrTooManyHeaderRules
+	}
+
+	rule.SiteID = siteID
+	return s.ruleRepo.Create(rule)
+}
+
+// Replace sets the rules of a site, in the given order; an empty list
+// removes them.
+func (s *HeaderRuleService) Replace(siteID int64, rules []*models.HeaderRule) error {
+	if len(rules) > MaxHeaderRules {
+		return ErrTooManyHeaderRules
+	}
+	for _, rule := range rules {
+		if err := normalizeHeaderRule(rule); err != nil {
+			return err
+		}
+	}
+	return s.ruleRepo.ReplaceForSite(siteID, rules)
+}
+
+// Move shifts a rule of a site one place up or down.
+func (s *HeaderRuleService) Move(siteID, ruleID int64, up bool) error {
+	rules, err := s.ruleRepo.ListBySite(siteID)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(rules, func(r *models.HeaderRule) bool { return r.ID == ruleID })
+	if i < 0 {
+		return repository.ErrNotFound
+	}
+	j := i + 1
+	if up {
+		j = i - 1
+	}
+	if j < 0 || j >= len(rules) {
+		return nil
+	}
+	rules[i], rules[j] = rules[j], rules[i]
+
+	ids := make([]int64, len(rules))
+	for k, r := range rules {
+		ids[k] = r.ID
+	}
+	return s.ruleRepo.SetPositions(siteID, ids)
+}
+
+func (s *HeaderRuleService) Delete(id int64) error {
+	return s.ruleRepo.Delete(id)
+}
+
+// normalizeHeaderRule checks a rule and writes it the way nginx expects:
+// lowercase extensions without dots, canonical header names and values
+// without surrounding spaces.
+func normalizeHeaderRule(rule *models.HeaderRule) error {
+	switch rule.MatchType {
+	case models.HeaderMatchAll:
+		rule.Match = ""
+	case models.HeaderMatchPrefix, models.HeaderMatchExact:
+		rule.Match = strings.TrimSpace(rule.Match)
+		if err := validators.ValidatePath(rule.Match); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRulePath, rule.Match)
+		}
+	case models.HeaderMatchExtension:
+		var extensions []string
+		for _, ext := range strings.FieldsFunc(strings.ToLower(rule.Match), func(r rune) bool { return r == ' ' || r == ',' }) {
+			ext = strings.TrimPrefix(strings.TrimPrefix(ext, "*"), ".")
+			if !extensionRegex.MatchString(ext) {
+				return fmt.Errorf("%w: %s", ErrInvalidExtension, ext)
+			}
+			if !slices.Contains(extensions, ext) {
+				extensions = append(extensions, ext)
+			}
+		}
+		if len(extensions) == 0 || len(extensions) > MaxRuleExtensions {
+			return ErrInvalidExtension
+		}
+		rule.Match = strings.Join(extensions, " ")
+	default:
+		return ErrInvalidHeaderMatch
+	}
+
+	if len(rule.Headers) == 0 {
+		return ErrNoRuleHeaders
+	}
+	if len(rule.Headers) > MaxRuleHeaders {
+		return ErrTooManyRuleHeaders
+	}
+	seen := make(map[string]bool)
+	for i := range rule.Headers {
+		h := &rule.Headers[i]
+		h.Name = strings.TrimSpace(h.Name)
+		h.Value = strings.TrimSpace(h.Value)
+		if err := validators.ValidateHeaderName(h.Name); err != nil {
+			return fmt.Errorf("%w: %q", err, h.Name)
+		}
+		h.Name = http.CanonicalHeaderKey(h.Name)
+		if slices.Contains(reservedHeaders, h.Name) {
+			return fmt.Errorf("%w: %s", ErrReservedHeader, h.Name)
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("%w: %s", ErrDuplicateRuleHeader, h.Name)
+		}
+		seen[h.Name] = true
+		if err := validators.ValidateHeaderValue(h.Value); err != nil {
+			return fmt.Errorf("%w for %s", err, h.Name)
+		}
+	}
+	return nil
+}
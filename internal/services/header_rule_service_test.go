@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"micropanel/internal/models"
+	"micropanel/internal/validators"
+)
+
+func TestNormalizeHeaderRule(t *testing.T) {
+	rule := &models.HeaderRule{
+		MatchType: models.HeaderMatchExtension,
+		Match:     " *.CSS, .js js  woff2",
+		Headers: []models.ResponseHeader{
+			{Name: " cache-control ", Value: " public, max-age=31536000, immutable "},
+			{Name: "content-security-policy", Value: "default-src 'self'; img-src 'self' data:"},
+		},
+	}
+	if err := normalizeHeaderRule(rule); err != nil {
+		t.Fatalf("normalizeHeaderRule() error = %v", err)
+	}
+	if rule.Match != "css js woff2" {
+		t.Errorf("Match = %q", rule.Match)
+	}
+	want := []models.ResponseHeader{
+		{Name: "Cache-Control", Value: "public, max-age=31536000, immutable"},
+		{Name: "Content-Security-Policy", Value: "default-src 'self'; img-src 'self' data:"},
+	}
+	if !reflect.DeepEqual(rule.Headers, want) {
+		t.Errorf("Headers = %+v, want %+v", rule.Headers, want)
+	}
+
+	all := &models.HeaderRule{MatchType: models.HeaderMatchAll, Match: "/ignored", Headers: []models.ResponseHeader{{Name: "X-Frame-Options"}}}
+	if err := normalizeHeaderRule(all); err != nil || all.Match != "" {
+		t.Errorf("normalizeHeaderRule() of a rule for all requests = %q, %v", all.Match, err)
+	}
+
+	header := func(name, value string) []models.ResponseHeader {
+		return []models.ResponseHeader{{Name: name, Value: value}}
+	}
+	tests := []struct {
+		name string
+		rule models.HeaderRule
+		want error
+	}{
+		{"unknown match", models.HeaderRule{MatchType: "regex", Match: ".*", Headers: header("X-A", "1")}, ErrInvalidHeaderMatch},
+		{"relative path", models.HeaderRule{MatchType: models.HeaderMatchPrefix, Match: "assets/", Headers: header("X-A", "1")}, ErrInvalidRulePath},
+		{"path injection", models.HeaderRule{MatchType: models.HeaderMatchExact, Match: `/a" "x`, Headers: header("X-A", "1")}, ErrInvalidRulePath},
+		{"bad extension", models.HeaderRule{MatchType: models.HeaderMatchExtension, Match: "js|css", Headers: header("X-A", "1")}, ErrInvalidExtension},
+		{"no extension", models.HeaderRule{MatchType: models.HeaderMatchExtension, Match: " ", Headers: header("X-A", "1")}, ErrInvalidExtension},
+		{"no headers", models.HeaderRule{MatchType: models.HeaderMatchAll}, ErrNoRuleHeaders},
+		{"reserved header", models.HeaderRule{MatchType: models.HeaderMatchAll, Headers: header("content-length", "0")}, ErrReservedHeader},
+		{"header injection", models.HeaderRule{MatchType: models.HeaderMatchAll, Headers: header("X-A", "1\r\nSet-Cookie: a=b")}, validators.ErrInvalidHeader},
+		{"quote injection", models.HeaderRule{MatchType: models.HeaderMatchAll, Headers: header("X-A", `1" always; include /etc/passwd; #`)}, validators.ErrInvalidHeader},
+		{"bad name", models.HeaderRule{MatchType: models.HeaderMatchAll, Headers: header("X A", "1")}, validators.ErrInvalidHeaderName},
+		{"duplicate header", models.HeaderRule{MatchType: models.HeaderMatchAll, Headers: append(header("x-a", "1"), header("X-A", "2")...)}, ErrDuplicateRuleHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := normalizeHeaderRule(&tt.rule); !errors.Is(err, tt.want) {
+				t.Errorf("normalizeHeaderRule() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRulePresets(t *testing.T) {
+	for _, p := range HeaderRulePresets {
+		rule, err := HeaderRulePresetRule(p.Name)
+		if err != nil {
+			t.Fatalf("HeaderRulePresetRule(%q) error = %v", p.Name, err)
+		}
+		if err := normalizeHeaderRule(rule); err != nil {
+			t.Errorf("preset %q is not a valid rule: %v", p.Name, err)
+		}
+	}
+	if _, err := HeaderRulePresetRule("cache-everything"); !errors.Is(err, ErrUnknownPreset) {
+		t.Errorf("HeaderRulePresetRule() of an unknown preset error = %v", err)
+	}
+}
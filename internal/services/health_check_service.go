@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const (
+	DefaultHealthCheckHTTP  = "127.0.0.1:80"
+	DefaultHealthCheckHTTPS = "127.0.0.1:443"
+
+	MaxHealthChecks     = 20
+	healthCheckTimeout  = 10 * time.Second
+	healthCheckBodySize = 1024 * 1024 // bytes searched for BodyContains
+)
+
+var (
+	ErrHealthCheckFailed  = errors.New("health check failed")
+	ErrInvalidCheckPath   = errors.New("health check path must be a URL path starting with /")
+	ErrInvalidCheckStatus = errors.New("expected status must be between 100 and 599")
+	ErrTooManyChecks      = fmt.Errorf("a site can have at most %d health checks", MaxHealthChecks)
+)
+
+// HealthCheckService stores the health checks of sites and runs them
+// against the local nginx after a deploy.
+type HealthCheckService struct {
+	config    *config.Config
+	checkRepo *repository.HealthCheckRepository
+	siteRepo  *repository.SiteRepository
+}
+
+func NewHealthCheckService(cfg *config.Config, checkRepo *repository.HealthCheckRepository, siteRepo *repository.SiteRepository) *HealthCheckService {
+	return &HealthCheckService{
+		config:    cfg,
+		checkRepo: checkRepo,
+		siteRepo:  siteRepo,
+	}
+}
+
+func (s *HealthCheckService) ListBySite(siteID int64) ([]*models.HealthCheck, error) {
+	return s.checkRepo.ListBySite(siteID)
+}
+
+func (s *HealthCheckService) GetByID(id int64) (*models.HealthCheck, error) {
+	return s.checkRepo.GetByID(id)
+}
+
+// Create adds a check to a site. An expected status of 0 means 200.
+func (s *HealthCheckService) Create(siteID int64, path string, expectedStatus int, bodyContains string) (*models.HealthCheck, error) {
+	check := &models.HealthCheck{SiteID: siteID, Path: path, ExpectedStatus: expectedStatus, BodyContains: bodyContains}
+	if err := normalizeHealthCheck(check); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.checkRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxHealthChecks {
+		return nil, ErrTooManyChecks
+	}
+
+	if err := s.checkRepo.Create(check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// Replace sets the checks of a site; an empty list disables them.
+func (s *HealthCheckService) Replace(siteID int64, checks []*models.HealthCheck) error {
+	if len(checks) > MaxHealthChecks {
+		return ErrTooManyChecks
+	}
+	for _, check := range checks {
+		if err := normalizeHealthCheck(check); err != nil {
+			return err
+		}
+	}
+	return s.checkRepo.ReplaceForSite(siteID, checks)
+}
+
+func (s *HealthCheckService) Delete(id int64) error {
+	return s.checkRepo.Delete(id)
+}
+
+func normalizeHealthCheck(check *models.HealthCheck) error {
+	if check.ExpectedStatus == 0 {
+		check.ExpectedStatus = http.StatusOK
+	}
+	if check.ExpectedStatus < 100 || check.ExpectedStatus > 599 {
+		return ErrInvalidCheckStatus
+	}
+
+	// "//host" would be read as a host, not a path
+	path := check.Path
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || len(path) > 2048 {
+		return ErrInvalidCheckPath
+	}
+	if strings.ContainsFunc(path, func(r rune) bool { return r < 0x20 || r == 0x7f || r == ' ' }) {
+		return ErrInvalidCheckPath
+	}
+	if _, err := url.ParseRequestURI(path); err != nil {
+		return ErrInvalidCheckPath
+	}
+	return nil
+}
+
+// Run performs the checks of a site against nginx. It returns nil when the
+// site has no checks, and an error wrapping ErrHealthCheckFailed that lists
+// every failed check otherwise.
+func (s *HealthCheckService) Run(siteID int64) error {
+	checks, err := s.checkRepo.ListBySite(siteID)
+	if err != nil {
+		return fmt.Errorf("load health checks: %w", err)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	site, err := s.siteRepo.GetByID(siteID)
+	if err != nil {
+		return fmt.Errorf("get site: %w", err)
+	}
+
+	client := s.client()
+	defer client.CloseIdleConnections()
+
+	var failures []string
+	for _, check := range checks {
+		if msg := runHealthCheck(client, site, check); msg != "" {
+			failures = append(failures, msg)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %s", ErrHealthCheckFailed, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// client returns an HTTP client that sends every request to nginx, whatever
+// the host in the URL, so checks reach the site by its Host header and SNI.
+func (s *HealthCheckService) client() *http.Client {
+	httpAddr := s.config.Nginx.HealthCheckHTTP
+	if httpAddr == "" {
+		httpAddr = DefaultHealthCheckHTTP
+	}
+	httpsAddr := s.config.Nginx.HealthCheckHTTPS
+	if httpsAddr == "" {
+		httpsAddr = DefaultHealthCheckHTTPS
+	}
+
+	dialer := &net.Dialer{Timeout: healthCheckTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasSuffix(addr, ":443") {
+				return dialer.DialContext(ctx, network, httpsAddr)
+			}
+			return dialer.DialContext(ctx, network, httpAddr)
+		},
+		// nginx is reached on a local address; the certificate is not what
+		// is being checked and may be a staging or freshly issued one
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   healthCheckTimeout,
+		// A redirect is a response like any other, it can be expected
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// runHealthCheck performs one check and describes its failure, or returns
+// an empty string when it passed.
+func runHealthCheck(client *http.Client, site *models.Site, check *models.HealthCheck) string {
+	scheme := "http"
+	if site.SSLEnabled {
+		scheme = "https"
+	}
+
+	resp, err := client.Get(scheme + "://" + site.Name + check.Path)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Sprintf("GET %s: %v", check.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != check.ExpectedStatus {
+		return fmt.Sprintf("GET %s: status %d, want %d", check.Path, resp.StatusCode, check.ExpectedStatus)
+	}
+
+	if check.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, healthCheckBodySize))
+		if err != nil {
+			return fmt.Sprintf("GET %s: read body: %v", check.Path, err)
+		}
+		if !strings.Contains(string(body), check.BodyContains) {
+			return fmt.Sprintf("GET %s: body does not contain %q", check.Path, check.BodyContains)
+		}
+	}
+
+	return ""
+}
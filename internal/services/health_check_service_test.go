@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+)
+
+func TestNormalizeHealthCheck(t *testing.T) {
+	tests := []struct {
+		path    string
+		status  int
+		wantErr error
+	}{
+		{"/", 0, nil},
+		{"/health?full=1", 204, nil},
+		{"/old", 301, nil},
+		{"", 200, ErrInvalidCheckPath},
+		{"health", 200, ErrInvalidCheckPath},
+		{"//evil.com/", 200, ErrInvalidCheckPath},
+		{"/a b", 200, ErrInvalidCheckPath},
+		{"/a\r\nHost: x", 200, ErrInvalidCheckPath},
+		{"/", 99, ErrInvalidCheckStatus},
+		{"/", 600, ErrInvalidCheckStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			check := &models.HealthCheck{Path: tt.path, ExpectedStatus: tt.status}
+			err := normalizeHealthCheck(check)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("normalizeHealthCheck(%q, %d) = %v, want %v", tt.path, tt.status, err, tt.wantErr)
+			}
+			if err == nil && tt.status == 0 && check.ExpectedStatus != http.StatusOK {
+				t.Errorf("ExpectedStatus = %d, want default %d", check.ExpectedStatus, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte("<h1>Welcome</h1>"))
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.Nginx.HealthCheckHTTP = strings.TrimPrefix(server.URL, "http://")
+	s := &HealthCheckService{config: cfg}
+	client := s.client()
+	site := &models.Site{Name: "example.com"}
+
+	tests := []struct {
+		check    models.HealthCheck
+		wantFail string
+	}{
+		{models.HealthCheck{Path: "/", ExpectedStatus: 200}, ""},
+		{models.HealthCheck{Path: "/", ExpectedStatus: 200, BodyContains: "Welcome"}, ""},
+		{models.HealthCheck{Path: "/old", ExpectedStatus: 301}, ""},
+		{models.HealthCheck{Path: "/missing", ExpectedStatus: 200}, "status 404, want 200"},
+		{models.HealthCheck{Path: "/", ExpectedStatus: 200, BodyContains: "Goodbye"}, `body does not contain "Goodbye"`},
+	}
+
+	for _, tt := range tests {
+		got := runHealthCheck(client, site, &tt.check)
+		if tt.wantFail == "" && got != "" {
+			t.Errorf("check %s failed: %s", tt.check.Path, got)
+		}
+		if tt.wantFail != "" && !strings.Contains(got, tt.wantFail) {
+			t.Errorf("check %s = %q, want failure containing %q", tt.check.Path, got, tt.wantFail)
+		}
+	}
+
+	for _, host := range hosts {
+		if host != "example.com" {
+			t.Errorf("request sent with Host %q, want example.com", host)
+		}
+	}
+}
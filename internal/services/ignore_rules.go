@@ -0,0 +1,244 @@
+package services
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the file in the root of a release listing, in gitignore
+// syntax, the paths that are removed before the release is published.
+const IgnoreFileName = ".micropanelignore"
+
+// maxIgnoreFileSize bounds the ignore file read from a release.
+const maxIgnoreFileSize = 64 * 1024
+
+// ignoreRule is one pattern of a gitignore-style list.
+type ignoreRule struct {
+	pattern string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are patterns in gitignore syntax: "*" and "?" within a path
+// segment, "**" across segments, a leading "/" or a "/" in the middle
+// anchors to the root, a trailing "/" matches directories only and "!"
+// re-includes. The last matching pattern wins.
+type ignoreRules []ignoreRule
+
+// parseIgnoreRules parses gitignore-style lines. Invalid patterns are
+// skipped and the first of them is reported.
+func parseIgnoreRules(lines []string) (ignoreRules, error) {
+	var rules ignoreRules
+	var firstErr error
+	for _, line := range lines {
+		rule, ok, err := compileIgnoreRule(line)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid pattern %q: %w", strings.TrimSpace(line), err)
+			}
+			continue
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, firstErr
+}
+
+func compileIgnoreRule(line string) (ignoreRule, bool, error) {
+	line = strings.TrimRight(line, "\r")
+	// Trailing spaces are dropped unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+
+	rule := ignoreRule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += ignorePatternRegexp(line) + "$"
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignoreRule{}, false, err
+	}
+	rule.re = re
+	return rule, true, nil
+}
+
+// ignorePatternRegexp translates the wildcards of a pattern to a regular
+// expression.
+func ignorePatternRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		segmentStart := i == 0 || pattern[i-1] == '/'
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*' && segmentStart:
+			i++
+			switch {
+			case i+1 == len(pattern):
+				b.WriteString(".*")
+			case pattern[i+1] == '/':
+				i++
+				b.WriteString("(?:.*/)?")
+			default:
+				b.WriteString("[^/]*")
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// match returns the last rule matching a slash-separated path relative to
+// the root, or nil when none does or the last match is a negation.
+func (r ignoreRules) match(name string, isDir bool) *ignoreRule {
+	var matched *ignoreRule
+	for i := range r {
+		rule := &r[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(name) {
+			matched = rule
+		}
+	}
+	if matched != nil && matched.negate {
+		return nil
+	}
+	return matched
+}
+
+// excludes returns the rule that excludes a path, directly or through one
+// of its parent directories. As with git, a file cannot be re-included when
+// its directory is excluded.
+func (r ignoreRules) excludes(name string, isDir bool) (*ignoreRule, string) {
+	if len(r) == 0 {
+		return nil, ""
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] != '/' {
+			continue
+		}
+		if rule := r.match(name[:i], true); rule != nil {
+			return rule, name[:i] + "/"
+		}
+	}
+	if rule := r.match(name, isDir); rule != nil {
+		if isDir {
+			return rule, name + "/"
+		}
+		return rule, name
+	}
+	return nil, ""
+}
+
+// readIgnoreRules parses the content of an ignore file. Invalid patterns are
+// logged and skipped, as git does.
+func readIgnoreRules(data []byte) ignoreRules {
+	rules, err := parseIgnoreRules(strings.Split(string(data), "\n"))
+	if err != nil {
+		slog.Warn("skipping invalid pattern in "+IgnoreFileName, "error", err)
+	}
+	return rules
+}
+
+// applyIgnoreFile removes the paths listed in the ignore file of a release,
+// and the ignore file itself, so they are not published.
+func applyIgnoreFile(releasePath string) error {
+	ignorePath := filepath.Join(releasePath, IgnoreFileName)
+	info, err := os.Lstat(ignorePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxIgnoreFileSize {
+		return fmt.Errorf("%s must be a file of at most %d bytes", IgnoreFileName, maxIgnoreFileSize)
+	}
+
+	data, err := os.ReadFile(ignorePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(ignorePath); err != nil {
+		return err
+	}
+
+	rules := readIgnoreRules(data)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return filepath.WalkDir(releasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == releasePath {
+			return nil
+		}
+		rel, err := filepath.Rel(releasePath, path)
+		if err != nil {
+			return err
+		}
+		// Directories are visited before their contents, so matching the
+		// path alone is enough
+		if rules.match(filepath.ToSlash(rel), d.IsDir()) == nil {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
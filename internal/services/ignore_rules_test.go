@@ -0,0 +1,113 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := parseIgnoreRules([]string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"node_modules/",
+		"docs/**/*.md",
+		"src/*.map",
+		"secret?.txt",
+		"\\#literal",
+	})
+	if err != nil {
+		t.Fatalf("parseIgnoreRules() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"logs/app.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build/app.js", false, true},
+		{"assets/build", true, false},
+		{"node_modules", true, true},
+		{"web/node_modules/x.js", false, true},
+		{"node_modules", false, false},
+		{"docs/a.md", false, true},
+		{"docs/a/b/c.md", false, true},
+		{"other/docs/a.md", false, false},
+		{"src/app.js.map", false, true},
+		{"src/lib/app.js.map", false, false},
+		{"secret1.txt", false, true},
+		{"secret10.txt", false, false},
+		{"#literal", false, true},
+		{"index.html", false, false},
+	}
+
+	for _, tt := range tests {
+		rule, _ := rules.excludes(tt.name, tt.isDir)
+		if got := rule != nil; got != tt.want {
+			t.Errorf("excludes(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreRules_ParentExcluded(t *testing.T) {
+	rules, _ := parseIgnoreRules([]string{".git/", "!.git/config"})
+
+	rule, matched := rules.excludes(".git/config", false)
+	if rule == nil || matched != ".git/" {
+		t.Errorf("excludes(.git/config) = %v, %q, want the .git/ rule matching %q", rule, matched, ".git/")
+	}
+}
+
+func TestParseIgnoreRules_Invalid(t *testing.T) {
+	rules, err := parseIgnoreRules([]string{"*.php", "[z-a]", ".env"})
+	if err == nil {
+		t.Fatal("parseIgnoreRules() with an invalid class error = nil")
+	}
+	if len(rules) != 2 {
+		t.Errorf("parseIgnoreRules() kept %d rules, want 2", len(rules))
+	}
+}
+
+func TestApplyIgnoreFile(t *testing.T) {
+	release := t.TempDir()
+	files := []string{"index.html", "notes.md", "src/app.ts", "assets/app.js", "assets/app.js.map"}
+	for _, name := range files {
+		path := filepath.Join(release, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	os.WriteFile(filepath.Join(release, IgnoreFileName), []byte("*.md\nsrc/\n*.map\n"), 0644)
+
+	if err := applyIgnoreFile(release); err != nil {
+		t.Fatalf("applyIgnoreFile() error = %v", err)
+	}
+
+	var got []string
+	filepath.WalkDir(release, func(path string, d os.DirEntry, err error) error {
+		if err == nil && path != release {
+			rel, _ := filepath.Rel(release, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(got)
+
+	want := []string{"assets", "assets/app.js", "index.html"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("release after applyIgnoreFile() = %q, want %q", got, want)
+	}
+
+	// Releases without an ignore file are left alone
+	if err := applyIgnoreFile(release); err != nil {
+		t.Errorf("applyIgnoreFile() without ignore file error = %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// ErrQuotaExceeded is returned when a write would take a site over its disk quota.
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// LimitsService resolves the limits of a site: config defaults, then the
+// overrides of the site owner, then the overrides of the site itself.
+type LimitsService struct {
+	config     *config.Config
+	limitsRepo *repository.LimitsRepository
+	siteRepo   *repository.SiteRepository
+}
+
+func NewLimitsService(cfg *config.Config, limitsRepo *repository.LimitsRepository, siteRepo *repository.SiteRepository) *LimitsService {
+	return &LimitsService{
+		config:     cfg,
+		limitsRepo: limitsRepo,
+		siteRepo:   siteRepo,
+	}
+}
+
+// DefaultLimits returns the limits from config. Unset sizes fall back to the
+// built-in maximums.
+func DefaultLimits(cfg *config.Config) *models.Limits {
+	l := &models.Limits{
+		MaxArchiveSize: cfg.Limits.MaxZipSize,
+		MaxFileSize:    cfg.Limits.MaxFileSize,
+		MaxUploadSize:  cfg.Limits.MaxUploadSize,
+		DiskQuota:      cfg.Limits.DiskQuota,
+	}
+	if l.MaxArchiveSize <= 0 {
+		l.MaxArchiveSize = MaxArchiveSize
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = MaxFileSize
+	}
+	if l.MaxUploadSize <= 0 {
+		l.MaxUploadSize = MaxUploadSize
+	}
+	if l.DiskQuota < 0 {
+		l.DiskQuota = 0
+	}
+	return l
+}
+
+// ForSite returns the limits in effect for a site.
+func (s *LimitsService) ForSite(siteID int64) (*models.Limits, error) {
+	site, err := s.siteRepo.GetByID(siteID)
+	if err != nil {
+		return nil, fmt.Errorf("get site: %w", err)
+	}
+
+	limits, err := s.ForUser(site.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	siteOverrides, err := s.limitsRepo.GetForSite(siteID)
+	if err != nil {
+		return nil, fmt.Errorf("get site limits: %w", err)
+	}
+	siteOverrides.Apply(limits)
+
+	return limits, nil
+}
+
+// ForUser returns the limits of sites owned by a user that have no
+// overrides of their own.
+func (s *LimitsService) ForUser(userID int64) (*models.Limits, error) {
+	limits := DefaultLimits(s.config)
+
+	overrides, err := s.limitsRepo.GetForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user limits: %w", err)
+	}
+	overrides.Apply(limits)
+
+	return limits, nil
+}
+
+// Usage returns the disk space taken by a site and its quota.
+func (s *LimitsService) Usage(siteID int64) (*models.DiskUsage, error) {
+	limits, err := s.ForSite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	used, err := siteDiskUsage(s.config.Sites.Path, siteID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DiskUsage{Used: used, Quota: limits.DiskQuota}, nil
+}
+
+func (s *LimitsService) GetSiteOverrides(siteID int64) (*models.LimitOverrides, error) {
+	return s.limitsRepo.GetForSite(siteID)
+}
+
+func (s *LimitsService) SetSiteOverrides(siteID int64, o *models.LimitOverrides) error {
+	if err := validateOverrides(o); err != nil {
+		return err
+	}
+	return s.limitsRepo.SetForSite(siteID, o)
+}
+
+func (s *LimitsService) GetUserOverrides(userID int64) (*models.LimitOverrides, error) {
+	return s.limitsRepo.GetForUser(userID)
+}
+
+func (s *LimitsService) SetUserOverrides(userID int64, o *models.LimitOverrides) error {
+	if err := validateOverrides(o); err != nil {
+		return err
+	}
+	return s.limitsRepo.SetForUser(userID, o)
+}
+
+func validateOverrides(o *models.LimitOverrides) error {
+	for _, v := range []*int64{o.MaxArchiveSize, o.MaxFileSize, o.MaxUploadSize} {
+		if v != nil && *v <= 0 {
+			return errors.New("size limits must be positive")
+		}
+	}
+	if o.DiskQuota != nil && *o.DiskQuota < 0 {
+		return errors.New("disk quota cannot be negative")
+	}
+	return nil
+}
+
+// resolveLimits returns the limits of a site, or the config defaults when no
+// LimitsService is set or the overrides cannot be loaded.
+func resolveLimits(limits *LimitsService, cfg *config.Config, siteID int64) *models.Limits {
+	if limits == nil {
+		return DefaultLimits(cfg)
+	}
+	l, err := limits.ForSite(siteID)
+	if err != nil {
+		slog.Error("failed to resolve site limits, using defaults", "site_id", siteID, "error", err)
+		return DefaultLimits(cfg)
+	}
+	return l
+}
+
+// siteDiskUsage returns the size of the regular files in a site directory:
+// releases, uploaded archives and everything else. Symlinks are not followed
+// and files hardlinked between releases are counted once.
+func siteDiskUsage(sitesPath string, siteID int64) (int64, error) {
+	var used int64
+	seen := make(map[fileID]bool)
+	err := filepath.Walk(siteDir(sitesPath, siteID), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			id := fileID{dev: uint64(st.Dev), ino: st.Ino}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+		used += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("measure disk usage: %w", err)
+	}
+	return used, nil
+}
+
+// fileID identifies a file independent of the links pointing to it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// quotaRemaining returns the bytes a site may still write, or -1 when it has
+// no quota.
+func quotaRemaining(sitesPath string, siteID int64, limits *models.Limits) (int64, error) {
+	if limits.DiskQuota <= 0 {
+		return -1, nil
+	}
+	used, err := siteDiskUsage(sitesPath, siteID)
+	if err != nil {
+		return 0, err
+	}
+	if used >= limits.DiskQuota {
+		return 0, nil
+	}
+	return limits.DiskQuota - used, nil
+}
+
+// checkQuota returns ErrQuotaExceeded if growing a site by size bytes would
+// exceed its quota.
+func checkQuota(sitesPath string, siteID int64, limits *models.Limits, size int64) error {
+	remaining, err := quotaRemaining(sitesPath, siteID, limits)
+	if err != nil {
+		return err
+	}
+	if remaining >= 0 && size > remaining {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
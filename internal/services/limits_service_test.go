@@ -0,0 +1,79 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+)
+
+func TestDefaultLimits(t *testing.T) {
+	cfg := &config.Config{}
+	limits := DefaultLimits(cfg)
+	if limits.MaxArchiveSize != MaxArchiveSize || limits.MaxFileSize != MaxFileSize || limits.MaxUploadSize != MaxUploadSize {
+		t.Errorf("DefaultLimits() of empty config = %+v, want built-in maximums", limits)
+	}
+	if limits.DiskQuota != 0 {
+		t.Errorf("DefaultLimits() DiskQuota = %d, want 0", limits.DiskQuota)
+	}
+
+	cfg.Limits = config.LimitsConfig{MaxZipSize: 1, MaxFileSize: 2, MaxUploadSize: 3, DiskQuota: 4}
+	limits = DefaultLimits(cfg)
+	want := models.Limits{MaxArchiveSize: 1, MaxFileSize: 2, MaxUploadSize: 3, DiskQuota: 4}
+	if *limits != want {
+		t.Errorf("DefaultLimits() = %+v, want %+v", *limits, want)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	sitesPath := t.TempDir()
+	releasePath := siteReleasePath(sitesPath, 1, 1)
+	os.MkdirAll(releasePath, 0755)
+	os.WriteFile(filepath.Join(releasePath, "index.html"), make([]byte, 40), 0644)
+	os.MkdirAll(filepath.Join(siteDir(sitesPath, 1), "deploys"), 0755)
+	os.WriteFile(filepath.Join(siteDir(sitesPath, 1), "deploys", "site.zip"), make([]byte, 30), 0644)
+	switchRelease(siteDir(sitesPath, 1), 1)
+
+	used, err := siteDiskUsage(sitesPath, 1)
+	if err != nil || used != 70 {
+		t.Fatalf("siteDiskUsage() = %d, %v, want 70", used, err)
+	}
+
+	limits := &models.Limits{DiskQuota: 100}
+	if err := checkQuota(sitesPath, 1, limits, 30); err != nil {
+		t.Errorf("checkQuota() within quota error = %v", err)
+	}
+	if err := checkQuota(sitesPath, 1, limits, 31); err != ErrQuotaExceeded {
+		t.Errorf("checkQuota() over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	limits.DiskQuota = 0
+	if err := checkQuota(sitesPath, 1, limits, 1<<40); err != nil {
+		t.Errorf("checkQuota() without quota error = %v", err)
+	}
+
+	// A site without a directory uses nothing
+	if used, err := siteDiskUsage(sitesPath, 2); err != nil || used != 0 {
+		t.Errorf("siteDiskUsage() of missing site = %d, %v, want 0", used, err)
+	}
+}
+
+func TestSiteDiskUsage_Hardlinks(t *testing.T) {
+	sitesPath := t.TempDir()
+	first := siteReleasePath(sitesPath, 1, 1)
+	second := siteReleasePath(sitesPath, 1, 2)
+	os.MkdirAll(first, 0755)
+	os.MkdirAll(second, 0755)
+	os.WriteFile(filepath.Join(first, "app.js"), make([]byte, 50), 0644)
+	if err := os.Link(filepath.Join(first, "app.js"), filepath.Join(second, "app.js")); err != nil {
+		t.Skipf("hardlinks not supported: %v", err)
+	}
+	os.WriteFile(filepath.Join(second, "index.html"), make([]byte, 10), 0644)
+
+	// The linked file is stored once
+	if used, err := siteDiskUsage(sitesPath, 1); err != nil || used != 60 {
+		t.Errorf("siteDiskUsage() = %d, %v, want 60", used, err)
+	}
+}
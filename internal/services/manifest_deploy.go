@@ -0,0 +1,419 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+)
+
+const (
+	// ManifestFilename is recorded as the filename of manifest deploys.
+	ManifestFilename = "manifest"
+
+	// ManifestUploadTimeout is how long a manifest deploy waits for its files
+	// before it is failed and stops blocking other deploys of the site.
+	ManifestUploadTimeout = time.Hour
+)
+
+var (
+	ErrInvalidManifest    = errors.New("invalid manifest")
+	ErrManifestNotWaiting = errors.New("deploy is not waiting for files")
+	ErrManifestUnexpected = errors.New("file is not in the manifest")
+	ErrManifestHash       = errors.New("file does not match its hash in the manifest")
+	ErrManifestIncomplete = errors.New("files missing from the upload")
+	ErrManifestStale      = errors.New("file changed in the current release since the manifest was sent")
+	ErrManifestExpired    = errors.New("files were not uploaded in time")
+)
+
+// deployManifest is kept next to the uploaded archives while a manifest
+// deploy waits for its files.
+type deployManifest struct {
+	Files       map[string]string `json:"files"`        // path -> hex SHA-256
+	Missing     []string          `json:"missing"`      // paths the client has to upload
+	BaseRelease int64             `json:"base_release"` // release unchanged files are linked from, -1 = none
+}
+
+// StartManifest creates a deploy from a manifest of file paths and their
+// SHA-256 hashes. It returns the paths whose content the current release
+// does not have; the client uploads those with UploadManifestFiles and the
+// other files are hardlinked from the current release. The deploy always
+// goes to production, opts.Target is not used.
+func (s *DeployService) StartManifest(siteID, userID int64, files map[string]string, opts DeployOptions) (*models.Deploy, []string, error) {
+	normalized, err := s.validateManifest(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkUnsigned(siteID); err != nil {
+		return nil, nil, err
+	}
+
+	// Manifest deploys build on the live release, so they go to production
+	opts.Target = models.DeployTargetProduction
+	deploy, err := s.create(siteID, userID, ManifestFilename, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &deployManifest{Files: normalized, BaseRelease: -1}
+	if base, err := currentRelease(s.sitePath(siteID)); err == nil {
+		manifest.BaseRelease = base
+	}
+	manifest.Missing = s.missingFiles(siteID, manifest)
+
+	if err := s.saveManifest(deploy, manifest); err != nil {
+		s.fail(deploy, err)
+		return deploy, nil, err
+	}
+
+	s.setPhase(deploy, models.DeployPhaseUploading, 0)
+	return deploy, manifest.Missing, nil
+}
+
+// UploadManifestFiles receives the files of a manifest deploy from next,
+// which returns the path and content of one file per call and io.EOF at the
+// end, then builds the release. Unless wait is set the build is queued like
+// Enqueue does. If the upload is rejected the deploy keeps waiting, so the
+// client can correct it and upload again.
+func (s *DeployService) UploadManifestFiles(deployID int64, next func() (string, io.Reader, error), wait bool) (*models.Deploy, error) {
+	deploy, err := s.claimManifest(deployID)
+	if err != nil {
+		return deploy, err
+	}
+
+	manifest, err := s.loadManifest(deploy)
+	if err != nil {
+		s.discardManifest(deploy)
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	if err := s.stageManifestFiles(deploy, manifest, next); err != nil {
+		s.setPhase(deploy, models.DeployPhaseUploading, 0)
+		return deploy, err
+	}
+
+	build := s.manifestBuilder(deploy.SiteID, deploy.ID, manifest)
+	if wait {
+		defer s.discardManifest(deploy)
+		return deploy, s.run(deploy, build)
+	}
+
+	s.setPhase(deploy, models.DeployPhaseQueued, 0)
+
+	queued := *deploy
+	job := deployJob{deploy: &queued, build: build, cleanup: func() { s.discardManifest(&queued) }}
+	if err := s.dispatch(job); err != nil {
+		s.discardManifest(deploy)
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	return deploy, nil
+}
+
+// validateManifest checks the paths and hashes of a manifest and returns it
+// with hashes in lower case.
+func (s *DeployService) validateManifest(files map[string]string) (map[string]string, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files", ErrInvalidManifest)
+	}
+	if len(files) > MaxFiles {
+		return nil, ErrTooManyFiles
+	}
+
+	normalized := make(map[string]string, len(files))
+	for name, hash := range files {
+		if name == "" || name == "." || strings.HasPrefix(name, "/") || path.Clean(name) != name {
+			return nil, fmt.Errorf("%w: bad path %q", ErrInvalidManifest, name)
+		}
+		if err := s.validatePath(name); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, name)
+		}
+
+		hash = strings.ToLower(hash)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%w: bad SHA-256 for %s", ErrInvalidManifest, name)
+		}
+		normalized[name] = hash
+	}
+
+	// A file cannot also be the directory of another one
+	for name := range normalized {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := normalized[dir]; ok {
+				return nil, fmt.Errorf("%w: %s is both a file and a directory", ErrInvalidManifest, dir)
+			}
+		}
+	}
+
+	return normalized, nil
+}
+
+// missingFiles returns the paths of the manifest the base release has no
+// identical file for, sorted.
+func (s *DeployService) missingFiles(siteID int64, manifest *deployManifest) []string {
+	missing := []string{}
+	for _, name := range sortedManifestPaths(manifest) {
+		if manifest.BaseRelease < 0 {
+			missing = append(missing, name)
+			continue
+		}
+		src := filepath.Join(s.releasePath(siteID, manifest.BaseRelease), filepath.FromSlash(name))
+		if hash, err := regularFileSHA256(src); err != nil || hash != manifest.Files[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// claimManifest moves a deploy waiting for files to the saving phase, so
+// that only one upload at a time can complete it.
+func (s *DeployService) claimManifest(deployID int64) (*models.Deploy, error) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	deploy, err := s.deployRepo.GetByID(deployID)
+	if err != nil {
+		return nil, err
+	}
+	if deploy.Filename != ManifestFilename || !deploy.IsRunning() || deploy.Phase != models.DeployPhaseUploading {
+		return deploy, ErrManifestNotWaiting
+	}
+
+	s.setPhase(deploy, models.DeployPhaseSaving, 0)
+	return deploy, nil
+}
+
+// stageManifestFiles stores uploaded files next to the manifest, checking
+// them against their hashes and the limits of the site.
+func (s *DeployService) stageManifestFiles(deploy *models.Deploy, manifest *deployManifest, next func() (string, io.Reader, error)) error {
+	limits := s.siteLimits(deploy.SiteID)
+	remaining, err := quotaRemaining(s.config.Sites.Path, deploy.SiteID, limits)
+	if err != nil {
+		return err
+	}
+
+	stagingPath := s.manifestStagingPath(deploy.SiteID, deploy.ID)
+	var total int64
+	for {
+		name, reader, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read upload: %w", err)
+		}
+
+		hash, ok := manifest.Files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrManifestUnexpected, name)
+		}
+
+		size, err := stageManifestFile(filepath.Join(stagingPath, filepath.FromSlash(name)), reader, hash, limits.MaxFileSize)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+
+		total += size
+		if total > limits.MaxArchiveSize {
+			return ErrArchiveTooLarge
+		}
+		if remaining >= 0 && total > remaining {
+			return ErrQuotaExceeded
+		}
+	}
+
+	// Files staged by an earlier, rejected upload count too
+	var absent []string
+	for _, name := range manifest.Missing {
+		if _, err := os.Stat(filepath.Join(stagingPath, filepath.FromSlash(name))); err != nil {
+			absent = append(absent, name)
+		}
+	}
+	if len(absent) > 0 {
+		return fmt.Errorf("%w: %s", ErrManifestIncomplete, strings.Join(absent, ", "))
+	}
+
+	return nil
+}
+
+// stageManifestFile writes an uploaded file and returns its size. The file
+// is removed again if it is too large or does not match the hash.
+func stageManifestFile(dest string, reader io.Reader, hash string, maxSize int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(reader, maxSize+1))
+	f.Close()
+	if err == nil && written > maxSize {
+		err = ErrFileTooLarge
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != hash {
+		err = ErrManifestHash
+	}
+	if err != nil {
+		os.Remove(dest)
+		return 0, err
+	}
+	return written, nil
+}
+
+// manifestBuilder builds a release from the staged uploads and files of the
+// base release that are unchanged. Uploads were checked against the limits
+// when they arrived and hardlinks take no space, so the budget is not used.
+func (s *DeployService) manifestBuilder(siteID, deployID int64, manifest *deployManifest) releaseBuilder {
+	return func(releasePath string, _ *extractBudget, progress func(done, total int)) error {
+		if err := os.MkdirAll(releasePath, 0755); err != nil {
+			return err
+		}
+
+		stagingPath := s.manifestStagingPath(siteID, deployID)
+		basePath := ""
+		if manifest.BaseRelease >= 0 {
+			basePath = s.releasePath(siteID, manifest.BaseRelease)
+		}
+
+		paths := sortedManifestPaths(manifest)
+		for i, name := range paths {
+			rel := filepath.FromSlash(name)
+			dest := filepath.Join(releasePath, rel)
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+
+			staged := filepath.Join(stagingPath, rel)
+			if _, err := os.Lstat(staged); err == nil {
+				if err := os.Rename(staged, dest); err != nil {
+					return fmt.Errorf("move %s: %w", name, err)
+				}
+			} else if err := linkUnchanged(basePath, rel, manifest.Files[name], dest); err != nil {
+				return fmt.Errorf("%w: %s", err, name)
+			}
+
+			progress(i+1, len(paths))
+		}
+		return nil
+	}
+}
+
+// linkUnchanged hardlinks a file of the base release into the new release
+// after checking it still has the content the manifest expects.
+func linkUnchanged(basePath, rel, hash, dest string) error {
+	if basePath == "" {
+		return ErrManifestStale
+	}
+	src := filepath.Join(basePath, rel)
+	if current, err := regularFileSHA256(src); err != nil || current != hash {
+		return ErrManifestStale
+	}
+	return os.Link(src, dest)
+}
+
+// regularFileSHA256 returns the hex SHA-256 of a regular file. Symlinks and
+// other special files are an error.
+func regularFileSHA256(name string) (string, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", ErrInvalidPath
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedManifestPaths(manifest *deployManifest) []string {
+	paths := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// expireManifests fails manifest deploys of a site whose files did not
+// arrive within ManifestUploadTimeout. Callers hold createMu.
+func (s *DeployService) expireManifests(siteID int64) {
+	pending, err := s.deployRepo.ListPending()
+	if err != nil {
+		return
+	}
+	for _, d := range pending {
+		if d.SiteID == siteID && d.Phase == models.DeployPhaseUploading && time.Since(d.CreatedAt) > ManifestUploadTimeout {
+			s.discardManifest(d)
+			s.fail(d, ErrManifestExpired)
+		}
+	}
+}
+
+func (s *DeployService) saveManifest(deploy *models.Deploy, manifest *deployManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestPath := s.manifestPath(deploy.SiteID, deploy.ID)
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		return fmt.Errorf("save manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *DeployService) loadManifest(deploy *models.Deploy) (*deployManifest, error) {
+	data, err := os.ReadFile(s.manifestPath(deploy.SiteID, deploy.ID))
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	manifest := &deployManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// discardManifest removes the manifest and staged uploads of a deploy.
+func (s *DeployService) discardManifest(deploy *models.Deploy) {
+	os.Remove(s.manifestPath(deploy.SiteID, deploy.ID))
+	os.RemoveAll(s.manifestStagingPath(deploy.SiteID, deploy.ID))
+}
+
+func (s *DeployService) manifestPath(siteID, deployID int64) string {
+	return filepath.Join(s.sitePath(siteID), "deploys", fmt.Sprintf(".manifest-%d.json", deployID))
+}
+
+// manifestStagingPath holds the uploaded files of a manifest deploy until
+// they are moved into the release.
+func (s *DeployService) manifestStagingPath(siteID, deployID int64) string {
+	return filepath.Join(s.sitePath(siteID), "deploys", fmt.Sprintf(".manifest-%d", deployID))
+}
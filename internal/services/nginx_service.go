@@ -2,11 +2,15 @@ package services
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"text/template"
 
@@ -21,8 +25,15 @@ type NginxService struct {
 	domainRepo   *repository.DomainRepository
 	redirectRepo *repository.RedirectRepository
 	authZoneRepo *repository.AuthZoneRepository
+	stagingRepo  *repository.StagingRepository
+	proxyRepo    *repository.ProxyRepository
+	headerRepo   *repository.HeaderRuleRepository
+	snippetRepo  *repository.NginxSnippetRepository
 }
 
+// ErrNginxTestFailed is returned when nginx -t rejects the config.
+var ErrNginxTestFailed = errors.New("nginx test failed")
+
 func NewNginxService(cfg *config.Config, siteRepo *repository.SiteRepository, domainRepo *repository.DomainRepository) *NginxService {
 	return &NginxService{
 		config:     cfg,
@@ -39,9 +50,43 @@ func (s *NginxService) SetAuthZoneRepo(repo *repository.AuthZoneRepository) {
 	s.authZoneRepo = repo
 }
 
+func (s *NginxService) SetStagingRepo(repo *repository.StagingRepository) {
+	s.stagingRepo = repo
+}
+
+func (s *NginxService) SetProxyRepo(repo *repository.ProxyRepository) {
+	s.proxyRepo = repo
+}
+
+func (s *NginxService) SetHeaderRuleRepo(repo *repository.HeaderRuleRepository) {
+	s.headerRepo = repo
+}
+
+func (s *NginxService) SetNginxSnippetRepo(repo *repository.NginxSnippetRepository) {
+	s.snippetRepo = repo
+}
+
 const nginxSiteTemplate = `# Site: {{.Site.Name}} (ID: {{.Site.ID}})
 # Generated by MicroPanel - DO NOT EDIT MANUALLY
-{{if .HasSSL}}
+{{with .Proxy}}
+upstream {{.UpstreamName}} {
+{{range .Upstreams}}    server {{.}};
+{{end}}    keepalive 16;
+}
+{{end}}{{range .Redirects}}
+# Redirect: {{.Source}} -> {{.TargetURL}}
+map $uri ${{.Variable}} {
+    default "";
+    "~^/\.well-known/acme-challenge/" "";
+    "{{.Pattern}}" "{{.Target}}";
+}
+{{end}}{{range .HeaderMaps}}
+# Header rules: {{.Name}}
+map $uri ${{.Variable}} {
+    default "{{.Default}}";
+{{range .Entries}}    "{{.Pattern}}" "{{.Value}}";
+{{end}}}
+{{end}}{{if .HasSSL}}
 # HTTP -> HTTPS redirect (ACME challenges still served on port 80)
 server {
     listen 80;
@@ -75,7 +120,7 @@ server {
     ssl_prefer_server_ciphers off;
 
     # HSTS
-    add_header Strict-Transport-Security "max-age=63072000" always;
+    add_header Strict-Transport-Security {{.HeaderValue "Strict-Transport-Security" "max-age=63072000"}} always;
 
     root {{.PublicPath}};
     index index.html index.htm;
@@ -85,36 +130,45 @@ server {
     error_log /var/log/nginx/{{.LogName}}_error.log;
 
     # Security headers
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-{{range .Redirects}}{{if .IsEnabled}}
-    # Redirect: {{.SourcePath}} -> {{.TargetURL}}
-    location {{.SourcePath}} {
-        return {{.Code}} {{.TargetURL}}{{if .PreservePath}}$uri{{end}}{{if .PreserveQuery}}$is_args$args{{end}};
-    }
-{{end}}{{end}}
+    add_header X-Frame-Options {{.HeaderValue "X-Frame-Options" "SAMEORIGIN"}} always;
+    add_header X-Content-Type-Options {{.HeaderValue "X-Content-Type-Options" "nosniff"}} always;
+    add_header X-XSS-Protection {{.HeaderValue "X-XSS-Protection" "1; mode=block"}} always;
+{{if .RuleHeaders}}
+    # Header rules
+{{range .RuleHeaders}}    add_header {{.Name}} ${{.Variable}} always;
+{{end}}{{end}}{{if and .Precompress .ServesFiles}}
+    # Serve the .gz{{if .BrotliStatic}} and .br{{end}} copies written on deploy
+    gzip_static on;{{if .BrotliStatic}}
+    brotli_static on;{{end}}
+    gzip_vary on;
+{{end}}{{with index .Snippets "server"}}
+    # Custom snippet: server
+{{.}}{{end}}{{template "redirects" .}}
 {{range .AuthZones}}{{if .IsEnabled}}
     # Auth Zone: {{.PathPrefix}}
     location {{.PathPrefix}} {
         auth_basic "{{.Realm}}";
         auth_basic_user_file {{$.AuthPath}}/zone_{{.ID}}.htpasswd;
-        try_files $uri $uri/ =404;
-    }
+{{template "serve" $}}    }
 {{end}}{{end}}
 {{if .FixMimeTypes}}
     # Fix MIME types for files with encoded query strings in filenames
     include /etc/nginx/hack.conf;
-{{end}}
+{{end}}{{with index .Snippets "before_location"}}
+    # Custom snippet: before location /
+{{.}}{{end}}
     location / {
-        try_files $uri $uri/ =404;
-    }
-
+{{with index .Snippets "location"}}        # Custom snippet: location /
+{{.}}{{end}}{{template "serve" .}}    }
+{{template "pages" .}}{{with .Proxy}}{{if $.ServesFiles}}
+    location @upstream {
+{{template "proxy" .}}    }
+{{end}}{{end}}{{if .ServesFiles}}
     # Deny access to hidden files
     location ~ /\. {
         deny all;
     }
-}
+{{end}}}
 {{else}}
 server {
     listen 80;
@@ -130,48 +184,181 @@ server {
     error_log /var/log/nginx/{{.LogName}}_error.log;
 
     # Security headers
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-
+    add_header X-Frame-Options {{.HeaderValue "X-Frame-Options" "SAMEORIGIN"}} always;
+    add_header X-Content-Type-Options {{.HeaderValue "X-Content-Type-Options" "nosniff"}} always;
+    add_header X-XSS-Protection {{.HeaderValue "X-XSS-Protection" "1; mode=block"}} always;
+{{if .RuleHeaders}}
+    # Header rules
+{{range .RuleHeaders}}    add_header {{.Name}} ${{.Variable}} always;
+{{end}}{{end}}{{if and .Precompress .ServesFiles}}
+    # Serve the .gz{{if .BrotliStatic}} and .br{{end}} copies written on deploy
+    gzip_static on;{{if .BrotliStatic}}
+    brotli_static on;{{end}}
+    gzip_vary on;
+{{end}}{{with index .Snippets "server"}}
+    # Custom snippet: server
+{{.}}{{end}}
     # ACME challenge for Let's Encrypt
     location ^~ /.well-known/acme-challenge/ {
         root /var/www/certbot;
     }
-{{range .Redirects}}{{if .IsEnabled}}
-    # Redirect: {{.SourcePath}} -> {{.TargetURL}}
-    location {{.SourcePath}} {
-        return {{.Code}} {{.TargetURL}}{{if .PreservePath}}$uri{{end}}{{if .PreserveQuery}}$is_args$args{{end}};
-    }
-{{end}}{{end}}
+{{template "redirects" .}}
 {{range .AuthZones}}{{if .IsEnabled}}
     # Auth Zone: {{.PathPrefix}}
     location {{.PathPrefix}} {
         auth_basic "{{.Realm}}";
         auth_basic_user_file {{$.AuthPath}}/zone_{{.ID}}.htpasswd;
-        try_files $uri $uri/ =404;
-    }
+{{template "serve" $}}    }
 {{end}}{{end}}
 {{if .FixMimeTypes}}
     # Fix MIME types for files with encoded query strings in filenames
     include /etc/nginx/hack.conf;
-{{end}}
+{{end}}{{with index .Snippets "before_location"}}
+    # Custom snippet: before location /
+{{.}}{{end}}
+    location / {
+{{with index .Snippets "location"}}        # Custom snippet: location /
+{{.}}{{end}}{{template "serve" .}}    }
+{{template "pages" .}}{{with .Proxy}}{{if $.ServesFiles}}
+    location @upstream {
+{{template "proxy" .}}    }
+{{end}}{{end}}{{if .ServesFiles}}
+    # Deny access to hidden files
+    location ~ /\. {
+        deny all;
+    }
+{{end}}}
+{{end}}{{with .Staging}}
+# Staging: {{.Hostname}}
+{{if $.HasSSL}}
+server {
+    listen 80;
+    listen [::]:80;
+
+    server_name {{.Hostname}};
+
+    location ^~ /.well-known/acme-challenge/ {
+        root /var/www/certbot;
+    }
+
     location / {
-        try_files $uri $uri/ =404;
+        return 301 https://$host$request_uri;
     }
+}
+{{end}}
+server {
+{{if $.HasSSL}}    listen 443 ssl http2;
+    listen [::]:443 ssl http2;
+{{else}}    listen 80;
+    listen [::]:80;
+{{end}}
+    server_name {{.Hostname}};
+{{if $.HasSSL}}
+    ssl_certificate /etc/letsencrypt/live/{{$.SSLCertName}}/fullchain.pem;
+    ssl_certificate_key /etc/letsencrypt/live/{{$.SSLCertName}}/privkey.pem;
+    ssl_session_timeout 1d;
+    ssl_session_cache shared:SSL:50m;
+    ssl_session_tickets off;
+
+    ssl_protocols TLSv1.2 TLSv1.3;
+    ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384;
+    ssl_prefer_server_ciphers off;
+{{end}}
+    root {{.PublicPath}};
+    index index.html index.htm;
 
+    # Logging
+    access_log /var/log/nginx/{{$.LogName}}_staging_access.log;
+    error_log /var/log/nginx/{{$.LogName}}_staging_error.log;
+
+    # Keep previews out of search engines
+    add_header X-Robots-Tag "noindex, nofollow" always;
+
+    # Security headers
+    add_header X-Frame-Options "SAMEORIGIN" always;
+    add_header X-Content-Type-Options "nosniff" always;
+    add_header X-XSS-Protection "1; mode=block" always;
+{{if $.Precompress}}
+    # Serve the .gz{{if $.BrotliStatic}} and .br{{end}} copies written on deploy
+    gzip_static on;{{if $.BrotliStatic}}
+    brotli_static on;{{end}}
+    gzip_vary on;
+{{end}}{{if not $.HasSSL}}
+    # ACME challenge for Let's Encrypt
+    location ^~ /.well-known/acme-challenge/ {
+        root /var/www/certbot;
+    }
+{{end}}
+    location / {
+{{if and .AllowedIPs .AuthUser}}        # Allowed IPs get in without a password
+        satisfy any;
+{{end}}{{range .AllowedIPs}}        allow {{.}};
+{{end}}{{if .AllowedIPs}}        deny all;
+{{end}}{{if .AuthUser}}        auth_basic "Staging";
+        auth_basic_user_file {{.HtpasswdPath}};
+{{end}}{{if $.SPAFallback}}        try_files $uri $uri/ @spa;
+{{else}}        try_files $uri $uri/ =404;
+{{end}}    }
+{{template "pages" $}}
     # Deny access to hidden files
     location ~ /\. {
         deny all;
     }
 }
-{{end}}
-`
+{{end}}`
+
+// nginxServeTemplates hold how a location of the site answers: with the
+// files of the release, the upstream, or the files falling through to the
+// upstream or the SPA fallback; the error pages of the site; and its
+// redirects, checked before a location is picked.
+const nginxServeTemplates = `{{define "serve"}}{{if not .ServesFiles}}{{template "proxy" .Proxy}}{{else if .Proxy}}        try_files $uri $uri/index.html @upstream;
+{{else if .SPAFallback}}        try_files $uri $uri/ @spa;
+{{else}}        try_files $uri $uri/ =404;
+{{end}}{{end}}{{define "pages"}}{{if .SPAFallback}}
+    # Single-page app: unknown paths get /index.html, missing assets a 404
+    location @spa {
+        if ($uri ~* "\.(?:{{.SPAAssetExtensions}})$") {
+            return 404;
+        }
+        try_files /index.html =404;
+    }
+{{end}}{{range .ErrorPages}}
+    error_page {{.Codes}} {{.Path}};
+    location = {{.Path}} {
+        internal;
+    }
+{{end}}{{end}}{{define "redirects"}}{{if .Redirects}}
+    # Redirects, by priority: the first one matching wins
+{{range .Redirects}}    set $micropanel_redirect ${{.Variable}};
+{{if .Host}}    if ($host != "{{.Host}}") {
+        set $micropanel_redirect "";
+    }
+{{end}}{{if .QueryArg}}    if ($arg_{{.QueryArg}} {{if .QueryValue}}!= "{{.QueryValue}}"{{else}}= ""{{end}}) {
+        set $micropanel_redirect "";
+    }
+{{end}}    if ($micropanel_redirect) {
+        return {{.Code}} $micropanel_redirect;
+    }
+{{end}}{{end}}{{end}}{{define "proxy"}}        proxy_pass http://{{.UpstreamName}};
+        proxy_http_version 1.1;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header X-Forwarded-Host $host;
+{{if .WebSocket}}        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $http_connection;
+{{else}}        proxy_set_header Connection "";
+{{end}}        proxy_connect_timeout {{.ConnectTimeout}}s;
+        proxy_read_timeout {{.ReadTimeout}}s;
+        proxy_send_timeout {{.SendTimeout}}s;
+{{range .PassHeaders}}        proxy_pass_header {{.}};
+{{end}}{{end}}`
 
 type nginxTemplateData struct {
 	Site         *models.Site
 	ServerNames  string
-	Redirects    []*models.Redirect
+	Redirects    []nginxRedirect // enabled redirects, by priority
 	AuthZones    []*models.AuthZone
 	PublicPath   string
 	LogName      string
@@ -179,6 +366,229 @@ type nginxTemplateData struct {
 	HasSSL       bool
 	SSLCertName  string
 	FixMimeTypes bool
+	Precompress  bool
+	BrotliStatic bool
+	ServesFiles  bool
+	SPAFallback  bool
+	ErrorPages   []nginxErrorPage
+	HeaderMaps   []nginxHeaderMap
+	RuleHeaders  []nginxHeaderMap  // headers only set by rules
+	Snippets     map[string]string // custom snippets by hook, indented
+	Proxy        *nginxProxyData
+	Staging      *nginxStagingData
+}
+
+// SPAAssetExtensions are the file types the SPA fallback answers with a 404
+// instead of /index.html, so a missing asset is not served as HTML.
+func (nginxTemplateData) SPAAssetExtensions() string {
+	return spaAssetExtensions
+}
+
+const spaAssetExtensions = "css|js|mjs|map|json|xml|txt|png|jpe?g|gif|svg|ico|webp|avif|bmp|woff2?|ttf|otf|eot|wasm|mp3|mp4|webm|ogg|pdf|zip"
+
+// HeaderValue returns the value of a header the server block sets by
+// default, or the variable of the header rules that change it.
+func (d nginxTemplateData) HeaderValue(name, value string) string {
+	name = http.CanonicalHeaderKey(name)
+	for _, m := range d.HeaderMaps {
+		if m.Name == name {
+			return "$" + m.Variable
+		}
+	}
+	return `"` + value + `"`
+}
+
+// nginxHeaderMap picks the value of a header set by header rules from the
+// URI of the request. Patterns are regular expressions tried in the order
+// of the rules; an empty value leaves the header out.
+type nginxHeaderMap struct {
+	Name     string
+	Variable string
+	Default  string
+	Entries  []nginxHeaderMapEntry
+}
+
+type nginxHeaderMapEntry struct {
+	Pattern string
+	Value   string
+}
+
+// nginxHeaderMaps turns the header rules of a site into a map per header.
+// Headers the server block sets by default keep their value as the default
+// of the map, the others are left out unless a rule matches.
+func nginxHeaderMaps(siteID int64, rules []*models.HeaderRule, defaults map[string]string) (maps, ruleHeaders []nginxHeaderMap) {
+	index := make(map[string]int)
+	done := make(map[string]bool) // a rule for all requests hides the later ones
+	for _, rule := range rules {
+		for _, h := range rule.Headers {
+			i, ok := index[h.Name]
+			if !ok {
+				i = len(maps)
+				index[h.Name] = i
+				maps = append(maps, nginxHeaderMap{
+					Name:     h.Name,
+					Variable: fmt.Sprintf("micropanel_site_%d_header_%d", siteID, i),
+					Default:  defaults[h.Name],
+				})
+			}
+			if done[h.Name] {
+				continue
+			}
+			if rule.MatchType == models.HeaderMatchAll {
+				maps[i].Default = h.Value
+				done[h.Name] = true
+				continue
+			}
+			maps[i].Entries = append(maps[i].Entries, nginxHeaderMapEntry{Pattern: headerRulePattern(rule), Value: h.Value})
+		}
+	}
+
+	for _, m := range maps {
+		if _, ok := defaults[m.Name]; !ok {
+			ruleHeaders = append(ruleHeaders, m)
+		}
+	}
+	return maps, ruleHeaders
+}
+
+// headerRulePattern returns the map regular expression matching the URIs of
+// a rule. Paths are validated to hold no characters special in nginx
+// strings, only dots need escaping.
+func headerRulePattern(rule *models.HeaderRule) string {
+	escape := func(path string) string { return strings.ReplaceAll(path, ".", `\.`) }
+	switch rule.MatchType {
+	case models.HeaderMatchExact:
+		return "~^" + escape(rule.Match) + "$"
+	case models.HeaderMatchExtension:
+		return `~*\.(?:` + strings.ReplaceAll(rule.Match, " ", "|") + ")$"
+	}
+	return "~^" + escape(rule.Match)
+}
+
+// nginxRedirect is an enabled redirect of a site. Its map sets Variable to
+// the target for the URIs matching Pattern; the server block then checks
+// the conditions and returns the first target set.
+type nginxRedirect struct {
+	Source     string
+	TargetURL  string
+	Variable   string
+	Pattern    string
+	Target     string
+	Code       int
+	Host       string
+	QueryArg   string
+	QueryValue string
+}
+
+// nginxRedirects turns the enabled redirects of a site into their maps, in
+// the order they are checked: by descending priority, then exact paths,
+// longer prefixes first like the prefix locations redirects used to be, and
+// regular expressions in the order they were listed.
+func nginxRedirects(siteID int64, redirects []*models.Redirect) []nginxRedirect {
+	rank := map[models.RedirectMatchType]int{models.RedirectMatchExact: 0, models.RedirectMatchPrefix: 1, models.RedirectMatchRegex: 2}
+	redirects = slices.Clone(redirects)
+	slices.SortStableFunc(redirects, func(a, b *models.Redirect) int {
+		if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(rank[a.MatchType], rank[b.MatchType]); c != 0 {
+			return c
+		}
+		if a.MatchType == models.RedirectMatchPrefix {
+			return cmp.Compare(len(b.SourcePath), len(a.SourcePath))
+		}
+		return 0
+	})
+
+	var list []nginxRedirect
+	for _, r := range redirects {
+		if !r.IsEnabled {
+			continue
+		}
+		target := r.TargetURL
+		if r.PreservePath {
+			target += "$uri"
+		}
+		if r.PreserveQuery {
+			target += "$is_args$args"
+		}
+		list = append(list, nginxRedirect{
+			Source:     r.SourcePath,
+			TargetURL:  r.TargetURL,
+			Variable:   fmt.Sprintf("micropanel_site_%d_redirect_%d", siteID, r.ID),
+			Pattern:    redirectPattern(r),
+			Target:     target,
+			Code:       r.Code,
+			Host:       r.Host,
+			QueryArg:   r.QueryArg,
+			QueryValue: r.QueryValue,
+		})
+	}
+	return list
+}
+
+// redirectPattern returns the map regular expression matching the URIs of
+// a redirect. Regex sources are validated to hold no quotes or escaped
+// backslashes, so nginx passes them to PCRE as they are; paths need only
+// their dots escaped, like in headerRulePattern.
+func redirectPattern(r *models.Redirect) string {
+	escape := func(path string) string { return strings.ReplaceAll(path, ".", `\.`) }
+	switch r.MatchType {
+	case models.RedirectMatchRegex:
+		return "~" + r.SourcePath
+	case models.RedirectMatchExact:
+		return "~^" + escape(r.SourcePath) + "$"
+	}
+	return "~^" + escape(r.SourcePath)
+}
+
+// nginxSnippets indents the snippets of a site for their hooks: server
+// level and before location / in the server block, the location hook
+// inside location /.
+func nginxSnippets(snippets []*models.NginxSnippet) map[string]string {
+	indented := make(map[string]string)
+	for _, snippet := range snippets {
+		indent := "    "
+		if snippet.Hook == models.SnippetHookLocation {
+			indent = "        "
+		}
+		var b strings.Builder
+		for _, line := range strings.Split(snippet.Content, "\n") {
+			if line != "" {
+				b.WriteString(indent)
+			}
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		indented[string(snippet.Hook)] = b.String()
+	}
+	return indented
+}
+
+// nginxErrorPage is a file of the release nginx shows for status codes.
+type nginxErrorPage struct {
+	Codes string
+	Path  string
+}
+
+// nginxProxyData is the upstream of a proxy or hybrid site.
+type nginxProxyData struct {
+	UpstreamName   string
+	Upstreams      []string
+	ConnectTimeout int
+	ReadTimeout    int
+	SendTimeout    int
+	WebSocket      bool
+	PassHeaders    []string
+}
+
+// nginxStagingData is the server block of the preview hostname of a site.
+type nginxStagingData struct {
+	Hostname     string
+	PublicPath   string
+	AllowedIPs   []string
+	AuthUser     string
+	HtpasswdPath string
 }
 
 func (s *NginxService) GenerateConfig(siteID int64) (string, error) {
@@ -227,17 +637,114 @@ func (s *NginxService) GenerateConfig(siteID int64) (string, error) {
 	data := nginxTemplateData{
 		Site:         site,
 		ServerNames:  serverNames,
-		Redirects:    redirects,
+		Redirects:    nginxRedirects(siteID, redirects),
 		AuthZones:    authZones,
-		PublicPath:   filepath.Join(sitePath, "public"),
+		PublicPath:   filepath.Join(sitePath, currentLinkName),
 		LogName:      logName,
 		AuthPath:     filepath.Join(sitePath, "auth"),
 		HasSSL:       site.SSLEnabled,
 		SSLCertName:  site.GetSSLCertName(),
 		FixMimeTypes: site.FixMimeTypes,
+		Precompress:  site.Precompress,
+		BrotliStatic: site.Precompress && s.config.Nginx.BrotliStatic,
+		ServesFiles:  site.ServesFiles(),
+		SPAFallback:  site.SPAFallback && site.Type == models.SiteTypeStatic,
+		ErrorPages:   nginxErrorPages(site),
+	}
+
+	// Set the headers of the header rules if repo is set
+	if s.headerRepo != nil {
+		rules, err := s.headerRepo.ListBySite(siteID)
+		if err != nil {
+			return "", fmt.Errorf("get header rules: %w", err)
+		}
+		defaults := map[string]string{
+			"X-Frame-Options":        "SAMEORIGIN",
+			"X-Content-Type-Options": "nosniff",
+			"X-Xss-Protection":       "1; mode=block",
+		}
+		if site.SSLEnabled {
+			defaults["Strict-Transport-Security"] = "max-age=63072000"
+		}
+		data.HeaderMaps, data.RuleHeaders = nginxHeaderMaps(siteID, rules, defaults)
 	}
 
+	// Insert the custom snippets of the site if repo is set
+	if s.snippetRepo != nil {
+		snippets, err := s.snippetRepo.ListBySite(siteID)
+		if err != nil {
+			return "", fmt.Errorf("get nginx snippets: %w", err)
+		}
+		data.Snippets = nginxSnippets(snippets)
+	}
+
+	// Pass requests of proxy and hybrid sites to their upstream
+	if site.IsProxied() {
+		if s.proxyRepo == nil {
+			return "", fmt.Errorf("site %d is a %s site but proxies are not configured", siteID, site.Type)
+		}
+		proxy, err := s.proxyRepo.GetBySite(siteID)
+		if err != nil {
+			return "", fmt.Errorf("get upstream: %w", err)
+		}
+		data.Proxy = &nginxProxyData{
+			UpstreamName:   fmt.Sprintf("micropanel_site_%d", siteID),
+			Upstreams:      proxy.Upstreams,
+			ConnectTimeout: proxy.ConnectTimeout,
+			ReadTimeout:    proxy.ReadTimeout,
+			SendTimeout:    proxy.SendTimeout,
+			WebSocket:      proxy.WebSocket,
+			PassHeaders:    proxy.PassHeaders,
+		}
+	}
+
+	// Serve the staging slot if repo is set and the site has one
+	if s.stagingRepo != nil {
+		slot, err := s.stagingRepo.GetBySite(siteID)
+		switch {
+		case err == nil:
+			data.Staging = &nginxStagingData{
+				Hostname:     slot.PreviewHostname(site.Name),
+				PublicPath:   filepath.Join(sitePath, stagingLinkName),
+				AllowedIPs:   slot.AllowedIPs,
+				AuthUser:     slot.AuthUser,
+				HtpasswdPath: filepath.Join(sitePath, "auth", stagingHtpasswdName),
+			}
+		case !errors.Is(err, repository.ErrNotFound):
+			return "", fmt.Errorf("get staging slot: %w", err)
+		}
+	}
+
+	return renderNginxConfig(data)
+}
+
+// nginxErrorPages returns the error pages a site has set. Codes sharing a
+// page are listed together, nginx allows one location per path.
+func nginxErrorPages(site *models.Site) []nginxErrorPage {
+	var pages []nginxErrorPage
+	for _, p := range []nginxErrorPage{
+		{Codes: "404", Path: site.ErrorPage404},
+		{Codes: "403", Path: site.ErrorPage403},
+		{Codes: "500 502 503 504", Path: site.ErrorPage50x},
+	} {
+		if p.Path == "" {
+			continue
+		}
+		i := slices.IndexFunc(pages, func(q nginxErrorPage) bool { return q.Path == p.Path })
+		if i >= 0 {
+			pages[i].Codes += " " + p.Codes
+			continue
+		}
+		pages = append(pages, p)
+	}
+	return pages
+}
+
+func renderNginxConfig(data nginxTemplateData) (string, error) {
 	tmpl, err := template.New("nginx").Parse(nginxSiteTemplate)
+	if err == nil {
+		tmpl, err = tmpl.Parse(nginxServeTemplates)
+	}
 	if err != nil {
 		return "", fmt.Errorf("parse template: %w", err)
 	}
@@ -285,7 +792,7 @@ func (s *NginxService) TestConfig() error {
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		slog.Error("nginx config test failed", "output", string(output), "error", err)
-		return fmt.Errorf("nginx test failed: %s", string(output))
+		return fmt.Errorf("%w: %s", ErrNginxTestFailed, string(output))
 	}
 	return nil
 }
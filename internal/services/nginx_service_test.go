@@ -0,0 +1,316 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"micropanel/internal/models"
+)
+
+func TestRenderNginxConfig_SiteTypes(t *testing.T) {
+	proxy := &nginxProxyData{
+		UpstreamName:   "micropanel_site_3",
+		Upstreams:      []string{"127.0.0.1:3000", "unix:/run/app.sock"},
+		ConnectTimeout: 5,
+		ReadTimeout:    60,
+		SendTimeout:    30,
+		WebSocket:      true,
+		PassHeaders:    []string{"Server"},
+	}
+
+	tests := []struct {
+		siteType models.SiteType
+		want     []string
+		notWant  []string
+		zoneWant string // how the auth zone answers once the password is checked
+	}{
+		{
+			siteType: models.SiteTypeStatic,
+			want:     []string{"try_files $uri $uri/ =404;", "location ~ /\\. {", "gzip_static on;"},
+			notWant:  []string{"upstream ", "proxy_pass", "@upstream"},
+			zoneWant: "try_files $uri $uri/ =404;",
+		},
+		{
+			siteType: models.SiteTypeProxy,
+			want: []string{
+				"upstream micropanel_site_3 {\n    server 127.0.0.1:3000;\n    server unix:/run/app.sock;\n",
+				"    location / {\n        proxy_pass http://micropanel_site_3;\n",
+				"proxy_set_header Upgrade $http_upgrade;",
+				"proxy_connect_timeout 5s;",
+				"proxy_send_timeout 30s;",
+				"proxy_pass_header Server;",
+			},
+			notWant:  []string{"try_files", "@upstream", "gzip_static", "location ~ /\\. {"},
+			zoneWant: "proxy_pass http://micropanel_site_3;",
+		},
+		{
+			siteType: models.SiteTypeHybrid,
+			want: []string{
+				"    location / {\n        try_files $uri $uri/index.html @upstream;\n",
+				"    location @upstream {\n        proxy_pass http://micropanel_site_3;\n",
+				"location ~ /\\. {",
+			},
+			notWant:  []string{"=404"},
+			zoneWant: "try_files $uri $uri/index.html @upstream;",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, ssl := range []bool{false, true} {
+			site := &models.Site{ID: 3, Name: "app.example.com", Type: tt.siteType}
+			data := nginxTemplateData{
+				Site:        site,
+				ServerNames: site.Name,
+				AuthZones:   []*models.AuthZone{{ID: 1, PathPrefix: "/admin", Realm: "Admin", IsEnabled: true}},
+				PublicPath:  "/var/www/sites/3/current",
+				LogName:     "app_example_com",
+				AuthPath:    "/var/www/sites/3/auth",
+				HasSSL:      ssl,
+				SSLCertName: site.Name,
+				Precompress: true,
+				ServesFiles: site.ServesFiles(),
+			}
+			if site.IsProxied() {
+				data.Proxy = proxy
+			}
+
+			config, err := renderNginxConfig(data)
+			if err != nil {
+				t.Fatalf("renderNginxConfig(%s) error = %v", tt.siteType, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("%s site (ssl=%v) config lacks %q", tt.siteType, ssl, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(config, notWant) {
+					t.Errorf("%s site (ssl=%v) config has %q", tt.siteType, ssl, notWant)
+				}
+			}
+			zone := config[strings.Index(config, "location /admin {"):]
+			zone = zone[:strings.Index(zone, "}")]
+			if !strings.Contains(zone, tt.zoneWant) {
+				t.Errorf("%s site (ssl=%v) auth zone = %q, want %q", tt.siteType, ssl, zone, tt.zoneWant)
+			}
+		}
+	}
+}
+
+func TestRenderNginxConfig_Pages(t *testing.T) {
+	site := &models.Site{ID: 3, Name: "app.example.com", Type: models.SiteTypeStatic, SPAFallback: true,
+		ErrorPage404: "/404.html", ErrorPage403: "/404.html", ErrorPage50x: "/errors/50x.html"}
+	data := nginxTemplateData{
+		Site:        site,
+		ServerNames: site.Name,
+		AuthZones:   []*models.AuthZone{{ID: 1, PathPrefix: "/admin", Realm: "Admin", IsEnabled: true}},
+		PublicPath:  "/var/www/sites/3/current",
+		LogName:     "app_example_com",
+		AuthPath:    "/var/www/sites/3/auth",
+		ServesFiles: true,
+		SPAFallback: true,
+		ErrorPages:  nginxErrorPages(site),
+		Staging:     &nginxStagingData{Hostname: "staging.app.example.com", PublicPath: "/var/www/sites/3/staging", AuthUser: "preview"},
+	}
+
+	config, err := renderNginxConfig(data)
+	if err != nil {
+		t.Fatalf("renderNginxConfig() error = %v", err)
+	}
+	for _, tt := range []struct {
+		want  string
+		count int // site and staging server blocks
+	}{
+		{"        try_files $uri $uri/ @spa;\n", 3},
+		{"        auth_basic_user_file /var/www/sites/3/auth/zone_1.htpasswd;\n        try_files $uri $uri/ @spa;\n", 1},
+		{"    location @spa {\n        if ($uri ~* \"\\.(?:" + spaAssetExtensions + ")$\") {\n            return 404;\n        }\n        try_files /index.html =404;\n", 2},
+		{"    error_page 404 403 /404.html;\n    location = /404.html {\n        internal;\n", 2},
+		{"    error_page 500 502 503 504 /errors/50x.html;\n", 2},
+	} {
+		if n := strings.Count(config, tt.want); n != tt.count {
+			t.Errorf("config has %q %d times, want %d", tt.want, n, tt.count)
+		}
+	}
+
+	// Hybrid sites fall through to the upstream, not to index.html
+	data.SPAFallback = false
+	data.Staging = nil
+	data.Proxy = &nginxProxyData{UpstreamName: "micropanel_site_3", Upstreams: []string{"127.0.0.1:3000"}}
+	config, err = renderNginxConfig(data)
+	if err != nil {
+		t.Fatalf("renderNginxConfig() error = %v", err)
+	}
+	if strings.Contains(config, "@spa") || !strings.Contains(config, "error_page 404 403 /404.html;") {
+		t.Errorf("hybrid config = %s", config)
+	}
+}
+
+func TestNormalizeProxyConfig(t *testing.T) {
+	got, err := normalizeProxyConfig(&models.ProxyConfig{
+		Upstreams:   []string{" 127.0.0.1:3000 ", "", "127.0.0.1:3000", "unix:/run/app.sock"},
+		ReadTimeout: 300,
+		PassHeaders: []string{"server", " x-accel-buffering", "Server"},
+	})
+	if err != nil {
+		t.Fatalf("normalizeProxyConfig() error = %v", err)
+	}
+	if strings.Join(got.Upstreams, ",") != "127.0.0.1:3000,unix:/run/app.sock" {
+		t.Errorf("Upstreams = %v", got.Upstreams)
+	}
+	if got.ConnectTimeout != DefaultProxyTimeout || got.ReadTimeout != 300 || got.SendTimeout != DefaultProxyTimeout {
+		t.Errorf("timeouts = %d/%d/%d", got.ConnectTimeout, got.ReadTimeout, got.SendTimeout)
+	}
+	if strings.Join(got.PassHeaders, ",") != "Server,X-Accel-Buffering" {
+		t.Errorf("PassHeaders = %v", got.PassHeaders)
+	}
+
+	if _, err := normalizeProxyConfig(&models.ProxyConfig{Upstreams: []string{"127.0.0.1:3000; include /etc/passwd"}}); err == nil {
+		t.Error("normalizeProxyConfig() accepted an invalid upstream")
+	}
+	if _, err := normalizeProxyConfig(&models.ProxyConfig{Upstreams: []string{"127.0.0.1:3000"}, SendTimeout: MaxProxyTimeout + 1}); err != ErrInvalidProxyTimeout {
+		t.Errorf("normalizeProxyConfig() with a long timeout error = %v, want ErrInvalidProxyTimeout", err)
+	}
+	if _, err := normalizeProxyConfig(&models.ProxyConfig{Upstreams: []string{"127.0.0.1:3000"}, PassHeaders: []string{"X Bad"}}); err == nil {
+		t.Error("normalizeProxyConfig() accepted an invalid header name")
+	}
+}
+
+func TestNginxHeaderMaps(t *testing.T) {
+	rules := []*models.HeaderRule{
+		{MatchType: models.HeaderMatchExact, Match: "/embed.html", Headers: []models.ResponseHeader{{Name: "X-Frame-Options", Value: ""}}},
+		{MatchType: models.HeaderMatchExtension, Match: "css js", Headers: []models.ResponseHeader{{Name: "Cache-Control", Value: "public, max-age=31536000, immutable"}}},
+		{MatchType: models.HeaderMatchAll, Headers: []models.ResponseHeader{{Name: "Cache-Control", Value: "no-cache"}}},
+		{MatchType: models.HeaderMatchPrefix, Match: "/static/", Headers: []models.ResponseHeader{{Name: "Cache-Control", Value: "max-age=60"}, {Name: "Access-Control-Allow-Origin", Value: "*"}}},
+	}
+	defaults := map[string]string{"X-Frame-Options": "SAMEORIGIN", "X-Content-Type-Options": "nosniff"}
+
+	maps, ruleHeaders := nginxHeaderMaps(3, rules, defaults)
+	want := []nginxHeaderMap{
+		{Name: "X-Frame-Options", Variable: "micropanel_site_3_header_0", Default: "SAMEORIGIN", Entries: []nginxHeaderMapEntry{{Pattern: `~^/embed\.html$`, Value: ""}}},
+		// The rule for all requests sets the default, the later prefix rule never applies
+		{Name: "Cache-Control", Variable: "micropanel_site_3_header_1", Default: "no-cache", Entries: []nginxHeaderMapEntry{{Pattern: `~*\.(?:css|js)$`, Value: "public, max-age=31536000, immutable"}}},
+		{Name: "Access-Control-Allow-Origin", Variable: "micropanel_site_3_header_2", Entries: []nginxHeaderMapEntry{{Pattern: "~^/static/", Value: "*"}}},
+	}
+	if !reflect.DeepEqual(maps, want) {
+		t.Errorf("nginxHeaderMaps() maps = %+v, want %+v", maps, want)
+	}
+	if len(ruleHeaders) != 2 || ruleHeaders[0].Name != "Cache-Control" || ruleHeaders[1].Name != "Access-Control-Allow-Origin" {
+		t.Errorf("nginxHeaderMaps() rule headers = %+v", ruleHeaders)
+	}
+
+	data := nginxTemplateData{HeaderMaps: maps}
+	if got := data.HeaderValue("X-Frame-Options", "SAMEORIGIN"); got != "$micropanel_site_3_header_0" {
+		t.Errorf("HeaderValue() of a header set by rules = %s", got)
+	}
+	if got := data.HeaderValue("X-Content-Type-Options", "nosniff"); got != `"nosniff"` {
+		t.Errorf("HeaderValue() of a default header = %s", got)
+	}
+
+	site := &models.Site{ID: 3, Name: "app.example.com", Type: models.SiteTypeStatic}
+	config, err := renderNginxConfig(nginxTemplateData{Site: site, ServerNames: site.Name, ServesFiles: true, HeaderMaps: maps, RuleHeaders: ruleHeaders})
+	if err != nil {
+		t.Fatalf("renderNginxConfig() error = %v", err)
+	}
+	for _, want := range []string{
+		"map $uri $micropanel_site_3_header_1 {\n    default \"no-cache\";\n    \"~*\\.(?:css|js)$\" \"public, max-age=31536000, immutable\";\n}\n",
+		"    add_header X-Frame-Options $micropanel_site_3_header_0 always;\n    add_header X-Content-Type-Options \"nosniff\" always;\n",
+		"    add_header Access-Control-Allow-Origin $micropanel_site_3_header_2 always;\n",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config lacks %q", want)
+		}
+	}
+}
+
+func TestRenderNginxConfig_Snippets(t *testing.T) {
+	site := &models.Site{ID: 4, Name: "example.com", Type: models.SiteTypeStatic}
+	data := nginxTemplateData{
+		Site:        site,
+		ServerNames: site.Name,
+		PublicPath:  "/var/www/sites/4/current",
+		LogName:     "example_com",
+		ServesFiles: true,
+		Snippets: nginxSnippets([]*models.NginxSnippet{
+			{Hook: models.SnippetHookServer, Content: "client_max_body_size 50m;"},
+			{Hook: models.SnippetHookBeforeLocation, Content: "location /api/ {\n    proxy_pass http://127.0.0.1:4000;\n\n}"},
+			{Hook: models.SnippetHookLocation, Content: "limit_except GET {\n    deny all;\n}"},
+		}),
+		Staging: &nginxStagingData{Hostname: "staging.example.com", PublicPath: "/var/www/sites/4/staging"},
+	}
+
+	for _, ssl := range []bool{false, true} {
+		data.HasSSL = ssl
+		config, err := renderNginxConfig(data)
+		if err != nil {
+			t.Fatalf("renderNginxConfig() error = %v", err)
+		}
+		for _, want := range []string{
+			"    # Custom snippet: server\n    client_max_body_size 50m;\n",
+			"    # Custom snippet: before location /\n    location /api/ {\n        proxy_pass http://127.0.0.1:4000;\n\n    }\n\n    location / {\n",
+			"    location / {\n        # Custom snippet: location /\n        limit_except GET {\n            deny all;\n        }\n        try_files $uri $uri/ =404;\n",
+		} {
+			// The staging server block does not get the snippets
+			if n := strings.Count(config, want); n != 1 {
+				t.Errorf("config (ssl %v) has %q %d times, want 1", ssl, want, n)
+			}
+		}
+	}
+}
+
+func TestRenderNginxConfig_Redirects(t *testing.T) {
+	site := &models.Site{ID: 4, Name: "example.com", Type: models.SiteTypeStatic}
+	data := nginxTemplateData{
+		Site:        site,
+		ServerNames: site.Name,
+		PublicPath:  "/var/www/sites/4/current",
+		LogName:     "example_com",
+		ServesFiles: true,
+		// As listed by the repository, by priority then ID
+		Redirects: nginxRedirects(site.ID, []*models.Redirect{
+			{ID: 3, MatchType: models.RedirectMatchRegex, SourcePath: `^/blog/(\d+)$`, TargetURL: "/posts/$1", Code: 308, Priority: 10, IsEnabled: true},
+			{ID: 1, MatchType: models.RedirectMatchExact, SourcePath: "/old.html", TargetURL: "/new", Code: 301, PreserveQuery: true, IsEnabled: true, Host: "www.example.com", QueryArg: "ref"},
+			{ID: 2, MatchType: models.RedirectMatchPrefix, SourcePath: "/docs", TargetURL: "https://docs.example.com", Code: 307, PreservePath: true, QueryArg: "lang", QueryValue: "fr", IsEnabled: true},
+			{ID: 4, SourcePath: "/off", TargetURL: "/on", Code: 302},
+			{ID: 5, MatchType: models.RedirectMatchPrefix, SourcePath: "/docs/v1", TargetURL: "/docs/v2", Code: 301, IsEnabled: true},
+			{ID: 6, MatchType: models.RedirectMatchExact, SourcePath: "/team", TargetURL: "/about", Code: 301, IsEnabled: true},
+		}),
+		Staging: &nginxStagingData{Hostname: "staging.example.com", PublicPath: "/var/www/sites/4/staging"},
+	}
+
+	for _, ssl := range []bool{false, true} {
+		data.HasSSL = ssl
+		config, err := renderNginxConfig(data)
+		if err != nil {
+			t.Fatalf("renderNginxConfig() error = %v", err)
+		}
+		for _, want := range []string{
+			"map $uri $micropanel_site_4_redirect_3 {\n    default \"\";\n    \"~^/\\.well-known/acme-challenge/\" \"\";\n    \"~^/blog/(\\d+)$\" \"/posts/$1\";\n}\n",
+			"    \"~^/old\\.html$\" \"/new$is_args$args\";\n",
+			"    \"~^/docs\" \"https://docs.example.com$uri\";\n",
+			"    set $micropanel_redirect $micropanel_site_4_redirect_3;\n    if ($micropanel_redirect) {\n        return 308 $micropanel_redirect;\n    }\n",
+			"    set $micropanel_redirect $micropanel_site_4_redirect_1;\n    if ($host != \"www.example.com\") {\n        set $micropanel_redirect \"\";\n    }\n" +
+				"    if ($arg_ref = \"\") {\n        set $micropanel_redirect \"\";\n    }\n    if ($micropanel_redirect) {\n        return 301 $micropanel_redirect;\n    }\n",
+			"    set $micropanel_redirect $micropanel_site_4_redirect_2;\n    if ($arg_lang != \"fr\") {\n        set $micropanel_redirect \"\";\n    }\n",
+		} {
+			// The staging server block does not get the redirects
+			if n := strings.Count(config, want); n != 1 {
+				t.Errorf("config (ssl %v) has %q %d times, want 1", ssl, want, n)
+			}
+		}
+		if strings.Contains(config, "redirect_4") {
+			t.Errorf("config (ssl %v) has the disabled redirect", ssl)
+		}
+
+		// Same priority: exact paths, then the longer prefix first
+		var order []int
+		for _, id := range []int{3, 1, 6, 5, 2} {
+			order = append(order, strings.Index(config, fmt.Sprintf("set $micropanel_redirect $micropanel_site_4_redirect_%d;", id)))
+		}
+		if slices.Contains(order, -1) || !slices.IsSorted(order) {
+			t.Errorf("config (ssl %v) checks the redirects at %v, want them in order", ssl, order)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// MaxSnippetSize bounds a snippet, in bytes.
+const MaxSnippetSize = 8 * 1024
+
+var (
+	ErrInvalidSnippetHook = errors.New("snippet hook must be server, before_location or location")
+	ErrSnippetTooLong     = fmt.Errorf("a snippet is at most %d bytes", MaxSnippetSize)
+	ErrInvalidSnippet     = errors.New("invalid snippet")
+	ErrDeniedDirective    = errors.New("directive not allowed in snippets")
+	ErrSnippetPath        = errors.New("path not allowed in snippets")
+	ErrSnippetRejected    = errors.New("nginx rejected the snippet")
+)
+
+// snippetBlocks are the block directives a snippet may open.
+var snippetBlocks = []string{"location", "if", "limit_except", "types"}
+
+// snippetDeniedDirectives would reach outside the site: they change what the
+// server listens to, run code, or make nginx, which opens files as root,
+// read or write files anywhere. Directives starting with one of
+// snippetDeniedPrefixes, ending in _file or _crl, or holding certificate or
+// _by_lua are denied too.
+var snippetDeniedDirectives = []string{
+	"listen", "server_name", "error_log", "load_module", "user", "env", "pid",
+	"working_directory", "client_body_temp_path", "proxy_temp_path", "fastcgi_temp_path",
+	"uwsgi_temp_path", "scgi_temp_path", "proxy_cache_path", "fastcgi_cache_path",
+	"uwsgi_cache_path", "scgi_cache_path", "proxy_store", "fastcgi_store", "uwsgi_store",
+	"scgi_store", "dav_methods", "create_full_put_path", "xslt_stylesheet", "xml_entities",
+}
+
+var snippetDeniedPrefixes = []string{"ssl_", "lua_", "perl", "js_"}
+
+// NginxSnippetService keeps the custom nginx snippets admins add to sites.
+// Snippets are checked against the directives allowed in them, then by
+// nginx itself before they are kept.
+type NginxSnippetService struct {
+	config       *config.Config
+	snippetRepo  *repository.NginxSnippetRepository
+	nginxService *NginxService
+}
+
+func NewNginxSnippetService(cfg *config.Config, snippetRepo *repository.NginxSnippetRepository, nginxService *NginxService) *NginxSnippetService {
+	return &NginxSnippetService{
+		config:       cfg,
+		snippetRepo:  snippetRepo,
+		nginxService: nginxService,
+	}
+}
+
+// Get returns the snippets of a site by hook; hooks without one are left out.
+func (s *NginxSnippetService) Get(siteID int64) (map[models.SnippetHook]string, error) {
+	snippets, err := s.snippetRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	byHook := make(map[models.SnippetHook]string, len(snippets))
+	for _, snippet := range snippets {
+		byHook[snippet.Hook] = snippet.Content
+	}
+	return byHook, nil
+}
+
+// Set replaces the snippets of a site and applies the nginx config. If
+// nginx -t rejects it, the config file is rolled back, the previous
+// snippets are restored and ErrSnippetRejected is returned with the output
+// of nginx. Other nginx errors are returned as they are, with the snippets
+// kept.
+func (s *NginxSnippetService) Set(site *models.Site, snippets map[models.SnippetHook]string) (map[models.SnippetHook]string, error) {
+	for hook := range snippets {
+		if !hook.Valid() {
+			return nil, ErrInvalidSnippetHook
+		}
+	}
+
+	normalized := make(map[models.SnippetHook]string, len(snippets))
+	var list []*models.NginxSnippet
+	for _, hook := range models.SnippetHooks {
+		content, err := s.normalizeSnippet(site.ID, hook, snippets[hook])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hook, err)
+		}
+		if content != "" {
+			normalized[hook] = content
+			list = append(list, &models.NginxSnippet{Hook: hook, Content: content})
+		}
+	}
+
+	previous, err := s.snippetRepo.ListBySite(site.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.snippetRepo.ReplaceForSite(site.ID, list); err != nil {
+		return nil, err
+	}
+
+	if err := s.nginxService.ApplyConfig(site.ID); err != nil {
+		if !errors.Is(err, ErrNginxTestFailed) {
+			return normalized, err
+		}
+		if restoreErr := s.snippetRepo.ReplaceForSite(site.ID, previous); restoreErr != nil {
+			return nil, fmt.Errorf("%w (restoring the previous snippets failed: %v)", err, restoreErr)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrSnippetRejected, err)
+	}
+	return normalized, nil
+}
+
+// normalizeSnippet checks a snippet for a hook. Line endings are unified
+// and trailing blank space is dropped.
+func (s *NginxSnippetService) normalizeSnippet(siteID int64, hook models.SnippetHook, content string) (string, error) {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	content = strings.Trim(strings.Join(lines, "\n"), "\n")
+	if content == "" {
+		return "", nil
+	}
+	if len(content) > MaxSnippetSize {
+		return "", ErrSnippetTooLong
+	}
+
+	directives, err := parseSnippet(content)
+	if err != nil {
+		return "", err
+	}
+	site := siteDir(s.config.Sites.Path, siteID)
+	roots := []string{filepath.Join(site, currentLinkName), filepath.Join(site, sharedDirName)}
+	for _, d := range directives {
+		if err := checkSnippetDirective(d, roots, s.config.Nginx.SnippetIncludeDirs); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// snippetDirective is a directive of a snippet with its arguments, unquoted.
+type snippetDirective struct {
+	Name  string
+	Args  []string
+	Line  int
+	Block bool // opens a block
+}
+
+// parseSnippet splits nginx config into its directives the way nginx reads
+// it. The braces must balance, so a snippet cannot close the block it is
+// inserted in, and quoted strings cannot span lines, so indenting the
+// snippet does not change them.
+func parseSnippet(content string) ([]snippetDirective, error) {
+	var (
+		directives []snippetDirective
+		words      []string
+		depth      int
+		line       = 1
+		first      int // line of the directive name
+	)
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%w: line %d: %s", ErrInvalidSnippet, line, fmt.Sprintf(format, args...))
+	}
+	addWord := func(word string) {
+		if len(words) == 0 {
+			first = line
+		}
+		words = append(words, word)
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == ' ' || c == '\t':
+		case c < ' ' || c == 0x7f:
+			return nil, fail("control character")
+		case c == '#':
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{':
+			if len(words) == 0 {
+				return nil, fail("unexpected %q", c)
+			}
+			directives = append(directives, snippetDirective{Name: words[0], Args: words[1:], Line: first, Block: c == '{'})
+			words = nil
+			if c == '{' {
+				depth++
+			}
+		case c == '}':
+			if len(words) > 0 {
+				return nil, fail(`unexpected "}", missing ";"`)
+			}
+			if depth == 0 {
+				return nil, fail(`unexpected "}"`)
+			}
+			depth--
+		case c == '"' || c == '\'':
+			var word strings.Builder
+			for i++; i < len(content) && content[i] != c; i++ {
+				switch {
+				case content[i] == '\n':
+					return nil, fail("quoted string spans lines")
+				case content[i] == '\\' && i+1 < len(content) && content[i+1] != '\n':
+					i++
+					writeSnippetEscape(&word, content[i])
+				default:
+					word.WriteByte(content[i])
+				}
+			}
+			if i == len(content) {
+				return nil, fail("unterminated quoted string")
+			}
+			if i+1 < len(content) && !strings.ContainsRune(" \t\n;{)", rune(content[i+1])) {
+				return nil, fail("unexpected %q after quoted string", content[i+1])
+			}
+			addWord(word.String())
+		default:
+			var word strings.Builder
+			for ; i < len(content); i++ {
+				ch := content[i]
+				if strings.ContainsRune(" \t\n;{", rune(ch)) || ch < ' ' || ch == 0x7f {
+					break
+				}
+				switch {
+				case ch == '\\' && i+1 < len(content) && content[i+1] >= ' ':
+					i++
+					writeSnippetEscape(&word, content[i])
+				case ch == '$' && i+1 < len(content) && content[i+1] == '{':
+					i++
+					word.WriteString("${")
+				default:
+					word.WriteByte(ch)
+				}
+			}
+			addWord(word.String())
+			i-- // the character ending the word is read again
+		}
+	}
+
+	if len(words) > 0 {
+		return nil, fail(`unexpected end of snippet, missing ";"`)
+	}
+	if depth > 0 {
+		return nil, fail(`unexpected end of snippet, missing "}"`)
+	}
+	return directives, nil
+}
+
+// writeSnippetEscape writes the character escaped by a backslash the way
+// nginx reads it: quotes and backslashes lose the backslash, others keep it.
+func writeSnippetEscape(word *strings.Builder, c byte) {
+	switch c {
+	case '"', '\'', '\\':
+	default:
+		word.WriteByte('\\')
+	}
+	word.WriteByte(c)
+}
+
+// checkSnippetDirective checks a directive is allowed in snippets: root and
+// alias must point into one of roots, include into one of includeDirs.
+func checkSnippetDirective(d snippetDirective, roots, includeDirs []string) error {
+	name := strings.ToLower(d.Name)
+	denied := func() error {
+		return fmt.Errorf("%w: %s (line %d)", ErrDeniedDirective, d.Name, d.Line)
+	}
+
+	if d.Block && !slices.Contains(snippetBlocks, name) {
+		return denied()
+	}
+	switch name {
+	case "root", "alias":
+		return checkSnippetPath(d, roots)
+	case "include":
+		return checkSnippetPath(d, includeDirs)
+	case "access_log":
+		if len(d.Args) != 1 || d.Args[0] != "off" {
+			return fmt.Errorf("%w: access_log can only be turned off (line %d)", ErrDeniedDirective, d.Line)
+		}
+		return nil
+	}
+	if slices.Contains(snippetDeniedDirectives, name) || strings.HasSuffix(name, "_file") || strings.HasSuffix(name, "_crl") ||
+		strings.Contains(name, "certificate") || strings.Contains(name, "_by_lua") {
+		return denied()
+	}
+	for _, prefix := range snippetDeniedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return denied()
+		}
+	}
+	return nil
+}
+
+// checkSnippetPath checks the path of a root, alias or include directive is
+// a plain absolute path inside one of dirs.
+func checkSnippetPath(d snippetDirective, dirs []string) error {
+	if len(d.Args) != 1 {
+		return fmt.Errorf("%w: %s takes one path (line %d)", ErrInvalidSnippet, d.Name, d.Line)
+	}
+	path := d.Args[0]
+	trimmed := strings.TrimSuffix(path, "/") // alias of a location ending in /
+	if !filepath.IsAbs(path) || strings.ContainsAny(path, `$\`) || filepath.Clean(trimmed) != trimmed {
+		return fmt.Errorf("%w: %s %s (line %d)", ErrSnippetPath, d.Name, path, d.Line)
+	}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if trimmed == dir || strings.HasPrefix(trimmed, dir+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s %s (line %d)", ErrSnippetPath, d.Name, path, d.Line)
+}
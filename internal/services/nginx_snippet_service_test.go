@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+)
+
+func TestParseSnippet(t *testing.T) {
+	directives, err := parseSnippet("client_max_body_size 50m; # uploads\nlocation ~* \"\\.(?:pdf)$\" {\n    add_header X-A 'a;b}';\n    set $v ${uri}x}y;\n}")
+	if err != nil {
+		t.Fatalf("parseSnippet() error = %v", err)
+	}
+	want := []snippetDirective{
+		{Name: "client_max_body_size", Args: []string{"50m"}, Line: 1},
+		{Name: "location", Args: []string{"~*", `\.(?:pdf)$`}, Line: 2, Block: true},
+		{Name: "add_header", Args: []string{"X-A", "a;b}"}, Line: 3},
+		{Name: "set", Args: []string{"$v", "${uri}x}y"}, Line: 4},
+	}
+	if !reflect.DeepEqual(directives, want) {
+		t.Errorf("parseSnippet() = %+v, want %+v", directives, want)
+	}
+
+	for _, content := range []string{
+		"}\nserver { listen 8080; }",         // closes the block it is inserted in
+		"location / { return 404;",           // leaves a block open
+		"return 404",                         // missing ;
+		"return 404 }",                       // missing ; before }
+		"return \"404;",                      // unterminated quote
+		"add_header X \"a\nb\";",             // quote across lines
+		"add_header X \"a\"b;",               // text right after a quote
+		"; return 404;",                      // empty directive
+		"return 404;\x00",                    // control character
+		"add_header X-A \"\\\";\n} server {", // escaped quote keeps the string open
+	} {
+		if _, err := parseSnippet(content); !errors.Is(err, ErrInvalidSnippet) {
+			t.Errorf("parseSnippet(%q) error = %v, want %v", content, err, ErrInvalidSnippet)
+		}
+	}
+}
+
+func TestNginxSnippetService_NormalizeSnippet(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Sites.Path = "/var/www/sites"
+	cfg.Nginx.SnippetIncludeDirs = []string{"/etc/nginx/snippets"}
+	svc := NewNginxSnippetService(cfg, nil, nil)
+
+	got, err := svc.normalizeSnippet(3, models.SnippetHookBeforeLocation, "\r\nlocation /docs/ {  \r\n    alias /var/www/sites/3/shared/docs/;\r\n    include /etc/nginx/snippets/cors.conf;\r\n    access_log off;\r\n}\r\n\r\n")
+	if err != nil {
+		t.Fatalf("normalizeSnippet() error = %v", err)
+	}
+	want := "location /docs/ {\n    alias /var/www/sites/3/shared/docs/;\n    include /etc/nginx/snippets/cors.conf;\n    access_log off;\n}"
+	if got != want {
+		t.Errorf("normalizeSnippet() = %q, want %q", got, want)
+	}
+	if got, err := svc.normalizeSnippet(3, models.SnippetHookServer, " \n\t\n"); got != "" || err != nil {
+		t.Errorf("normalizeSnippet() of a blank snippet = %q, %v", got, err)
+	}
+
+	tests := []struct {
+		content string
+		want    error
+	}{
+		{"root /etc;", ErrSnippetPath},
+		{"root /var/www/sites/4/current;", ErrSnippetPath},
+		{"root /var/www/sites/3/current/../../4/current;", ErrSnippetPath},
+		{"root /var/www/sites/3/current$uri;", ErrSnippetPath},
+		{"root /var/www/sites/3/currently;", ErrSnippetPath},
+		{"location /a { alias /home/; }", ErrSnippetPath},
+		{"include snippets/cors.conf;", ErrSnippetPath},
+		{"include /etc/nginx/snippets/../nginx.conf;", ErrSnippetPath},
+		{"include /etc/nginx/sites-enabled/*;", ErrSnippetPath},
+		{"listen 8080;", ErrDeniedDirective},
+		{"server_name evil.example.com;", ErrDeniedDirective},
+		{"error_log /etc/passwd;", ErrDeniedDirective},
+		{"access_log /etc/cron.d/x;", ErrDeniedDirective},
+		{"ssl_certificate /etc/ssl/other.pem;", ErrDeniedDirective},
+		{"auth_basic_user_file /etc/shadow;", ErrDeniedDirective},
+		{"proxy_ssl_trusted_certificate /etc/ssl/ca.pem;", ErrDeniedDirective},
+		{"client_body_temp_path /etc;", ErrDeniedDirective},
+		{"content_by_lua 'ngx.say(1)';", ErrDeniedDirective},
+		{"perl_set $x 'sub { 1 }';", ErrDeniedDirective},
+		{"js_import /tmp/x.js;", ErrDeniedDirective},
+		{"server { listen 81; }", ErrDeniedDirective},
+		{"upstream x { server 127.0.0.1; }", ErrDeniedDirective},
+		{"}", ErrInvalidSnippet},
+	}
+	for _, tt := range tests {
+		if _, err := svc.normalizeSnippet(3, models.SnippetHookServer, tt.content); !errors.Is(err, tt.want) {
+			t.Errorf("normalizeSnippet(%q) error = %v, want %v", tt.content, err, tt.want)
+		}
+	}
+
+	long := make([]byte, MaxSnippetSize+1)
+	for i := range long {
+		long[i] = '#'
+	}
+	if _, err := svc.normalizeSnippet(3, models.SnippetHookServer, string(long)); !errors.Is(err, ErrSnippetTooLong) {
+		t.Errorf("normalizeSnippet() of a long snippet error = %v, want %v", err, ErrSnippetTooLong)
+	}
+
+	if _, err := svc.Set(&models.Site{ID: 3}, map[models.SnippetHook]string{"http": "gzip on;"}); !errors.Is(err, ErrInvalidSnippetHook) {
+		t.Errorf("Set() with an unknown hook error = %v, want %v", err, ErrInvalidSnippetHook)
+	}
+}
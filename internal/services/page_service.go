@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// maxPageChoices bounds the HTML files of a release offered as error pages.
+const maxPageChoices = 500
+
+// errorPageRegex accepts paths from the site root made of plain file names,
+// which nginx takes as they are in error_page and location.
+var errorPageRegex = regexp.MustCompile(`^(/[a-zA-Z0-9_][a-zA-Z0-9._-]*)+\.html?$`)
+
+var (
+	ErrInvalidErrorPage  = errors.New("error page must be the path of an .html file inside the site")
+	ErrErrorPageNotFound = errors.New("error page is not a file of the live release")
+	ErrSPAFallbackType   = errors.New("SPA fallback is only available for static sites")
+	ErrSPAIndexNotFound  = errors.New("SPA fallback needs an index.html in the live release")
+)
+
+// PageService keeps how sites answer paths without a file: the SPA fallback
+// to /index.html and the custom error pages, both files of the live release.
+type PageService struct {
+	config   *config.Config
+	siteRepo *repository.SiteRepository
+}
+
+func NewPageService(cfg *config.Config, siteRepo *repository.SiteRepository) *PageService {
+	return &PageService{
+		config:   cfg,
+		siteRepo: siteRepo,
+	}
+}
+
+// Set changes the SPA fallback and error pages of a site. Pages are checked
+// against the live release, so they point at files that exist when saved;
+// a later deploy without them gets the nginx default pages.
+func (s *PageService) Set(site *models.Site, pages models.SitePages) (models.SitePages, error) {
+	root := siteCurrentPath(s.config.Sites.Path, site.ID)
+
+	if pages.SPAFallback {
+		if site.Type != models.SiteTypeStatic {
+			return pages, ErrSPAFallbackType
+		}
+		if !isReleaseFile(root, "/index.html") {
+			return pages, ErrSPAIndexNotFound
+		}
+	}
+
+	for _, page := range []*string{&pages.ErrorPage404, &pages.ErrorPage403, &pages.ErrorPage50x} {
+		normalized, err := normalizeErrorPage(*page)
+		if err != nil {
+			return pages, err
+		}
+		if normalized != "" && !isReleaseFile(root, normalized) {
+			return pages, fmt.Errorf("%w: %s", ErrErrorPageNotFound, normalized)
+		}
+		*page = normalized
+	}
+
+	site.SPAFallback = pages.SPAFallback
+	site.ErrorPage404 = pages.ErrorPage404
+	site.ErrorPage403 = pages.ErrorPage403
+	site.ErrorPage50x = pages.ErrorPage50x
+	if err := s.siteRepo.Update(site); err != nil {
+		return pages, err
+	}
+	return pages, nil
+}
+
+// ListPages returns the HTML files of the live release of a site that can
+// be chosen as error pages, sorted and at most maxPageChoices of them.
+func (s *PageService) ListPages(siteID int64) ([]string, error) {
+	root, err := filepath.EvalSymlinks(siteCurrentPath(s.config.Sites.Path, siteID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		page := "/" + filepath.ToSlash(rel)
+		if !errorPageRegex.MatchString(page) {
+			return nil
+		}
+		pages = append(pages, page)
+		if len(pages) >= maxPageChoices {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(pages)
+	return pages, nil
+}
+
+// normalizeErrorPage returns an error page as a path from the site root,
+// "" when none is given.
+func normalizeErrorPage(page string) (string, error) {
+	page = strings.TrimSpace(page)
+	if page == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(page, "/") {
+		page = "/" + page
+	}
+	if len(page) > 255 || path.Clean(page) != page || !errorPageRegex.MatchString(page) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidErrorPage, page)
+	}
+	return page, nil
+}
+
+// isReleaseFile reports whether page is a regular file under root, the
+// current symlink of a site.
+func isReleaseFile(root, page string) bool {
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(page)))
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"micropanel/internal/config"
+)
+
+func TestNormalizeErrorPage(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"404.html", "/404.html"},
+		{" /errors/50x.htm ", "/errors/50x.htm"},
+		{"/errors/not-found_v2.html", "/errors/not-found_v2.html"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeErrorPage(tt.page)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeErrorPage(%q) = %q, %v, want %q", tt.page, got, err, tt.want)
+		}
+	}
+
+	for _, page := range []string{"/../404.html", "/errors/../404.html", "/.hidden/404.html", "//404.html", "/404.php", "/404 page.html", "/404.html;", "/errors/"} {
+		if _, err := normalizeErrorPage(page); !errors.Is(err, ErrInvalidErrorPage) {
+			t.Errorf("normalizeErrorPage(%q) error = %v, want ErrInvalidErrorPage", page, err)
+		}
+	}
+}
+
+func TestPageService_ListPages(t *testing.T) {
+	sitesDir := t.TempDir()
+	release := filepath.Join(sitesDir, "1", "releases", "2")
+	for _, name := range []string{"index.html", "404.html", "errors/50x.htm", "app.js", ".git/index.html", "errors/.draft.html", "bad name.html"} {
+		path := filepath.Join(release, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("<h1>page</h1>"), 0644)
+	}
+	os.Symlink(filepath.Join("releases", "2"), filepath.Join(sitesDir, "1", "current"))
+
+	s := &PageService{config: &config.Config{Sites: config.SitesConfig{Path: sitesDir}}}
+	got, err := s.ListPages(1)
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	want := []string{"/404.html", "/errors/50x.htm", "/index.html"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListPages() = %v, want %v", got, want)
+	}
+
+	if got, err := s.ListPages(2); err != nil || got != nil {
+		t.Errorf("ListPages() of a site without release = %v, %v, want nil", got, err)
+	}
+
+	root := filepath.Join(sitesDir, "1", "current")
+	if !isReleaseFile(root, "/errors/50x.htm") || isReleaseFile(root, "/errors") || isReleaseFile(root, "/missing.html") {
+		t.Error("isReleaseFile() does not tell files of the release apart")
+	}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+
+	"micropanel/internal/models"
+)
+
+// PrecompressMinSize is the size below which text assets are served as they
+// are; compressing them saves less than the headers cost.
+const PrecompressMinSize = 1024
+
+// brotliLevel trades some ratio for speed: level 11 takes seconds on a large
+// bundle and every deploy of the site pays it again.
+const brotliLevel = 9
+
+// precompressExtensions are the text assets that get .gz and .br copies.
+var precompressExtensions = map[string]bool{
+	".html":        true,
+	".htm":         true,
+	".css":         true,
+	".js":          true,
+	".mjs":         true,
+	".json":        true,
+	".map":         true,
+	".xml":         true,
+	".svg":         true,
+	".txt":         true,
+	".wasm":        true,
+	".webmanifest": true,
+}
+
+// precompressedSuffixes are appended to the name of an asset for its
+// precompressed copies, as gzip_static and brotli_static expect.
+var precompressedSuffixes = []string{".gz", ".br"}
+
+// isPrecompressible reports whether a file of this name and size gets
+// precompressed copies.
+func isPrecompressible(name string, size int64) bool {
+	return size >= PrecompressMinSize && precompressExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// isPrecompressedCopy reports whether name is the precompressed copy of a
+// file listed in names.
+func isPrecompressedCopy(name string, names map[string]bool) bool {
+	for _, suffix := range precompressedSuffixes {
+		if source, ok := strings.CutSuffix(name, suffix); ok && names[source] && isPrecompressible(source, PrecompressMinSize) {
+			return true
+		}
+	}
+	return false
+}
+
+// precompressRelease writes compressed copies of the text assets of a
+// release. Assets with a .gz copy at least as new as themselves, such as one
+// built by the site's own tooling, are left alone.
+func precompressRelease(releasePath string, withBrotli bool) (int, error) {
+	count := 0
+	err := filepath.WalkDir(releasePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !isPrecompressible(d.Name(), info.Size()) {
+			return nil
+		}
+		if copyInfo, err := os.Lstat(p + ".gz"); err == nil && !copyInfo.ModTime().Before(info.ModTime()) {
+			return nil
+		}
+		if err := precompressFile(p, withBrotli); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// precompressFile replaces the compressed copies of a file. A file that no
+// longer qualifies, or does not compress, loses its copies, so nginx never
+// serves a stale one.
+func precompressFile(fullPath string, withBrotli bool) error {
+	info, err := os.Lstat(fullPath)
+	if err != nil || !info.Mode().IsRegular() || !isPrecompressible(fullPath, info.Size()) {
+		removePrecompressed(fullPath)
+		return nil
+	}
+
+	gzipWriter := func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	}
+	if err := writeCompressed(fullPath, ".gz", info, gzipWriter); err != nil {
+		return err
+	}
+
+	if !withBrotli {
+		os.Remove(fullPath + ".br")
+		return nil
+	}
+	brotliWriter := func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotliLevel), nil
+	}
+	return writeCompressed(fullPath, ".br", info, brotliWriter)
+}
+
+// writeCompressed writes the compressed copy of a file next to it. The copy
+// is dropped when it is not smaller than the file. It gets the modification
+// time of the file, so both are sent with the same Last-Modified and ETag.
+func writeCompressed(fullPath, suffix string, info os.FileInfo, newWriter func(io.Writer) (io.WriteCloser, error)) error {
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+suffix+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	w, err := newWriter(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compress %s: %w", filepath.Base(fullPath), err)
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	compressed, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if compressed.Size() >= info.Size() {
+		os.Remove(fullPath + suffix)
+		return nil
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fullPath+suffix)
+}
+
+// removePrecompressed removes the compressed copies of a file.
+func removePrecompressed(fullPath string) {
+	for _, suffix := range precompressedSuffixes {
+		os.Remove(fullPath + suffix)
+	}
+}
+
+// PrecompressCurrent writes the compressed copies missing from the current
+// release of a site, for sites that have just enabled precompression.
+func (s *DeployService) PrecompressCurrent(siteID int64) error {
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := currentRelease(s.sitePath(siteID))
+	if err != nil {
+		return nil
+	}
+	releasePath := s.releasePath(siteID, current)
+	if _, err := precompressRelease(releasePath, s.config.Nginx.BrotliStatic); err != nil {
+		return err
+	}
+	s.chownPath(releasePath)
+	return nil
+}
+
+// precompress writes the compressed copies of a release when its site asks
+// for them. A failure is only logged: nginx serves the release without them.
+func (s *DeployService) precompress(deploy *models.Deploy, releasePath string) {
+	site, err := s.siteRepo.GetByID(deploy.SiteID)
+	if err != nil || !site.Precompress {
+		return
+	}
+
+	s.setPhase(deploy, models.DeployPhaseCompressing, 100)
+
+	count, err := precompressRelease(releasePath, s.config.Nginx.BrotliStatic)
+	if err != nil {
+		slog.Warn("failed to precompress release", "deploy_id", deploy.ID, "site_id", deploy.SiteID, "error", err)
+		return
+	}
+	slog.Debug("precompressed release", "deploy_id", deploy.ID, "site_id", deploy.SiteID, "files", count)
+}
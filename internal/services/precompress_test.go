@@ -0,0 +1,149 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestPrecompressFile(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("body { color: red; }\n", 200)
+	path := filepath.Join(dir, "app.css")
+	os.WriteFile(path, []byte(content), 0644)
+
+	if err := precompressFile(path, true); err != nil {
+		t.Fatalf("precompressFile() error = %v", err)
+	}
+
+	gz, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatalf("open .gz: %v", err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != content {
+		t.Error(".gz content differs from the file")
+	}
+
+	br, err := os.Open(path + ".br")
+	if err != nil {
+		t.Fatalf("open .br: %v", err)
+	}
+	defer br.Close()
+	if data, _ := io.ReadAll(brotli.NewReader(br)); string(data) != content {
+		t.Error(".br content differs from the file")
+	}
+
+	src, _ := os.Stat(path)
+	copyInfo, _ := os.Stat(path + ".gz")
+	if !copyInfo.ModTime().Equal(src.ModTime()) {
+		t.Errorf(".gz modification time = %v, want %v", copyInfo.ModTime(), src.ModTime())
+	}
+
+	// Without brotli the .br copy is dropped rather than left stale
+	if err := precompressFile(path, false); err != nil {
+		t.Fatalf("precompressFile() without brotli error = %v", err)
+	}
+	if _, err := os.Stat(path + ".br"); !os.IsNotExist(err) {
+		t.Error(".br copy should be removed")
+	}
+
+	// A file that shrank below the threshold loses its copies
+	os.WriteFile(path, []byte("a{}"), 0644)
+	if err := precompressFile(path, true); err != nil {
+		t.Fatalf("precompressFile() for a small file error = %v", err)
+	}
+	if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+		t.Error(".gz copy of a small file should be removed")
+	}
+}
+
+func TestPrecompressFile_Incompressible(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	// Random-looking bytes do not get smaller
+	data := make([]byte, 4096)
+	seed := uint32(1)
+	for i := range data {
+		seed = seed*1664525 + 1013904223
+		data[i] = byte(seed >> 24)
+	}
+	os.WriteFile(path, data, 0644)
+
+	if err := precompressFile(path, false); err != nil {
+		t.Fatalf("precompressFile() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+		t.Error("a copy that is not smaller should not be kept")
+	}
+}
+
+func TestPrecompressRelease(t *testing.T) {
+	release := t.TempDir()
+	text := strings.Repeat("<p>hello</p>\n", 200)
+	files := map[string]string{
+		"index.html":      text,
+		"assets/app.js":   text,
+		"assets/logo.png": text,
+		"small.txt":       "hi",
+		"built.js":        text,
+	}
+	for name, content := range files {
+		path := filepath.Join(release, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	// A copy built by the site's tooling after its source is kept
+	os.WriteFile(filepath.Join(release, "built.js.gz"), []byte("own"), 0644)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(release, "built.js.gz"), later, later)
+
+	count, err := precompressRelease(release, false)
+	if err != nil {
+		t.Fatalf("precompressRelease() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("precompressRelease() = %d files, want 2", count)
+	}
+
+	for name, want := range map[string]bool{
+		"index.html.gz":      true,
+		"assets/app.js.gz":   true,
+		"assets/logo.png.gz": false,
+		"small.txt.gz":       false,
+	} {
+		_, err := os.Stat(filepath.Join(release, filepath.FromSlash(name)))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", name, got, want)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(release, "built.js.gz")); string(data) != "own" {
+		t.Error("precompressRelease() replaced a copy newer than its source")
+	}
+}
+
+func TestIsPrecompressedCopy(t *testing.T) {
+	names := map[string]bool{"app.js": true, "app.js.gz": true, "app.js.br": true, "backup.tar.gz": true, "logo.png": true, "logo.png.gz": true}
+
+	tests := map[string]bool{
+		"app.js.gz":     true,
+		"app.js.br":     true,
+		"backup.tar.gz": false,
+		"logo.png.gz":   false,
+		"other.js.gz":   false,
+	}
+	for name, want := range tests {
+		if got := isPrecompressedCopy(name, names); got != want {
+			t.Errorf("isPrecompressedCopy(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/validators"
+)
+
+const (
+	// MaxProxyUpstreams bounds the upstreams of a site.
+	MaxProxyUpstreams = 16
+	// MaxProxyPassHeaders bounds the upstream headers passed to clients.
+	MaxProxyPassHeaders = 20
+	// DefaultProxyTimeout is the nginx default for the proxy timeouts, in seconds.
+	DefaultProxyTimeout = 60
+	// MaxProxyTimeout bounds the proxy timeouts, in seconds.
+	MaxProxyTimeout = 3600
+)
+
+var (
+	ErrInvalidSiteType     = errors.New("site type must be static, proxy or hybrid")
+	ErrNoUpstream          = errors.New("proxy and hybrid sites need an upstream")
+	ErrTooManyUpstreams    = fmt.Errorf("a site has at most %d upstreams", MaxProxyUpstreams)
+	ErrInvalidProxyTimeout = fmt.Errorf("proxy timeouts must be between 1 and %d seconds", MaxProxyTimeout)
+	ErrTooManyPassHeaders  = fmt.Errorf("a site passes at most %d headers", MaxProxyPassHeaders)
+)
+
+// ProxyService keeps the type of sites and the upstream proxy and hybrid
+// sites pass requests to.
+type ProxyService struct {
+	proxyRepo *repository.ProxyRepository
+	siteRepo  *repository.SiteRepository
+}
+
+func NewProxyService(proxyRepo *repository.ProxyRepository, siteRepo *repository.SiteRepository) *ProxyService {
+	return &ProxyService{
+		proxyRepo: proxyRepo,
+		siteRepo:  siteRepo,
+	}
+}
+
+// Get returns the upstream of a site, nil if it has none.
+func (s *ProxyService) Get(siteID int64) (*models.ProxyConfig, error) {
+	proxy, err := s.proxyRepo.GetBySite(siteID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return proxy, err
+}
+
+// Set changes the type of a site and, unless proxy is nil, its upstream.
+// Zero timeouts are set to the nginx default. Proxy and hybrid sites need an
+// upstream, given or stored before; a static site keeps the one it has
+// unless it is given one without upstreams, which removes it.
+func (s *ProxyService) Set(site *models.Site, siteType models.SiteType, proxy *models.ProxyConfig) (*models.ProxyConfig, error) {
+	if !siteType.Valid() {
+		return nil, ErrInvalidSiteType
+	}
+
+	if proxy != nil {
+		normalized, err := normalizeProxyConfig(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = normalized
+		proxy.SiteID = site.ID
+	} else {
+		existing, err := s.Get(site.ID)
+		if err != nil {
+			return nil, err
+		}
+		proxy = existing
+	}
+
+	hasUpstream := proxy != nil && len(proxy.Upstreams) > 0
+	if siteType != models.SiteTypeStatic && !hasUpstream {
+		return nil, ErrNoUpstream
+	}
+
+	switch {
+	case hasUpstream:
+		if err := s.proxyRepo.Set(proxy); err != nil {
+			return nil, err
+		}
+	case proxy != nil:
+		if err := s.proxyRepo.Delete(site.ID); err != nil {
+			return nil, err
+		}
+		proxy = nil
+	}
+
+	if site.Type != siteType {
+		site.Type = siteType
+		if err := s.siteRepo.Update(site); err != nil {
+			return nil, err
+		}
+	}
+	return proxy, nil
+}
+
+// normalizeProxyConfig checks an upstream and writes it the way nginx
+// expects. Blank and repeated upstreams and headers are dropped.
+func normalizeProxyConfig(proxy *models.ProxyConfig) (*models.ProxyConfig, error) {
+	normalized := &models.ProxyConfig{
+		ConnectTimeout: proxy.ConnectTimeout,
+		ReadTimeout:    proxy.ReadTimeout,
+		SendTimeout:    proxy.SendTimeout,
+		WebSocket:      proxy.WebSocket,
+	}
+
+	seen := make(map[string]bool)
+	for _, upstream := range proxy.Upstreams {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" || seen[upstream] {
+			continue
+		}
+		if err := validators.ValidateUpstream(upstream); err != nil {
+			return nil, fmt.Errorf("%w: %q", err, upstream)
+		}
+		seen[upstream] = true
+		normalized.Upstreams = append(normalized.Upstreams, upstream)
+	}
+	if len(normalized.Upstreams) > MaxProxyUpstreams {
+		return nil, ErrTooManyUpstreams
+	}
+
+	for _, timeout := range []*int{&normalized.ConnectTimeout, &normalized.ReadTimeout, &normalized.SendTimeout} {
+		if *timeout == 0 {
+			*timeout = DefaultProxyTimeout
+		}
+		if *timeout < 1 || *timeout > MaxProxyTimeout {
+			return nil, ErrInvalidProxyTimeout
+		}
+	}
+
+	seen = make(map[string]bool)
+	for _, name := range proxy.PassHeaders {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err := validators.ValidateHeaderName(name); err != nil {
+			return nil, fmt.Errorf("%w: %q", err, name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			normalized.PassHeaders = append(normalized.PassHeaders, name)
+		}
+	}
+	if len(normalized.PassHeaders) > MaxProxyPassHeaders {
+		return nil, ErrTooManyPassHeaders
+	}
+
+	return normalized, nil
+}
@@ -2,6 +2,12 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"micropanel/internal/models"
 	"micropanel/internal/repository"
@@ -9,9 +15,23 @@ import (
 )
 
 var (
-	ErrInvalidRedirectCode = errors.New("redirect code must be 301 or 302")
-	ErrInvalidSourcePath   = errors.New("source path must start with /")
-	ErrInvalidTargetURL    = errors.New("target URL is required")
+	ErrInvalidRedirectCode  = errors.New("redirect code must be 301, 302, 307 or 308")
+	ErrInvalidSourcePath    = errors.New("source path must start with /")
+	ErrInvalidTargetURL     = errors.New("target URL is required")
+	ErrInvalidRedirectMatch = errors.New("match type must be exact, prefix or regex")
+	ErrInvalidRedirectRegex = errors.New("source is not a valid regular expression")
+	ErrInvalidCapture       = errors.New("target URL can only use the captures of a regex source, as $1 to $9")
+	ErrInvalidRedirectHost  = errors.New("host condition must be a domain name")
+	ErrInvalidQueryArg      = errors.New("query argument must be letters, digits and underscores")
+	ErrInvalidQueryValue    = errors.New("query value must be URL characters and needs a query argument")
+	ErrRedirectLoop         = errors.New("target URL matches the source again, the redirect would loop")
+	ErrRedirectRejected     = errors.New("nginx rejected the redirect")
+)
+
+var (
+	queryArgRegex   = regexp.MustCompile(`^[a-zA-Z0-9_]{1,64}$`)
+	queryValueRegex = regexp.MustCompile(`^[a-zA-Z0-9._~%+\-]{1,256}$`)
+	captureRegex    = regexp.MustCompile(`\$(.?)`)
 )
 
 type RedirectService struct {
@@ -26,32 +46,31 @@ func NewRedirectService(redirectRepo *repository.RedirectRepository, nginxServic
 	}
 }
 
-func (s *RedirectService) Create(siteID int64, sourcePath, targetURL string, code int, preservePath, preserveQuery bool, priority int) (*models.Redirect, error) {
-	if err := s.validateRedirect(sourcePath, targetURL, code); err != nil {
-		return nil, err
-	}
-
-	redirect := &models.Redirect{
-		SiteID:        siteID,
-		SourcePath:    sourcePath,
-		TargetURL:     targetURL,
-		Code:          code,
-		PreservePath:  preservePath,
-		PreserveQuery: preserveQuery,
-		Priority:      priority,
-		IsEnabled:     true,
+// Create adds an enabled redirect to a site. A redirect without a match
+// type is a prefix redirect. Regex sources are checked with Go's regexp
+// while nginx uses PCRE, so a redirect nginx rejects is removed again.
+func (s *RedirectService) Create(siteID int64, redirect *models.Redirect) error {
+	if err := normalizeRedirect(redirect); err != nil {
+		return err
 	}
 
+	redirect.SiteID = siteID
+	redirect.IsEnabled = true
 	if err := s.redirectRepo.Create(redirect); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Regenerate nginx config
 	if err := s.nginxService.ApplyConfig(siteID); err != nil {
-		return redirect, err
+		if !errors.Is(err, ErrNginxTestFailed) {
+			return err
+		}
+		if removeErr := s.redirectRepo.Delete(redirect.ID); removeErr != nil {
+			return fmt.Errorf("%w (removing the redirect failed: %v)", err, removeErr)
+		}
+		return fmt.Errorf("%w: %v", ErrRedirectRejected, err)
 	}
-
-	return redirect, nil
+	return nil
 }
 
 func (s *RedirectService) GetByID(id int64) (*models.Redirect, error) {
@@ -62,17 +81,32 @@ func (s *RedirectService) ListBySite(siteID int64) ([]*models.Redirect, error) {
 	return s.redirectRepo.ListBySite(siteID)
 }
 
+// Update saves a changed redirect, restoring the previous one when nginx
+// rejects the change.
 func (s *RedirectService) Update(redirect *models.Redirect) error {
-	if err := s.validateRedirect(redirect.SourcePath, redirect.TargetURL, redirect.Code); err != nil {
+	if err := normalizeRedirect(redirect); err != nil {
 		return err
 	}
 
+	previous, err := s.redirectRepo.GetByID(redirect.ID)
+	if err != nil {
+		return err
+	}
 	if err := s.redirectRepo.Update(redirect); err != nil {
 		return err
 	}
 
 	// Regenerate nginx config
-	return s.nginxService.ApplyConfig(redirect.SiteID)
+	if err := s.nginxService.ApplyConfig(redirect.SiteID); err != nil {
+		if !errors.Is(err, ErrNginxTestFailed) {
+			return err
+		}
+		if restoreErr := s.redirectRepo.Update(previous); restoreErr != nil {
+			return fmt.Errorf("%w (restoring the previous redirect failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("%w: %v", ErrRedirectRejected, err)
+	}
+	return nil
 }
 
 func (s *RedirectService) Delete(id int64) error {
@@ -105,18 +139,101 @@ func (s *RedirectService) Toggle(id int64) error {
 	return s.nginxService.ApplyConfig(redirect.SiteID)
 }
 
-func (s *RedirectService) validateRedirect(sourcePath, targetURL string, code int) error {
-	if code != 301 && code != 302 {
+// normalizeRedirect checks a redirect before it is written into the nginx
+// config: the source for its match type, captures in the target, the
+// conditions, and that it does not redirect to itself.
+func normalizeRedirect(r *models.Redirect) error {
+	if r.MatchType == "" {
+		r.MatchType = models.RedirectMatchPrefix
+	}
+	switch r.Code {
+	case 301, 302, 307, 308:
+	default:
 		return ErrInvalidRedirectCode
 	}
 
-	if err := validators.ValidatePath(sourcePath); err != nil {
-		return ErrInvalidSourcePath
+	var re *regexp.Regexp
+	switch r.MatchType {
+	case models.RedirectMatchExact, models.RedirectMatchPrefix:
+		if err := validators.ValidatePath(r.SourcePath); err != nil {
+			return ErrInvalidSourcePath
+		}
+	case models.RedirectMatchRegex:
+		// Quotes and backslash pairs would be read by nginx before PCRE
+		if r.SourcePath == "" || len(r.SourcePath) > 1024 || strings.Contains(r.SourcePath, `"`) ||
+			strings.Contains(r.SourcePath, `\\`) || strings.ContainsFunc(r.SourcePath, unicode.IsControl) {
+			return ErrInvalidRedirectRegex
+		}
+		var err error
+		if re, err = regexp.Compile(r.SourcePath); err != nil {
+			return ErrInvalidRedirectRegex
+		}
+	default:
+		return ErrInvalidRedirectMatch
 	}
 
-	if err := validators.ValidateRedirectURL(targetURL); err != nil {
+	if err := validators.ValidateRedirectURL(r.TargetURL); err != nil || strings.ContainsAny(r.TargetURL, "\\ \t") {
 		return ErrInvalidTargetURL
 	}
+	for _, m := range captureRegex.FindAllStringSubmatch(r.TargetURL, -1) {
+		n, err := strconv.Atoi(m[1])
+		if re == nil || err != nil || n < 1 || n > re.NumSubexp() {
+			return ErrInvalidCapture
+		}
+	}
+
+	r.Host = strings.ToLower(strings.TrimSpace(r.Host))
+	if r.Host != "" && validators.ValidateDomain(r.Host) != nil {
+		return ErrInvalidRedirectHost
+	}
+	r.QueryArg = strings.TrimSpace(r.QueryArg)
+	r.QueryValue = strings.TrimSpace(r.QueryValue)
+	if r.QueryArg != "" && !queryArgRegex.MatchString(r.QueryArg) {
+		return ErrInvalidQueryArg
+	}
+	if r.QueryValue != "" && (r.QueryArg == "" || !queryValueRegex.MatchString(r.QueryValue)) {
+		return ErrInvalidQueryValue
+	}
 
+	if redirectLoops(r, re) {
+		return ErrRedirectLoop
+	}
 	return nil
 }
+
+// redirectLoops reports whether a redirect sends the requests it matches to
+// a URL it matches again. Only targets known to stay on the same host are
+// checked: relative ones, and absolute ones for the host of the condition.
+// Targets using captures depend on the request and are not checked.
+func redirectLoops(r *models.Redirect, re *regexp.Regexp) bool {
+	if strings.Contains(r.TargetURL, "$") {
+		return false
+	}
+	target, err := url.Parse(r.TargetURL)
+	if err != nil {
+		return false
+	}
+	if target.Host != "" && (r.Host == "" || !strings.EqualFold(target.Hostname(), r.Host)) {
+		return false
+	}
+	if r.QueryArg != "" && !r.PreserveQuery && !target.Query().Has(r.QueryArg) {
+		return false
+	}
+
+	path := target.Path
+	if path == "" {
+		path = "/"
+	}
+	switch r.MatchType {
+	case models.RedirectMatchExact:
+		return !r.PreservePath && path == r.SourcePath
+	case models.RedirectMatchPrefix:
+		// With the path preserved, the target is followed by the path
+		// requested, which starts with the source
+		if r.PreservePath {
+			path = target.Path + r.SourcePath
+		}
+		return strings.HasPrefix(path, r.SourcePath)
+	}
+	return !r.PreservePath && re.MatchString(path)
+}
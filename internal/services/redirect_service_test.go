@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+func TestNormalizeRedirect(t *testing.T) {
+	redirect := &models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 308, Host: " Old.Example.com ", QueryArg: " lang "}
+	if err := normalizeRedirect(redirect); err != nil {
+		t.Fatalf("normalizeRedirect() error = %v", err)
+	}
+	if redirect.MatchType != models.RedirectMatchPrefix || redirect.Host != "old.example.com" || redirect.QueryArg != "lang" {
+		t.Errorf("normalizeRedirect() = %+v", redirect)
+	}
+
+	tests := []struct {
+		name     string
+		redirect models.Redirect
+		wantErr  error
+	}{
+		{"exact", models.Redirect{MatchType: models.RedirectMatchExact, SourcePath: "/about.html", TargetURL: "/about", Code: 301}, nil},
+		{"regex with captures", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `^/blog/(\d+)/(.*)$`, TargetURL: "/posts/$2?id=$1", Code: 307}, nil},
+		{"query value", models.Redirect{SourcePath: "/", TargetURL: "https://fr.example.com/", Code: 302, QueryArg: "lang", QueryValue: "fr"}, nil},
+		{"unknown code", models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 303}, ErrInvalidRedirectCode},
+		{"unknown match type", models.Redirect{MatchType: "glob", SourcePath: "/old", TargetURL: "/new", Code: 301}, ErrInvalidRedirectMatch},
+		{"path with regex", models.Redirect{MatchType: models.RedirectMatchPrefix, SourcePath: "/old/(.*)", TargetURL: "/new", Code: 301}, ErrInvalidSourcePath},
+		{"invalid regex", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: "^/old/(.*", TargetURL: "/new", Code: 301}, ErrInvalidRedirectRegex},
+		{"regex with quote", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `^/old"`, TargetURL: "/new", Code: 301}, ErrInvalidRedirectRegex},
+		{"regex with escaped backslash", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `^/old\\`, TargetURL: "/new", Code: 301}, ErrInvalidRedirectRegex},
+		{"capture of a prefix", models.Redirect{SourcePath: "/old", TargetURL: "/new/$1", Code: 301}, ErrInvalidCapture},
+		{"missing capture", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: "^/old/(.*)$", TargetURL: "/new/$2", Code: 301}, ErrInvalidCapture},
+		{"variable", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: "^/old/(.*)$", TargetURL: "/new/$host", Code: 301}, ErrInvalidCapture},
+		{"target with space", models.Redirect{SourcePath: "/old", TargetURL: "/new page", Code: 301}, ErrInvalidTargetURL},
+		{"host", models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 301, Host: "example.com/path"}, ErrInvalidRedirectHost},
+		{"query argument", models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 301, QueryArg: "a-b"}, ErrInvalidQueryArg},
+		{"query value without argument", models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 301, QueryValue: "fr"}, ErrInvalidQueryValue},
+		{"query value", models.Redirect{SourcePath: "/old", TargetURL: "/new", Code: 301, QueryArg: "lang", QueryValue: `"fr"`}, ErrInvalidQueryValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := normalizeRedirect(&tt.redirect); !errors.Is(err, tt.wantErr) {
+				t.Errorf("normalizeRedirect() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeRedirect_Loops(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect models.Redirect
+		loops    bool
+	}{
+		{"exact to itself", models.Redirect{MatchType: models.RedirectMatchExact, SourcePath: "/a", TargetURL: "/a"}, true},
+		{"exact to itself with the path", models.Redirect{MatchType: models.RedirectMatchExact, SourcePath: "/a", TargetURL: "/a", PreservePath: true}, false},
+		{"prefix to a subpath", models.Redirect{SourcePath: "/docs", TargetURL: "/docs/v2"}, true},
+		{"prefix to the host with the path", models.Redirect{SourcePath: "/docs", TargetURL: "https://example.com", PreservePath: true, Host: "example.com"}, true},
+		{"prefix elsewhere with the path", models.Redirect{SourcePath: "/docs", TargetURL: "/v2", PreservePath: true}, false},
+		{"regex", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `\.html$`, TargetURL: "/index.html"}, true},
+		{"regex with captures", models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `^/(.*)\.html$`, TargetURL: "/$1.html"}, false},
+		{"other host", models.Redirect{SourcePath: "/", TargetURL: "https://www.example.com/"}, false},
+		{"host of the condition", models.Redirect{SourcePath: "/", TargetURL: "https://example.com/", Host: "example.com"}, true},
+		{"query argument dropped", models.Redirect{SourcePath: "/", TargetURL: "/home", QueryArg: "old"}, false},
+		{"query argument kept", models.Redirect{SourcePath: "/", TargetURL: "/home", QueryArg: "old", PreserveQuery: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.redirect.Code = 301
+			err := normalizeRedirect(&tt.redirect)
+			if loops := errors.Is(err, ErrRedirectLoop); loops != tt.loops || (!loops && err != nil) {
+				t.Errorf("normalizeRedirect() error = %v, want loop %v", err, tt.loops)
+			}
+		})
+	}
+}
+
+// fakeNginx puts a sudo on PATH that runs the file commands of NginxService
+// and makes nginx -t reject the config.
+func fakeNginx(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	script := "#!/bin/sh\ncase \"$1\" in\nnginx) echo 'nginx: [emerg] pcre2_compile() failed' >&2; exit 1 ;;\nsystemctl) exit 0 ;;\nesac\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRedirectService_NginxRejects(t *testing.T) {
+	fakeNginx(t)
+	env := newDeployTestEnv(t)
+	env.config.Nginx.ConfigPath = t.TempDir()
+	redirects := repository.NewRedirectRepository(env.db)
+	nginx := NewNginxService(env.config, env.sites, repository.NewDomainRepository(env.db))
+	nginx.SetRedirectRepo(redirects)
+	service := NewRedirectService(redirects, nginx)
+
+	// Compiles with Go's regexp, PCRE rejects it without UTF mode.
+	bad := &models.Redirect{MatchType: models.RedirectMatchRegex, SourcePath: `^/\x{400}$`, TargetURL: "/new", Code: 301}
+	if err := service.Create(env.site.ID, bad); !errors.Is(err, ErrRedirectRejected) {
+		t.Fatalf("Create() error = %v, want %v", err, ErrRedirectRejected)
+	}
+	if list, _ := redirects.ListBySite(env.site.ID); len(list) != 0 {
+		t.Errorf("Create() kept %d rejected redirects", len(list))
+	}
+
+	good := &models.Redirect{SiteID: env.site.ID, MatchType: models.RedirectMatchExact, SourcePath: "/old", TargetURL: "/new", Code: 301, IsEnabled: true}
+	if err := redirects.Create(good); err != nil {
+		t.Fatal(err)
+	}
+	changed := *good
+	changed.MatchType = models.RedirectMatchRegex
+	changed.SourcePath = `^/\x{400}$`
+	if err := service.Update(&changed); !errors.Is(err, ErrRedirectRejected) {
+		t.Fatalf("Update() error = %v, want %v", err, ErrRedirectRejected)
+	}
+	got, err := redirects.GetByID(good.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MatchType != models.RedirectMatchExact || got.SourcePath != "/old" {
+		t.Errorf("Update() left %s %q, want the previous exact /old", got.MatchType, got.SourcePath)
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// maxDiffOutput bounds the unified diffs of one release diff; files after
+// the budget is used up are listed without theirs.
+const maxDiffOutput = 1024 * 1024
+
+// releaseFile is a file of a release being compared.
+type releaseFile struct {
+	path string // on disk
+	size int64
+}
+
+// DiffReleases compares the releases of two deploys of a site. A zero toID
+// means the active deploy, a zero fromID the successful deploy before toID.
+// With content, text files get a unified diff. A release that was pruned is
+// read from the archive of its deploy while that is kept.
+func (s *DeployService) DiffReleases(siteID, fromID, toID int64, content bool) (*models.ReleaseDiff, error) {
+	to, err := s.diffDeploy(siteID, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	var from *models.Deploy
+	if fromID == 0 {
+		from, err = s.deployRepo.GetPreviousSuccessful(siteID, to.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNoPreviousRelease
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if from, err = s.diffDeploy(siteID, fromID); err != nil {
+		return nil, err
+	}
+
+	fromRoot, fromCleanup, err := s.releaseSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	defer fromCleanup()
+
+	toRoot, toCleanup, err := s.releaseSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	defer toCleanup()
+
+	diff, err := diffReleaseDirs(fromRoot, toRoot, content)
+	if err != nil {
+		return nil, err
+	}
+	diff.FromDeployID = from.ID
+	diff.ToDeployID = to.ID
+	return diff, nil
+}
+
+// diffDeploy loads a deploy of the site to compare, the active one for id 0.
+func (s *DeployService) diffDeploy(siteID, id int64) (*models.Deploy, error) {
+	var deploy *models.Deploy
+	var err error
+	if id == 0 {
+		deploy, err = s.deployRepo.GetActive(siteID)
+	} else {
+		deploy, err = s.deployRepo.GetByID(id)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrReleaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deploy.SiteID != siteID || deploy.Status != models.DeployStatusSuccess {
+		return nil, ErrReleaseNotFound
+	}
+	return deploy, nil
+}
+
+// releaseSnapshot returns a directory holding the release of a deploy: the
+// release itself while it is kept, otherwise its archive extracted into a
+// scratch directory of the site, which cleanup removes.
+func (s *DeployService) releaseSnapshot(deploy *models.Deploy) (string, func(), error) {
+	if deploy.Status != models.DeployStatusSuccess {
+		return "", nil, ErrReleaseNotFound
+	}
+
+	releasePath := s.releasePath(deploy.SiteID, deploy.ID)
+	if deploy.HasRelease {
+		if _, err := os.Stat(releasePath); err == nil {
+			return releasePath, func() {}, nil
+		}
+	}
+
+	if !deploy.HasArchive() {
+		return "", nil, ErrReleaseNotFound
+	}
+	archivePath := s.archivePath(deploy.SiteID, deploy.Archive)
+	if _, err := os.Stat(archivePath); err != nil {
+		return "", nil, ErrReleaseNotFound
+	}
+
+	// Hidden, so the archive janitor leaves it alone
+	scratch, err := os.MkdirTemp(filepath.Dir(archivePath), ".diff-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(scratch) }
+
+	// The deploy already passed the limits; the copy is gone after the diff
+	root := filepath.Join(scratch, "release")
+	budget := &extractBudget{maxFileSize: s.siteLimits(deploy.SiteID).MaxFileSize, remaining: -1}
+	if err := s.archiveBuilder(archivePath)(root, budget, func(done, total int) {}); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("extract archive of deploy %d: %w", deploy.ID, err)
+	}
+	if err := applyIgnoreFile(root); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("apply %s: %w", IgnoreFileName, err)
+	}
+	return root, cleanup, nil
+}
+
+// diffReleaseDirs lists the files that differ between two release
+// directories, sorted by path.
+func diffReleaseDirs(fromRoot, toRoot string, content bool) (*models.ReleaseDiff, error) {
+	fromFiles, err := listReleaseFiles(fromRoot)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := listReleaseFiles(toRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(fromFiles)+len(toFiles))
+	for p := range fromFiles {
+		paths = append(paths, p)
+	}
+	for p := range toFiles {
+		if _, ok := fromFiles[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	diff := &models.ReleaseDiff{Files: []models.ReleaseFileDiff{}}
+	budget := maxDiffOutput
+	for _, p := range paths {
+		oldFile, inFrom := fromFiles[p]
+		newFile, inTo := toFiles[p]
+
+		f := models.ReleaseFileDiff{Path: p}
+		switch {
+		case !inFrom:
+			f.Change = models.FileAdded
+			diff.Added++
+		case !inTo:
+			f.Change = models.FileRemoved
+			diff.Removed++
+		default:
+			same, err := sameContent(oldFile, newFile)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+			f.Change = models.FileModified
+			diff.Modified++
+		}
+		if inFrom {
+			f.OldSize = oldFile.size
+		}
+		if inTo {
+			f.NewSize = newFile.size
+		}
+		f.SizeDelta = f.NewSize - f.OldSize
+		diff.SizeDelta += f.SizeDelta
+
+		if content {
+			if err := fileDiff(&f, oldFile, newFile, &budget); err != nil {
+				return nil, err
+			}
+		}
+		diff.Files = append(diff.Files, f)
+	}
+	return diff, nil
+}
+
+// listReleaseFiles returns the regular files of a release by slash-separated
+// relative path. Shared path links and precompressed copies are left out:
+// they are not part of what was deployed.
+func listReleaseFiles(root string) (map[string]releaseFile, error) {
+	files := make(map[string]releaseFile)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = releaseFile{path: p, size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list release files: %w", err)
+	}
+
+	names := make(map[string]bool, len(files))
+	for name := range files {
+		names[name] = true
+	}
+	for name := range files {
+		if isPrecompressedCopy(name, names) {
+			delete(files, name)
+		}
+	}
+	return files, nil
+}
+
+// sameContent reports whether two files have the same bytes. Files
+// hardlinked by an incremental deploy are recognized without reading them.
+func sameContent(a, b releaseFile) (bool, error) {
+	if a.size != b.size {
+		return false, nil
+	}
+	aInfo, errA := os.Stat(a.path)
+	bInfo, errB := os.Stat(b.path)
+	if errA == nil && errB == nil && os.SameFile(aInfo, bInfo) {
+		return true, nil
+	}
+
+	fa, err := os.Open(a.path)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b.path)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(fa, bufA)
+		nB, errB := io.ReadFull(fb, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// fileDiff sets the unified diff of a changed file, or why it has none.
+// budget is the diff output still allowed and shrinks by what is used.
+func fileDiff(f *models.ReleaseFileDiff, oldFile, newFile releaseFile, budget *int) error {
+	if f.OldSize > MaxEditFileSize || f.NewSize > MaxEditFileSize {
+		f.DiffSkipped = models.DiffSkippedTooLarge
+		return nil
+	}
+	if *budget <= 0 {
+		f.DiffSkipped = models.DiffSkippedOutputLimit
+		return nil
+	}
+
+	read := func(file releaseFile) (string, error) {
+		if file.path == "" {
+			return "", nil
+		}
+		data, err := os.ReadFile(file.path)
+		return string(data), err
+	}
+	oldText, err := read(oldFile)
+	if err != nil {
+		return err
+	}
+	newText, err := read(newFile)
+	if err != nil {
+		return err
+	}
+	if !isText(oldText) || !isText(newText) {
+		f.DiffSkipped = models.DiffSkippedBinary
+		return nil
+	}
+
+	if oldText == newText {
+		// An empty file added or removed
+		return nil
+	}
+
+	text, ok := unifiedDiff(f.Path, oldText, newText)
+	switch {
+	case !ok:
+		f.DiffSkipped = models.DiffSkippedTooComplex
+	case len(text) > *budget:
+		f.DiffSkipped = models.DiffSkippedOutputLimit
+		*budget = 0
+	default:
+		f.Diff = text
+		*budget -= len(text)
+	}
+	return nil
+}
+
+// isText reports whether file content can be shown as text.
+func isText(content string) bool {
+	return utf8.ValidString(content) && strings.IndexByte(content, 0) < 0
+}
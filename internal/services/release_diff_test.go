@@ -0,0 +1,114 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"micropanel/internal/models"
+)
+
+func writeRelease(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestDiffReleaseDirs(t *testing.T) {
+	css := strings.Repeat("body { margin: 0 }\n", 100)
+	from := writeRelease(t, map[string]string{
+		"index.html":    "<h1>Hello</h1>\n",
+		"old.txt":       "gone\n",
+		"logo.png":      "\x89PNG\x00\x01",
+		"same.css":      css,
+		"same.css.gz":   "copy",
+		"assets/app.js": "let a = 1;\n",
+	})
+	to := writeRelease(t, map[string]string{
+		"index.html":    "<h1>Hello, world</h1>\n",
+		"new.txt":       "added\n",
+		"logo.png":      "\x89PNG\x00\x02\x03",
+		"same.css":      css,
+		"assets/app.js": "let a = 1;\n",
+	})
+	// Shared paths are linked into releases, not part of them
+	os.Symlink(t.TempDir(), filepath.Join(to, "uploads"))
+
+	diff, err := diffReleaseDirs(from, to, true)
+	if err != nil {
+		t.Fatalf("diffReleaseDirs() error = %v", err)
+	}
+
+	var got []string
+	for _, f := range diff.Files {
+		got = append(got, string(f.Change)+" "+f.Path)
+	}
+	want := []string{"modified index.html", "modified logo.png", "added new.txt", "removed old.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffReleaseDirs() files = %v, want %v", got, want)
+	}
+	if diff.Added != 1 || diff.Removed != 1 || diff.Modified != 2 || diff.SizeDelta != 7+6+1-5 {
+		t.Errorf("diffReleaseDirs() totals = +%d -%d ~%d %d bytes", diff.Added, diff.Removed, diff.Modified, diff.SizeDelta)
+	}
+
+	index := diff.Files[0]
+	if index.SizeDelta != 7 || !strings.Contains(index.Diff, "-<h1>Hello</h1>\n+<h1>Hello, world</h1>\n") {
+		t.Errorf("index.html diff = %+v", index)
+	}
+	if logo := diff.Files[1]; logo.Diff != "" || logo.DiffSkipped != models.DiffSkippedBinary {
+		t.Errorf("logo.png diff = %+v, want it skipped as binary", logo)
+	}
+	if added := diff.Files[2]; !strings.Contains(added.Diff, "+added\n") {
+		t.Errorf("new.txt diff = %q", added.Diff)
+	}
+
+	withoutContent, err := diffReleaseDirs(from, to, false)
+	if err != nil {
+		t.Fatalf("diffReleaseDirs() without content error = %v", err)
+	}
+	for _, f := range withoutContent.Files {
+		if f.Diff != "" || f.DiffSkipped != "" {
+			t.Errorf("%s has a diff without content", f.Path)
+		}
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) releaseFile {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		return releaseFile{path: path, size: int64(len(content))}
+	}
+	long := strings.Repeat("x", 100*1024)
+
+	a := write("a", long+"1")
+	b := write("b", long+"2")
+	c := write("c", long+"1")
+	os.Link(a.path, filepath.Join(dir, "a-link"))
+
+	tests := []struct {
+		x, y releaseFile
+		want bool
+	}{
+		{a, b, false},
+		{a, c, true},
+		{a, releaseFile{path: filepath.Join(dir, "a-link"), size: a.size}, true},
+		{a, write("d", "short"), false},
+	}
+	for i, tt := range tests {
+		got, err := sameContent(tt.x, tt.y)
+		if err != nil || got != tt.want {
+			t.Errorf("case %d: sameContent() = %v, %v, want %v", i, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+)
+
+const (
+	// RemoteFetchTimeout bounds the download of a remote archive.
+	RemoteFetchTimeout = 30 * time.Minute
+
+	// remoteConnectTimeout bounds connecting to the server of a remote
+	// archive and waiting for its response headers.
+	remoteConnectTimeout = 30 * time.Second
+
+	maxRemoteRedirects = 5
+)
+
+var (
+	ErrInvalidRemoteSource = errors.New("give either a url or an S3 bucket and key")
+	ErrInvalidRemoteURL    = errors.New("url must be an http or https URL")
+	ErrRemoteSHA256        = errors.New("a url deploy needs the sha256 of the archive as 64 hex characters")
+	ErrRemoteFetch         = errors.New("could not download the archive")
+	ErrRemoteChecksum      = errors.New("downloaded archive does not match its sha256")
+)
+
+// remoteClient downloads remote archives. Redirects are followed to http and
+// https URLs only.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: remoteConnectTimeout}).DialContext,
+		TLSHandshakeTimeout:   remoteConnectTimeout,
+		ResponseHeaderTimeout: remoteConnectTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRemoteRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to %s URL", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// RemoteSource is an archive the server downloads itself instead of having
+// it uploaded: from a URL, for which the SHA-256 of the archive must be
+// known, or as an object of an S3-compatible bucket, read with the
+// credentials of the site.
+type RemoteSource struct {
+	URL       string
+	Bucket    string
+	Key       string
+	SHA256    string // hex, optional for S3 objects
+	Signature string // as for Deploy
+}
+
+// validate normalizes and checks the source.
+func (src *RemoteSource) validate() error {
+	src.URL = strings.TrimSpace(src.URL)
+	src.Bucket = strings.TrimSpace(src.Bucket)
+	src.Key = strings.TrimPrefix(strings.TrimSpace(src.Key), "/")
+	src.SHA256 = strings.ToLower(strings.TrimSpace(src.SHA256))
+
+	if (src.URL == "") == (src.Bucket == "" && src.Key == "") {
+		return ErrInvalidRemoteSource
+	}
+	if src.SHA256 != "" {
+		if sum, err := hex.DecodeString(src.SHA256); err != nil || len(sum) != sha256.Size {
+			return ErrRemoteSHA256
+		}
+	}
+
+	if src.URL == "" {
+		return validateS3Object(src.Bucket, src.Key)
+	}
+	u, err := url.Parse(src.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidRemoteURL
+	}
+	if src.SHA256 == "" {
+		return ErrRemoteSHA256
+	}
+	return nil
+}
+
+// String describes the source without the credentials a URL may carry, in
+// its user info or, for presigned URLs, in its query.
+func (src *RemoteSource) String() string {
+	if src.URL == "" {
+		return "s3://" + src.Bucket + "/" + src.Key
+	}
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		return "invalid URL"
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.Redacted()
+}
+
+// filename is the name the archive is recorded under: the last element of
+// its URL path or object key.
+func (src *RemoteSource) filename() string {
+	name := src.Key
+	if src.URL != "" {
+		if u, err := url.Parse(src.URL); err == nil {
+			name = u.Path
+		}
+	}
+	name = path.Base(name)
+	if name == "." || name == "/" || name == "" {
+		return "archive"
+	}
+	return name
+}
+
+// SetS3CredentialsService enables deploys of objects of S3-compatible buckets.
+func (s *DeployService) SetS3CredentialsService(s3 *S3CredentialsService) {
+	s.s3 = s3
+}
+
+// DeployRemote downloads an archive and deploys it as opts says, returning
+// once the release is active, held for approval, or the deploy has failed.
+func (s *DeployService) DeployRemote(siteID, userID int64, src RemoteSource, opts DeployOptions) (*models.Deploy, error) {
+	deploy, err := s.createRemote(siteID, userID, &src, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	archivePath, err := s.fetchRemote(deploy, &src)
+	if err != nil {
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	return deploy, s.run(deploy, s.archiveBuilder(archivePath))
+}
+
+// EnqueueRemote hands a deploy of a remote archive to the workers, which
+// download it before extracting it.
+func (s *DeployService) EnqueueRemote(siteID, userID int64, src RemoteSource, opts DeployOptions) (*models.Deploy, error) {
+	deploy, err := s.createRemote(siteID, userID, &src, opts)
+	if err != nil {
+		return deploy, err
+	}
+
+	s.setPhase(deploy, models.DeployPhaseQueued, 0)
+
+	queued := *deploy
+	if err := s.dispatch(deployJob{deploy: &queued, remote: &src}); err != nil {
+		s.fail(deploy, err)
+		return deploy, err
+	}
+
+	return deploy, nil
+}
+
+func (s *DeployService) createRemote(siteID, userID int64, src *RemoteSource, opts DeployOptions) (*models.Deploy, error) {
+	if err := src.validate(); err != nil {
+		return nil, err
+	}
+	if src.URL == "" {
+		if s.s3 == nil {
+			return nil, ErrNoS3Credentials
+		}
+		creds, err := s.s3.Get(siteID)
+		if err != nil {
+			return nil, err
+		}
+		if creds == nil {
+			return nil, ErrNoS3Credentials
+		}
+	}
+	if _, err := s.checkUpload(siteID, 0); err != nil {
+		return nil, err
+	}
+
+	return s.create(siteID, userID, src.filename(), opts)
+}
+
+// fetchRemote downloads the archive of a deploy into the deploys directory,
+// where it goes through the same checks as an upload.
+func (s *DeployService) fetchRemote(deploy *models.Deploy, src *RemoteSource) (string, error) {
+	s.setPhase(deploy, models.DeployPhaseFetching, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), RemoteFetchTimeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	if src.URL != "" {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	} else {
+		req, err = s.s3.objectRequest(ctx, deploy.SiteID, src.Bucket, src.Key)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		// The error of a request names its URL, which may hold credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("%w from %s: %v", ErrRemoteFetch, src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w from %s: %s", ErrRemoteFetch, src, resp.Status)
+	}
+
+	limits, err := s.checkUpload(deploy.SiteID, max(resp.ContentLength, 0))
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	archivePath, err := s.saveArchive(deploy, io.TeeReader(resp.Body, hash), limits.MaxArchiveSize, src.Signature)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%w from %s: timed out after %s", ErrRemoteFetch, src, RemoteFetchTimeout)
+		}
+		return "", err
+	}
+	if src.SHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != src.SHA256 {
+		os.Remove(archivePath)
+		return "", ErrRemoteChecksum
+	}
+
+	if err := s.checkArchivePolicy(archivePath); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+	return archivePath, nil
+}
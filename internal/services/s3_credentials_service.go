@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+// DefaultS3Region is the region signed for when the credentials name none.
+// MinIO and most other S3-compatible servers accept it.
+const DefaultS3Region = "us-east-1"
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var (
+	ErrNoS3Credentials    = errors.New("site has no S3 credentials")
+	ErrInvalidS3Endpoint  = errors.New("S3 endpoint must be an http or https URL without a path")
+	ErrInvalidS3Region    = errors.New("invalid S3 region")
+	ErrS3AccessKey        = errors.New("S3 access key ID and secret access key are required")
+	ErrInvalidS3Bucket    = errors.New("invalid S3 bucket name")
+	ErrInvalidS3ObjectKey = errors.New("S3 object key must be 1 to 1024 characters")
+)
+
+var (
+	s3BucketRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	s3RegionRegex = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+)
+
+// S3CredentialsService keeps the object storage credentials of sites and
+// signs the requests made with them (AWS Signature Version 4).
+type S3CredentialsService struct {
+	credsRepo *repository.S3CredentialsRepository
+}
+
+func NewS3CredentialsService(credsRepo *repository.S3CredentialsRepository) *S3CredentialsService {
+	return &S3CredentialsService{credsRepo: credsRepo}
+}
+
+// Get returns the credentials of a site, nil if it has none.
+func (s *S3CredentialsService) Get(siteID int64) (*models.S3Credentials, error) {
+	creds, err := s.credsRepo.GetBySite(siteID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return creds, err
+}
+
+// Set validates and stores the credentials of a site. An empty secret keeps
+// the one stored, so it does not have to be sent again to change the rest.
+func (s *S3CredentialsService) Set(creds *models.S3Credentials) error {
+	creds.Endpoint = strings.TrimRight(strings.TrimSpace(creds.Endpoint), "/")
+	creds.Region = strings.TrimSpace(creds.Region)
+	creds.AccessKeyID = strings.TrimSpace(creds.AccessKeyID)
+	creds.SecretAccessKey = strings.TrimSpace(creds.SecretAccessKey)
+	if creds.Region == "" {
+		creds.Region = DefaultS3Region
+	}
+
+	u, err := url.Parse(creds.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return ErrInvalidS3Endpoint
+	}
+	if !s3RegionRegex.MatchString(creds.Region) {
+		return ErrInvalidS3Region
+	}
+
+	if creds.SecretAccessKey == "" {
+		existing, err := s.Get(creds.SiteID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			creds.SecretAccessKey = existing.SecretAccessKey
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return ErrS3AccessKey
+	}
+
+	return s.credsRepo.Set(creds)
+}
+
+func (s *S3CredentialsService) Delete(siteID int64) error {
+	return s.credsRepo.Delete(siteID)
+}
+
+// objectRequest returns a signed request for an object of a bucket the site
+// has credentials for.
+func (s *S3CredentialsService) objectRequest(ctx context.Context, siteID int64, bucket, key string) (*http.Request, error) {
+	creds, err := s.Get(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return nil, ErrNoS3Credentials
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s3ObjectURL(creds, bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	signS3Request(req, creds, time.Now())
+	return req, nil
+}
+
+// validateS3Object checks a bucket name and object key.
+func validateS3Object(bucket, key string) error {
+	if !s3BucketRegex.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return ErrInvalidS3Bucket
+	}
+	if key == "" || len(key) > 1024 {
+		return ErrInvalidS3ObjectKey
+	}
+	return nil
+}
+
+// s3ObjectURL returns the URL of an object, with the bucket in the path or in
+// the host name.
+func s3ObjectURL(creds *models.S3Credentials, bucket, key string) string {
+	u, _ := url.Parse(creds.Endpoint)
+	path := "/" + s3EscapePath(key)
+	if creds.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	return u.Scheme + "://" + u.Host + path
+}
+
+// s3EscapePath escapes an object key for a URL and a canonical request: every
+// byte but the unreserved characters of RFC 3986 and the slash.
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// signS3Request signs a request without a body with AWS Signature Version 4.
+// The host, the x-amz-* headers and a Range header are signed.
+func signS3Request(req *http.Request, creds *models.S3Credentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + creds.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, creds.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery sorts and escapes a query string for a canonical request.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3EscapeQuery(name)+"="+s3EscapeQuery(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapeQuery(s string) string {
+	return strings.ReplaceAll(s3EscapePath(s), "/", "%2F")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,11 @@ import (
 const (
 	SettingServerName  = "server_name"
 	SettingServerNotes = "server_notes"
+
+	SettingDeployRequireIndex   = "deploy_require_index"
+	SettingDeployForbiddenPaths = "deploy_forbidden_paths" // one pattern per line
+	SettingDeployBlockSecrets   = "deploy_block_secrets"
+	SettingDeployMaxTotalSize   = "deploy_max_total_size" // bytes
 )
 
 type SettingsService struct {
@@ -88,3 +95,48 @@ func (s *SettingsService) GetServerNotes() string {
 	val, _ := s.repo.Get(SettingServerNotes)
 	return val
 }
+
+// GetDeployPolicy returns the policy every release is checked against
+// before it is activated.
+func (s *SettingsService) GetDeployPolicy() (*models.DeployPolicy, error) {
+	settings, err := s.repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &models.DeployPolicy{
+		RequireIndex: settings[SettingDeployRequireIndex] == "true",
+		BlockSecrets: settings[SettingDeployBlockSecrets] == "true",
+	}
+	for _, line := range strings.Split(settings[SettingDeployForbiddenPaths], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			policy.ForbiddenPaths = append(policy.ForbiddenPaths, line)
+		}
+	}
+	if size := settings[SettingDeployMaxTotalSize]; size != "" {
+		policy.MaxTotalSize, _ = strconv.ParseInt(size, 10, 64)
+	}
+	return policy, nil
+}
+
+func (s *SettingsService) UpdateDeployPolicy(policy *models.DeployPolicy) error {
+	if policy.MaxTotalSize < 0 {
+		return errors.New("maximum total size cannot be negative")
+	}
+	if _, err := parseIgnoreRules(policy.ForbiddenPaths); err != nil {
+		return err
+	}
+
+	values := map[string]string{
+		SettingDeployRequireIndex:   strconv.FormatBool(policy.RequireIndex),
+		SettingDeployForbiddenPaths: strings.Join(policy.ForbiddenPaths, "\n"),
+		SettingDeployBlockSecrets:   strconv.FormatBool(policy.BlockSecrets),
+		SettingDeployMaxTotalSize:   strconv.FormatInt(policy.MaxTotalSize, 10),
+	}
+	for key, value := range values {
+		if err := s.repo.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
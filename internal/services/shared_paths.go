@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"micropanel/internal/repository"
+)
+
+// MaxSharedPaths is the number of shared paths a site may declare.
+const MaxSharedPaths = 20
+
+var (
+	ErrInvalidSharedPath   = errors.New("shared path must be a relative directory path inside the site")
+	ErrSharedPathOverlap   = errors.New("shared paths cannot contain one another")
+	ErrSharedPathNotDir    = errors.New("shared path is not a directory in the current release")
+	ErrSharedPathInArchive = errors.New("archive overwrites a shared path")
+	ErrTooManySharedPaths  = fmt.Errorf("a site can have at most %d shared paths", MaxSharedPaths)
+)
+
+// SetSharedPathRepo enables shared paths: directories kept in shared/ and
+// linked into every release, so their files survive deploys.
+func (s *DeployService) SetSharedPathRepo(repo *repository.SharedPathRepository) {
+	s.sharedRepo = repo
+}
+
+// SharedPaths returns the shared paths of a site.
+func (s *DeployService) SharedPaths(siteID int64) ([]string, error) {
+	if s.sharedRepo == nil {
+		return nil, nil
+	}
+	return s.sharedRepo.ListBySite(siteID)
+}
+
+// SetSharedPaths replaces the shared paths of a site and returns them
+// normalized. A newly shared directory of the current release is moved to
+// shared/ so its files are kept; a path that is no longer shared is moved
+// back into the current release, where the next deploy replaces it.
+func (s *DeployService) SetSharedPaths(siteID int64, paths []string) ([]string, error) {
+	if s.sharedRepo == nil {
+		return nil, errors.New("shared paths are not enabled")
+	}
+
+	paths, err := normalizeSharedPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock(siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	old, err := s.sharedRepo.ListBySite(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.MigrateLayout(siteID); err != nil {
+		return nil, fmt.Errorf("migrate site layout: %w", err)
+	}
+
+	// Without a current release there is nothing to move, the directories
+	// are created when a release is activated
+	releasePath := ""
+	if current, err := currentRelease(s.sitePath(siteID)); err == nil {
+		releasePath = s.releasePath(siteID, current)
+	}
+
+	for _, p := range paths {
+		if slices.Contains(old, p) || releasePath == "" {
+			continue
+		}
+		if err := s.shareFromRelease(siteID, releasePath, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range old {
+		if slices.Contains(paths, p) || releasePath == "" {
+			continue
+		}
+		if err := s.unshareToRelease(siteID, releasePath, p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.sharedRepo.ReplaceForSite(siteID, paths); err != nil {
+		return nil, err
+	}
+
+	if releasePath != "" {
+		if err := s.linkSharedPaths(siteID, releasePath, paths); err != nil {
+			return nil, fmt.Errorf("link shared paths: %w", err)
+		}
+	}
+	return paths, nil
+}
+
+// shareFromRelease moves a directory of the release to shared/, or creates
+// an empty one there when the release has none.
+func (s *DeployService) shareFromRelease(siteID int64, releasePath, p string) error {
+	sharedPath := siteSharedPath(s.config.Sites.Path, siteID, p)
+	if _, err := os.Lstat(sharedPath); err == nil {
+		// Files left from an earlier time the path was shared
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(sharedPath), 0755); err != nil {
+		return fmt.Errorf("create shared directory: %w", err)
+	}
+
+	entry := filepath.Join(releasePath, filepath.FromSlash(p))
+	info, err := os.Lstat(entry)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(sharedPath, 0755); err != nil {
+			return fmt.Errorf("create shared directory: %w", err)
+		}
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("%w: %s", ErrSharedPathNotDir, p)
+	default:
+		if err := os.Rename(entry, sharedPath); err != nil {
+			return fmt.Errorf("move %s to shared: %w", p, err)
+		}
+	}
+	s.chownPath(sharedPath)
+	return nil
+}
+
+// unshareToRelease replaces the link to a shared directory in the release
+// with the directory itself.
+func (s *DeployService) unshareToRelease(siteID int64, releasePath, p string) error {
+	entry := filepath.Join(releasePath, filepath.FromSlash(p))
+	info, err := os.Lstat(entry)
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		// The release has its own copy, the shared files stay in shared/
+		return nil
+	}
+
+	sharedPath := siteSharedPath(s.config.Sites.Path, siteID, p)
+	if _, err := os.Lstat(sharedPath); err != nil {
+		return nil
+	}
+
+	if err == nil {
+		if err := os.Remove(entry); err != nil {
+			return fmt.Errorf("remove shared link: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.Rename(sharedPath, entry); err != nil {
+		return fmt.Errorf("move %s to release: %w", p, err)
+	}
+	return nil
+}
+
+// checkSharedPaths fails when a new release has files where a shared path
+// is linked. Deploying them would hide or replace the shared files.
+func (s *DeployService) checkSharedPaths(siteID int64, releasePath string) error {
+	paths, err := s.SharedPaths(siteID)
+	if err != nil {
+		return fmt.Errorf("load shared paths: %w", err)
+	}
+	if p := sharedPathConflict(releasePath, paths); p != "" {
+		return fmt.Errorf("%w: %s", ErrSharedPathInArchive, p)
+	}
+	return nil
+}
+
+// sharedPathConflict returns the first shared path the release has an entry
+// for, or "" when all of them can be linked.
+func sharedPathConflict(releasePath string, paths []string) string {
+	for _, p := range paths {
+		// A file in place of a parent directory blocks the link as well
+		parts := strings.Split(p, "/")
+		for i := range parts {
+			info, err := os.Lstat(filepath.Join(releasePath, filepath.Join(parts[:i+1]...)))
+			if err != nil {
+				break
+			}
+			if i == len(parts)-1 || !info.IsDir() {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// linkSharedPaths links the shared paths of a site into a release, creating
+// empty shared directories as needed. Releases deployed before a path was
+// shared keep their own directory and are left alone.
+func (s *DeployService) linkSharedPaths(siteID int64, releasePath string, paths []string) error {
+	for _, p := range paths {
+		linkPath := filepath.Join(releasePath, filepath.FromSlash(p))
+		if info, err := os.Lstat(linkPath); err == nil {
+			if info.Mode()&os.ModeSymlink == 0 {
+				slog.Warn("release has its own copy of a shared path, not linking", "site_id", siteID, "release", releasePath, "path", p)
+			}
+			continue
+		}
+
+		sharedPath := siteSharedPath(s.config.Sites.Path, siteID, p)
+		if _, err := os.Stat(sharedPath); os.IsNotExist(err) {
+			if err := os.MkdirAll(sharedPath, 0755); err != nil {
+				return fmt.Errorf("create shared directory: %w", err)
+			}
+			s.chownPath(sharedPath)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			return fmt.Errorf("create directory: %w", err)
+		}
+		// Relative, so the site directory can be moved as a whole
+		target, err := filepath.Rel(filepath.Dir(linkPath), sharedPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, linkPath); err != nil {
+			return fmt.Errorf("link %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// normalizeSharedPaths cleans, deduplicates and sorts shared paths. Leading
+// and trailing slashes are dropped, so "/uploads/" becomes "uploads".
+func normalizeSharedPaths(paths []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, p := range paths {
+		p = strings.Trim(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		if strings.ContainsAny(p, "\\\x00") || path.Clean(p) != p || p == "." || p == ".." || strings.HasPrefix(p, "../") || len(p) > 255 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSharedPath, p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+
+	if len(result) > MaxSharedPaths {
+		return nil, ErrTooManySharedPaths
+	}
+
+	sort.Strings(result)
+	for _, a := range result {
+		for _, b := range result {
+			if strings.HasPrefix(b, a+"/") {
+				return nil, fmt.Errorf("%w: %s and %s", ErrSharedPathOverlap, a, b)
+			}
+		}
+	}
+	return result, nil
+}
+
+// sharedPathOf returns the shared path that rel is or lies in, or "" when
+// rel is not shared.
+func sharedPathOf(paths []string, rel string) string {
+	for _, p := range paths {
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return p
+		}
+	}
+	return ""
+}
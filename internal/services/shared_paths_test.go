@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"micropanel/internal/config"
+)
+
+func TestNormalizeSharedPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr error
+	}{
+		{"trims and sorts", []string{" /uploads/ ", "", "downloads", "uploads"}, []string{"downloads", "uploads"}, nil},
+		{"nested", []string{"media/uploads"}, []string{"media/uploads"}, nil},
+		{"empty", nil, nil, nil},
+		{"root", []string{"."}, nil, ErrInvalidSharedPath},
+		{"parent", []string{"../etc"}, nil, ErrInvalidSharedPath},
+		{"unclean", []string{"a/../b"}, nil, ErrInvalidSharedPath},
+		{"double slash", []string{"a//b"}, nil, ErrInvalidSharedPath},
+		{"backslash", []string{"a\\b"}, nil, ErrInvalidSharedPath},
+		{"overlap", []string{"media", "media-old", "media/uploads"}, nil, ErrSharedPathOverlap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeSharedPaths(tt.paths)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("normalizeSharedPaths(%q) error = %v, want %v", tt.paths, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeSharedPaths(%q) = %q, want %q", tt.paths, got, tt.want)
+			}
+		})
+	}
+
+	tooMany := make([]string, MaxSharedPaths+1)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	if _, err := normalizeSharedPaths(tooMany); !errors.Is(err, ErrTooManySharedPaths) {
+		t.Errorf("normalizeSharedPaths() with %d paths error = %v, want %v", len(tooMany), err, ErrTooManySharedPaths)
+	}
+}
+
+func TestSharedPathConflict(t *testing.T) {
+	release := t.TempDir()
+	os.MkdirAll(filepath.Join(release, "media"), 0755)
+	os.WriteFile(filepath.Join(release, "files"), []byte("not a directory"), 0644)
+	os.MkdirAll(filepath.Join(release, "uploads"), 0755)
+
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"downloads", "media/uploads"}, ""},
+		{[]string{"downloads", "uploads"}, "uploads"},
+		{[]string{"files/uploads"}, "files/uploads"},
+		{[]string{"media"}, "media"},
+	}
+
+	for _, tt := range tests {
+		if got := sharedPathConflict(release, tt.paths); got != tt.want {
+			t.Errorf("sharedPathConflict(%q) = %q, want %q", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestLinkSharedPaths(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Sites.Path = t.TempDir()
+	s := &DeployService{config: cfg}
+
+	release := siteReleasePath(cfg.Sites.Path, 1, 5)
+	os.MkdirAll(filepath.Join(release, "own"), 0755)
+	os.WriteFile(filepath.Join(release, "own", "file.txt"), []byte("release"), 0644)
+
+	paths := []string{"media/uploads", "own"}
+	for i := 0; i < 2; i++ {
+		if err := s.linkSharedPaths(1, release, paths); err != nil {
+			t.Fatalf("linkSharedPaths() call %d error = %v", i+1, err)
+		}
+	}
+
+	// Files written through the link end up in shared/
+	if err := os.WriteFile(filepath.Join(release, "media", "uploads", "photo.jpg"), []byte("photo"), 0644); err != nil {
+		t.Fatalf("write through link: %v", err)
+	}
+	if data, _ := os.ReadFile(siteSharedPath(cfg.Sites.Path, 1, "media/uploads/photo.jpg")); string(data) != "photo" {
+		t.Errorf("shared file content = %q, want %q", data, "photo")
+	}
+	if target, err := os.Readlink(filepath.Join(release, "media", "uploads")); err != nil || filepath.IsAbs(target) {
+		t.Errorf("link target = %q, %v, want a relative link", target, err)
+	}
+
+	// A release with its own copy keeps it
+	if info, err := os.Lstat(filepath.Join(release, "own")); err != nil || !info.IsDir() {
+		t.Errorf("own directory should be left in place")
+	}
+}
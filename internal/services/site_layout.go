@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Site directory layout:
+//
+//	<sites.path>/<id>/releases/<deploy_id>  extracted releases
+//	<sites.path>/<id>/releases/0            content of a site that was never deployed
+//	<sites.path>/<id>/current               symlink to the active release, nginx root
+//	<sites.path>/<id>/staging               symlink to the staged release, root of the preview hostname
+//	<sites.path>/<id>/deploys               uploaded archives
+//	<sites.path>/<id>/shared/<path>         shared paths, linked into every release
+//
+// Older installs served sites straight from public/ and kept the previous
+// version in public_prev/. MigrateLayout converts them and leaves public/
+// behind as a symlink to current.
+const (
+	currentLinkName  = "current"
+	stagingLinkName  = "staging"
+	releasesDirName  = "releases"
+	sharedDirName    = "shared"
+	legacyPublicName = "public"
+	legacyPrevName   = "public_prev"
+
+	// InitialReleaseID names the release holding content that does not
+	// come from a deploy: the placeholder page or a migrated public/.
+	InitialReleaseID int64 = 0
+)
+
+func siteDir(sitesPath string, siteID int64) string {
+	return filepath.Join(sitesPath, fmt.Sprintf("%d", siteID))
+}
+
+func siteCurrentPath(sitesPath string, siteID int64) string {
+	return filepath.Join(siteDir(sitesPath, siteID), currentLinkName)
+}
+
+func siteStagingPath(sitesPath string, siteID int64) string {
+	return filepath.Join(siteDir(sitesPath, siteID), stagingLinkName)
+}
+
+func siteReleasePath(sitesPath string, siteID, releaseID int64) string {
+	return filepath.Join(siteDir(sitesPath, siteID), releasesDirName, fmt.Sprintf("%d", releaseID))
+}
+
+// siteSharedPath returns where a shared path of a site keeps its files.
+func siteSharedPath(sitesPath string, siteID int64, path string) string {
+	return filepath.Join(siteDir(sitesPath, siteID), sharedDirName, filepath.FromSlash(path))
+}
+
+// switchRelease points the current symlink of a site at releases/<releaseID>.
+// The new link is created next to current and renamed over it, so the root
+// nginx serves never disappears, not even for a moment.
+func switchRelease(sitePath string, releaseID int64) error {
+	return switchLink(sitePath, currentLinkName, releaseID)
+}
+
+// switchLink points a release symlink of a site, current or staging, at
+// releases/<releaseID>, replacing it atomically.
+func switchLink(sitePath, linkName string, releaseID int64) error {
+	target := filepath.Join(releasesDirName, fmt.Sprintf("%d", releaseID))
+	tmpLink := filepath.Join(sitePath, fmt.Sprintf(".%s-%d", linkName, time.Now().UnixNano()))
+
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return fmt.Errorf("create release link: %w", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(sitePath, linkName)); err != nil {
+		os.Remove(tmpLink)
+		return fmt.Errorf("switch release link: %w", err)
+	}
+	return nil
+}
+
+// currentRelease returns the ID of the release the current symlink points at.
+func currentRelease(sitePath string) (int64, error) {
+	return linkedRelease(sitePath, currentLinkName)
+}
+
+// linkedRelease returns the ID of the release a symlink of a site points at.
+func linkedRelease(sitePath, linkName string) (int64, error) {
+	target, err := os.Readlink(filepath.Join(sitePath, linkName))
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(filepath.Base(target), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected release link %q", target)
+	}
+	return id, nil
+}
+
+// migrateLegacyLayout moves public/ into releases/<releaseID> and points
+// current at it. public/ is replaced with a symlink to current so nginx
+// configs written before the migration keep serving the site until they
+// are regenerated. It reports false when there is nothing to migrate.
+func migrateLegacyLayout(sitePath string, releaseID int64) (bool, error) {
+	if _, err := os.Lstat(filepath.Join(sitePath, currentLinkName)); err == nil {
+		return false, nil
+	}
+
+	publicPath := filepath.Join(sitePath, legacyPublicName)
+	info, err := os.Lstat(publicPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() {
+		return false, fmt.Errorf("%s is not a directory", publicPath)
+	}
+
+	releasesPath := filepath.Join(sitePath, releasesDirName)
+	if err := os.MkdirAll(releasesPath, 0755); err != nil {
+		return false, fmt.Errorf("create releases directory: %w", err)
+	}
+
+	// public may carry file manager edits on top of the release it was
+	// copied from, so it wins over the copy in releases/
+	releasePath := filepath.Join(releasesPath, fmt.Sprintf("%d", releaseID))
+	if err := os.RemoveAll(releasePath); err != nil {
+		return false, fmt.Errorf("remove stale release: %w", err)
+	}
+	if err := os.Rename(publicPath, releasePath); err != nil {
+		return false, fmt.Errorf("move public to release: %w", err)
+	}
+
+	if err := switchRelease(sitePath, releaseID); err != nil {
+		// Put public back so the site keeps being served
+		os.Rename(releasePath, publicPath)
+		return false, err
+	}
+
+	if err := os.Symlink(currentLinkName, publicPath); err != nil {
+		return true, fmt.Errorf("link public to current: %w", err)
+	}
+
+	// Leftovers of interrupted swaps
+	os.RemoveAll(filepath.Join(sitePath, "public_new"))
+	os.RemoveAll(filepath.Join(sitePath, "public_temp"))
+
+	return true, nil
+}
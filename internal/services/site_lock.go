@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const lockFileName = ".lock"
+
+// ErrSiteBusy is returned when another operation is changing the files of a site.
+var ErrSiteBusy = errors.New("site is busy with a deploy or rollback, try again shortly")
+
+// DeployInProgressError is returned when a site already has a deploy queued
+// or running.
+type DeployInProgressError struct {
+	DeployID int64
+}
+
+func (e *DeployInProgressError) Error() string {
+	return fmt.Sprintf("deploy #%d is already in progress", e.DeployID)
+}
+
+// lockSite takes the exclusive lock of a site. Deploys, rollbacks and file
+// manager writes hold it while they change the files of the site. The lock is
+// a flock on <site>/.lock, so it is shared with CLI processes too. Unless
+// wait is set, ErrSiteBusy is returned instead of blocking.
+func lockSite(sitesPath string, siteID int64, wait bool) (unlock func(), err error) {
+	sitePath := siteDir(sitesPath, siteID)
+	if err := os.MkdirAll(sitePath, 0755); err != nil {
+		return nil, fmt.Errorf("create site directory: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(sitePath, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open site lock: %w", err)
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrSiteBusy
+		}
+		return nil, fmt.Errorf("lock site: %w", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
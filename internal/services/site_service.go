@@ -1,11 +1,13 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"micropanel/internal/config"
 	"micropanel/internal/models"
@@ -38,6 +40,7 @@ func (s *SiteService) Create(name string, ownerID int64) (*models.Site, error) {
 		OwnerID:   ownerID,
 		IsEnabled: true,
 		WWWAlias:  false, // www alias disabled by default
+		Type:      models.SiteTypeStatic,
 	}
 
 	if err := s.siteRepo.Create(site); err != nil {
@@ -109,6 +112,44 @@ func (s *SiteService) Update(site *models.Site) error {
 	return s.siteRepo.Update(site)
 }
 
+// DefaultGitBranch is deployed when a git source is linked without a branch.
+const DefaultGitBranch = "main"
+
+// ErrLocalGitSource is returned when a non-admin links a repository on the
+// panel's own filesystem.
+var ErrLocalGitSource = errors.New("local repositories can only be linked by admins")
+
+// SetGitSource links the site to a git repository, or unlinks it when
+// repoURL is empty. Local paths are only accepted with allowLocal, they
+// give access to any repository the panel can read.
+func (s *SiteService) SetGitSource(site *models.Site, repoURL, branch, subdir string, allowLocal bool) error {
+	if repoURL == "" {
+		site.GitURL, site.GitBranch, site.GitSubdir = "", "", ""
+		return s.siteRepo.Update(site)
+	}
+
+	if branch == "" {
+		branch = DefaultGitBranch
+	}
+	if err := validators.ValidateGitURL(repoURL); err != nil {
+		return err
+	}
+	if validators.IsLocalGitURL(repoURL) && !allowLocal {
+		return ErrLocalGitSource
+	}
+	if err := validators.ValidateGitBranch(branch); err != nil {
+		return err
+	}
+	if err := validators.ValidateGitSubdir(subdir); err != nil {
+		return err
+	}
+
+	site.GitURL = repoURL
+	site.GitBranch = branch
+	site.GitSubdir = strings.TrimSuffix(subdir, "/")
+	return s.siteRepo.Update(site)
+}
+
 func (s *SiteService) Delete(id int64) error {
 	// Delete site directory
 	sitePath := s.GetSitePath(id)
@@ -127,13 +168,16 @@ func (s *SiteService) GetSitePath(siteID int64) string {
 	return filepath.Join(s.config.Sites.Path, fmt.Sprintf("%d", siteID))
 }
 
+// GetPublicPath returns the directory nginx serves for the site: the
+// current symlink to the active release.
 func (s *SiteService) GetPublicPath(siteID int64) string {
-	return filepath.Join(s.GetSitePath(siteID), "public")
+	return siteCurrentPath(s.config.Sites.Path, siteID)
 }
 
 func (s *SiteService) createSiteDirectories(siteID int64) error {
+	initialPath := siteReleasePath(s.config.Sites.Path, siteID, InitialReleaseID)
 	dirs := []string{
-		s.GetPublicPath(siteID),
+		initialPath,
 		filepath.Join(s.GetSitePath(siteID), "deploys"),
 	}
 
@@ -144,7 +188,7 @@ func (s *SiteService) createSiteDirectories(siteID int64) error {
 	}
 
 	// Create default index.html
-	indexPath := filepath.Join(s.GetPublicPath(siteID), "index.html")
+	indexPath := filepath.Join(initialPath, "index.html")
 	defaultContent := fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head><title>Site %d</title></head>
@@ -155,6 +199,10 @@ func (s *SiteService) createSiteDirectories(siteID int64) error {
 		return err
 	}
 
+	if err := switchRelease(s.GetSitePath(siteID), InitialReleaseID); err != nil {
+		return err
+	}
+
 	// Chown to configured user/group
 	return s.chownSiteDirectory(siteID)
 }
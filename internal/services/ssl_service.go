@@ -51,6 +51,7 @@ type SSLService struct {
 	siteRepo     *repository.SiteRepository
 	domainRepo   *repository.DomainRepository
 	nginxService *NginxService
+	stagingRepo  *repository.StagingRepository
 	certbotMu    sync.Mutex
 }
 
@@ -63,7 +64,13 @@ func NewSSLService(cfg *config.Config, siteRepo *repository.SiteRepository, doma
 	}
 }
 
-// IssueCertificate requests a new SSL certificate for site (primary domain + www + aliases).
+// SetStagingRepo makes certificates cover the preview hostnames of sites.
+func (s *SSLService) SetStagingRepo(repo *repository.StagingRepository) {
+	s.stagingRepo = repo
+}
+
+// IssueCertificate requests a new SSL certificate for site (primary domain + www + aliases
+// + preview hostname).
 // Uses certbot certonly --webroot to avoid conflicts with nginx config management.
 // A mutex ensures only one certbot process runs at a time.
 func (s *SSLService) IssueCertificate(siteID int64) error {
@@ -84,6 +91,11 @@ func (s *SSLService) IssueCertificate(siteID int64) error {
 
 	// Get all hostnames for certificate
 	hostnames := site.GetAllHostnames()
+	if s.stagingRepo != nil {
+		if slot, err := s.stagingRepo.GetBySite(siteID); err == nil {
+			hostnames = append(hostnames, slot.PreviewHostname(site.Name))
+		}
+	}
 	if len(hostnames) == 0 {
 		return ErrNoDomains
 	}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+	"micropanel/internal/validators"
+)
+
+// MaxStagingAllowedIPs bounds the IP allowlist of a staging slot.
+const MaxStagingAllowedIPs = 50
+
+// stagingHtpasswdName is the password file of the preview hostname, in the
+// auth directory of the site next to those of the auth zones.
+const stagingHtpasswdName = "staging.htpasswd"
+
+var (
+	ErrStagingDisabled      = errors.New("site has no staging slot")
+	ErrInvalidPreviewHost   = errors.New("invalid preview hostname")
+	ErrStagingHostTaken     = errors.New("preview hostname is already used by a site")
+	ErrInvalidStagingUser   = errors.New("invalid basic auth user")
+	ErrInvalidAllowedIP     = errors.New("allowed IPs must be IP addresses or CIDR ranges")
+	ErrTooManyAllowedIPs    = fmt.Errorf("a staging slot allows at most %d IP entries", MaxStagingAllowedIPs)
+	ErrStagingPassword      = errors.New("basic auth needs a password")
+	ErrStagingUnprotected   = errors.New("staging needs basic auth or an IP allowlist")
+	ErrNoStagedRelease      = errors.New("staging has no release to promote")
+	ErrStagingNotPromotable = errors.New("the staged release is already in production")
+)
+
+// StagingService keeps the staging slots of sites: the preview hostname and
+// how it is protected.
+type StagingService struct {
+	config      *config.Config
+	stagingRepo *repository.StagingRepository
+	siteRepo    *repository.SiteRepository
+	domainRepo  *repository.DomainRepository
+}
+
+func NewStagingService(cfg *config.Config, stagingRepo *repository.StagingRepository, siteRepo *repository.SiteRepository, domainRepo *repository.DomainRepository) *StagingService {
+	return &StagingService{
+		config:      cfg,
+		stagingRepo: stagingRepo,
+		siteRepo:    siteRepo,
+		domainRepo:  domainRepo,
+	}
+}
+
+// Get returns the staging slot of a site, nil if it has none.
+func (s *StagingService) Get(siteID int64) (*models.StagingSlot, error) {
+	slot, err := s.stagingRepo.GetBySite(siteID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return slot, err
+}
+
+// Set enables the staging slot of a site or changes it. An empty hostname
+// serves it on staging.<site>. An empty password keeps the one stored, an
+// empty authUser turns basic auth off; the slot needs basic auth, an IP
+// allowlist or both.
+func (s *StagingService) Set(site *models.Site, hostname string, allowedIPs []string, authUser, password string) (*models.StagingSlot, error) {
+	existing, err := s.Get(site.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	slot := &models.StagingSlot{
+		SiteID:   site.ID,
+		Hostname: strings.ToLower(strings.TrimSpace(hostname)),
+		AuthUser: strings.TrimSpace(authUser),
+	}
+
+	if err := s.checkHostname(site, slot.PreviewHostname(site.Name)); err != nil {
+		return nil, err
+	}
+
+	slot.AllowedIPs, err = normalizeAllowedIPs(allowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	if slot.AuthUser != "" {
+		if err := validators.ValidateHtpasswdUsername(slot.AuthUser); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidStagingUser, err)
+		}
+		switch {
+		case password != "":
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("hash password: %w", err)
+			}
+			slot.AuthHash = string(hash)
+		case existing != nil && existing.HasAuth():
+			slot.AuthHash = existing.AuthHash
+		default:
+			return nil, ErrStagingPassword
+		}
+	}
+	if !slot.HasAuth() && len(slot.AllowedIPs) == 0 {
+		return nil, ErrStagingUnprotected
+	}
+
+	if err := s.writeHtpasswd(slot); err != nil {
+		return nil, err
+	}
+	if err := s.stagingRepo.Set(slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// delete removes the staging slot of a site and its password file.
+func (s *StagingService) delete(siteID int64) error {
+	if err := s.stagingRepo.Delete(siteID); err != nil {
+		return err
+	}
+	if err := os.Remove(s.htpasswdPath(siteID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove htpasswd: %w", err)
+	}
+	return nil
+}
+
+// checkHostname refuses a preview hostname that is invalid or served by a site.
+func (s *StagingService) checkHostname(site *models.Site, hostname string) error {
+	if err := validators.ValidateDomain(hostname); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPreviewHost, err)
+	}
+	if hostname == site.Name || hostname == "www."+site.Name {
+		return ErrStagingHostTaken
+	}
+	if _, err := s.siteRepo.GetByName(hostname); err == nil {
+		return ErrStagingHostTaken
+	}
+	if _, err := s.domainRepo.GetByHostname(hostname); err == nil {
+		return ErrStagingHostTaken
+	}
+	if other, err := s.stagingRepo.GetByHostname(hostname); err == nil && other.SiteID != site.ID {
+		return ErrStagingHostTaken
+	}
+	return nil
+}
+
+// htpasswdPath returns the password file of the preview hostname of a site.
+func (s *StagingService) htpasswdPath(siteID int64) string {
+	return filepath.Join(siteDir(s.config.Sites.Path, siteID), "auth", stagingHtpasswdName)
+}
+
+func (s *StagingService) writeHtpasswd(slot *models.StagingSlot) error {
+	path := s.htpasswdPath(slot.SiteID)
+	if !slot.HasAuth() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove htpasswd: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create auth dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(slot.AuthUser+":"+slot.AuthHash+"\n"), 0600); err != nil {
+		return fmt.Errorf("write htpasswd: %w", err)
+	}
+	return nil
+}
+
+// normalizeAllowedIPs checks the entries of an IP allowlist and writes them
+// the way nginx expects. Blank entries are dropped.
+func normalizeAllowedIPs(entries []string) ([]string, error) {
+	var allowed []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(entry); ip != nil {
+			entry = ip.String()
+		} else if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			entry = ipNet.String()
+		} else {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAllowedIP, entry)
+		}
+
+		if !seen[entry] {
+			seen[entry] = true
+			allowed = append(allowed, entry)
+		}
+	}
+	if len(allowed) > MaxStagingAllowedIPs {
+		return nil, ErrTooManyAllowedIPs
+	}
+	return allowed, nil
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAllowedIPs(t *testing.T) {
+	got, err := normalizeAllowedIPs([]string{" 203.0.113.7 ", "", "10.1.2.3/8", "2001:DB8::1", "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("normalizeAllowedIPs() error = %v", err)
+	}
+	want := []string{"203.0.113.7", "10.0.0.0/8", "2001:db8::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeAllowedIPs() = %v, want %v", got, want)
+	}
+
+	for _, entry := range []string{"example.com", "10.0.0.0/33", "all", "1.2.3.4; deny all"} {
+		if _, err := normalizeAllowedIPs([]string{entry}); !errors.Is(err, ErrInvalidAllowedIP) {
+			t.Errorf("normalizeAllowedIPs(%q) error = %v, want ErrInvalidAllowedIP", entry, err)
+		}
+	}
+
+	var many []string
+	for i := 0; i <= MaxStagingAllowedIPs; i++ {
+		many = append(many, fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	if _, err := normalizeAllowedIPs(many); err != ErrTooManyAllowedIPs {
+		t.Errorf("normalizeAllowedIPs() with %d entries error = %v, want ErrTooManyAllowedIPs", len(many), err)
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffEdits bounds the work of a line diff: files that differ in more
+// lines than this are reported as changed without a diff.
+const maxDiffEdits = 5000
+
+type diffOpKind byte
+
+const (
+	diffEqual  diffOpKind = ' '
+	diffDelete diffOpKind = '-'
+	diffInsert diffOpKind = '+'
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string // with its line break, if it has one
+}
+
+// splitLines splits text into lines that keep their line breaks, so a
+// missing final newline is a difference like any other.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b (Myers'
+// algorithm), or false when it takes more than maxEdits edits.
+func diffLines(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k = x - y
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] is v over diagonals -d-1..d+1 before step d
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrackDiff walks the trace of diffLines back from the end of both
+// inputs and returns the edit script in order.
+func backtrackDiff(a, b []string, trace [][]int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{diffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{diffInsert, b[y-1]})
+			} else {
+				ops = append(ops, diffOp{diffDelete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff formats the difference between two texts as a unified diff.
+// It returns false when the texts differ too much to compute one.
+func unifiedDiff(name, oldText, newText string) (string, bool) {
+	ops, ok := diffLines(splitLines(oldText), splitLines(newText), maxDiffEdits)
+	if !ok {
+		return "", false
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// Line numbers before each op, 1-based
+	oldLine, newLine := make([]int, len(ops)), make([]int, len(ops))
+	o, n := 1, 1
+	for i, op := range ops {
+		oldLine[i], newLine[i] = o, n
+		if op.kind != diffInsert {
+			o++
+		}
+		if op.kind != diffDelete {
+			n++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+
+		// A hunk spans changes less than two contexts apart
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != diffEqual {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(ops))
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != diffInsert {
+				oldCount++
+			}
+			if op.kind != diffDelete {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldCount), hunkRange(newLine[start], newCount))
+		for _, op := range ops[start:end] {
+			out.WriteByte(byte(op.kind))
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String(), true
+}
+
+// hunkRange formats the start and length of a hunk side, as diff -u does.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty side is placed after the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
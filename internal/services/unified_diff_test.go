@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "a\nb\n",
+			want: "--- a/f\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "missing final newline",
+			old:  "a\n",
+			new:  "a",
+			want: "--- a/f\n+++ b/f\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := unifiedDiff("f", tt.old, tt.new)
+			if !ok {
+				t.Fatal("unifiedDiff() gave up")
+			}
+			if got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLines_MaxEdits(t *testing.T) {
+	old := splitLines(strings.Repeat("a\n", 100))
+	new := splitLines(strings.Repeat("b\n", 100))
+
+	if _, ok := diffLines(old, new, 50); ok {
+		t.Error("diffLines() should give up after 50 edits")
+	}
+	ops, ok := diffLines(old, new, 200)
+	if !ok || len(ops) != 200 {
+		t.Errorf("diffLines() = %d ops, %v, want 200 ops", len(ops), ok)
+	}
+}
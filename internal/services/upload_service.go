@@ -0,0 +1,435 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"micropanel/internal/config"
+	"micropanel/internal/models"
+	"micropanel/internal/repository"
+)
+
+const (
+	// UploadPruneInterval is how often the serve process removes uploads
+	// that were abandoned.
+	UploadPruneInterval = time.Hour
+
+	// UploadExpiry is how long an upload is kept without receiving data.
+	UploadExpiry = 24 * time.Hour
+
+	// MaxOpenUploads is how many uploads a user can have in progress.
+	MaxOpenUploads = 10
+
+	// uploadsDirName is the directory of the sites path holding the files of
+	// uploads in progress. It is outside every site, so they do not count
+	// against the disk quota twice once deployed.
+	uploadsDirName = ".uploads"
+)
+
+var (
+	ErrUploadNotFound      = errors.New("upload not found")
+	ErrUploadOffset        = errors.New("upload offset does not match the bytes received")
+	ErrUploadTooLarge      = errors.New("upload is larger than its announced length")
+	ErrUploadIncomplete    = errors.New("upload is not complete")
+	ErrUploadChecksum      = errors.New("checksum does not match the data received")
+	ErrUploadFileChecksum  = errors.New("file does not match its sha256 checksum, the upload was removed")
+	ErrInvalidUploadName   = errors.New("upload needs a file name")
+	ErrInvalidChecksum     = errors.New("invalid checksum")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm, use sha256")
+	ErrTooManyUploads      = fmt.Errorf("at most %d uploads can be in progress at a time", MaxOpenUploads)
+	ErrUploadExceedsLimits = errors.New("upload is larger than the limits of the site allow")
+	ErrInvalidUploadLength = errors.New("upload length cannot be negative")
+)
+
+// UploadService receives files in chunks that can be resumed after a broken
+// connection, for the resumable upload protocol (tus). A complete upload is
+// then deployed or put into the file manager like a file sent in one request.
+type UploadService struct {
+	config     *config.Config
+	uploadRepo *repository.UploadRepository
+	limits     *LimitsService
+
+	mu    sync.Mutex
+	locks map[string]*uploadLock // by upload id, held while writing
+}
+
+// uploadLock is the lock of an upload, removed once nobody holds or waits for it.
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+func NewUploadService(cfg *config.Config, uploadRepo *repository.UploadRepository, limits *LimitsService) *UploadService {
+	return &UploadService{
+		config:     cfg,
+		uploadRepo: uploadRepo,
+		limits:     limits,
+		locks:      make(map[string]*uploadLock),
+	}
+}
+
+// MaxSize returns the largest upload the site takes: an archive to deploy or
+// a file for the file manager, whichever limit is larger.
+func (s *UploadService) MaxSize(siteID int64) int64 {
+	limits := resolveLimits(s.limits, s.config, siteID)
+	return max(limits.MaxArchiveSize, limits.MaxUploadSize)
+}
+
+// Create starts an upload of size bytes for a site. sha256 is the optional
+// hex checksum of the whole file, verified once it is complete.
+func (s *UploadService) Create(siteID, userID int64, filename string, size int64, sha256 string) (*models.Upload, error) {
+	filename = filepath.Base(strings.TrimSpace(filename))
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) || len(filename) > 255 {
+		return nil, ErrInvalidUploadName
+	}
+	if size < 0 {
+		return nil, ErrInvalidUploadLength
+	}
+	sha256 = strings.ToLower(strings.TrimSpace(sha256))
+	if sha256 != "" {
+		if sum, err := hex.DecodeString(sha256); err != nil || len(sum) != 32 {
+			return nil, ErrInvalidChecksum
+		}
+	}
+
+	limits := resolveLimits(s.limits, s.config, siteID)
+	if size > max(limits.MaxArchiveSize, limits.MaxUploadSize) {
+		return nil, ErrUploadExceedsLimits
+	}
+	if err := checkQuota(s.config.Sites.Path, siteID, limits, size); err != nil {
+		return nil, err
+	}
+
+	count, err := s.uploadRepo.CountByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= MaxOpenUploads {
+		return nil, ErrTooManyUploads
+	}
+
+	id, err := generateUploadID()
+	if err != nil {
+		return nil, err
+	}
+	upload := &models.Upload{
+		ID:       id,
+		SiteID:   siteID,
+		UserID:   userID,
+		Filename: filename,
+		Size:     size,
+		SHA256:   sha256,
+	}
+
+	if err := os.MkdirAll(s.uploadsDir(), 0700); err != nil {
+		return nil, fmt.Errorf("create uploads directory: %w", err)
+	}
+	f, err := os.OpenFile(s.uploadPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("create upload file: %w", err)
+	}
+	f.Close()
+
+	if err := s.uploadRepo.Create(upload); err != nil {
+		os.Remove(s.uploadPath(id))
+		return nil, fmt.Errorf("create upload record: %w", err)
+	}
+	return upload, nil
+}
+
+// Get returns an upload with the number of bytes received so far.
+func (s *UploadService) Get(id string) (*models.Upload, error) {
+	upload, err := s.uploadRepo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(s.uploadPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = info.Size()
+	return upload, nil
+}
+
+// Append writes a chunk that starts at offset, which must be the number of
+// bytes received so far. checksum is the optional checksum of the chunk as
+// sent in an Upload-Checksum header; a chunk that does not match it is
+// dropped. Once the last chunk is in, the whole file is checked against the
+// checksum given at creation and the upload is removed if it does not match;
+// without one, the checksum of the file is recorded for the client to
+// compare. It returns the upload with its new offset.
+func (s *UploadService) Append(id string, offset int64, reader io.Reader, checksum string) (*models.Upload, error) {
+	var want []byte
+	var h hash.Hash
+	if checksum != "" {
+		var err error
+		if want, err = parseUploadChecksum(checksum); err != nil {
+			return nil, err
+		}
+		h = sha256.New()
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffset
+	}
+
+	path := s.uploadPath(id)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open upload file: %w", err)
+	}
+
+	// One byte more than what is left tells a chunk that is too long
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(reader, upload.Size-offset+1))
+	closeErr := f.Close()
+
+	// Keep what a broken connection delivered, so the client can resume
+	// from there, unless the chunk has to match a checksum
+	switch {
+	case offset+n > upload.Size:
+		copyErr = ErrUploadTooLarge
+	case h != nil && copyErr == nil && !bytes.Equal(h.Sum(nil), want):
+		copyErr = ErrUploadChecksum
+	case copyErr == nil:
+		copyErr = closeErr
+	}
+	if copyErr != nil && (h != nil || errors.Is(copyErr, ErrUploadTooLarge)) {
+		if err := os.Truncate(path, offset); err != nil {
+			return nil, fmt.Errorf("truncate upload file: %w", err)
+		}
+		n = 0
+	}
+	upload.Offset = offset + n
+
+	if n > 0 {
+		if err := s.uploadRepo.Touch(id); err != nil {
+			slog.Error("failed to touch upload", "upload_id", id, "error", err)
+		}
+	}
+	if copyErr != nil {
+		return upload, copyErr
+	}
+
+	if !upload.IsComplete() {
+		return upload, nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case upload.SHA256 == "":
+		if err := s.uploadRepo.SetSHA256(id, sum); err != nil {
+			return nil, fmt.Errorf("record upload checksum: %w", err)
+		}
+		upload.SHA256 = sum
+	case sum != upload.SHA256:
+		s.remove(upload)
+		return upload, ErrUploadFileChecksum
+	}
+	return upload, nil
+}
+
+// Open opens the file of a complete upload of the user. The caller removes
+// the upload with Delete once it has used the file.
+func (s *UploadService) Open(id string, userID int64) (*os.File, *models.Upload, error) {
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if upload.UserID != userID {
+		return nil, nil, ErrUploadNotFound
+	}
+	if !upload.IsComplete() {
+		return nil, upload, ErrUploadIncomplete
+	}
+
+	f, err := os.Open(s.uploadPath(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("open upload file: %w", err)
+	}
+	return f, upload, nil
+}
+
+// Delete removes an upload and what it received.
+func (s *UploadService) Delete(id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.uploadRepo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrUploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.remove(upload)
+	return nil
+}
+
+// remove deletes the file and record of an upload.
+func (s *UploadService) remove(upload *models.Upload) {
+	if err := os.Remove(s.uploadPath(upload.ID)); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to remove upload file", "upload_id", upload.ID, "error", err)
+	}
+	if err := s.uploadRepo.Delete(upload.ID); err != nil {
+		slog.Error("failed to remove upload record", "upload_id", upload.ID, "error", err)
+	}
+}
+
+// StartJanitor removes abandoned uploads now and then every interval, for as
+// long as the process runs.
+func (s *UploadService) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			removed, err := s.PruneExpired(time.Now())
+			if err != nil {
+				slog.Error("failed to prune uploads", "error", err)
+			}
+			if removed > 0 {
+				slog.Info("pruned abandoned uploads", "count", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PruneExpired removes the uploads that received nothing for UploadExpiry,
+// and files in the uploads directory that no upload owns, such as those of
+// uploads whose site was deleted. It returns how many were removed.
+func (s *UploadService) PruneExpired(now time.Time) (int, error) {
+	uploads, err := s.uploadRepo.ListIdleSince(now.Add(-UploadExpiry))
+	if err != nil {
+		return 0, fmt.Errorf("list idle uploads: %w", err)
+	}
+	removed := 0
+	for _, upload := range uploads {
+		if err := s.Delete(upload.ID); err == nil {
+			removed++
+		}
+	}
+
+	entries, err := os.ReadDir(s.uploadsDir())
+	if os.IsNotExist(err) {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("read uploads directory: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < UploadExpiry {
+			continue
+		}
+		if _, err := s.uploadRepo.GetByID(entry.Name()); !errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.uploadsDir(), entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// lock serializes the writes to an upload and returns the unlock function.
+func (s *UploadService) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *UploadService) uploadsDir() string {
+	return filepath.Join(s.config.Sites.Path, uploadsDirName)
+}
+
+func (s *UploadService) uploadPath(id string) string {
+	return filepath.Join(s.uploadsDir(), id)
+}
+
+func generateUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseUploadChecksum parses an Upload-Checksum header, the algorithm and
+// the base64 digest separated by a space, into the digest.
+func parseUploadChecksum(value string) ([]byte, error) {
+	algorithm, digest, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return nil, ErrInvalidChecksum
+	}
+	if !strings.EqualFold(algorithm, "sha256") {
+		return nil, ErrUnsupportedChecksum
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(digest))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, ErrInvalidChecksum
+	}
+	return sum, nil
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of a file.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"micropanel/internal/repository"
+)
+
+// newTestUploadService returns an upload service for the site of a deploy
+// test environment, with the site and user uploads are made for.
+func newTestUploadService(t *testing.T) (*UploadService, int64, int64) {
+	t.Helper()
+	env := newDeployTestEnv(t)
+	s := NewUploadService(env.config, repository.NewUploadRepository(env.db), nil)
+	return s, env.site.ID, env.owner.ID
+}
+
+// chunkChecksum returns the Upload-Checksum header of a chunk.
+func chunkChecksum(chunk string) string {
+	sum := sha256.Sum256([]byte(chunk))
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestUploadService_Append(t *testing.T) {
+	s, siteID, userID := newTestUploadService(t)
+	data := "0123456789"
+	sum := sha256.Sum256([]byte(data))
+	upload, err := s.Create(siteID, userID, "site.zip", int64(len(data)), hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// wantOffset checks the offset of the upload is what is on disk
+	wantOffset := func(name string, got int64, want int64) {
+		t.Helper()
+		info, err := os.Stat(s.uploadPath(upload.ID))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		stored, err := s.Get(upload.ID)
+		if err != nil {
+			t.Fatalf("%s: Get() error = %v", name, err)
+		}
+		if got != want || stored.Offset != want || info.Size() != want {
+			t.Errorf("%s: offset = %d, stored %d, on disk %d, want %d", name, got, stored.Offset, info.Size(), want)
+		}
+	}
+
+	got, err := s.Append(upload.ID, 0, strings.NewReader("0123"), chunkChecksum("0123"))
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	wantOffset("chunk with checksum", got.Offset, 4)
+
+	got, err = s.Append(upload.ID, 2, strings.NewReader("23"), "")
+	if !errors.Is(err, ErrUploadOffset) {
+		t.Errorf("Append() at a stale offset error = %v, want %v", err, ErrUploadOffset)
+	}
+	wantOffset("stale offset", got.Offset, 4)
+
+	got, err = s.Append(upload.ID, 4, strings.NewReader("45"), chunkChecksum("xx"))
+	if !errors.Is(err, ErrUploadChecksum) {
+		t.Errorf("Append() with a bad checksum error = %v, want %v", err, ErrUploadChecksum)
+	}
+	wantOffset("bad checksum", got.Offset, 4)
+
+	got, err = s.Append(upload.ID, 4, strings.NewReader("456789-and-more"), "")
+	if !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Append() past the length error = %v, want %v", err, ErrUploadTooLarge)
+	}
+	wantOffset("oversize chunk", got.Offset, 4)
+
+	// A broken connection keeps what arrived when the chunk has no checksum
+	broken := io.MultiReader(strings.NewReader("45"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	got, err = s.Append(upload.ID, 4, broken, "")
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Append() of a broken chunk error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	wantOffset("broken chunk", got.Offset, 6)
+
+	// but drops it when it has one
+	broken = io.MultiReader(strings.NewReader("67"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	got, err = s.Append(upload.ID, 6, broken, chunkChecksum("6789"))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Append() of a broken chunk with checksum error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	wantOffset("broken chunk with checksum", got.Offset, 6)
+
+	got, err = s.Append(upload.ID, 6, strings.NewReader("6789"), "")
+	if err != nil {
+		t.Fatalf("Append() of the last chunk error = %v", err)
+	}
+	wantOffset("last chunk", got.Offset, 10)
+	if !got.IsComplete() {
+		t.Errorf("upload not complete after its last chunk")
+	}
+}
+
+func TestUploadService_AppendFileChecksum(t *testing.T) {
+	s, siteID, userID := newTestUploadService(t)
+	sum := sha256.Sum256([]byte("expected"))
+	upload, err := s.Create(siteID, userID, "site.zip", 8, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := s.Append(upload.ID, 0, strings.NewReader("received"), ""); !errors.Is(err, ErrUploadFileChecksum) {
+		t.Fatalf("Append() of a mismatched file error = %v, want %v", err, ErrUploadFileChecksum)
+	}
+	if _, err := s.Get(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Get() of a mismatched file error = %v, want %v", err, ErrUploadNotFound)
+	}
+	if _, err := os.Stat(s.uploadPath(upload.ID)); !os.IsNotExist(err) {
+		t.Errorf("mismatched file still on disk: %v", err)
+	}
+}
+
+func TestUploadService_AppendRecordsChecksum(t *testing.T) {
+	s, siteID, userID := newTestUploadService(t)
+	upload, err := s.Create(siteID, userID, "site.zip", 8, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	got, err := s.Append(upload.ID, 0, strings.NewReader("rece"), "")
+	if err != nil || got.SHA256 != "" {
+		t.Fatalf("Append() of the first chunk = %q, %v, want no checksum yet", got.SHA256, err)
+	}
+	got, err = s.Append(upload.ID, 4, strings.NewReader("ived"), "")
+	if err != nil {
+		t.Fatalf("Append() of the last chunk error = %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("received"))
+	want := hex.EncodeToString(sum[:])
+	if got.SHA256 != want {
+		t.Errorf("Append() checksum = %q, want %q", got.SHA256, want)
+	}
+	if stored, err := s.Get(upload.ID); err != nil || stored.SHA256 != want {
+		t.Errorf("Get() checksum = %q, %v, want %q", stored.SHA256, err, want)
+	}
+}
+
+func TestParseUploadChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("chunk"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr error
+	}{
+		{"sha256", "sha256 " + encoded, nil},
+		{"algorithm is case insensitive", "SHA256 " + encoded, nil},
+		{"other algorithm", "sha1 " + encoded, ErrUnsupportedChecksum},
+		{"no digest", "sha256", ErrInvalidChecksum},
+		{"not base64", "sha256 not-base64!", ErrInvalidChecksum},
+		{"wrong length", "sha256 " + base64.StdEncoding.EncodeToString(sum[:16]), ErrInvalidChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUploadChecksum(tt.header)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseUploadChecksum(%q) error = %v, want %v", tt.header, err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, sum[:]) {
+				t.Errorf("parseUploadChecksum(%q) = %x, want %x", tt.header, got, sum)
+			}
+		})
+	}
+}
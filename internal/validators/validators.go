@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -181,3 +182,168 @@ func ValidateHtpasswdUsername(username string) error {
 
 	return nil
 }
+
+var (
+	ErrInvalidGitURL    = errors.New("invalid git repository URL")
+	ErrInvalidGitBranch = errors.New("invalid git branch")
+	ErrInvalidGitSubdir = errors.New("invalid repository subdirectory")
+	// scp-like ssh address: user@host:path
+	gitSCPRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\.]+@[a-zA-Z0-9\-\.]+:[a-zA-Z0-9_\-\./~]+$`)
+	// Branch names and subdirectories: no spaces, quotes or shell characters
+	gitRefRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\./]+$`)
+)
+
+// ValidateGitURL validates a repository URL: http(s), ssh, git and file URLs,
+// scp-like ssh addresses and absolute paths of local repositories
+func ValidateGitURL(rawURL string) error {
+	if rawURL == "" || len(rawURL) > 2048 {
+		return ErrInvalidGitURL
+	}
+
+	// Anything git could read as an option or that breaks out of the argument
+	if strings.HasPrefix(rawURL, "-") || strings.ContainsAny(rawURL, " \t\n\r\x00") {
+		return ErrInvalidGitURL
+	}
+
+	if strings.HasPrefix(rawURL, "/") {
+		return nil
+	}
+
+	if gitSCPRegex.MatchString(rawURL) {
+		return nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrInvalidGitURL
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "ssh", "git":
+		if parsed.Host == "" {
+			return ErrInvalidGitURL
+		}
+	case "file":
+		if parsed.Path == "" {
+			return ErrInvalidGitURL
+		}
+	default:
+		// Other transports (ext::, fd::) can run commands
+		return ErrInvalidGitURL
+	}
+
+	return nil
+}
+
+// IsLocalGitURL reports whether a repository URL points at the local filesystem
+func IsLocalGitURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "/") || strings.HasPrefix(strings.ToLower(rawURL), "file:")
+}
+
+// ValidateGitBranch validates a branch name
+func ValidateGitBranch(branch string) error {
+	if branch == "" || len(branch) > 255 {
+		return ErrInvalidGitBranch
+	}
+
+	if !gitRefRegex.MatchString(branch) {
+		return ErrInvalidGitBranch
+	}
+
+	if strings.HasPrefix(branch, "-") || strings.HasPrefix(branch, "/") || strings.HasSuffix(branch, "/") ||
+		strings.HasSuffix(branch, ".lock") || strings.Contains(branch, "..") || strings.Contains(branch, "//") {
+		return ErrInvalidGitBranch
+	}
+
+	return nil
+}
+
+// ValidateGitSubdir validates a subdirectory of a repository to deploy, such
+// as dist. Empty means the repository root.
+func ValidateGitSubdir(subdir string) error {
+	if subdir == "" {
+		return nil
+	}
+
+	if len(subdir) > 255 || !gitRefRegex.MatchString(subdir) {
+		return ErrInvalidGitSubdir
+	}
+
+	if strings.HasPrefix(subdir, "/") || strings.HasPrefix(subdir, "-") {
+		return ErrInvalidGitSubdir
+	}
+
+	for _, part := range strings.Split(strings.TrimSuffix(subdir, "/"), "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidGitSubdir
+		}
+	}
+
+	return nil
+}
+
+var (
+	ErrInvalidUpstream   = errors.New("invalid upstream: use host:port or unix:/path/to.sock")
+	ErrInvalidHeaderName = errors.New("invalid header name")
+	ErrInvalidHeader     = errors.New("invalid header value")
+	// Upstream host: hostname or IPv4 address, or an IPv6 address in brackets
+	upstreamHostRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?$|^\[[0-9a-fA-F:\.]+\]$`)
+	// Unix socket path: absolute, no spaces or nginx syntax
+	socketPathRegex = regexp.MustCompile(`^/[a-zA-Z0-9_\-\./]+$`)
+	// HTTP header name (RFC 7230 token, without characters nginx treats specially)
+	headerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9\-_]+$`)
+)
+
+// ValidateUpstream validates a backend a site is proxied to: host:port, or
+// unix:/path for a unix socket
+func ValidateUpstream(upstream string) error {
+	if upstream == "" || len(upstream) > 255 || containsDangerousChars(upstream) {
+		return ErrInvalidUpstream
+	}
+
+	if path, ok := strings.CutPrefix(upstream, "unix:"); ok {
+		if !socketPathRegex.MatchString(path) || strings.Contains(path, "..") {
+			return ErrInvalidUpstream
+		}
+		return nil
+	}
+
+	i := strings.LastIndex(upstream, ":")
+	if i <= 0 {
+		return ErrInvalidUpstream
+	}
+	host, port := upstream[:i], upstream[i+1:]
+	if !upstreamHostRegex.MatchString(host) {
+		return ErrInvalidUpstream
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != port {
+		return ErrInvalidUpstream
+	}
+
+	return nil
+}
+
+// ValidateHeaderName validates the name of an HTTP header
+func ValidateHeaderName(name string) error {
+	if name == "" || len(name) > 64 || !headerNameRegex.MatchString(name) {
+		return ErrInvalidHeaderName
+	}
+	return nil
+}
+
+// ValidateHeaderValue validates the value of an HTTP header written into
+// nginx config in double quotes: printable ASCII without quotes, backslashes
+// or variables. Semicolons and single quotes are allowed, CSP needs them.
+func ValidateHeaderValue(value string) error {
+	if len(value) > 2048 {
+		return ErrInvalidHeader
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' || c == '$' {
+			return ErrInvalidHeader
+		}
+	}
+	return nil
+}
@@ -264,3 +264,181 @@ func TestXSSPayloads(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateGitURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		// Valid URLs
+		{"https://github.com/example/site.git", false},
+		{"ssh://git@github.com/example/site.git", false},
+		{"git@github.com:example/site.git", false},
+		{"git://example.com/site.git", false},
+		{"/srv/git/site.git", false},
+		{"file:///srv/git/site.git", false},
+
+		// Invalid URLs
+		{"", true},
+		{"--upload-pack=touch /tmp/pwned", true}, // option injection
+		{"ext::sh -c touch% /tmp/pwned", true},   // command transport
+		{"fd::17", true},
+		{"https://", true},
+		{"https://github.com/example/site.git\n", true},
+		{"site.git", true}, // relative path
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := ValidateGitURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGitURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGitBranch(t *testing.T) {
+	tests := []struct {
+		branch  string
+		wantErr bool
+	}{
+		{"main", false},
+		{"release/1.2", false},
+		{"feature_x-2", false},
+
+		{"", true},
+		{"-main", true},
+		{"main..dev", true},
+		{"main.lock", true},
+		{"/main", true},
+		{"main branch", true},
+		{"main;rm", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			err := ValidateGitBranch(tt.branch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGitBranch(%q) error = %v, wantErr %v", tt.branch, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGitSubdir(t *testing.T) {
+	tests := []struct {
+		subdir  string
+		wantErr bool
+	}{
+		{"", false},
+		{"dist", false},
+		{"dist/", false},
+		{"build/public", false},
+
+		{"/dist", true},
+		{"../dist", true},
+		{"dist/../..", true},
+		{"./dist", true},
+		{"dist//public", true},
+		{"dist public", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subdir, func(t *testing.T) {
+			err := ValidateGitSubdir(tt.subdir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGitSubdir(%q) error = %v, wantErr %v", tt.subdir, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateUpstream(t *testing.T) {
+	tests := []struct {
+		upstream string
+		wantErr  bool
+	}{
+		{"127.0.0.1:3000", false},
+		{"localhost:8080", false},
+		{"app.internal:80", false},
+		{"[::1]:3000", false},
+		{"unix:/run/app/app.sock", false},
+
+		{"", true},
+		{"127.0.0.1", true},
+		{":3000", true},
+		{"127.0.0.1:0", true},
+		{"127.0.0.1:70000", true},
+		{"127.0.0.1:03000", true},
+		{"::1:3000", true},
+		{"http://127.0.0.1:3000", true},
+		{"unix:run/app.sock", true},
+		{"unix:/run/../etc/app.sock", true},
+		{"127.0.0.1:3000; include /etc/passwd", true},
+		{"127.0.0.1:3000 backup", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			err := ValidateUpstream(tt.upstream)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpstream(%q) error = %v, wantErr %v", tt.upstream, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHeaderName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"X-Request-Id", false},
+		{"Server", false},
+
+		{"", true},
+		{"X Request", true},
+		{"X-Id:", true},
+		{"X-Id;", true},
+		{strings.Repeat("a", 65), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHeaderName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHeaderName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHeaderValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"cache control", "public, max-age=31536000, immutable", false},
+		{"csp", "default-src 'self'; img-src 'self' data: https://cdn.example.com", false},
+		{"cors", "*", false},
+
+		{"newline", "no-cache\r\nSet-Cookie: a=b", true},
+		{"double quote", `no-cache" always; include /etc/passwd; #`, true},
+		{"backslash", `a\"b`, true},
+		{"variable", "$http_cookie", true},
+		{"non ascii", "café", true},
+		{"too long", strings.Repeat("a", 2049), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHeaderValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHeaderValue(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
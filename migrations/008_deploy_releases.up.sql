@@ -0,0 +1,5 @@
+-- Keep each successful deploy as its own release directory (sites/<id>/releases/<deploy_id>)
+ALTER TABLE sites ADD COLUMN keep_releases INTEGER NOT NULL DEFAULT 0; -- 0 = use sites.keep_releases from config
+
+ALTER TABLE deploys ADD COLUMN has_release INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE deploys ADD COLUMN is_active INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Track the phase and progress of asynchronous deploys
+ALTER TABLE deploys ADD COLUMN phase TEXT NOT NULL DEFAULT '';
+ALTER TABLE deploys ADD COLUMN progress INTEGER NOT NULL DEFAULT 0;
+
+UPDATE deploys SET phase = 'done', progress = 100 WHERE status = 'success';
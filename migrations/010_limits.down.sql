@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_limits;
+DROP TABLE IF EXISTS site_limits;
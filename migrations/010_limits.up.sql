@@ -0,0 +1,18 @@
+-- Admin overrides of config limits. NULL columns inherit: site -> owner -> config
+CREATE TABLE IF NOT EXISTS site_limits (
+    site_id INTEGER PRIMARY KEY,
+    max_archive_size INTEGER,
+    max_file_size INTEGER,
+    max_upload_size INTEGER,
+    disk_quota INTEGER,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_limits (
+    user_id INTEGER PRIMARY KEY,
+    max_archive_size INTEGER,
+    max_file_size INTEGER,
+    max_upload_size INTEGER,
+    disk_quota INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
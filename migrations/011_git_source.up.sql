@@ -0,0 +1,8 @@
+-- Git repository a site can be deployed from (empty git_url = none)
+ALTER TABLE sites ADD COLUMN git_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE sites ADD COLUMN git_branch TEXT NOT NULL DEFAULT '';
+ALTER TABLE sites ADD COLUMN git_subdir TEXT NOT NULL DEFAULT '';
+
+-- Commit a deploy from git was built from
+ALTER TABLE deploys ADD COLUMN commit_sha TEXT NOT NULL DEFAULT '';
+ALTER TABLE deploys ADD COLUMN commit_message TEXT NOT NULL DEFAULT '';
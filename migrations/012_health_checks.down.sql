@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_health_checks_site;
+DROP TABLE IF EXISTS health_checks;
@@ -0,0 +1,11 @@
+-- Requests run against nginx after a deploy; a failing one rolls the deploy back
+CREATE TABLE IF NOT EXISTS health_checks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    expected_status INTEGER NOT NULL DEFAULT 200,
+    body_contains TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_checks_site ON health_checks(site_id);
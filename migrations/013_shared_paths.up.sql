@@ -0,0 +1,8 @@
+-- Directories kept outside the releases and linked into each of them
+CREATE TABLE IF NOT EXISTS shared_paths (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    UNIQUE (site_id, path),
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+-- Deploy policy violations of a rejected release, as a JSON list
+ALTER TABLE deploys ADD COLUMN violations TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE sites ADD COLUMN precompress INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+ALTER TABLE deploys ADD COLUMN archive TEXT NOT NULL DEFAULT '';
+ALTER TABLE deploys ADD COLUMN archive_pruned_at DATETIME;
+ALTER TABLE sites ADD COLUMN keep_archives INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN archive_max_age INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN archive_max_size INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_deploy_keys_user;
+DROP INDEX IF EXISTS idx_deploy_keys_site;
+DROP TABLE IF EXISTS deploy_keys;
+-- SQLite does not support DROP COLUMN before 3.35.0
+-- This is a best-effort rollback
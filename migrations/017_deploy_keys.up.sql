@@ -0,0 +1,20 @@
+-- Ed25519 public keys that sign deploy archives. A key belongs to a site, or
+-- to a user and then covers every site the user owns.
+CREATE TABLE IF NOT EXISTS deploy_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER,
+    user_id INTEGER,
+    name TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CHECK ((site_id IS NULL) != (user_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deploy_keys_site ON deploy_keys(site_id, fingerprint);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deploy_keys_user ON deploy_keys(user_id, fingerprint);
+
+-- Fingerprint of the key that signed the archive of a deploy
+ALTER TABLE deploys ADD COLUMN signed_by TEXT NOT NULL DEFAULT '';
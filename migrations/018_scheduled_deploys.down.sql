@@ -0,0 +1,40 @@
+DROP INDEX IF EXISTS idx_freeze_windows_site;
+DROP TABLE IF EXISTS freeze_windows;
+
+-- Restore the old status check (recreate table), scheduled deploys that
+-- never ran are failed
+CREATE TABLE deploys_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'success', 'failed')),
+    error_message TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    has_release INTEGER NOT NULL DEFAULT 0,
+    is_active INTEGER NOT NULL DEFAULT 0,
+    phase TEXT NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    commit_sha TEXT NOT NULL DEFAULT '',
+    commit_message TEXT NOT NULL DEFAULT '',
+    violations TEXT NOT NULL DEFAULT '',
+    archive TEXT NOT NULL DEFAULT '',
+    archive_pruned_at DATETIME,
+    signed_by TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO deploys_old (id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by)
+SELECT id, site_id, user_id, filename,
+    CASE WHEN status IN ('scheduled', 'cancelled') THEN 'failed' ELSE status END,
+    CASE WHEN status = 'scheduled' THEN 'scheduled deploy dropped by a rollback of the database'
+         WHEN status = 'cancelled' THEN 'cancelled'
+         ELSE error_message END,
+    created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by
+FROM deploys;
+
+DROP TABLE deploys;
+ALTER TABLE deploys_old RENAME TO deploys;
+
+CREATE INDEX IF NOT EXISTS idx_deploys_site ON deploys(site_id);
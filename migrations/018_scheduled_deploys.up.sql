@@ -0,0 +1,49 @@
+-- Deploys uploaded ahead of time and released by the scheduler of serve.
+-- The status check gains 'scheduled' and 'cancelled'; SQLite cannot alter
+-- a CHECK constraint, so we recreate the table
+
+CREATE TABLE deploys_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('scheduled', 'pending', 'success', 'failed', 'cancelled')),
+    error_message TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    has_release INTEGER NOT NULL DEFAULT 0,
+    is_active INTEGER NOT NULL DEFAULT 0,
+    phase TEXT NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    commit_sha TEXT NOT NULL DEFAULT '',
+    commit_message TEXT NOT NULL DEFAULT '',
+    violations TEXT NOT NULL DEFAULT '',
+    archive TEXT NOT NULL DEFAULT '',
+    archive_pruned_at DATETIME,
+    signed_by TEXT NOT NULL DEFAULT '',
+    scheduled_at DATETIME,
+    -- An admin let the deploy through a freeze window
+    freeze_override INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO deploys_new (id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by)
+SELECT id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by FROM deploys;
+
+DROP TABLE deploys;
+ALTER TABLE deploys_new RENAME TO deploys;
+
+CREATE INDEX IF NOT EXISTS idx_deploys_site ON deploys(site_id);
+
+-- Periods during which a site takes no deploys unless an admin overrides it
+CREATE TABLE IF NOT EXISTS freeze_windows (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_freeze_windows_site ON freeze_windows(site_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_uploads_user;
+DROP TABLE IF EXISTS uploads;
@@ -0,0 +1,12 @@
+-- Credentials a site reads its artifacts with from S3-compatible object
+-- storage, for deploys of a bucket object
+CREATE TABLE IF NOT EXISTS s3_credentials (
+    site_id INTEGER PRIMARY KEY,
+    endpoint TEXT NOT NULL,
+    region TEXT NOT NULL DEFAULT 'us-east-1',
+    access_key_id TEXT NOT NULL,
+    secret_access_key TEXT NOT NULL,
+    path_style INTEGER NOT NULL DEFAULT 1,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS staging_slots;
+
+-- SQLite does not support DROP COLUMN before 3.35.0
+-- This is a best-effort rollback
@@ -0,0 +1,15 @@
+-- Staging slots: a second release of a site served on a preview hostname
+-- behind basic auth or an IP allowlist, promoted to production on demand
+CREATE TABLE IF NOT EXISTS staging_slots (
+    site_id INTEGER PRIMARY KEY,
+    hostname TEXT NOT NULL DEFAULT '',
+    allowed_ips TEXT NOT NULL DEFAULT '',
+    auth_user TEXT NOT NULL DEFAULT '',
+    auth_hash TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+-- Slot a deploy was made for, and whether its release is the one staging serves
+ALTER TABLE deploys ADD COLUMN target TEXT NOT NULL DEFAULT 'production';
+ALTER TABLE deploys ADD COLUMN is_staged INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,46 @@
+DROP TABLE IF EXISTS site_approvers;
+
+-- SQLite does not support DROP COLUMN before 3.35.0, sites keep
+-- require_approval; this is a best-effort rollback
+
+-- Restore the old status check (recreate table), deploys still awaiting
+-- approval are failed
+CREATE TABLE deploys_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('scheduled', 'pending', 'success', 'failed', 'cancelled')),
+    error_message TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    has_release INTEGER NOT NULL DEFAULT 0,
+    is_active INTEGER NOT NULL DEFAULT 0,
+    phase TEXT NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    commit_sha TEXT NOT NULL DEFAULT '',
+    commit_message TEXT NOT NULL DEFAULT '',
+    violations TEXT NOT NULL DEFAULT '',
+    archive TEXT NOT NULL DEFAULT '',
+    archive_pruned_at DATETIME,
+    signed_by TEXT NOT NULL DEFAULT '',
+    scheduled_at DATETIME,
+    freeze_override INTEGER NOT NULL DEFAULT 0,
+    target TEXT NOT NULL DEFAULT 'production',
+    is_staged INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO deploys_old (id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by, scheduled_at, freeze_override, target, is_staged)
+SELECT id, site_id, user_id, filename,
+    CASE WHEN status IN ('awaiting_approval', 'rejected') THEN 'failed' ELSE status END,
+    CASE WHEN status = 'awaiting_approval' THEN 'approval dropped by a rollback of the database'
+         WHEN status = 'rejected' THEN 'rejected'
+         ELSE error_message END,
+    created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by, scheduled_at, freeze_override, target, is_staged
+FROM deploys;
+
+DROP TABLE deploys;
+ALTER TABLE deploys_old RENAME TO deploys;
+
+CREATE INDEX IF NOT EXISTS idx_deploys_site ON deploys(site_id);
@@ -0,0 +1,56 @@
+-- Deploys held for a second person to approve before their release goes
+-- live. The status check gains 'awaiting_approval' and 'rejected'; SQLite
+-- cannot alter a CHECK constraint, so we recreate the table
+
+CREATE TABLE deploys_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('scheduled', 'pending', 'awaiting_approval', 'success', 'failed', 'cancelled', 'rejected')),
+    error_message TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    has_release INTEGER NOT NULL DEFAULT 0,
+    is_active INTEGER NOT NULL DEFAULT 0,
+    phase TEXT NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    commit_sha TEXT NOT NULL DEFAULT '',
+    commit_message TEXT NOT NULL DEFAULT '',
+    violations TEXT NOT NULL DEFAULT '',
+    archive TEXT NOT NULL DEFAULT '',
+    archive_pruned_at DATETIME,
+    signed_by TEXT NOT NULL DEFAULT '',
+    scheduled_at DATETIME,
+    freeze_override INTEGER NOT NULL DEFAULT 0,
+    target TEXT NOT NULL DEFAULT 'production',
+    is_staged INTEGER NOT NULL DEFAULT 0,
+    -- The release waits for an approver before it is activated
+    needs_approval INTEGER NOT NULL DEFAULT 0,
+    reviewed_by INTEGER,
+    reviewed_at DATETIME,
+    review_note TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (reviewed_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+INSERT INTO deploys_new (id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by, scheduled_at, freeze_override, target, is_staged)
+SELECT id, site_id, user_id, filename, status, error_message, created_at, has_release, is_active, phase, progress, commit_sha, commit_message, violations, archive, archive_pruned_at, signed_by, scheduled_at, freeze_override, target, is_staged FROM deploys;
+
+DROP TABLE deploys;
+ALTER TABLE deploys_new RENAME TO deploys;
+
+CREATE INDEX IF NOT EXISTS idx_deploys_site ON deploys(site_id);
+
+-- Sites whose deploys by non-admins and API tokens need approval
+ALTER TABLE sites ADD COLUMN require_approval INTEGER NOT NULL DEFAULT 0;
+
+-- Users allowed to approve the deploys of a site besides the admins
+CREATE TABLE IF NOT EXISTS site_approvers (
+    site_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (site_id, user_id),
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+-- How nginx serves a site: static files, an upstream, or files falling
+-- through to an upstream
+ALTER TABLE sites ADD COLUMN site_type TEXT NOT NULL DEFAULT 'static' CHECK (site_type IN ('static', 'proxy', 'hybrid'));
+
+-- Upstream of proxy and hybrid sites
+CREATE TABLE IF NOT EXISTS site_proxies (
+    site_id INTEGER PRIMARY KEY,
+    upstreams TEXT NOT NULL,
+    connect_timeout INTEGER NOT NULL DEFAULT 60,
+    read_timeout INTEGER NOT NULL DEFAULT 60,
+    send_timeout INTEGER NOT NULL DEFAULT 60,
+    websocket INTEGER NOT NULL DEFAULT 0,
+    pass_headers TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
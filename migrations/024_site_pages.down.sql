@@ -0,0 +1,2 @@
+-- SQLite does not support DROP COLUMN before 3.35.0
+-- This is a best-effort rollback
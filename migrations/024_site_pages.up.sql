@@ -0,0 +1,7 @@
+-- Send unknown paths of single-page apps to /index.html
+ALTER TABLE sites ADD COLUMN spa_fallback INTEGER NOT NULL DEFAULT 0;
+
+-- Files of the release shown instead of the nginx error pages
+ALTER TABLE sites ADD COLUMN error_page_404 TEXT NOT NULL DEFAULT '';
+ALTER TABLE sites ADD COLUMN error_page_403 TEXT NOT NULL DEFAULT '';
+ALTER TABLE sites ADD COLUMN error_page_50x TEXT NOT NULL DEFAULT '';
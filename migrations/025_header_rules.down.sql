@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_site_header_rules_site;
+DROP TABLE IF EXISTS site_header_rules;
@@ -0,0 +1,14 @@
+-- Response headers added or overridden per site, for the requests matching
+-- a path or file extension. The first matching rule sets a header.
+CREATE TABLE IF NOT EXISTS site_header_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    match_type TEXT NOT NULL CHECK (match_type IN ('all', 'prefix', 'exact', 'extension')),
+    pattern TEXT NOT NULL DEFAULT '',
+    headers TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_site_header_rules_site ON site_header_rules(site_id, position);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS site_nginx_snippets;
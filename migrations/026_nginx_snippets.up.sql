@@ -0,0 +1,9 @@
+-- Custom nginx config added by admins to a site, one snippet per hook
+CREATE TABLE IF NOT EXISTS site_nginx_snippets (
+    site_id INTEGER NOT NULL,
+    hook TEXT NOT NULL CHECK (hook IN ('server', 'before_location', 'location')),
+    content TEXT NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (site_id, hook),
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
@@ -0,0 +1,27 @@
+-- Restore the old code check (recreate table). Regex and conditional
+-- redirects cannot be expressed and are dropped rather than widened;
+-- exact redirects become prefix redirects, 307 and 308 become 302 and 301
+CREATE TABLE redirects_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    source_path TEXT NOT NULL,
+    target_url TEXT NOT NULL,
+    code INTEGER NOT NULL DEFAULT 301 CHECK (code IN (301, 302)),
+    preserve_path INTEGER NOT NULL DEFAULT 0,
+    preserve_query INTEGER NOT NULL DEFAULT 0,
+    priority INTEGER NOT NULL DEFAULT 0,
+    is_enabled INTEGER NOT NULL DEFAULT 1,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+INSERT INTO redirects_old (id, site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled)
+SELECT id, site_id, source_path, target_url,
+    CASE code WHEN 307 THEN 302 WHEN 308 THEN 301 ELSE code END,
+    preserve_path, preserve_query, priority, is_enabled
+FROM redirects
+WHERE match_type != 'regex' AND host = '' AND query_arg = '';
+
+DROP TABLE redirects;
+ALTER TABLE redirects_old RENAME TO redirects;
+
+CREATE INDEX IF NOT EXISTS idx_redirects_site ON redirects(site_id);
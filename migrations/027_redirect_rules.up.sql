@@ -0,0 +1,30 @@
+-- Redirects match an exact path, a prefix or a regular expression, can be
+-- limited to a host or a query argument, and can use 307 and 308. SQLite
+-- cannot alter a CHECK constraint, so we recreate the table; existing
+-- redirects are prefix redirects, as nginx matched them before
+
+CREATE TABLE redirects_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_id INTEGER NOT NULL,
+    match_type TEXT NOT NULL DEFAULT 'prefix' CHECK (match_type IN ('exact', 'prefix', 'regex')),
+    source_path TEXT NOT NULL,
+    target_url TEXT NOT NULL,
+    code INTEGER NOT NULL DEFAULT 301 CHECK (code IN (301, 302, 307, 308)),
+    preserve_path INTEGER NOT NULL DEFAULT 0,
+    preserve_query INTEGER NOT NULL DEFAULT 0,
+    priority INTEGER NOT NULL DEFAULT 0,
+    is_enabled INTEGER NOT NULL DEFAULT 1,
+    -- Conditions, empty when unset
+    host TEXT NOT NULL DEFAULT '',
+    query_arg TEXT NOT NULL DEFAULT '',
+    query_value TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+);
+
+INSERT INTO redirects_new (id, site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled)
+SELECT id, site_id, source_path, target_url, code, preserve_path, preserve_query, priority, is_enabled FROM redirects;
+
+DROP TABLE redirects;
+ALTER TABLE redirects_new RENAME TO redirects;
+
+CREATE INDEX IF NOT EXISTS idx_redirects_site ON redirects(site_id);
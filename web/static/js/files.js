@@ -226,8 +226,20 @@ async function submitUpload(e) {
     e.preventDefault();
 
     const formData = new FormData(e.target);
+    const progress = document.getElementById('upload-progress');
 
     try {
+        // Large files go through the resumable uploader first
+        const file = document.getElementById('upload-file').files[0];
+        if (file && file.size > resumableThreshold) {
+            const uploadID = await resumableUpload(file, siteID, (done, total) => {
+                progress.textContent = `Uploading ${file.name}: ${Math.floor(done * 100 / total)}%`;
+                progress.classList.remove('hidden');
+            });
+            formData.delete('file');
+            formData.set('upload_id', uploadID);
+        }
+
         const response = await fetch(`/sites/${siteID}/files/upload`, {
             method: 'POST',
             headers: {
@@ -243,10 +255,13 @@ async function submitUpload(e) {
             return;
         }
 
+        progress.classList.add('hidden');
         closeUploadModal();
         loadFiles(currentPath);
     } catch (error) {
         console.error('Error uploading:', error);
+        progress.textContent = `Upload failed: ${error.message}`;
+        progress.classList.remove('hidden');
     }
 }
 
@@ -0,0 +1,191 @@
+// Resumable uploads (tus) for large files.
+// Files above resumableThreshold are sent to /uploads in chunks instead of
+// in one request, so a broken connection only costs the chunk in flight and
+// no proxy limit on the request size applies. The form is then submitted
+// with the upload_id of the finished upload in place of the file.
+
+const resumableThreshold = 10 * 1024 * 1024;
+const resumableChunkSize = 5 * 1024 * 1024;
+const resumableRetries = 5;
+
+function resumableCSRFToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    if (meta) {
+        return meta.content;
+    }
+    const cookie = document.cookie.split('; ').find(row => row.startsWith('_csrf='));
+    return cookie ? cookie.split('=')[1] : '';
+}
+
+function encodeUploadMetadata(values) {
+    return Object.entries(values)
+        .map(([key, value]) => key + ' ' + btoa(unescape(encodeURIComponent(String(value)))))
+        .join(',');
+}
+
+// The Upload-Checksum of a chunk, or of a whole file for the Upload-Digest
+// of the finished upload, or null where the browser cannot compute it (Web
+// Crypto only works over HTTPS and on localhost).
+async function uploadChecksum(blob) {
+    if (!window.crypto || !window.crypto.subtle) {
+        return null;
+    }
+    const digest = await window.crypto.subtle.digest('SHA-256', await blob.arrayBuffer());
+    let binary = '';
+    new Uint8Array(digest).forEach(b => { binary += String.fromCharCode(b); });
+    return 'sha256 ' + btoa(binary);
+}
+
+async function uploadError(response) {
+    try {
+        const data = await response.json();
+        return data.error || response.statusText;
+    } catch (e) {
+        return response.statusText;
+    }
+}
+
+// resumableUpload sends a file for a site and resolves with the id of the
+// finished upload. An upload of the same file interrupted earlier, even
+// before a page reload, is resumed where it stopped.
+async function resumableUpload(file, siteID, onProgress) {
+    const key = `upload:${siteID}:${file.name}:${file.size}:${file.lastModified}`;
+    const headers = {
+        'Tus-Resumable': '1.0.0',
+        'X-CSRF-Token': resumableCSRFToken(),
+    };
+    const progress = offset => { if (onProgress) onProgress(offset, file.size); };
+    // Hashed while the chunks go out, compared with what the server assembled
+    const digest = uploadChecksum(file).catch(() => null);
+
+    let url = localStorage.getItem(key);
+    let offset = 0;
+    if (url) {
+        const response = await fetch(url, { method: 'HEAD', headers });
+        if (response.ok) {
+            offset = parseInt(response.headers.get('Upload-Offset'), 10);
+        } else {
+            url = null;
+        }
+    }
+    if (!url) {
+        const response = await fetch('/uploads', {
+            method: 'POST',
+            headers: {
+                ...headers,
+                'Upload-Length': String(file.size),
+                'Upload-Metadata': encodeUploadMetadata({ site_id: siteID, filename: file.name }),
+            },
+        });
+        if (!response.ok) {
+            throw new Error(await uploadError(response));
+        }
+        url = response.headers.get('Location');
+        localStorage.setItem(key, url);
+    }
+
+    let failures = 0;
+    while (offset < file.size) {
+        progress(offset);
+        const chunk = file.slice(offset, offset + resumableChunkSize);
+        const chunkHeaders = {
+            ...headers,
+            'Content-Type': 'application/offset+octet-stream',
+            'Upload-Offset': String(offset),
+        };
+        const checksum = await uploadChecksum(chunk);
+        if (checksum) {
+            chunkHeaders['Upload-Checksum'] = checksum;
+        }
+
+        try {
+            const response = await fetch(url, { method: 'PATCH', headers: chunkHeaders, body: chunk });
+            if (response.ok) {
+                offset = parseInt(response.headers.get('Upload-Offset'), 10);
+                failures = 0;
+                continue;
+            }
+            // Offset conflicts, corrupted chunks and rate limits are retried,
+            // other client errors will not go away
+            if (response.status >= 400 && response.status < 500 && ![409, 429, 460].includes(response.status)) {
+                localStorage.removeItem(key);
+                throw new Error(await uploadError(response));
+            }
+        } catch (e) {
+            if (!(e instanceof TypeError)) {
+                throw e;
+            }
+            // A network error, retried below
+        }
+
+        if (++failures > resumableRetries) {
+            throw new Error('Upload interrupted, submit the form again to resume it');
+        }
+        await new Promise(resolve => setTimeout(resolve, 1000 * failures));
+
+        // Continue from what the server has
+        const response = await fetch(url, { method: 'HEAD', headers }).catch(() => null);
+        if (response && response.ok) {
+            offset = parseInt(response.headers.get('Upload-Offset'), 10);
+        } else if (response && response.status === 404) {
+            localStorage.removeItem(key);
+            throw new Error('The upload was discarded, try again');
+        }
+    }
+
+    localStorage.removeItem(key);
+
+    // Each chunk was checked on its own, this checks the file the server
+    // assembled from them, chunks of an earlier page included
+    const expected = await digest;
+    if (expected) {
+        const response = await fetch(url, { method: 'HEAD', headers });
+        const received = response.ok ? response.headers.get('Upload-Digest') : null;
+        if (received && received !== expected) {
+            await fetch(url, { method: 'DELETE', headers }).catch(() => null);
+            throw new Error('The uploaded file does not match ' + file.name + ', try again');
+        }
+    }
+
+    progress(file.size);
+    return url.substring(url.lastIndexOf('/') + 1);
+}
+
+// Forms with data-resumable-upload="<site id>" send a large file through
+// resumableUpload before htmx submits them. The form needs a hidden
+// upload_id input; an element with data-upload-progress shows the progress.
+document.addEventListener('htmx:confirm', function(e) {
+    const form = e.detail.elt;
+    if (!form.matches || !form.matches('form[data-resumable-upload]')) {
+        return;
+    }
+    const input = form.querySelector('input[type="file"][name="file"]');
+    const file = input && input.files[0];
+    if (!file || file.size <= resumableThreshold) {
+        return;
+    }
+    e.preventDefault();
+
+    const uploadID = form.querySelector('input[name="upload_id"]');
+    const status = form.querySelector('[data-upload-progress]');
+    const show = text => {
+        if (status) {
+            status.textContent = text;
+            status.classList.remove('hidden');
+        }
+    };
+
+    resumableUpload(file, form.dataset.resumableUpload, (done, total) => {
+        show(`Uploading ${file.name}: ${Math.floor(done * 100 / total)}%`);
+    }).then(id => {
+        show(`Uploaded ${file.name}, deploying...`);
+        // The file is already on the server, send only its upload id
+        uploadID.value = id;
+        input.disabled = true;
+        e.detail.issueRequest();
+        input.disabled = false;
+        uploadID.value = '';
+    }).catch(err => {
+        show(`Upload failed: ${err.message}`);
+    });
+});